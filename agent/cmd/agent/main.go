@@ -1,136 +1,65 @@
+// Command agent is an agent worker: it connects to the backend, takes the calls the backend
+// dispatches to it and runs their AI agents. Run the backend with AGENT_DISPATCH=workers.
+//
+// On SIGINT or SIGTERM the worker drains: it takes no new calls and exits once its agents
+// have finished. A second signal exits at once.
 package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/backend/worker"
+	"github.com/jacky-htg/ai-call-center/libs/config"
 )
 
 func main() {
-	var (
-		sessionID  string
-		backendURL string
-		timeoutSec int
-	)
-	flag.StringVar(&sessionID, "session", "", "agent session ID to fetch token for")
-	flag.StringVar(&backendURL, "backend", "http://localhost:8080", "backend base URL")
-	flag.IntVar(&timeoutSec, "timeout", 10, "HTTP timeout seconds")
+	var opts worker.Options
+	flag.StringVar(&opts.BackendURL, "backend", "http://localhost:8080", "backend base URL")
+	flag.StringVar(&opts.ID, "id", "", "worker id (default: picked by the backend)")
+	flag.IntVar(&opts.Capacity, "capacity", worker.DefaultCapacity, "calls run at once")
 	flag.Parse()
+	// the backend authenticates workers with the secret of its token endpoint
+	opts.Secret = os.Getenv("AGENT_TOKEN_ENDPOINT_SECRET")
 
-	if sessionID == "" {
-		fmt.Fprintln(os.Stderr, "session id required: -session <id>")
-		os.Exit(2)
+	// agents hear and talk to callers in Opus
+	if err := worker.CheckCodec(); err != nil {
+		log.Fatalf("audio codec: %v", err)
 	}
-
-	// build token endpoint
-	tokenEndpoint := fmt.Sprintf("%s/sessions/%s/token", backendURL, sessionID)
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSec)*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", tokenEndpoint, nil)
+	w, err := worker.New(config.LoadFromEnv(), opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "new request: %v\n", err)
-		os.Exit(1)
+		log.Fatalf("new worker: %v", err)
 	}
 
-	// optional auth using AGENT_TOKEN_ENDPOINT_SECRET
-	if s := os.Getenv("AGENT_TOKEN_ENDPOINT_SECRET"); s != "" {
-		req.Header.Set("Authorization", "Bearer "+s)
-	}
-
-	client := &http.Client{Timeout: time.Duration(timeoutSec) * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "request failed: %v\n", err)
-		os.Exit(1)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		fmt.Fprintf(os.Stderr, "bad status %d: %s\n", resp.StatusCode, string(b))
-		os.Exit(1)
-	}
-
-	var out struct {
-		Token string `json:"token"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		fmt.Fprintf(os.Stderr, "decode response: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Printf("session=%s token=%s\n", sessionID, out.Token)
-
-	// Attempt to open websocket signaling to LiveKit /rtc endpoint using the retrieved token
-	// Build WS URL: <LIVEKIT_URL>/rtc?access_token=<token>
-	livekitURL := os.Getenv("LIVEKIT_URL")
-	if livekitURL == "" {
-		fmt.Fprintln(os.Stderr, "LIVEKIT_URL not set in env; skipping WS join")
-		return
-	}
-
-	// ensure token is present
-	if out.Token == "" {
-		fmt.Fprintln(os.Stderr, "no token returned; cannot join LiveKit")
-		return
-	}
-
-	// parse livekitURL and append path /rtc and query
-	u, err := url.Parse(livekitURL)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "invalid LIVEKIT_URL: %v\n", err)
-		return
-	}
-	// ensure scheme is ws/wss
-	if u.Scheme == "https" {
-		u.Scheme = "wss"
-	} else if u.Scheme == "http" {
-		u.Scheme = "ws"
-	}
-	u.Path = "/rtc"
-	q := u.Query()
-	q.Set("access_token", out.Token)
-	u.RawQuery = q.Encode()
-
-	fmt.Printf("dialing livekit websocket %s\n", u.String())
-	dialer := websocket.DefaultDialer
-	// create connection
-	wsConn, resp2, err := dialer.Dial(u.String(), nil)
-	if err != nil {
-		if resp2 != nil {
-			b, _ := io.ReadAll(resp2.Body)
-			fmt.Fprintf(os.Stderr, "websocket dial failed: %v status=%d body=%s\n", err, resp2.StatusCode, string(b))
-		} else {
-			fmt.Fprintf(os.Stderr, "websocket dial failed: %v\n", err)
-		}
-		return
-	}
-	defer wsConn.Close()
-
-	// read messages in background
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for {
-			mt, msg, err := wsConn.ReadMessage()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "ws read error: %v\n", err)
+		<-signals
+		log.Printf("draining: %d agents running", w.Active())
+		w.SetCapacity(0)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for w.Active() > 0 {
+			select {
+			case <-signals:
+				log.Printf("stopping %d agents", w.Active())
+				cancel()
 				return
+			case <-ticker.C:
 			}
-			fmt.Printf("ws message (type=%d): %s\n", mt, string(msg))
 		}
+		cancel()
 	}()
 
-	// keep open for a short duration so we can observe signaling
-	fmt.Println("connected to LiveKit signaling websocket; keeping connection open for 20s to observe messages")
-	time.Sleep(20 * time.Second)
-	fmt.Println("done")
+	if err := w.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatalf("worker: %v", err)
+	}
+	log.Printf("worker stopped")
 }
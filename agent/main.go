@@ -47,7 +47,7 @@ func main() {
 	fmt.Printf("created call %s caller_session %s\n", callID, callerSession)
 
 	// 2) simulate participant_joined webhook so server will spawn agent
-	webhook := map[string]any{"type": "participant_joined", "participant": map[string]string{"identity": callerSession}}
+	webhook := map[string]any{"event": "participant_joined", "participant": map[string]string{"identity": callerSession}}
 	wb, _ := json.Marshal(webhook)
 	wresp, err := http.Post(server+"/webhook/livekit", "application/json", bytes.NewReader(wb))
 	if err != nil {
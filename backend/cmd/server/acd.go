@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/acd"
+	"github.com/jacky-htg/ai-call-center/backend/internal/supervise"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// humanJSON is a human agent as served by the API. Token and URL let the agent join the
+// call routed to them until they answer it and are left out of the list of agents, as are
+// Whispers; Summary is the call so far if an AI agent escalated it.
+type humanJSON struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Skills     []string  `json:"skills"`
+	State      string    `json:"state"`
+	StateSince time.Time `json:"state_since"`
+	CallID     string    `json:"call_id,omitempty"`
+	SessionID  string    `json:"session_id,omitempty"`
+	Token      string    `json:"token,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Summary    string    `json:"summary,omitempty"`
+	// Whispers are the supervisors whispering to the agent.
+	Whispers []whisperJSON `json:"whispers,omitempty"`
+}
+
+// whisperJSON is a supervisor whispering to a human agent, with the token the agent joins
+// the whisper's room with to hear them.
+type whisperJSON struct {
+	SessionID    string `json:"session_id"`
+	SupervisorID string `json:"supervisor_id"`
+	Room         string `json:"room"`
+	Token        string `json:"token"`
+	URL          string `json:"url"`
+}
+
+// queueJSON is a queue as served by the API, with the calls waiting in it.
+type queueJSON struct {
+	Name           string           `json:"name"`
+	Skills         []string         `json:"skills"`
+	TimeoutSeconds int64            `json:"timeout_seconds"`
+	WrapUpSeconds  int64            `json:"wrap_up_seconds"`
+	Fallback       string           `json:"fallback"`
+	Waiting        []queuedCallJSON `json:"waiting"`
+}
+
+// queuedCallJSON is a call waiting in a queue for a human agent.
+type queuedCallJSON struct {
+	CallID      string    `json:"call_id"`
+	Position    int       `json:"position"`
+	Since       time.Time `json:"since"`
+	WaitSeconds int64     `json:"wait_seconds"`
+}
+
+func newHumanJSON(a acd.AgentStatus) humanJSON {
+	skills := a.Skills
+	if skills == nil {
+		skills = []string{}
+	}
+	return humanJSON{
+		ID:         a.ID,
+		Name:       a.Name,
+		Skills:     skills,
+		State:      a.State,
+		StateSince: a.StateSince.UTC(),
+		CallID:     a.CallID,
+		SessionID:  a.SessionID,
+		Token:      a.Token,
+		URL:        a.URL,
+		Summary:    a.Summary,
+	}
+}
+
+func newQueueJSON(q store.Queue, waiting []acd.Waiting, now time.Time) queueJSON {
+	out := queueJSON{
+		Name:           q.Name,
+		Skills:         q.Skills,
+		TimeoutSeconds: int64(q.Timeout / time.Second),
+		WrapUpSeconds:  int64(q.WrapUp / time.Second),
+		Fallback:       q.Fallback,
+		Waiting:        make([]queuedCallJSON, 0),
+	}
+	if out.Skills == nil {
+		out.Skills = []string{}
+	}
+	for _, c := range waiting {
+		if c.Queue == q.Name {
+			out.Waiting = append(out.Waiting, queuedCallJSON{
+				CallID:      c.CallID,
+				Position:    c.Position,
+				Since:       c.Since.UTC(),
+				WaitSeconds: int64(now.Sub(c.Since).Seconds()),
+			})
+		}
+	}
+	return out
+}
+
+// handleHumans serves GET /humans, listing the human agents, and POST /humans, registering
+// one. Agents start away unless registered available. Both need the secret as a bearer
+// token, as the calls routed to the agents can be read and taken through them.
+func handleHumans(w http.ResponseWriter, r *http.Request, router *acd.Router, secret string) {
+	if !bearerAuthorized(r, secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		out := make([]humanJSON, 0)
+		for _, a := range router.Agents() {
+			// only the agent joins their call: GET /humans/{id} has the token
+			h := newHumanJSON(a)
+			h.Token, h.URL = "", ""
+			out = append(out, h)
+		}
+		writeJSON(w, map[string][]humanJSON{"humans": out})
+	case http.MethodPost:
+		var body struct {
+			ID     string   `json:"id"`
+			Name   string   `json:"name"`
+			Skills []string `json:"skills"`
+			State  string   `json:"state"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		a, err := router.AddAgent(store.HumanAgent{ID: body.ID, Name: body.Name, Skills: body.Skills, State: body.State})
+		if errors.Is(err, acd.ErrUnknownState) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status, _ := router.Agent(a.ID)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, newHumanJSON(status))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHuman serves GET /humans/{id}, the agent with the call routed to them and the
+// supervisors whispering to them, and PUT /humans/{id}/state, which makes the agent
+// available or away. Both need the secret as a bearer token.
+func handleHuman(w http.ResponseWriter, r *http.Request, router *acd.Router, sup *supervise.Supervisor, secret string) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/humans/"), "/")
+	if id == "" || (action != "" && action != "state") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !bearerAuthorized(r, secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a, ok := router.Agent(id)
+		if !ok {
+			http.Error(w, "human agent not found", http.StatusNotFound)
+			return
+		}
+		h := newHumanJSON(a)
+		if a.SessionID != "" {
+			whispers, err := sup.Whispers(a.SessionID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, wh := range whispers {
+				h.Whispers = append(h.Whispers, whisperJSON{SessionID: wh.SessionID, SupervisorID: wh.SupervisorID, Room: wh.Room, Token: wh.Token, URL: wh.URL})
+			}
+		}
+		writeJSON(w, h)
+		return
+	}
+
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err := router.SetState(id, body.State)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "human agent not found", http.StatusNotFound)
+		return
+	case errors.Is(err, acd.ErrOnCall):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a, _ := router.Agent(id)
+	writeJSON(w, newHumanJSON(a))
+}
+
+// listQueues serves GET /queues: every queue with the calls waiting in it. It needs the
+// secret as a bearer token.
+func listQueues(w http.ResponseWriter, r *http.Request, st store.Repository, router *acd.Router, secret string) {
+	if !bearerAuthorized(r, secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	queues, err := st.ListQueues()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	waiting, now := router.Waiting(""), time.Now()
+	out := make([]queueJSON, 0, len(queues))
+	for _, q := range queues {
+		out = append(out, newQueueJSON(q, waiting, now))
+	}
+	writeJSON(w, map[string][]queueJSON{"queues": out})
+}
+
+// handleQueue serves GET /queues/{name}, the queue with the calls waiting in it, and
+// PUT /queues/{name}, which creates or replaces the queue. Both need the secret as a bearer
+// token.
+func handleQueue(w http.ResponseWriter, r *http.Request, st store.Repository, router *acd.Router, secret string) {
+	name := strings.TrimPrefix(r.URL.Path, "/queues/")
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !bearerAuthorized(r, secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Skills         []string `json:"skills"`
+			TimeoutSeconds int64    `json:"timeout_seconds"`
+			WrapUpSeconds  int64    `json:"wrap_up_seconds"`
+			Fallback       string   `json:"fallback"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		q := store.Queue{
+			Name:     name,
+			Skills:   body.Skills,
+			Timeout:  time.Duration(body.TimeoutSeconds) * time.Second,
+			WrapUp:   time.Duration(body.WrapUpSeconds) * time.Second,
+			Fallback: body.Fallback,
+		}
+		if err := router.SaveQueue(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := st.GetQueue(name)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "queue not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newQueueJSON(*q, router.Waiting(name), time.Now()))
+}
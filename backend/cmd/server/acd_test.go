@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/acd"
+	"github.com/jacky-htg/ai-call-center/backend/internal/supervise"
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+func TestHumanHandlers(t *testing.T) {
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	lk := livekitauth.Settings{URL: "ws://livekit", APIKey: "devkey", APISecret: "secret", TokenTTL: time.Hour}
+	router := acd.New(st, lk)
+	defer router.Close()
+	sup := supervise.New(st, lk)
+	const secret = "s3cret"
+
+	do := func(method, target, body, auth string, out any) int {
+		t.Helper()
+		var rd io.Reader
+		if body != "" {
+			rd = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, target, rd)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		switch {
+		case target == "/humans":
+			handleHumans(rec, req, router, secret)
+		case strings.HasPrefix(target, "/humans/"):
+			handleHuman(rec, req, router, sup, secret)
+		case target == "/queues":
+			listQueues(rec, req, st, router, secret)
+		default:
+			handleQueue(rec, req, st, router, secret)
+		}
+		if rec.Code < 300 && out != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+				t.Fatalf("%s %s: %v", method, target, err)
+			}
+		}
+		return rec.Code
+	}
+
+	// none of the endpoints answer without the secret
+	for _, req := range []struct{ method, target, body string }{
+		{http.MethodGet, "/humans", ""},
+		{http.MethodPost, "/humans", `{"id":"eve","name":"Eve","state":"available"}`},
+		{http.MethodGet, "/humans/eve", ""},
+		{http.MethodPut, "/humans/eve/state", `{"state":"available"}`},
+		{http.MethodGet, "/queues", ""},
+		{http.MethodGet, "/queues/billing", ""},
+		{http.MethodPut, "/queues/billing", `{"skills":["billing"]}`},
+	} {
+		for _, auth := range []string{"", "Bearer wrong"} {
+			if code := do(req.method, req.target, req.body, auth, nil); code != http.StatusUnauthorized {
+				t.Fatalf("%s %s with %q: status %d", req.method, req.target, auth, code)
+			}
+		}
+	}
+	if agents := router.Agents(); len(agents) != 0 {
+		t.Fatalf("agents registered without the secret: %+v", agents)
+	}
+
+	// the token to join a routed call is only served to the agent it is for
+	auth := "Bearer " + secret
+	if code := do(http.MethodPut, "/queues/billing", `{"skills":["billing"]}`, auth, nil); code != http.StatusOK {
+		t.Fatalf("PUT /queues/billing: status %d", code)
+	}
+	if code := do(http.MethodPost, "/humans", `{"id":"ann","name":"Ann","skills":["billing"],"state":"available"}`, auth, nil); code != http.StatusCreated {
+		t.Fatalf("POST /humans: status %d", code)
+	}
+	callID, _, err := st.CreateCall("kim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Enqueue(callID, "billing"); err != nil {
+		t.Fatal(err)
+	}
+	var ann humanJSON
+	if code := do(http.MethodGet, "/humans/ann", "", auth, &ann); code != http.StatusOK || ann.CallID != callID || ann.Token == "" || ann.URL == "" {
+		t.Fatalf("GET /humans/ann = %d %+v", code, ann)
+	}
+	var list struct {
+		Humans []humanJSON `json:"humans"`
+	}
+	if code := do(http.MethodGet, "/humans", "", auth, &list); code != http.StatusOK || len(list.Humans) != 1 {
+		t.Fatalf("GET /humans = %d %+v", code, list)
+	}
+	if h := list.Humans[0]; h.CallID != callID || h.Token != "" || h.URL != "" {
+		t.Fatalf("listed ann = %+v", h)
+	}
+
+	// ann hears the supervisor whispering to her through her own token for the whisper room
+	if err := st.UpdateSessionStatus(ann.SessionID, "active"); err != nil {
+		t.Fatal(err)
+	}
+	whisper, err := sup.Join(callID, "sup-1", livekitauth.SuperviseWhisper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := do(http.MethodGet, "/humans/ann", "", auth, &ann); code != http.StatusOK || len(ann.Whispers) != 1 {
+		t.Fatalf("GET /humans/ann while whispered to = %d %+v", code, ann)
+	}
+	if wh := ann.Whispers[0]; wh.SessionID != whisper.ID || wh.Room != whisper.WhisperRoom || wh.Token == "" || wh.URL != "ws://livekit" {
+		t.Fatalf("ann's whisper = %+v", wh)
+	}
+	if code := do(http.MethodGet, "/humans", "", auth, &list); code != http.StatusOK || len(list.Humans[0].Whispers) != 0 {
+		t.Fatalf("GET /humans while whispered to = %d %+v", code, list)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/blob"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// callJSON is a call as served by the API. Durations of calls and sessions still in
+// progress run up to the time of the request.
+type callJSON struct {
+	ID              string        `json:"id"`
+	CallerID        string        `json:"caller_id"`
+	Status          string        `json:"status"`
+	CreatedAt       time.Time     `json:"created_at"`
+	EndedAt         *time.Time    `json:"ended_at,omitempty"`
+	EndReason       string        `json:"end_reason,omitempty"`
+	Queue           string        `json:"queue,omitempty"`
+	DurationSeconds int64         `json:"duration_seconds"`
+	Sessions        []sessionJSON `json:"sessions,omitempty"`
+}
+
+// sessionJSON is a session as served by the API.
+type sessionJSON struct {
+	ID              string     `json:"id"`
+	CallID          string     `json:"call_id"`
+	UserID          string     `json:"user_id"`
+	Type            string     `json:"type"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"created_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	EndReason       string     `json:"end_reason,omitempty"`
+	Mode            string     `json:"mode,omitempty"`
+	DurationSeconds int64      `json:"duration_seconds"`
+}
+
+// callListJSON is a page of calls; NextCursor is set when there are more.
+type callListJSON struct {
+	Calls      []callJSON `json:"calls"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+func newCallJSON(c store.Call, now time.Time) callJSON {
+	return callJSON{
+		ID:              c.ID,
+		CallerID:        c.CallerID,
+		Status:          c.Status,
+		CreatedAt:       c.CreatedAt.UTC(),
+		EndedAt:         optionalTime(c.EndedAt),
+		EndReason:       c.EndReason,
+		Queue:           c.Queue,
+		DurationSeconds: durationSeconds(c.CreatedAt, c.EndedAt, now),
+	}
+}
+
+func newSessionJSON(s store.Session, now time.Time) sessionJSON {
+	return sessionJSON{
+		ID:              s.ID,
+		CallID:          s.CallID,
+		UserID:          s.UserID,
+		Type:            s.Type,
+		Status:          s.Status,
+		CreatedAt:       s.CreatedAt.UTC(),
+		EndedAt:         optionalTime(s.EndedAt),
+		EndReason:       s.EndReason,
+		Mode:            s.Mode,
+		DurationSeconds: durationSeconds(s.CreatedAt, s.EndedAt, now),
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+func durationSeconds(created, ended, now time.Time) int64 {
+	if created.IsZero() {
+		return 0
+	}
+	if ended.IsZero() {
+		ended = now
+	}
+	if d := int64(ended.Sub(created) / time.Second); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// listCalls serves GET /calls. Query parameters: status, caller_id, from and to (RFC 3339
+// or Unix seconds, bounding the creation time to [from, to)), sort (created_at or the
+// default -created_at), limit and cursor (next_cursor of the previous page).
+func listCalls(w http.ResponseWriter, r *http.Request, st store.Repository) {
+	q := r.URL.Query()
+	f := store.CallFilter{
+		Status:   q.Get("status"),
+		CallerID: q.Get("caller_id"),
+		Cursor:   q.Get("cursor"),
+	}
+	var err error
+	if f.From, err = parseTime(q.Get("from")); err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.To, err = parseTime(q.Get("to")); err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch q.Get("sort") {
+	case "", "-created_at":
+	case "created_at":
+		f.Ascending = true
+	default:
+		http.Error(w, "invalid sort: use created_at or -created_at", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 || f.Limit > store.MaxPageSize {
+			http.Error(w, fmt.Sprintf("invalid limit: use 1 to %d", store.MaxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	calls, next, err := st.ListCalls(f)
+	if errors.Is(err, store.ErrBadCursor) {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	out := callListJSON{Calls: make([]callJSON, 0, len(calls)), NextCursor: next}
+	for _, c := range calls {
+		out.Calls = append(out.Calls, newCallJSON(c, now))
+	}
+	writeJSON(w, out)
+}
+
+// getCall serves GET /calls/{id}: the call with its sessions.
+func getCall(w http.ResponseWriter, st store.Repository, callID string) {
+	c, err := st.GetCall(callID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "call not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sessions, err := st.ListSessions(callID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	out := newCallJSON(*c, now)
+	out.Sessions = make([]sessionJSON, 0, len(sessions))
+	for _, s := range sessions {
+		out.Sessions = append(out.Sessions, newSessionJSON(s, now))
+	}
+	writeJSON(w, out)
+}
+
+// getSession serves GET /sessions/{id}.
+func getSession(w http.ResponseWriter, st store.Repository, sessionID string) {
+	s, err := st.GetSession(sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newSessionJSON(*s, time.Now()))
+}
+
+// getCallRecording serves GET /calls/{id}/recording: the call's latest finished recording.
+// Range requests are supported so players can seek.
+func getCallRecording(w http.ResponseWriter, r *http.Request, st store.Repository, blobs blob.Store, callID string) {
+	rec, ok := callRecording(w, st, callID)
+	if !ok {
+		return
+	}
+	obj, err := blobs.Open(r.Context(), rec.Key)
+	if errors.Is(err, blob.ErrNotFound) {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+	w.Header().Set("Content-Type", rec.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", callID+path.Ext(rec.Key)))
+	http.ServeContent(w, r, "", rec.EndedAt, obj)
+}
+
+// recordingURLExpiry is how long URLs from GET /calls/{id}/recording/url stay valid.
+const recordingURLExpiry = 15 * time.Minute
+
+// recordingURLJSON is a signed download URL of a recording.
+type recordingURLJSON struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// getCallRecordingURL serves GET /calls/{id}/recording/url: a signed URL that downloads the
+// call's latest recording straight from storage, without credentials, for a while.
+func getCallRecordingURL(w http.ResponseWriter, r *http.Request, st store.Repository, blobs blob.Store, callID string) {
+	rec, ok := callRecording(w, st, callID)
+	if !ok {
+		return
+	}
+	expires := time.Now().Add(recordingURLExpiry)
+	u, err := blobs.SignedURL(r.Context(), rec.Key, recordingURLExpiry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, recordingURLJSON{URL: u, ExpiresAt: expires.UTC().Truncate(time.Second)})
+}
+
+// callRecording looks up the call's latest recording, answering the request if there is none.
+func callRecording(w http.ResponseWriter, st store.Repository, callID string) (*store.Recording, bool) {
+	rec, err := st.GetCallRecording(callID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return rec, true
+}
+
+// parseTime parses an RFC 3339 time or Unix seconds; empty is the zero time.
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
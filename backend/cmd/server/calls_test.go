@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/blob"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+func TestCallQueryHandlers(t *testing.T) {
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ended, endedSession, err := st.CreateCall("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateCallStatus(ended, "ended"); err != nil {
+		t.Fatal(err)
+	}
+	live, _, err := st.CreateCall("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateSession(live, "ai-agent", "agent", "active"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string, handle func(http.ResponseWriter, *http.Request), out any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handle(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code == http.StatusOK && out != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+				t.Fatalf("GET %s: %v", target, err)
+			}
+		}
+		return rec.Code
+	}
+	list := func(w http.ResponseWriter, r *http.Request) { listCalls(w, r, st) }
+
+	var page callListJSON
+	if code := get("/calls?caller_id=alice", list, &page); code != http.StatusOK {
+		t.Fatalf("list status %d", code)
+	}
+	if len(page.Calls) != 1 || page.Calls[0].ID != ended || page.Calls[0].EndedAt == nil || page.NextCursor != "" {
+		t.Fatalf("alice's calls = %+v", page)
+	}
+	if code := get("/calls?status=active&limit=1", list, &page); code != http.StatusOK || len(page.Calls) != 0 {
+		t.Fatalf("active calls = %d %+v", code, page)
+	}
+	for _, target := range []string{"/calls?limit=0", "/calls?from=yesterday", "/calls?sort=status", "/calls?cursor=%25"} {
+		if code := get(target, list, nil); code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want 400", target, code)
+		}
+	}
+
+	var call callJSON
+	if code := get("/calls/"+live, func(w http.ResponseWriter, r *http.Request) { getCall(w, st, live) }, &call); code != http.StatusOK {
+		t.Fatalf("get call status %d", code)
+	}
+	if call.CallerID != "bob" || call.EndedAt != nil || len(call.Sessions) != 2 {
+		t.Fatalf("call = %+v", call)
+	}
+	// both sessions started in the same second, so their order is not fixed
+	if types := call.Sessions[0].Type + "," + call.Sessions[1].Type; types != "caller,agent" && types != "agent,caller" {
+		t.Fatalf("session types %s", types)
+	}
+	if code := get("/calls/missing", func(w http.ResponseWriter, r *http.Request) { getCall(w, st, "missing") }, nil); code != http.StatusNotFound {
+		t.Fatalf("missing call status %d", code)
+	}
+
+	var sess sessionJSON
+	if code := get("/sessions/"+endedSession, func(w http.ResponseWriter, r *http.Request) { getSession(w, st, endedSession) }, &sess); code != http.StatusOK {
+		t.Fatalf("get session status %d", code)
+	}
+	if sess.CallID != ended || sess.UserID != "alice" {
+		t.Fatalf("session = %+v", sess)
+	}
+	if code := get("/sessions/missing", func(w http.ResponseWriter, r *http.Request) { getSession(w, st, "missing") }, nil); code != http.StatusNotFound {
+		t.Fatalf("missing session status %d", code)
+	}
+}
+
+func TestGetCallRecording_ServesRanges(t *testing.T) {
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	callID, _, err := st.CreateCall("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blob.NewLocal(t.TempDir(), "http://calls.test/blobs/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(header string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/calls/"+callID+"/recording", nil)
+		if header != "" {
+			req.Header.Set("Range", header)
+		}
+		getCallRecording(rec, req, st, blobs, callID)
+		return rec
+	}
+	if rec := get(""); rec.Code != http.StatusNotFound {
+		t.Fatalf("status without recording %d", rec.Code)
+	}
+
+	key := blob.PrefixRecordings + callID + "/agent.wav"
+	if err := blobs.Put(context.Background(), key, strings.NewReader("RIFF0123456789"), "audio/wav"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := st.CreateRecording(store.Recording{CallID: callID, Key: key, ContentType: "audio/wav", Channels: 1, SampleRate: 16000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.FinishRecording(id, 14, time.Second, store.RecordingReady); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get("")
+	if rec.Code != http.StatusOK || rec.Body.String() != "RIFF0123456789" || rec.Header().Get("Content-Type") != "audio/wav" || rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("full response %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+	rec = get("bytes=4-7")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "0123" || rec.Header().Get("Content-Range") != "bytes 4-7/14" {
+		t.Fatalf("range response %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+	if rec := get("bytes=20-"); rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unsatisfiable range status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	getCallRecordingURL(rec, httptest.NewRequest(http.MethodGet, "/calls/"+callID+"/recording/url", nil), st, blobs, callID)
+	var signed recordingURLJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &signed); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("recording url response %d %q", rec.Code, rec.Body)
+	}
+	if !strings.HasPrefix(signed.URL, "http://calls.test/blobs/"+key+"?") || signed.ExpiresAt.Before(time.Now()) {
+		t.Fatalf("recording url = %+v", signed)
+	}
+
+	if err := blobs.Delete(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(""); rec.Code != http.StatusNotFound {
+		t.Fatalf("status with missing blob %d", rec.Code)
+	}
+}
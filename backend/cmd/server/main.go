@@ -1,37 +1,55 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/jacky-htg/ai-call-center/backend/internal/acd"
 	"github.com/jacky-htg/ai-call-center/backend/internal/agentmgr"
 	"github.com/jacky-htg/ai-call-center/backend/internal/agents"
 	"github.com/jacky-htg/ai-call-center/backend/internal/factory"
+	"github.com/jacky-htg/ai-call-center/backend/internal/livekitclient"
+	"github.com/jacky-htg/ai-call-center/backend/internal/retention"
+	"github.com/jacky-htg/ai-call-center/backend/internal/supervise"
+	"github.com/jacky-htg/ai-call-center/backend/internal/transcript"
+	"github.com/jacky-htg/ai-call-center/backend/internal/workers"
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/blob"
 	"github.com/jacky-htg/ai-call-center/libs/config"
+	"github.com/jacky-htg/ai-call-center/libs/dispatch"
 	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/livekit/webhook"
 	"github.com/jacky-htg/ai-call-center/libs/store"
 
 	_ "modernc.org/sqlite"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	fmt.Println("ai-call-center server (demo) starting")
 
 	// Load config from environment (with sane defaults). You can override using env vars:
 	// TTS_VENDOR, STT_VENDOR, LLM_VENDOR, WEBRTC_VENDOR, WHISPER_ENDPOINT
 	cfg := config.LoadFromEnv()
 
+	// ctx is cancelled on SIGINT/SIGTERM so outstanding vendor work is aborted on shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	tts, err := factory.NewTTS(cfg)
 	if err != nil {
 		log.Fatalf("new tts: %v", err)
@@ -44,36 +62,130 @@ func main() {
 	if err != nil {
 		log.Fatalf("new llm: %v", err)
 	}
+	// agents and announcements talk to callers in Opus
+	if err := livekitclient.CheckCodec(); err != nil {
+		log.Fatalf("audio codec: %v", err)
+	}
 	webrtc, err := factory.NewWebRTC(cfg)
 	if err != nil {
 		log.Fatalf("new webrtc: %v", err)
 	}
+	vad, err := factory.NewVAD(cfg)
+	if err != nil {
+		log.Fatalf("new vad: %v", err)
+	}
+	recording, err := factory.NewRecording(cfg)
+	if err != nil {
+		log.Fatalf("new recording: %v", err)
+	}
+
+	lk, err := livekitauth.SettingsFrom(cfg.VendorSettings["livekit"])
+	if err != nil {
+		log.Fatalf("livekit settings: %v", err)
+	}
 
 	agent := agents.New(tts, stt, llm, webrtc)
 
-	// Open SQLite DB
-	dbPath := os.Getenv("DATABASE_PATH")
-	if dbPath == "" {
-		dbPath = "data/ai.callcenter.db"
-	}
-	if err := os.MkdirAll("data", 0755); err != nil {
-		log.Fatalf("create data dir: %v", err)
-	}
-	st, err := store.Open(dbPath)
+	// Open the store (SQLite or Postgres), applying pending migrations
+	st, err := factory.NewStore(cfg)
 	if err != nil {
-		log.Fatalf("open db: %v", err)
+		log.Fatalf("open store: %v", err)
 	}
 	defer st.Close()
 
 	// agent manager handles creating/stopping AI agent sessions (logical join/leave)
-	mgr := agentmgr.New(st, cfg, tts, llm, stt)
+	mgr := agentmgr.New(st, cfg, tts, llm, stt, vad)
+	mgr.SetRecording(recording)
+
+	// agents beyond the limits wait in a queue; callers turned away have their room closed
+	limits, err := factory.NewLimits(cfg)
+	if err != nil {
+		log.Fatalf("agent limits: %v", err)
+	}
+	mgr.SetLimits(limits)
+	if rooms, ok := webrtc.(agentmgr.RoomCloser); ok {
+		mgr.SetRooms(rooms)
+	}
+
+	// calls created with a queue wait there for a human agent instead of getting an AI one
+	router := acd.New(st, lk)
+	router.SetAI(mgr)
+	if rooms, ok := webrtc.(acd.RoomCloser); ok {
+		router.SetRooms(rooms)
+	}
+	if err := router.Load(); err != nil {
+		log.Fatalf("load human agents: %v", err)
+	}
+	defer router.Close()
 
-	// Ensure output dir exists
-	outDir := "out"
-	if err := os.MkdirAll(outDir, 0755); err != nil {
-		log.Fatalf("mkdir out: %v", err)
+	// supervisors listen to calls, whisper to their human agents or barge in; whispers are
+	// kept from everyone else in the call
+	supervisor := supervise.New(st, lk)
+	if rooms, ok := webrtc.(supervise.Rooms); ok {
+		supervisor.SetRooms(rooms)
 	}
 
+	// AI agents hand calls they cannot handle to the escalation queue
+	escalation, err := factory.NewEscalation(cfg)
+	if err != nil {
+		log.Fatalf("escalation: %v", err)
+	}
+	mgr.SetEscalation(escalation, router)
+
+	// recordings and synthesized audio are kept in the blob store
+	blobs, err := factory.NewBlobStore(cfg)
+	if err != nil {
+		log.Fatalf("new blob store: %v", err)
+	}
+	lifecycle, err := factory.BlobLifecycle(cfg)
+	if err != nil {
+		log.Fatalf("blob lifecycle: %v", err)
+	}
+	if err := blobs.SetLifecycle(ctx, lifecycle); err != nil {
+		log.Printf("set blob lifecycle: %v", err)
+	}
+	if exp, ok := blobs.(blob.Expirer); ok {
+		go expireBlobs(ctx, exp)
+	}
+	mgr.SetBlobStore(blobs)
+
+	// tokens for anyone but callers need the AGENT_TOKEN_ENDPOINT_SECRET bearer, as do
+	// agent workers
+	tokenSecret := os.Getenv("AGENT_TOKEN_ENDPOINT_SECRET")
+
+	// agents run in this process unless they are dispatched to agent workers
+	var pool *workers.Pool
+	switch cfg.AgentDispatch {
+	case "inprocess":
+	case "workers":
+		if tokenSecret == "" {
+			log.Fatalf("agent dispatch to workers needs AGENT_TOKEN_ENDPOINT_SECRET")
+		}
+		if escalation.Queue != "" {
+			log.Fatalf("agent workers do not escalate: unset ESCALATION_QUEUE or dispatch agents in process")
+		}
+		pool = workers.New(tokenSecret, st, mgr.HandleWorkerStatus)
+		mgr.SetDispatcher(pool)
+	default:
+		log.Fatalf("unknown agent dispatch %q", cfg.AgentDispatch)
+	}
+
+	// calls left active by an earlier run have no agents behind them: rejoin or end them
+	if rooms, ok := webrtc.(agentmgr.RoomLister); ok {
+		recoverCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := mgr.Recover(recoverCtx, rooms); err != nil {
+			log.Printf("recover agents: %v", err)
+		}
+		cancel()
+	}
+
+	// expired call data is deleted or anonymized in the background
+	rs, err := factory.NewRetention(cfg)
+	if err != nil {
+		log.Fatalf("retention: %v", err)
+	}
+	go retention.New(st, blobs, rs.Policies).Start(ctx, rs.Interval)
+
 	// Prefer a real WAV test file if present
 	inputPath := filepath.Join("testdata", "sample.raw")
 	jfkPath := filepath.Join("testdata", "jfk.wav")
@@ -81,22 +193,27 @@ func main() {
 		inputPath = jfkPath
 		fmt.Println("Using testdata/jfk.wav as input")
 	}
-	outputPath := filepath.Join(outDir, "output.wav")
-
-	if err := agent.HandleAudioFile(inputPath, outputPath); err != nil {
+	if err := agent.HandleAudioFile(ctx, inputPath, blobs, blob.PrefixTTS+"output.wav"); err != nil {
 		log.Fatalf("handle audio file: %v", err)
 	}
 
 	fmt.Println("demo finished")
 
-	// POST /calls - create call + session and return token
+	// POST /calls - create call + session and return token; calls with a queue are routed
+	// to human agents
+	// GET /calls - list calls, filtered and paginated
 	http.HandleFunc("/calls", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			listCalls(w, r, st)
+			return
+		}
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		var body struct {
 			CallerID string `json:"caller_id"`
+			Queue    string `json:"queue"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
@@ -106,175 +223,219 @@ func main() {
 		if body.CallerID == "" {
 			body.CallerID = fmt.Sprintf("user-%d", time.Now().UnixNano())
 		}
+		if body.Queue != "" {
+			if _, err := st.GetQueue(body.Queue); errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "unknown queue", http.StatusBadRequest)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		callID, sessionID, err := st.CreateCall(body.CallerID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if body.Queue != "" {
+			if err := st.UpdateCallQueue(callID, body.Queue); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		// generate token for caller
-		lk := cfg.VendorSettings["livekit"]
-		apiKey, apiSecret, url := "", "", ""
-		if lk != nil {
-			apiKey = lk["api_key"]
-			apiSecret = lk["api_secret"]
-			url = lk["url"]
-		}
-		token, err := livekitauth.GenerateAccessToken(apiKey, apiSecret, callID, sessionID, 3600)
+		token, err := livekitauth.ParticipantToken(lk, livekitauth.RoleCaller, callID, sessionID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		resp := map[string]string{"call_id": callID, "session_id": sessionID, "token": token, "url": url}
+		resp := map[string]string{"call_id": callID, "session_id": sessionID, "token": token, "url": lk.URL}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(resp)
 	})
 
-	// LiveKit token endpoint
-	http.HandleFunc("/livekit/token", func(w http.ResponseWriter, r *http.Request) {
-		room := r.URL.Query().Get("room")
-		if room == "" {
-			room = "default"
+	// GET /calls/{id} - the call with its sessions
+	// GET /calls/{id}/transcript - the call's conversation as JSON, text, SRT or WebVTT;
+	// the format comes from ?format= or, failing that, the Accept header
+	// POST /calls/{id}/supervise - a supervisor's token to listen, whisper or barge in
+	http.HandleFunc("/calls/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/calls/")
+		parts := strings.SplitN(path, "/", 2)
+		if parts[0] == "" || (len(parts) == 2 && parts[1] != "transcript" && parts[1] != "recording" && parts[1] != "recording/url" && parts[1] != "supervise") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
 		}
-		identity := r.URL.Query().Get("identity")
-		if identity == "" {
-			identity = "ai-agent"
+		if len(parts) == 2 && parts[1] == "supervise" {
+			handleSupervise(w, r, supervisor, tokenSecret, parts[0])
+			return
 		}
-
-		// read livekit config from env via config loader
-		lk := cfg.VendorSettings["livekit"]
-		apiKey := ""
-		apiSecret := ""
-		url := ""
-		if lk != nil {
-			apiKey = lk["api_key"]
-			apiSecret = lk["api_secret"]
-			url = lk["url"]
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		callID := parts[0]
+		if len(parts) == 1 {
+			getCall(w, st, callID)
+			return
+		}
+		switch parts[1] {
+		case "recording":
+			getCallRecording(w, r, st, blobs, callID)
+			return
+		case "recording/url":
+			getCallRecordingURL(w, r, st, blobs, callID)
+			return
 		}
 
-		token, err := livekitauth.GenerateAccessToken(apiKey, apiSecret, room, identity, 3600)
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = transcriptFormat(r.Header.Get("Accept"))
+		}
+		contentType, ok := transcript.ContentType(format)
+		if !ok {
+			http.Error(w, "unsupported format", http.StatusBadRequest)
+			return
+		}
+		if exists, err := st.CallExists(callID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !exists {
+			http.Error(w, "call not found", http.StatusNotFound)
+			return
+		}
+		turns, err := st.ListTurns(callID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("Content-Type", contentType)
+		if err := transcript.Write(w, format, callID, turns); err != nil {
+			log.Printf("write transcript of call %s: %v", callID, err)
+		}
+	})
 
-		resp := map[string]string{"url": url, "token": token, "apiKey": apiKey}
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(resp)
+	// LiveKit token endpoint: caller tokens for anyone, other roles only with the
+	// AGENT_TOKEN_ENDPOINT_SECRET bearer
+	http.HandleFunc("/livekit/token", func(w http.ResponseWriter, r *http.Request) {
+		issueToken(w, r, lk, tokenSecret)
+	})
+
+	// POST /livekit/token/refresh - exchange a token that is still valid for a fresh one,
+	// so long calls outlive the token TTL; only tokens of active sessions are refreshed
+	http.HandleFunc("/livekit/token/refresh", func(w http.ResponseWriter, r *http.Request) {
+		refreshToken(w, r, st, lk)
 	})
 
 	// LiveKit webhook handler - sync participant join/leave
+	hooks := webhook.NewReceiver(lk.APIKey, lk.APISecret)
 	http.HandleFunc("/webhook/livekit", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		// read raw body for signature verification and JSON parsing
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "failed to read body", http.StatusBadRequest)
-			return
-		}
-
-		// verify signature if livekit secret is available
-		lk := cfg.VendorSettings["livekit"]
-		if lk != nil {
-			secret := lk["api_secret"]
-			if secret != "" {
-				sig := r.Header.Get("X-LiveKit-Signature")
-				if sig == "" {
-					// try alternate header
-					sig = r.Header.Get("Livekit-Signature")
-				}
-				if sig == "" {
-					http.Error(w, "missing signature", http.StatusUnauthorized)
-					return
-				}
-				mac := hmac.New(sha256.New, []byte(secret))
-				mac.Write(body)
-				expected := mac.Sum(nil)
-
-				// compare hex
-				hexExp := hex.EncodeToString(expected)
-				if hmac.Equal([]byte(hexExp), []byte(sig)) {
-					// ok
-				} else {
-					// compare base64
-					b64 := base64.StdEncoding.EncodeToString(expected)
-					if !hmac.Equal([]byte(b64), []byte(sig)) {
-						http.Error(w, "invalid signature", http.StatusUnauthorized)
-						return
-					}
-				}
+		// verify the delivery if livekit credentials are configured; without them (local
+		// development) events are accepted unsigned
+		var evt *webhook.Event
+		var err error
+		if lk.APISecret != "" {
+			evt, err = hooks.Receive(r)
+			if errors.Is(err, webhook.ErrReplayed) {
+				// duplicate delivery: acknowledge it so LiveKit stops retrying, but do not act twice
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if err != nil {
+				log.Printf("rejected livekit webhook: %v", err)
+				http.Error(w, "invalid webhook", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "failed to read body", http.StatusBadRequest)
+				return
+			}
+			if evt, err = webhook.Decode(body); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
 			}
 		}
-
-		var evt map[string]interface{}
-		if err := json.Unmarshal(body, &evt); err != nil {
-			http.Error(w, "bad request", http.StatusBadRequest)
+		// whisper rooms hold a supervisor and a human agent, not a call; the sessions of
+		// both follow the call's room
+		if evt.Room != nil && livekitauth.IsWhisperRoom(evt.Room.Name) {
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		// event types include "participant_joined", "participant_left" etc.
-		t, _ := evt["type"].(string)
-		// try to extract identity
 		identity := ""
-		if p, ok := evt["participant"].(map[string]interface{}); ok {
-			if id, ok := p["identity"].(string); ok {
-				identity = id
-			}
+		if evt.Participant != nil {
+			identity = evt.Participant.Identity
 		}
 
-		switch t {
-		case "participant_joined":
+		switch evt.Event {
+		case webhook.EventParticipantJoined:
 			if identity != "" {
 				// mark session active
 				_ = st.UpdateSessionStatus(identity, "active")
 				// if this participant corresponds to a caller, mark call active
 				if callID, _, err := st.FindSessionByIdentity(identity); err == nil {
 					_ = st.UpdateCallStatus(callID, "active")
-					// Only spawn agent if this is a caller (not the agent itself)
-					// Check if this is a caller session by checking session type
-					var sessionType string
-					row := st.DB.QueryRow(`SELECT type FROM sessions WHERE id = ?`, identity)
-					if err := row.Scan(&sessionType); err == nil && sessionType == "caller" {
-						// spawn an AI agent for this call (creates session, returns token)
-						agentSessionID, token, err := mgr.SpawnAgent(callID)
-						if err != nil {
-							log.Printf("failed to spawn agent for call %s: %v", callID, err)
+					sess, err := st.GetSession(identity)
+					if err == nil && sess.Type == acd.SessionType {
+						// the human agent took the call; an AI agent that escalated it leaves
+						router.Answered(identity)
+						mgr.HandOver(callID)
+					}
+					// Only route the call when the caller joins (not the agent itself)
+					if err == nil && sess.Type == "caller" {
+						if call, err := st.GetCall(callID); err == nil && call.Queue != "" {
+							// wait in the call's queue for a human agent
+							if err := router.Enqueue(callID, call.Queue); err != nil {
+								log.Printf("failed to queue call %s: %v", callID, err)
+							}
 						} else {
-							log.Printf("spawned agent session=%s token_len=%d for call=%s", agentSessionID, len(token), callID)
+							// spawn an AI agent for this call (creates session, returns token)
+							agentSessionID, token, err := mgr.SpawnAgent(callID)
+							if err != nil {
+								log.Printf("failed to spawn agent for call %s: %v", callID, err)
+							} else {
+								log.Printf("spawned agent session=%s token_len=%d for call=%s", agentSessionID, len(token), callID)
+							}
 						}
 					}
 				}
 			}
-		case "participant_left":
+		case webhook.EventParticipantLeft:
 			if identity != "" {
 				_ = st.UpdateSessionStatus(identity, "ended")
 				if callID, _, err := st.FindSessionByIdentity(identity); err == nil {
+					sess, err := st.GetSession(identity)
+					if err == nil && sess.Type == acd.SessionType {
+						// the human agent left: they wrap up and the call ends
+						router.AgentLeft(identity)
+					}
 					// Check if this is the caller leaving - if so, stop agent
-					var sessionType string
-					row := st.DB.QueryRow(`SELECT type FROM sessions WHERE id = ?`, identity)
-					if err := row.Scan(&sessionType); err == nil && sessionType == "caller" {
-						// Caller left, stop agent and end call
-						if err := mgr.StopAgent(callID); err != nil {
+					if err == nil && sess.Type == "caller" {
+						// Caller left, take the call out of the queues, stop its agent and end it
+						queued := router.Abandon(callID)
+						if err := mgr.StopAgent(callID); err != nil && !queued {
 							log.Printf("stop agent error for call %s: %v", callID, err)
 						}
 						_ = st.UpdateCallStatus(callID, "ended")
 					}
 				}
 			}
-		case "room_disconnected", "room_ended":
-			// Handle room disconnection - end all calls in this room
-			if room, ok := evt["room"].(map[string]interface{}); ok {
-				if roomName, ok := room["name"].(string); ok {
-					// Find call by room name (callID = room name)
-					_ = st.UpdateCallStatus(roomName, "ended")
-					// Stop agent if exists
-					if err := mgr.StopAgent(roomName); err != nil {
-						log.Printf("stop agent error for call %s: %v", roomName, err)
-					}
-					log.Printf("Room %s disconnected, call ended", roomName)
+		case webhook.EventRoomFinished:
+			// the room is the call (callID = room name); end it and stop its agent
+			if evt.Room != nil && evt.Room.Name != "" {
+				roomName := evt.Room.Name
+				_ = st.UpdateCallStatus(roomName, "ended")
+				queued := router.Abandon(roomName)
+				if err := mgr.StopAgent(roomName); err != nil && !queued {
+					log.Printf("stop agent error for call %s: %v", roomName, err)
 				}
+				supervisor.CallEnded(r.Context(), roomName)
+				log.Printf("Room %s finished, call ended", roomName)
 			}
 		default:
 			// ignore other events for now
@@ -283,15 +444,23 @@ func main() {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
-	// Endpoint for external agent interactions under /sessions/{id}/...
+	// GET /sessions/{id} - the session; external agent interactions under /sessions/{id}/...
 	http.HandleFunc("/sessions/", func(w http.ResponseWriter, r *http.Request) {
-		// path after prefix should be {id}/<action>
+		// path after prefix should be {id} or {id}/<action>
 		path := strings.TrimPrefix(r.URL.Path, "/sessions/")
 		parts := strings.SplitN(path, "/", 2)
-		if len(parts) != 2 {
+		if parts[0] == "" {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
+		if len(parts) == 1 {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			getSession(w, st, parts[0])
+			return
+		}
 		sessionID := parts[0]
 		action := parts[1]
 
@@ -301,12 +470,15 @@ func main() {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
-			audio, err := io.ReadAll(r.Body)
-			if err != nil {
-				http.Error(w, "failed to read body", http.StatusBadRequest)
+			transcript, err := mgr.ProcessIncomingAudio(r.Context(), sessionID, r.Body)
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, audio.ErrUnsupportedAudio) {
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 				return
 			}
-			transcript, err := mgr.ProcessIncomingAudio(sessionID, audio)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -342,7 +514,7 @@ func main() {
 			}
 
 			token, err := st.GetSessionToken(sessionID)
-			if err != nil {
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
 				http.Error(w, "failed to get token", http.StatusInternalServerError)
 				return
 			}
@@ -360,18 +532,120 @@ func main() {
 		}
 	})
 
-	go func() {
-		srvPort := os.Getenv("LIVEKIT_HTTP_PORT")
-		if srvPort == "" {
-			srvPort = "8080"
+	// GET /retention/audit - the audit trail of purged call data
+	http.HandleFunc("/retention/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		listPurgeAudit(w, r, st)
+	})
+
+	// GET /agents/occupancy - agents running against the limits and calls waiting for one
+	http.HandleFunc("/agents/occupancy", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
-		addr := ":" + srvPort
-		log.Printf("livekit token server listening on %s", addr)
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		writeOccupancy(w, mgr.Occupancy())
+	})
+
+	// GET /humans - the human agents; POST /humans - register one. The human agent and
+	// queue endpoints need the AGENT_TOKEN_ENDPOINT_SECRET bearer.
+	http.HandleFunc("/humans", func(w http.ResponseWriter, r *http.Request) {
+		handleHumans(w, r, router, tokenSecret)
+	})
+	// GET /humans/{id} - the agent, the call routed to them and the supervisors whispering
+	// to them
+	// PUT /humans/{id}/state - make the agent available or away
+	http.HandleFunc("/humans/", func(w http.ResponseWriter, r *http.Request) {
+		handleHuman(w, r, router, supervisor, tokenSecret)
+	})
+
+	// GET /queues - the queues with their waiting calls
+	http.HandleFunc("/queues", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		listQueues(w, r, st, router, tokenSecret)
+	})
+	// GET /queues/{name} - the queue; PUT /queues/{name} - create or replace it
+	http.HandleFunc("/queues/", func(w http.ResponseWriter, r *http.Request) {
+		handleQueue(w, r, st, router, tokenSecret)
+	})
+
+	// GET /workers - the connected agent workers; workers connect at dispatch.Path
+	if pool != nil {
+		http.Handle(dispatch.Path, pool)
+		http.HandleFunc("/workers", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			listWorkers(w, pool)
+		})
+	}
+
+	// GET /blobs/{key} - downloads of signed URLs of the local blob store
+	if local, ok := blobs.(*blob.Local); ok {
+		http.Handle("/blobs/", http.StripPrefix("/blobs/", local.Handler()))
+	}
+
+	srvPort := os.Getenv("LIVEKIT_HTTP_PORT")
+	if srvPort == "" {
+		srvPort = "8080"
+	}
+	srv := &http.Server{Addr: ":" + srvPort}
+	go func() {
+		log.Printf("livekit token server listening on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("token server failed: %v", err)
 		}
 	}()
 
-	// keep the process running so the token server is available
-	select {}
+	// keep the process running until interrupted, then stop agents and drain requests
+	<-ctx.Done()
+	log.Printf("shutting down")
+	mgr.Shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+}
+
+// blobExpiryInterval is how often blobs past their retention are deleted from stores that
+// do not expire them themselves.
+const blobExpiryInterval = time.Hour
+
+// expireBlobs enforces the blob store's lifecycle until ctx is cancelled.
+func expireBlobs(ctx context.Context, exp blob.Expirer) {
+	ticker := time.NewTicker(blobExpiryInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := exp.Expire(ctx); err != nil {
+			log.Printf("expire blobs: %v", err)
+		} else if n > 0 {
+			log.Printf("expired %d blobs", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// transcriptFormat picks the transcript format for an Accept header, defaulting to JSON.
+func transcriptFormat(accept string) string {
+	switch {
+	case strings.Contains(accept, "text/vtt"):
+		return transcript.FormatVTT
+	case strings.Contains(accept, "application/x-subrip"):
+		return transcript.FormatSRT
+	case strings.Contains(accept, "text/plain"):
+		return transcript.FormatText
+	}
+	return transcript.FormatJSON
 }
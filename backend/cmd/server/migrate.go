@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/factory"
+	"github.com/jacky-htg/ai-call-center/libs/config"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  status          list migrations and whether they are applied
+  up [version]    apply pending migrations, up to version if given
+  down <version>  revert applied migrations above version (0 reverts all)`
+
+// runMigrate implements the migrate subcommand and returns the process exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	version := 0
+	if len(args) == 2 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			fmt.Fprintf(os.Stderr, "invalid version %q\n", args[1])
+			return 2
+		}
+		version = v
+	}
+
+	driver, dsn, err := factory.Database(config.LoadFromEnv())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "database: %v\n", err)
+		return 1
+	}
+	st, err := store.OpenUnmigrated(driver, dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open db: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		err = printMigrationStatus(st)
+	case args[0] == "up":
+		err = st.MigrateUp(version)
+	case args[0] == "down" && len(args) == 2:
+		err = st.MigrateDown(version)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s: %v\n", args[0], err)
+		return 1
+	}
+	if args[0] != "status" {
+		current, err := st.SchemaVersion()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "schema version: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s: schema at version %d\n", driver, current)
+	}
+	return 0
+}
+
+func printMigrationStatus(st *store.Store) error {
+	status, err := st.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, m := range status {
+		applied := "pending"
+		if m.Applied {
+			applied = m.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, applied)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/agentmgr"
+)
+
+// occupancyJSON is the load of the AI agents as served by the API. Limits of zero mean no cap.
+type occupancyJSON struct {
+	Active    int                   `json:"active"`
+	Limit     int                   `json:"limit"`
+	Vendors   []vendorOccupancyJSON `json:"vendors"`
+	Queue     []waitingJSON         `json:"queue"`
+	QueueSize int                   `json:"queue_size"`
+}
+
+type vendorOccupancyJSON struct {
+	Kind   string `json:"kind"`
+	Vendor string `json:"vendor"`
+	Active int    `json:"active"`
+	Limit  int    `json:"limit"`
+}
+
+// waitingJSON is a call waiting for an agent.
+type waitingJSON struct {
+	CallID      string    `json:"call_id"`
+	SessionID   string    `json:"session_id"`
+	Position    int       `json:"position"`
+	Since       time.Time `json:"since"`
+	WaitSeconds int64     `json:"wait_seconds"`
+}
+
+// writeOccupancy serves GET /agents/occupancy.
+func writeOccupancy(w http.ResponseWriter, o agentmgr.Occupancy) {
+	out := occupancyJSON{
+		Active:    o.Active,
+		Limit:     o.Limit,
+		Vendors:   make([]vendorOccupancyJSON, 0, len(o.Vendors)),
+		Queue:     make([]waitingJSON, 0, len(o.Waiting)),
+		QueueSize: o.QueueSize,
+	}
+	for _, v := range o.Vendors {
+		out.Vendors = append(out.Vendors, vendorOccupancyJSON{Kind: v.Kind, Vendor: v.Vendor, Active: v.Active, Limit: v.Limit})
+	}
+	for _, q := range o.Waiting {
+		out.Queue = append(out.Queue, waitingJSON{
+			CallID:      q.CallID,
+			SessionID:   q.SessionID,
+			Position:    q.Position,
+			Since:       q.Since.UTC(),
+			WaitSeconds: int64(time.Since(q.Since).Seconds()),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// purgeRecordJSON is an entry of the audit trail of purged data as served by the API.
+type purgeRecordJSON struct {
+	ID        int64     `json:"id"`
+	PurgedAt  time.Time `json:"purged_at"`
+	CallID    string    `json:"call_id"`
+	DataClass string    `json:"data_class"`
+	Action    string    `json:"action"`
+	Items     int64     `json:"items"`
+	Cutoff    time.Time `json:"cutoff"`
+}
+
+// purgeAuditJSON is a page of the audit trail; NextAfter is set when there may be more.
+type purgeAuditJSON struct {
+	Records   []purgeRecordJSON `json:"records"`
+	NextAfter int64             `json:"next_after,omitempty"`
+}
+
+// listPurgeAudit serves GET /retention/audit. Query parameters: since (RFC 3339 or Unix
+// seconds), limit and after (next_after of the previous page).
+func listPurgeAudit(w http.ResponseWriter, r *http.Request, st store.Repository) {
+	q := r.URL.Query()
+	since, err := parseTime(q.Get("since"))
+	if err != nil {
+		http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var after int64
+	if v := q.Get("after"); v != "" {
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil || after < 0 {
+			http.Error(w, "invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := store.DefaultPageSize
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > store.MaxPageSize {
+			http.Error(w, fmt.Sprintf("invalid limit: use 1 to %d", store.MaxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := st.ListPurgeAudit(since, after, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := purgeAuditJSON{Records: make([]purgeRecordJSON, 0, len(records))}
+	for _, p := range records {
+		out.Records = append(out.Records, purgeRecordJSON{
+			ID:        p.ID,
+			PurgedAt:  p.PurgedAt.UTC(),
+			CallID:    p.CallID,
+			DataClass: p.DataClass,
+			Action:    p.Action,
+			Items:     p.Items,
+			Cutoff:    p.Cutoff.UTC(),
+		})
+	}
+	if len(records) == limit {
+		out.NextAfter = records[len(records)-1].ID
+	}
+	writeJSON(w, out)
+}
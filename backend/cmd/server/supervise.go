@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/supervise"
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// supervisionJSON is a supervisor's session in a call with the token they join it with.
+type supervisionJSON struct {
+	SessionID    string `json:"session_id"`
+	CallID       string `json:"call_id"`
+	SupervisorID string `json:"supervisor_id"`
+	Mode         string `json:"mode"`
+	WhisperTo    string `json:"whisper_to,omitempty"`
+	// WhisperRoom is where a whispering supervisor talks, with WhisperToken; Token only
+	// lets them listen to the call.
+	WhisperRoom  string `json:"whisper_room,omitempty"`
+	WhisperToken string `json:"whisper_token,omitempty"`
+	Token        string `json:"token"`
+	URL          string `json:"url"`
+}
+
+// handleSupervise serves POST /calls/{id}/supervise, which issues a supervisor a session in
+// the call to listen, whisper to the human agent or barge in. Joining again in another mode
+// ends the supervisor's earlier session. Supervisors join hidden, so like the other roles of
+// /livekit/token they need the secret as a bearer token.
+func handleSupervise(w http.ResponseWriter, r *http.Request, sup *supervise.Supervisor, secret, callID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !bearerAuthorized(r, secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		SupervisorID string `json:"supervisor_id"`
+		Mode         string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.SupervisorID == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	s, err := sup.Join(callID, body.SupervisorID, livekitauth.SupervisorMode(body.Mode))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "call not found", http.StatusNotFound)
+		return
+	case errors.Is(err, supervise.ErrUnknownMode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, supervise.ErrCallEnded), errors.Is(err, supervise.ErrNoHuman):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, supervisionJSON{
+		SessionID:    s.ID,
+		CallID:       s.CallID,
+		SupervisorID: s.SupervisorID,
+		Mode:         string(s.Mode),
+		WhisperTo:    s.WhisperTo,
+		WhisperRoom:  s.WhisperRoom,
+		WhisperToken: s.WhisperToken,
+		Token:        s.Token,
+		URL:          s.URL,
+	})
+}
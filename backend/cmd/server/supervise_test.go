@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/supervise"
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+func TestHandleSupervise_Auth(t *testing.T) {
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	callID, _, err := st.CreateCall("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sup := supervise.New(st, livekitauth.Settings{URL: "ws://livekit", APIKey: "devkey", APISecret: "secret", TokenTTL: time.Hour})
+	join := func(secret, auth string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/calls/"+callID+"/supervise", strings.NewReader(`{"supervisor_id":"sup-1","mode":"listen"}`))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handleSupervise(rec, req, sup, secret, callID)
+		return rec.Code
+	}
+
+	if code := join("", ""); code != http.StatusUnauthorized {
+		t.Fatalf("without a secret configured: status %d", code)
+	}
+	if code := join("", "Bearer "); code != http.StatusUnauthorized {
+		t.Fatalf("empty bearer without a secret configured: status %d", code)
+	}
+	if code := join("s3cret", ""); code != http.StatusUnauthorized {
+		t.Fatalf("without a bearer: status %d", code)
+	}
+	if code := join("s3cret", "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("with a wrong secret: status %d", code)
+	}
+	if sessions, err := st.ListSessions(callID); err != nil || len(sessions) != 1 {
+		t.Fatalf("sessions after refused joins = %d, %v", len(sessions), err)
+	}
+	if code := join("s3cret", "Bearer s3cret"); code != http.StatusCreated {
+		t.Fatalf("with the secret: status %d", code)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// issueToken serves GET /livekit/token?room=&identity=&role=. Anyone gets a caller token;
+// the other roles join hidden or as agents, so they need the secret as a bearer token and
+// are refused while no secret is configured. Supervisors join calls through
+// POST /calls/{id}/supervise, which records them.
+func issueToken(w http.ResponseWriter, r *http.Request, lk livekitauth.Settings, secret string) {
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		room = "default"
+	}
+	identity := r.URL.Query().Get("identity")
+	if identity == "" {
+		identity = "ai-agent"
+	}
+	role := livekitauth.Role(r.URL.Query().Get("role"))
+	if role == "" {
+		role = livekitauth.RoleCaller
+	}
+	if role != livekitauth.RoleCaller && !bearerAuthorized(r, secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := livekitauth.ParticipantToken(lk, role, room, identity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]string{"url": lk.URL, "token": token, "apiKey": lk.APIKey})
+}
+
+// refreshToken serves POST /livekit/token/refresh, exchanging a token that is still valid
+// for a fresh one. Only tokens of sessions that are active are refreshed, so a token
+// outlives neither its session nor a session the store never knew.
+func refreshToken(w http.ResponseWriter, r *http.Request, st store.Repository, lk livekitauth.Settings) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	claims, err := livekitauth.ParseToken(lk.APIKey, lk.APISecret, body.Token)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	_, status, err := st.FindSessionByIdentity(claims.Subject)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case status != "active":
+		http.Error(w, "session not active", http.StatusForbidden)
+		return
+	}
+	token, err := livekitauth.RefreshToken(lk.APIKey, lk.APISecret, body.Token, lk.TokenTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"url": lk.URL, "token": token})
+}
+
+// bearerAuthorized reports whether r carries secret as its bearer token. No request is
+// authorized while secret is empty.
+func bearerAuthorized(r *http.Request, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+secret)) == 1
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+func TestIssueToken_Roles(t *testing.T) {
+	lk := livekitauth.Settings{URL: "ws://livekit", APIKey: "devkey", APISecret: "secret", TokenTTL: time.Hour}
+	issue := func(target, secret, auth string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		issueToken(rec, req, lk, secret)
+		return rec.Code
+	}
+
+	if code := issue("/livekit/token?room=call-1&identity=alice", "", ""); code != http.StatusOK {
+		t.Fatalf("caller token: status %d", code)
+	}
+	for _, role := range []string{"supervisor", "recorder", "agent", "human"} {
+		target := "/livekit/token?room=call-1&identity=eve&role=" + role
+		if code := issue(target, "", ""); code != http.StatusUnauthorized {
+			t.Fatalf("%s token without a secret configured: status %d", role, code)
+		}
+		if code := issue(target, "s3cret", "Bearer wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("%s token with a wrong secret: status %d", role, code)
+		}
+		if code := issue(target, "s3cret", "Bearer s3cret"); code != http.StatusOK {
+			t.Fatalf("%s token with the secret: status %d", role, code)
+		}
+	}
+}
+
+func TestRefreshToken_Sessions(t *testing.T) {
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	lk := livekitauth.Settings{URL: "ws://livekit", APIKey: "devkey", APISecret: "secret", TokenTTL: time.Hour}
+	callID, sessionID, err := st.CreateCall("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(sessionID string) int {
+		t.Helper()
+		token, err := livekitauth.ParticipantToken(lk, livekitauth.RoleCaller, callID, sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/livekit/token/refresh", strings.NewReader(`{"token":"`+token+`"}`))
+		rec := httptest.NewRecorder()
+		refreshToken(rec, req, st, lk)
+		return rec.Code
+	}
+
+	if code := refresh("no-such-session"); code != http.StatusNotFound {
+		t.Fatalf("unknown session: status %d", code)
+	}
+	if code := refresh(sessionID); code != http.StatusForbidden {
+		t.Fatalf("session that never joined: status %d", code)
+	}
+	if err := st.UpdateSessionStatus(sessionID, "active"); err != nil {
+		t.Fatal(err)
+	}
+	if code := refresh(sessionID); code != http.StatusOK {
+		t.Fatalf("active session: status %d", code)
+	}
+	if err := st.EndSession(sessionID, "hangup"); err != nil {
+		t.Fatal(err)
+	}
+	if code := refresh(sessionID); code != http.StatusForbidden {
+		t.Fatalf("ended session: status %d", code)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/workers"
+)
+
+// workerJSON is a connected agent worker as served by the API.
+type workerJSON struct {
+	ID          string    `json:"id"`
+	Capacity    int       `json:"capacity"`
+	Active      int       `json:"active"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// listWorkers serves GET /workers.
+func listWorkers(w http.ResponseWriter, pool *workers.Pool) {
+	out := make([]workerJSON, 0)
+	for _, info := range pool.Workers() {
+		out = append(out, workerJSON{ID: info.ID, Capacity: info.Capacity, Active: info.Active, ConnectedAt: info.ConnectedAt.UTC()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]workerJSON{"workers": out})
+}
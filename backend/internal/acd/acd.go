@@ -0,0 +1,597 @@
+// Package acd routes calls waiting in named queues to human agents: the call that waited
+// longest goes to the available agent, with every skill of its queue, that has been idle
+// longest. Human agents join the call's room with their own session of type "human", next
+// to the "agent" sessions of AI agents.
+package acd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// SessionType is the type of the sessions of human agents.
+const SessionType = "human"
+
+// Fallbacks for calls that wait in a queue until it times out.
+const (
+	// FallbackAI hands the call to an AI agent, or back to the one that escalated it.
+	FallbackAI = "ai"
+	// FallbackHangUp ends the call.
+	FallbackHangUp = "hangup"
+)
+
+// Reasons the calls and sessions of the router end for.
+const (
+	// ReasonQueueTimeout ends a call no human agent answered within its queue's timeout.
+	ReasonQueueTimeout = "queue_timeout"
+	// ReasonNoAnswer ends the session of an agent that did not join the call in time.
+	ReasonNoAnswer = "no_answer"
+	// ReasonAbandoned ends the session of an agent whose caller hung up before they joined.
+	ReasonAbandoned = "abandoned"
+	// ReasonAgentLeft ends a call whose human agent left it.
+	ReasonAgentLeft = "agent_left"
+)
+
+// DefaultAnswerTimeout is how long an agent has to join a call routed to them before it
+// goes to the next agent.
+const DefaultAnswerTimeout = 30 * time.Second
+
+var (
+	// ErrUnknownState is returned for a state that is not one of the store's HumanAvailable,
+	// HumanBusy, HumanWrapUp or HumanAway.
+	ErrUnknownState = errors.New("unknown agent state")
+	// ErrOnCall is returned when an agent on a call is asked to change state.
+	ErrOnCall = errors.New("agent is on a call")
+)
+
+// AI takes the calls that time out in a queue with FallbackAI.
+// *agentmgr.AgentManager implements it.
+type AI interface {
+	SpawnAgent(callID string) (sessionID, token string, err error)
+}
+
+// RoomCloser closes the rooms of calls the router ends, disconnecting their callers.
+type RoomCloser interface {
+	DeleteRoom(ctx context.Context, room string) error
+}
+
+// Router keeps the calls waiting in the queues and the states of the human agents, and
+// routes calls to agents as both become available.
+type Router struct {
+	store         store.Repository
+	lk            livekit.Settings
+	ai            AI
+	rooms         RoomCloser
+	answerTimeout time.Duration
+
+	mu     sync.Mutex
+	agents map[string]*agent
+	// calls are the calls waiting in a queue or routed to an agent, by call id
+	calls map[string]*call
+}
+
+type agent struct {
+	store.HumanAgent
+	// call is the call routed to the agent while they are busy
+	call *call
+	// wrapUp ends the agent's wrap-up
+	wrapUp *time.Timer
+}
+
+type call struct {
+	id    string
+	queue store.Queue
+	// since is when the call entered the queue; it keeps its place if an agent does not answer
+	since    time.Time
+	deadline *time.Timer
+	// summary is what an AI agent that escalated the call tells the human taking it over
+	summary string
+
+	// agent is the agent the call is routed to, nil while it waits
+	agent     *agent
+	sessionID string
+	token     string
+	answered  bool
+	noAnswer  *time.Timer
+}
+
+// New returns a router issuing agents tokens for the LiveKit server of lk. Load the agents
+// before routing calls.
+func New(st store.Repository, lk livekit.Settings) *Router {
+	return &Router{
+		store:         st,
+		lk:            lk,
+		answerTimeout: DefaultAnswerTimeout,
+		agents:        make(map[string]*agent),
+		calls:         make(map[string]*call),
+	}
+}
+
+// SetAI sets where calls that time out with FallbackAI go; without it they are ended.
+func (r *Router) SetAI(ai AI) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ai = ai
+}
+
+// SetRooms sets how the rooms of calls the router ends are closed.
+func (r *Router) SetRooms(rc RoomCloser) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rooms = rc
+}
+
+// SetAnswerTimeout sets how long agents have to join the calls routed to them.
+func (r *Router) SetAnswerTimeout(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.answerTimeout = d
+}
+
+// Load reads the human agents from the store. Agents left busy or in wrap-up by an earlier
+// run are set away: their calls are gone.
+func (r *Router) Load() error {
+	agents, err := r.store.ListHumanAgents()
+	if err != nil {
+		return fmt.Errorf("list human agents: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range agents {
+		ag := &agent{HumanAgent: a}
+		if a.State == store.HumanBusy || a.State == store.HumanWrapUp {
+			r.setStateLocked(ag, store.HumanAway)
+		}
+		r.agents[a.ID] = ag
+	}
+	return nil
+}
+
+// AddAgent registers a human agent and returns it as stored.
+func (r *Router) AddAgent(a store.HumanAgent) (store.HumanAgent, error) {
+	if a.State != "" && a.State != store.HumanAvailable && a.State != store.HumanAway {
+		return store.HumanAgent{}, fmt.Errorf("%w %q", ErrUnknownState, a.State)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, err := r.store.CreateHumanAgent(a)
+	if err != nil {
+		return store.HumanAgent{}, err
+	}
+	stored, err := r.store.GetHumanAgent(id)
+	if err != nil {
+		return store.HumanAgent{}, err
+	}
+	r.agents[id] = &agent{HumanAgent: *stored}
+	r.routeLocked()
+	return *stored, nil
+}
+
+// SetState makes an agent available or away; an agent in wrap-up may end it early either
+// way. Agents become busy and go to wrap-up only by taking calls.
+func (r *Router) SetState(agentID, state string) error {
+	if state != store.HumanAvailable && state != store.HumanAway {
+		if state == store.HumanBusy || state == store.HumanWrapUp {
+			return fmt.Errorf("agents cannot set themselves %s", state)
+		}
+		return fmt.Errorf("%w %q", ErrUnknownState, state)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.agents[agentID]
+	if !ok {
+		return fmt.Errorf("human agent %s: %w", agentID, store.ErrNotFound)
+	}
+	if a.State == store.HumanBusy {
+		return fmt.Errorf("%s: %w", agentID, ErrOnCall)
+	}
+	if a.State == state {
+		return nil
+	}
+	r.setStateLocked(a, state)
+	r.routeLocked()
+	return nil
+}
+
+// SaveQueue creates or replaces a queue. Calls already waiting in it keep the settings they
+// found; a queue without a fallback hands calls that time out to an AI agent.
+func (r *Router) SaveQueue(q store.Queue) error {
+	if q.Fallback == "" {
+		q.Fallback = FallbackAI
+	}
+	if q.Fallback != FallbackAI && q.Fallback != FallbackHangUp {
+		return fmt.Errorf("unknown fallback %q", q.Fallback)
+	}
+	if q.Timeout < 0 || q.WrapUp < 0 {
+		return errors.New("timeout and wrap-up must not be negative")
+	}
+	return r.store.SaveQueue(q)
+}
+
+// Enqueue puts a call in the named queue, where it waits for an agent with every skill of
+// the queue or until the queue times out.
+func (r *Router) Enqueue(callID, queue string) error {
+	q, err := r.store.GetQueue(queue)
+	if err != nil {
+		return err
+	}
+	return r.enqueue(callID, *q, "")
+}
+
+// Escalate puts a call an AI agent is handing over in the named queue. The agent taking it
+// is given summary, the conversation so far.
+func (r *Router) Escalate(callID, queue, summary string) error {
+	q, err := r.store.GetQueue(queue)
+	if err != nil {
+		return err
+	}
+	if err := r.store.UpdateCallQueue(callID, queue); err != nil {
+		return err
+	}
+	return r.enqueue(callID, *q, summary)
+}
+
+func (r *Router) enqueue(callID string, q store.Queue, summary string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.calls[callID]; ok {
+		return fmt.Errorf("call %s is already queued", callID)
+	}
+	c := &call{id: callID, queue: q, since: time.Now(), summary: summary}
+	if q.Timeout > 0 {
+		c.deadline = time.AfterFunc(q.Timeout, func() { r.timeOut(c) })
+	}
+	r.calls[callID] = c
+	log.Printf("call %s waiting in queue %s", callID, q.Name)
+	r.routeLocked()
+	return nil
+}
+
+// Answered records that the agent of a human session joined their call.
+func (r *Router) Answered(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c := r.callOfSessionLocked(sessionID); c != nil && !c.answered {
+		c.answered = true
+		stopTimer(c.noAnswer)
+		log.Printf("agent %s answered call %s", c.agent.ID, c.id)
+	}
+}
+
+// AgentLeft records that the agent of a human session left their call. The agent goes to
+// wrap-up for the time set by the call's queue, and the call ends if the caller is still in
+// it.
+func (r *Router) AgentLeft(sessionID string) {
+	r.mu.Lock()
+	c := r.callOfSessionLocked(sessionID)
+	if c == nil {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.calls, c.id)
+	stopTimer(c.deadline)
+	stopTimer(c.noAnswer)
+	r.wrapUpLocked(c.agent, c.queue.WrapUp)
+	r.routeLocked()
+	r.mu.Unlock()
+	r.hangUp(c.id, ReasonAgentLeft)
+}
+
+// Abandon takes a call whose caller hung up out of the queues, freeing the agent it was
+// routed to. It reports whether the router had the call.
+func (r *Router) Abandon(callID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.calls[callID]
+	if !ok {
+		return false
+	}
+	delete(r.calls, callID)
+	stopTimer(c.deadline)
+	if a := c.agent; a != nil {
+		stopTimer(c.noAnswer)
+		if c.answered {
+			r.wrapUpLocked(a, c.queue.WrapUp)
+		} else {
+			r.endSession(c.sessionID, ReasonAbandoned)
+			r.freeLocked(a, store.HumanAvailable)
+		}
+	}
+	r.routeLocked()
+	return true
+}
+
+// Close stops the timers of the router.
+func (r *Router) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.calls {
+		stopTimer(c.deadline)
+		stopTimer(c.noAnswer)
+	}
+	for _, a := range r.agents {
+		stopTimer(a.wrapUp)
+	}
+	r.calls = make(map[string]*call)
+}
+
+// AgentStatus is a human agent and the call routed to them, if any.
+type AgentStatus struct {
+	store.HumanAgent
+	CallID    string
+	SessionID string
+	// Token lets the agent join the call's room at URL, until they answer.
+	Token string
+	URL   string
+	// Summary is the conversation an AI agent had with the caller before escalating.
+	Summary string
+}
+
+// Agents returns every human agent in the order they were registered.
+func (r *Router) Agents() []AgentStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]AgentStatus, 0, len(r.agents))
+	for _, a := range r.agents {
+		out = append(out, r.statusLocked(a))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Agent returns the human agent with the id.
+func (r *Router) Agent(id string) (AgentStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.agents[id]
+	if !ok {
+		return AgentStatus{}, false
+	}
+	return r.statusLocked(a), true
+}
+
+func (r *Router) statusLocked(a *agent) AgentStatus {
+	s := AgentStatus{HumanAgent: a.HumanAgent}
+	s.Skills = slices.Clone(a.Skills)
+	if c := a.call; c != nil {
+		s.CallID, s.SessionID, s.Summary = c.id, c.sessionID, c.summary
+		if !c.answered {
+			s.Token, s.URL = c.token, r.lk.URL
+		}
+	}
+	return s
+}
+
+// Waiting is a call waiting in a queue.
+type Waiting struct {
+	CallID string
+	Queue  string
+	// Position is the call's place in its queue, from 1.
+	Position int
+	Since    time.Time
+}
+
+// Waiting returns the calls waiting in the named queue, or in every queue if queue is
+// empty, in the order they are routed.
+func (r *Router) Waiting(queue string) []Waiting {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Waiting
+	positions := make(map[string]int)
+	for _, c := range r.waitingLocked() {
+		positions[c.queue.Name]++
+		if queue == "" || c.queue.Name == queue {
+			out = append(out, Waiting{CallID: c.id, Queue: c.queue.Name, Position: positions[c.queue.Name], Since: c.since})
+		}
+	}
+	return out
+}
+
+// waitingLocked returns the calls no agent has taken, longest waiting first.
+func (r *Router) waitingLocked() []*call {
+	var waiting []*call
+	for _, c := range r.calls {
+		if c.agent == nil {
+			waiting = append(waiting, c)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		if !waiting[i].since.Equal(waiting[j].since) {
+			return waiting[i].since.Before(waiting[j].since)
+		}
+		return waiting[i].id < waiting[j].id
+	})
+	return waiting
+}
+
+// routeLocked routes waiting calls, longest waiting first, to the longest idle available
+// agents with the skills of their queues.
+func (r *Router) routeLocked() {
+	for _, c := range r.waitingLocked() {
+		var best *agent
+		for _, a := range r.agents {
+			if a.State != store.HumanAvailable || !hasSkills(a.Skills, c.queue.Skills) {
+				continue
+			}
+			if best == nil || a.StateSince.Before(best.StateSince) || (a.StateSince.Equal(best.StateSince) && a.ID < best.ID) {
+				best = a
+			}
+		}
+		if best != nil {
+			r.assignLocked(c, best)
+		}
+	}
+}
+
+// assignLocked routes a call to an agent: the agent gets a session in the call and a token
+// to join its room, and has answerTimeout to do so.
+func (r *Router) assignLocked(c *call, a *agent) {
+	sessionID, err := r.store.CreateSession(c.id, a.ID, SessionType, "new")
+	if err != nil {
+		log.Printf("route call %s to agent %s: %v", c.id, a.ID, err)
+		return
+	}
+	token, err := livekit.ParticipantToken(r.lk, livekit.RoleHuman, c.id, sessionID)
+	if err != nil {
+		log.Printf("route call %s to agent %s: %v", c.id, a.ID, err)
+		r.endSession(sessionID, "")
+		return
+	}
+	_ = r.store.UpdateSessionToken(sessionID, token)
+	c.agent, c.sessionID, c.token, c.answered = a, sessionID, token, false
+	c.noAnswer = time.AfterFunc(r.answerTimeout, func() { r.notAnswered(c, sessionID) })
+	a.call = c
+	r.setStateLocked(a, store.HumanBusy)
+	log.Printf("routed call %s from queue %s to agent %s", c.id, c.queue.Name, a.ID)
+}
+
+// notAnswered sets away an agent who did not join the call routed to them; the call goes
+// back to its place in the queue, unless the queue timed out meanwhile.
+func (r *Router) notAnswered(c *call, sessionID string) {
+	r.mu.Lock()
+	if r.calls[c.id] != c || c.sessionID != sessionID || c.answered {
+		r.mu.Unlock()
+		return
+	}
+	log.Printf("agent %s did not answer call %s", c.agent.ID, c.id)
+	r.endSession(sessionID, ReasonNoAnswer)
+	r.freeLocked(c.agent, store.HumanAway)
+	c.agent, c.sessionID, c.token = nil, "", ""
+	if c.deadline != nil && time.Since(c.since) >= c.queue.Timeout {
+		r.mu.Unlock()
+		r.timeOut(c)
+		return
+	}
+	r.routeLocked()
+	r.mu.Unlock()
+}
+
+// timeOut takes a call no agent took within its queue's timeout out of the queue and gives
+// it to an AI agent or ends it, as the queue says.
+func (r *Router) timeOut(c *call) {
+	r.mu.Lock()
+	if r.calls[c.id] != c || c.agent != nil {
+		// taken by an agent; if they do not answer, notAnswered times the call out
+		r.mu.Unlock()
+		return
+	}
+	delete(r.calls, c.id)
+	ai := r.ai
+	r.mu.Unlock()
+
+	log.Printf("call %s timed out in queue %s", c.id, c.queue.Name)
+	if c.queue.Fallback != FallbackHangUp && ai != nil {
+		_, _, err := ai.SpawnAgent(c.id)
+		if err == nil {
+			return
+		}
+		log.Printf("hand call %s to an AI agent: %v", c.id, err)
+	}
+	r.hangUp(c.id, ReasonQueueTimeout)
+}
+
+// freeLocked ends the agent's call and puts them in state.
+func (r *Router) freeLocked(a *agent, state string) {
+	a.call = nil
+	r.setStateLocked(a, state)
+}
+
+// wrapUpLocked puts an agent whose call ended in wrap-up, making them available after d.
+func (r *Router) wrapUpLocked(a *agent, d time.Duration) {
+	r.freeLocked(a, store.HumanWrapUp)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if a.wrapUp != t || a.State != store.HumanWrapUp {
+			return
+		}
+		r.setStateLocked(a, store.HumanAvailable)
+		r.routeLocked()
+	})
+	a.wrapUp = t
+}
+
+// setStateLocked moves an agent to state, in memory and in the store.
+func (r *Router) setStateLocked(a *agent, state string) {
+	a.State, a.StateSince = state, time.Now()
+	if state != store.HumanWrapUp {
+		stopTimer(a.wrapUp)
+		a.wrapUp = nil
+	}
+	if err := r.store.UpdateHumanAgentState(a.ID, state); err != nil {
+		log.Printf("set agent %s %s: %v", a.ID, state, err)
+	}
+}
+
+func (r *Router) callOfSessionLocked(sessionID string) *call {
+	for _, c := range r.calls {
+		if c.agent != nil && c.sessionID == sessionID {
+			return c
+		}
+	}
+	return nil
+}
+
+// hangUp ends a call and closes its room.
+func (r *Router) hangUp(callID, reason string) {
+	sessions, err := r.store.ListSessions(callID)
+	if err != nil {
+		log.Printf("end call %s: list sessions: %v", callID, err)
+	}
+	for _, s := range sessions {
+		if s.Status != "ended" {
+			r.endSession(s.ID, reason)
+		}
+	}
+	if err := r.store.EndCall(callID, reason); err != nil {
+		log.Printf("end call %s: %v", callID, err)
+	}
+	r.mu.Lock()
+	rooms := r.rooms
+	r.mu.Unlock()
+	if rooms == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := rooms.DeleteRoom(ctx, callID); err != nil {
+		log.Printf("close room of call %s: %v", callID, err)
+	}
+}
+
+func (r *Router) endSession(sessionID, reason string) {
+	if err := r.store.EndSession(sessionID, reason); err != nil {
+		log.Printf("end session %s: %v", sessionID, err)
+	}
+}
+
+// hasSkills reports whether have includes every skill of need.
+func hasSkills(have, need []string) bool {
+	for _, s := range need {
+		if !slices.Contains(have, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
@@ -0,0 +1,279 @@
+package acd
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// fakeAI and fakeRooms record the calls handed to AI agents and the rooms closed.
+type fakeAI struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeAI) SpawnAgent(callID string) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, callID)
+	return "ai-session", "ai-token", nil
+}
+
+func (f *fakeAI) spawned() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+type fakeRooms struct {
+	mu     sync.Mutex
+	closed []string
+}
+
+func (f *fakeRooms) DeleteRoom(ctx context.Context, room string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = append(f.closed, room)
+	return nil
+}
+
+func newTestRouter(t *testing.T) (*Router, *store.Store) {
+	t.Helper()
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	r := New(st, livekit.Settings{URL: "ws://livekit", APIKey: "devkey", APISecret: "secret", TokenTTL: time.Hour})
+	t.Cleanup(r.Close)
+	return r, st
+}
+
+func addAgent(t *testing.T, r *Router, id string, skills ...string) {
+	t.Helper()
+	if _, err := r.AddAgent(store.HumanAgent{ID: id, Name: id, Skills: skills}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func saveQueue(t *testing.T, r *Router, q store.Queue) {
+	t.Helper()
+	if err := r.SaveQueue(q); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func enqueue(t *testing.T, r *Router, st *store.Store, queue string) string {
+	t.Helper()
+	callID, _, err := st.CreateCall("kim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Enqueue(callID, queue); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	return callID
+}
+
+func setState(t *testing.T, r *Router, id, state string) {
+	t.Helper()
+	if err := r.SetState(id, state); err != nil {
+		t.Fatalf("SetState(%s, %s): %v", id, state, err)
+	}
+}
+
+func agentState(t *testing.T, r *Router, id string) AgentStatus {
+	t.Helper()
+	a, ok := r.Agent(id)
+	if !ok {
+		t.Fatalf("agent %s not found", id)
+	}
+	return a
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r, st := newTestRouter(t)
+	saveQueue(t, r, store.Queue{Name: "billing", Skills: []string{"billing"}, WrapUp: time.Second})
+	addAgent(t, r, "ann", "billing")
+	addAgent(t, r, "bob", "billing", "spanish")
+	addAgent(t, r, "cyd")
+
+	first, second := enqueue(t, r, st, "billing"), enqueue(t, r, st, "billing")
+	if w := r.Waiting("billing"); len(w) != 2 || w[0].CallID != first || w[1].Position != 2 {
+		t.Fatalf("Waiting = %+v", w)
+	}
+
+	// cyd lacks the skill; bob has been idle longest of the others
+	setState(t, r, "cyd", store.HumanAvailable)
+	setState(t, r, "bob", store.HumanAvailable)
+	setState(t, r, "ann", store.HumanAvailable)
+	bob := agentState(t, r, "bob")
+	if bob.State != store.HumanBusy || bob.CallID != first || bob.Token == "" || bob.URL != "ws://livekit" {
+		t.Fatalf("bob = %+v", bob)
+	}
+	if ann := agentState(t, r, "ann"); ann.State != store.HumanBusy || ann.CallID != second {
+		t.Fatalf("ann = %+v", ann)
+	}
+	if cyd := agentState(t, r, "cyd"); cyd.State != store.HumanAvailable || cyd.CallID != "" {
+		t.Fatalf("cyd = %+v", cyd)
+	}
+	sess, err := st.GetSession(bob.SessionID)
+	if err != nil || sess.Type != SessionType || sess.UserID != "bob" || sess.CallID != first {
+		t.Fatalf("bob's session = %+v, %v", sess, err)
+	}
+	claims, err := livekit.ParseToken("devkey", "secret", bob.Token)
+	if err != nil || claims.Subject != bob.SessionID || claims.Video.Room != first {
+		t.Fatalf("bob's token = %+v, %v", claims, err)
+	}
+	if stored, err := st.GetHumanAgent("bob"); err != nil || stored.State != store.HumanBusy {
+		t.Fatalf("stored bob = %+v, %v", stored, err)
+	}
+	if err := r.SetState("bob", store.HumanAway); err == nil {
+		t.Fatal("agent on a call set away")
+	}
+
+	// bob answers, then leaves: the call ends and bob wraps up before taking the next one
+	r.Answered(bob.SessionID)
+	if a := agentState(t, r, "bob"); a.Token != "" || a.CallID != first {
+		t.Fatalf("bob after answering = %+v", a)
+	}
+	third := enqueue(t, r, st, "billing")
+	r.AgentLeft(bob.SessionID)
+	if c, err := st.GetCall(first); err != nil || c.Status != "ended" || c.EndReason != ReasonAgentLeft {
+		t.Fatalf("first call = %+v, %v", c, err)
+	}
+	if a := agentState(t, r, "bob"); a.State != store.HumanWrapUp || a.CallID != "" {
+		t.Fatalf("bob after the call = %+v", a)
+	}
+	eventually(t, "bob to take the next call", func() bool { return agentState(t, r, "bob").CallID == third })
+
+	// the caller of the second call hangs up before ann answers: ann is free again
+	if !r.Abandon(second) {
+		t.Fatal("Abandon of a routed call reported unknown")
+	}
+	if a := agentState(t, r, "ann"); a.State != store.HumanAvailable || a.CallID != "" {
+		t.Fatalf("ann after the caller hung up = %+v", a)
+	}
+	if r.Abandon(second) {
+		t.Fatal("Abandon twice reported known")
+	}
+}
+
+func TestRouter_NoAnswer(t *testing.T) {
+	r, st := newTestRouter(t)
+	r.SetAnswerTimeout(20 * time.Millisecond)
+	saveQueue(t, r, store.Queue{Name: "sales"})
+	addAgent(t, r, "dee")
+	addAgent(t, r, "eve")
+	setState(t, r, "dee", store.HumanAvailable)
+
+	callID := enqueue(t, r, st, "sales")
+	dee := agentState(t, r, "dee")
+	if dee.CallID != callID {
+		t.Fatalf("dee = %+v", dee)
+	}
+	eventually(t, "dee to be set away", func() bool { return agentState(t, r, "dee").State == store.HumanAway })
+	if s, err := st.GetSession(dee.SessionID); err != nil || s.Status != "ended" || s.EndReason != ReasonNoAnswer {
+		t.Fatalf("dee's session = %+v, %v", s, err)
+	}
+	if w := r.Waiting(""); len(w) != 1 || w[0].CallID != callID {
+		t.Fatalf("Waiting = %+v", w)
+	}
+	setState(t, r, "eve", store.HumanAvailable)
+	if eve := agentState(t, r, "eve"); eve.CallID != callID {
+		t.Fatalf("eve = %+v", eve)
+	}
+}
+
+func TestRouter_QueueTimeout(t *testing.T) {
+	r, st := newTestRouter(t)
+	ai, rooms := &fakeAI{}, &fakeRooms{}
+	r.SetAI(ai)
+	r.SetRooms(rooms)
+	saveQueue(t, r, store.Queue{Name: "vip", Timeout: time.Second})
+	saveQueue(t, r, store.Queue{Name: "night", Timeout: time.Second, Fallback: FallbackHangUp})
+	if err := r.SaveQueue(store.Queue{Name: "bad", Fallback: "voicemail"}); err == nil {
+		t.Fatal("queue with an unknown fallback saved")
+	}
+
+	toAI, hungUp := enqueue(t, r, st, "vip"), enqueue(t, r, st, "night")
+	eventually(t, "call to go to an AI agent", func() bool { return len(ai.spawned()) > 0 })
+	if got := ai.spawned(); len(got) != 1 || got[0] != toAI {
+		t.Fatalf("calls handed to AI agents = %v", got)
+	}
+	eventually(t, "call to end", func() bool {
+		c, err := st.GetCall(hungUp)
+		return err == nil && c.Status == "ended"
+	})
+	if c, _ := st.GetCall(hungUp); c.EndReason != ReasonQueueTimeout {
+		t.Fatalf("timed out call = %+v", c)
+	}
+	if w := r.Waiting(""); len(w) != 0 {
+		t.Fatalf("Waiting = %+v", w)
+	}
+	rooms.mu.Lock()
+	defer rooms.mu.Unlock()
+	if len(rooms.closed) != 1 || rooms.closed[0] != hungUp {
+		t.Fatalf("closed rooms = %v", rooms.closed)
+	}
+}
+
+func TestRouter_Escalate(t *testing.T) {
+	r, st := newTestRouter(t)
+	saveQueue(t, r, store.Queue{Name: "support"})
+	addAgent(t, r, "hal")
+	callID, _, err := st.CreateCall("kim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Escalate(callID, "missing", ""); err == nil {
+		t.Fatal("escalated to a missing queue")
+	}
+	if err := r.Escalate(callID, "support", "Lost card, wants it blocked."); err != nil {
+		t.Fatalf("Escalate: %v", err)
+	}
+	if c, err := st.GetCall(callID); err != nil || c.Queue != "support" {
+		t.Fatalf("escalated call = %+v, %v", c, err)
+	}
+	setState(t, r, "hal", store.HumanAvailable)
+	if hal := agentState(t, r, "hal"); hal.CallID != callID || hal.Summary != "Lost card, wants it blocked." {
+		t.Fatalf("hal = %+v", hal)
+	}
+}
+
+func TestRouter_Load(t *testing.T) {
+	r, st := newTestRouter(t)
+	for id, state := range map[string]string{"fay": store.HumanBusy, "gus": store.HumanAvailable} {
+		if _, err := st.CreateHumanAgent(store.HumanAgent{ID: id, Name: id, State: state}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if a := agentState(t, r, "fay"); a.State != store.HumanAway {
+		t.Fatalf("agent left busy = %+v", a)
+	}
+	if a := agentState(t, r, "gus"); a.State != store.HumanAvailable {
+		t.Fatalf("available agent = %+v", a)
+	}
+	if agents := r.Agents(); len(agents) != 2 {
+		t.Fatalf("Agents = %+v", agents)
+	}
+}
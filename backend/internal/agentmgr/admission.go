@@ -0,0 +1,468 @@
+package agentmgr
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned by SpawnAgent when the call can neither get an agent nor wait for one.
+var ErrBusy = errors.New("all agents are busy")
+
+// Reasons recorded for the calls the manager turns away.
+const (
+	// ReasonBusy ends a call that found the agents busy and the queue full.
+	ReasonBusy = "busy"
+	// ReasonQueueTimeout ends a call that waited for an agent for too long.
+	ReasonQueueTimeout = "queue_timeout"
+)
+
+// Overflow actions, for calls the queue cannot take or that waited too long.
+const (
+	// OverflowBusy tells the caller the agents are busy and ends the call.
+	OverflowBusy = "busy"
+	// OverflowAdmit gives the call an agent anyway, beyond the limits.
+	OverflowAdmit = "admit"
+)
+
+// Default announcements of the queue.
+const (
+	DefaultQueueMessage = "All our agents are busy. You are number {position} in line; please stay on the line."
+	DefaultBusyMessage  = "All our agents are busy right now. Please call again later."
+)
+
+// turnAwayTimeout bounds telling a caller the agents are busy.
+const turnAwayTimeout = 30 * time.Second
+
+// Limits cap how many agents run at once. Calls beyond the limits wait in a queue, where
+// they hear their position, until an agent is free.
+type Limits struct {
+	// MaxAgents caps the agents of all calls; zero means no cap.
+	MaxAgents int
+	// Vendors caps the agents using each STT, LLM or TTS vendor, by vendor name.
+	Vendors map[string]int
+	// QueueSize is how many calls may wait for an agent; zero turns them away at once.
+	QueueSize int
+	// MaxWait is how long a call waits before it overflows; zero waits for as long as it takes.
+	MaxWait time.Duration
+	// AnnounceEvery is how often waiting callers hear their position; zero tells them once.
+	AnnounceEvery time.Duration
+	// QueueMessage announces the position in the queue, which replaces "{position}".
+	QueueMessage string
+	// BusyMessage is said to callers that are turned away.
+	BusyMessage string
+	// Overflow is what happens to calls the queue cannot take: OverflowBusy or OverflowAdmit.
+	Overflow string
+}
+
+// RoomCloser closes LiveKit rooms; the RoomService client of libs/vendors/livekit implements it.
+type RoomCloser interface {
+	DeleteRoom(ctx context.Context, room string) error
+}
+
+// announcer speaks to a caller waiting in the queue; a speech-only RoomClient implements it.
+type announcer interface {
+	Connect() error
+	Say(text string) error
+	Disconnect() error
+}
+
+// waiter is a call waiting for an agent.
+type waiter struct {
+	callID    string
+	sessionID string
+	url       string
+	token     string
+	since     time.Time
+	// admit is closed when the call may have an agent
+	admit  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// SetLimits caps the agents running at once from now on. Agents already running are not
+// stopped when the limits are lowered.
+func (m *AgentManager) SetLimits(l Limits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l.QueueMessage == "" {
+		l.QueueMessage = DefaultQueueMessage
+	}
+	if l.BusyMessage == "" {
+		l.BusyMessage = DefaultBusyMessage
+	}
+	m.limits = l
+	m.promoteLocked()
+}
+
+// SetRooms makes the manager close the rooms of the calls it turns away.
+func (m *AgentManager) SetRooms(r RoomCloser) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rooms = r
+}
+
+// vendor is an STT, LLM or TTS vendor the agents use.
+type vendor struct {
+	kind string
+	name string
+}
+
+func (m *AgentManager) vendors() []vendor {
+	var vs []vendor
+	for _, v := range []vendor{{"stt", m.cfg.STTVendor}, {"llm", m.cfg.LLMVendor}, {"tts", m.cfg.TTSVendor}} {
+		if v.name != "" {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// limitLocked returns how many agents may run at once, zero for no cap. m.mu must be held.
+func (m *AgentManager) limitLocked() int {
+	limit := m.limits.MaxAgents
+	for _, v := range m.vendors() {
+		if n := m.limits.Vendors[v.name]; n > 0 && (limit == 0 || n < limit) {
+			limit = n
+		}
+	}
+	return limit
+}
+
+// roomLocked reports whether one more agent fits within the limits. m.mu must be held.
+func (m *AgentManager) roomLocked() bool {
+	limit := m.limitLocked()
+	return limit == 0 || len(m.admitted) < limit
+}
+
+// admitLocked reports whether a new call may have an agent at once: there is room for it and
+// no call waits before it. m.mu must be held.
+func (m *AgentManager) admitLocked() bool {
+	return len(m.queue) == 0 && m.roomLocked()
+}
+
+// freeLocked stops counting the call's agent against the limits. m.mu must be held.
+func (m *AgentManager) freeLocked(callID string) {
+	if !m.admitted[callID] {
+		return
+	}
+	delete(m.admitted, callID)
+	m.promoteLocked()
+}
+
+// promoteLocked admits waiting calls for as long as there is room. m.mu must be held.
+func (m *AgentManager) promoteLocked() {
+	for len(m.queue) > 0 && m.roomLocked() {
+		w := m.queue[0]
+		m.queue = m.queue[1:]
+		m.admitted[w.callID] = true
+		close(w.admit)
+	}
+}
+
+// Occupancy is how many agents run against the limits and which calls wait for one.
+type Occupancy struct {
+	Active int
+	// Limit is the number of agents that may run at once; zero means no cap.
+	Limit     int
+	Vendors   []VendorOccupancy
+	Waiting   []Waiting
+	QueueSize int
+}
+
+// VendorOccupancy is how many agents use a vendor, out of its limit.
+type VendorOccupancy struct {
+	Kind   string
+	Vendor string
+	Active int
+	Limit  int
+}
+
+// Waiting is a call in the queue.
+type Waiting struct {
+	CallID    string
+	SessionID string
+	Position  int
+	Since     time.Time
+}
+
+// Occupancy reports the agents running against the limits and the calls waiting for one.
+func (m *AgentManager) Occupancy() Occupancy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := Occupancy{Active: len(m.admitted), Limit: m.limitLocked(), QueueSize: m.limits.QueueSize}
+	for _, v := range m.vendors() {
+		o.Vendors = append(o.Vendors, VendorOccupancy{Kind: v.kind, Vendor: v.name, Active: len(m.admitted), Limit: m.limits.Vendors[v.name]})
+	}
+	for i, w := range m.queue {
+		o.Waiting = append(o.Waiting, Waiting{CallID: w.callID, SessionID: w.sessionID, Position: i + 1, Since: w.since})
+	}
+	return o
+}
+
+// overflowLocked handles a new call the limits leave no agent for: it waits in the queue if
+// there is room in it and overflows otherwise. m.mu must be held.
+func (m *AgentManager) overflowLocked(callID, sessionID string) (string, string, error) {
+	if len(m.queue) >= m.limits.QueueSize && m.limits.Overflow == OverflowAdmit {
+		log.Printf("call %s gets an agent beyond the limits", callID)
+		token, err := m.joinLocked(callID, sessionID, sessionID)
+		if err != nil {
+			return "", "", err
+		}
+		return sessionID, token, nil
+	}
+	url, token, err := m.agentToken(callID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	if len(m.queue) >= m.limits.QueueSize {
+		log.Printf("call %s turned away: all agents are busy", callID)
+		m.running.Add(1)
+		go m.turnAway(callID, sessionID, url, token)
+		return "", "", ErrBusy
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	w := &waiter{
+		callID:    callID,
+		sessionID: sessionID,
+		url:       url,
+		token:     token,
+		since:     time.Now(),
+		admit:     make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	m.queue = append(m.queue, w)
+	m.waiting[callID] = w
+	_ = m.store.UpdateSessionStatus(sessionID, "queued")
+	log.Printf("call %s waits for an agent at position %d", callID, len(m.queue))
+	m.running.Add(1)
+	go m.wait(w, m.limits)
+	return sessionID, token, nil
+}
+
+// leaveQueueLocked drops a waiting call, giving up its place. m.mu must be held.
+func (m *AgentManager) leaveQueueLocked(w *waiter) {
+	delete(m.waiting, w.callID)
+	for i, q := range m.queue {
+		if q == w {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+	m.freeLocked(w.callID)
+}
+
+// position returns the place of a waiting call in the queue, counting from 1, or 0 if it no
+// longer waits.
+func (m *AgentManager) position(w *waiter) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, q := range m.queue {
+		if q == w {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// wait keeps a call in the queue, announcing its position, until it is admitted, stopped or
+// has waited for too long.
+func (m *AgentManager) wait(w *waiter, l Limits) {
+	defer m.running.Done()
+	defer w.cancel()
+	v := m.openVoice(w)
+	var timeout, announce <-chan time.Time
+	if l.MaxWait > 0 {
+		t := time.NewTimer(l.MaxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	if l.AnnounceEvery > 0 {
+		t := time.NewTicker(l.AnnounceEvery)
+		defer t.Stop()
+		announce = t.C
+	}
+	sayPosition := func() {
+		if pos := m.position(w); pos > 0 {
+			v.say(strings.ReplaceAll(l.QueueMessage, "{position}", strconv.Itoa(pos)))
+		}
+	}
+
+	for {
+		select {
+		case err := <-v.connected:
+			v.connected = nil
+			if err != nil {
+				if w.ctx.Err() == nil {
+					log.Printf("queue of call %s: join room: %v", w.callID, err)
+				}
+				continue
+			}
+			v.up = true
+			sayPosition()
+		case <-announce:
+			sayPosition()
+		case <-w.admit:
+			v.close()
+			m.join(w)
+			return
+		case <-timeout:
+			m.mu.Lock()
+			if m.waiting[w.callID] != w {
+				m.mu.Unlock()
+				continue
+			}
+			if m.admitted[w.callID] {
+				// admitted as the wait ran out
+				m.mu.Unlock()
+				continue
+			}
+			if l.Overflow == OverflowAdmit {
+				for i, q := range m.queue {
+					if q == w {
+						m.queue = append(m.queue[:i], m.queue[i+1:]...)
+						break
+					}
+				}
+				m.admitted[w.callID] = true
+				m.mu.Unlock()
+				log.Printf("call %s waited %s and gets an agent beyond the limits", w.callID, l.MaxWait)
+				v.close()
+				m.join(w)
+				return
+			}
+			m.leaveQueueLocked(w)
+			m.mu.Unlock()
+			log.Printf("call %s turned away after waiting %s", w.callID, l.MaxWait)
+			v.sayNow(l.BusyMessage)
+			v.close()
+			m.hangUp(w.callID, ReasonQueueTimeout)
+			return
+		case <-w.ctx.Done():
+			v.close()
+			return
+		}
+	}
+}
+
+// join gives an admitted call its agent, unless the call was stopped meanwhile.
+func (m *AgentManager) join(w *waiter) {
+	m.mu.Lock()
+	if m.waiting[w.callID] != w {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.waiting, w.callID)
+	_, err := m.joinLocked(w.callID, w.sessionID, w.sessionID)
+	if err != nil {
+		m.freeLocked(w.callID)
+	}
+	m.mu.Unlock()
+	if err != nil {
+		log.Printf("agent of call %s: %v", w.callID, err)
+		_ = m.store.EndSession(w.sessionID, ReasonJoinFailed)
+		return
+	}
+	log.Printf("call %s got an agent after waiting %s", w.callID, time.Since(w.since).Round(time.Second))
+}
+
+// turnAway tells the caller the agents are busy and ends the call.
+func (m *AgentManager) turnAway(callID, sessionID, url, token string) {
+	defer m.running.Done()
+	ctx, cancel := context.WithTimeout(m.ctx, turnAwayTimeout)
+	defer cancel()
+	m.mu.Lock()
+	message := m.limits.BusyMessage
+	m.mu.Unlock()
+	a := m.newAnnouncer(ctx, url, token, callID, sessionID)
+	if err := a.Connect(); err != nil {
+		log.Printf("turn away call %s: join room: %v", callID, err)
+	} else if err := a.Say(message); err != nil {
+		log.Printf("turn away call %s: %v", callID, err)
+	}
+	_ = a.Disconnect()
+	m.hangUp(callID, ReasonBusy)
+}
+
+// hangUp ends a call with reason and closes its room.
+func (m *AgentManager) hangUp(callID, reason string) {
+	m.endCall(callID, reason)
+	m.mu.Lock()
+	rooms := m.rooms
+	m.mu.Unlock()
+	if rooms == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := rooms.DeleteRoom(ctx, callID); err != nil {
+		log.Printf("close room of call %s: %v", callID, err)
+	}
+}
+
+// voice is the announcer in the room of a waiting call. It joins in the background, so
+// the call may be admitted before it is heard.
+type voice struct {
+	a      announcer
+	ctx    context.Context
+	cancel context.CancelFunc
+	// connected receives the result of joining the room, and is nil once received
+	connected chan error
+	up        bool
+	speaking  sync.WaitGroup
+}
+
+func (m *AgentManager) openVoice(w *waiter) *voice {
+	ctx, cancel := context.WithCancel(w.ctx)
+	v := &voice{ctx: ctx, cancel: cancel, connected: make(chan error, 1)}
+	v.a = m.newAnnouncer(ctx, w.url, w.token, w.callID, w.sessionID)
+	go func() { v.connected <- v.a.Connect() }()
+	return v
+}
+
+// say speaks text in the background if the voice is in the room.
+func (v *voice) say(text string) {
+	if !v.up {
+		return
+	}
+	v.speaking.Add(1)
+	go func() {
+		defer v.speaking.Done()
+		if err := v.a.Say(text); err != nil && v.ctx.Err() == nil {
+			log.Printf("queue announcement: %v", err)
+		}
+	}()
+}
+
+// sayNow speaks text and returns once it has been played.
+func (v *voice) sayNow(text string) {
+	if v.connected != nil {
+		// give it the chance to join
+		select {
+		case err := <-v.connected:
+			v.connected = nil
+			v.up = err == nil
+		case <-v.ctx.Done():
+		case <-time.After(turnAwayTimeout):
+		}
+	}
+	v.say(text)
+	v.speaking.Wait()
+}
+
+// close leaves the room.
+func (v *voice) close() {
+	v.cancel()
+	if v.connected != nil {
+		<-v.connected
+		v.connected = nil
+	}
+	v.speaking.Wait()
+	_ = v.a.Disconnect()
+}
@@ -0,0 +1,221 @@
+package agentmgr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// fakeVoice records what the announcers of a manager say and the rooms it closes.
+type fakeVoice struct {
+	mu     sync.Mutex
+	said   map[string][]string
+	closed []string
+}
+
+type fakeAnnouncer struct {
+	v      *fakeVoice
+	callID string
+}
+
+func (a fakeAnnouncer) Connect() error    { return nil }
+func (a fakeAnnouncer) Disconnect() error { return nil }
+func (a fakeAnnouncer) Say(text string) error {
+	a.v.mu.Lock()
+	defer a.v.mu.Unlock()
+	a.v.said[a.callID] = append(a.v.said[a.callID], text)
+	return nil
+}
+
+func (v *fakeVoice) DeleteRoom(ctx context.Context, room string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.closed = append(v.closed, room)
+	return nil
+}
+
+// heard returns what the caller of a call heard.
+func (v *fakeVoice) heard(callID string) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.said[callID]...)
+}
+
+// newLimitedManager returns a test manager running agents within l.
+func newLimitedManager(t *testing.T, l Limits) (*AgentManager, *store.Store, *fakeVoice) {
+	t.Helper()
+	m, st := newTestManager(t)
+	v := &fakeVoice{said: make(map[string][]string)}
+	m.newAnnouncer = func(ctx context.Context, url, token, callID, sessionID string) announcer {
+		return fakeAnnouncer{v: v, callID: callID}
+	}
+	m.SetRooms(v)
+	m.SetLimits(l)
+	return m, st, v
+}
+
+func newCall(t *testing.T, st *store.Store) string {
+	t.Helper()
+	callID, _, err := st.CreateCall("erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callID
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	m, st, v := newLimitedManager(t, Limits{MaxAgents: 1, QueueSize: 1})
+
+	first := newCall(t, st)
+	if _, _, err := m.SpawnAgent(first); err != nil {
+		t.Fatalf("SpawnAgent within the limits: %v", err)
+	}
+
+	// the second call waits and hears its position
+	second := newCall(t, st)
+	waiting, _, err := m.SpawnAgent(second)
+	if err != nil {
+		t.Fatalf("SpawnAgent beyond the limits: %v", err)
+	}
+	if s, err := st.GetSession(waiting); err != nil || s.Status != "queued" {
+		t.Fatalf("waiting agent session = %+v, %v", s, err)
+	}
+	eventually(t, "position announcement", func() bool { return len(v.heard(second)) > 0 })
+	if said := v.heard(second)[0]; !strings.Contains(said, "number 1 in line") {
+		t.Fatalf("announcement = %q", said)
+	}
+	o := m.Occupancy()
+	if o.Active != 1 || o.Limit != 1 || len(o.Waiting) != 1 || o.Waiting[0].CallID != second || o.Waiting[0].Position != 1 {
+		t.Fatalf("Occupancy = %+v", o)
+	}
+
+	// the third finds the queue full and is turned away
+	third := newCall(t, st)
+	if _, _, err := m.SpawnAgent(third); !errors.Is(err, ErrBusy) {
+		t.Fatalf("SpawnAgent with the queue full: err = %v, want ErrBusy", err)
+	}
+	eventually(t, "busy call to end", func() bool {
+		c, err := st.GetCall(third)
+		return err == nil && c.Status == "ended"
+	})
+	if c, _ := st.GetCall(third); c.EndReason != ReasonBusy {
+		t.Fatalf("busy call = %+v", c)
+	}
+	if said := v.heard(third); len(said) != 1 || said[0] != DefaultBusyMessage {
+		t.Fatalf("busy caller heard %q", said)
+	}
+	v.mu.Lock()
+	closed := v.closed
+	v.mu.Unlock()
+	if len(closed) != 1 || closed[0] != third {
+		t.Fatalf("closed rooms = %v", closed)
+	}
+
+	// the waiting call gets the agent that is freed
+	if err := m.StopAgent(first); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "waiting call to get an agent", func() bool { return m.agentSession(second) == waiting })
+	if s, err := st.GetSession(waiting); err != nil || s.Status != "active" {
+		t.Fatalf("admitted agent session = %+v, %v", s, err)
+	}
+	if o := m.Occupancy(); o.Active != 1 || len(o.Waiting) != 0 {
+		t.Fatalf("Occupancy = %+v", o)
+	}
+}
+
+func TestAdmission_StopWaiting(t *testing.T) {
+	m, st, _ := newLimitedManager(t, Limits{MaxAgents: 1, QueueSize: 2})
+	first, second, third := newCall(t, st), newCall(t, st), newCall(t, st)
+	for _, callID := range []string{first, second, third} {
+		if _, _, err := m.SpawnAgent(callID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a caller that hangs up while waiting gives up its place
+	if err := m.StopAgent(second); err != nil {
+		t.Fatalf("StopAgent of a waiting call: %v", err)
+	}
+	if o := m.Occupancy(); len(o.Waiting) != 1 || o.Waiting[0].CallID != third || o.Waiting[0].Position != 1 {
+		t.Fatalf("Occupancy = %+v", o)
+	}
+	if err := m.StopAgent(first); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "next call to get an agent", func() bool { return m.agentSession(third) != "" })
+	if m.agentSession(second) != "" {
+		t.Fatal("call stopped while waiting got an agent")
+	}
+}
+
+func TestAdmission_MaxWait(t *testing.T) {
+	m, st, v := newLimitedManager(t, Limits{MaxAgents: 1, QueueSize: 1, MaxWait: 20 * time.Millisecond})
+	if _, _, err := m.SpawnAgent(newCall(t, st)); err != nil {
+		t.Fatal(err)
+	}
+	late := newCall(t, st)
+	if _, _, err := m.SpawnAgent(late); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "waiting call to time out", func() bool {
+		c, err := st.GetCall(late)
+		return err == nil && c.Status == "ended"
+	})
+	if c, _ := st.GetCall(late); c.EndReason != ReasonQueueTimeout {
+		t.Fatalf("timed out call = %+v", c)
+	}
+	if said := v.heard(late); len(said) == 0 || said[len(said)-1] != DefaultBusyMessage {
+		t.Fatalf("timed out caller heard %q", said)
+	}
+	if o := m.Occupancy(); o.Active != 1 || len(o.Waiting) != 0 {
+		t.Fatalf("Occupancy = %+v", o)
+	}
+}
+
+func TestAdmission_OverflowAdmit(t *testing.T) {
+	m, st, _ := newLimitedManager(t, Limits{MaxAgents: 1, Overflow: OverflowAdmit})
+	for i := 0; i < 2; i++ {
+		callID := newCall(t, st)
+		if _, _, err := m.SpawnAgent(callID); err != nil {
+			t.Fatalf("SpawnAgent %d: %v", i, err)
+		}
+		if m.agentSession(callID) == "" {
+			t.Fatalf("call %d has no agent", i)
+		}
+	}
+	if o := m.Occupancy(); o.Active != 2 {
+		t.Fatalf("Occupancy = %+v", o)
+	}
+}
+
+func TestAdmission_VendorLimit(t *testing.T) {
+	m, st, _ := newLimitedManager(t, Limits{MaxAgents: 5, Vendors: map[string]int{"whisper": 1, "piper": 3}})
+	m.cfg.STTVendor, m.cfg.TTSVendor = "whisper", "piper"
+	if _, _, err := m.SpawnAgent(newCall(t, st)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.SpawnAgent(newCall(t, st)); !errors.Is(err, ErrBusy) {
+		t.Fatalf("SpawnAgent beyond the whisper limit: err = %v, want ErrBusy", err)
+	}
+	o := m.Occupancy()
+	if o.Limit != 1 || len(o.Vendors) != 2 || o.Vendors[0].Vendor != "whisper" || o.Vendors[0].Limit != 1 || o.Vendors[1].Limit != 3 {
+		t.Fatalf("Occupancy = %+v", o)
+	}
+}
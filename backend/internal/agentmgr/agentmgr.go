@@ -3,14 +3,21 @@ package agentmgr
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/jacky-htg/ai-call-center/backend/internal/conversation"
 	"github.com/jacky-htg/ai-call-center/backend/internal/livekitclient"
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/blob"
 	"github.com/jacky-htg/ai-call-center/libs/config"
 	"github.com/jacky-htg/ai-call-center/libs/interfaces"
 	"github.com/jacky-htg/ai-call-center/libs/livekit"
 	"github.com/jacky-htg/ai-call-center/libs/store"
+	"github.com/jacky-htg/ai-call-center/libs/vad"
 )
 
 // AgentManager manages AI agent sessions for calls. It's a light-weight in-memory
@@ -18,112 +25,220 @@ import (
 type AgentManager struct {
 	mu sync.Mutex
 	// map callID -> agentSessionID
-	agents  map[string]string
+	agents map[string]string
 	// map callID -> roomClient
-	clients  map[string]*livekitclient.RoomClient
+	clients map[string]*livekitclient.RoomClient
 	cancels map[string]context.CancelFunc
-	store   *store.Store
-	cfg     *config.Config
-	tts     interfaces.TTS
-	llm     interfaces.LLM
-	stt     interfaces.STT
+	// map callID -> call context, cancelled by StopAgent
+	contexts map[string]context.Context
+	// map callID -> conversation history shared by the room client and ProcessIncomingAudio
+	histories map[string]*conversation.History
+	store     store.Repository
+	cfg       *config.Config
+	tts       interfaces.TTS
+	llm       interfaces.LLM
+	stt       interfaces.STT
+	vad       vad.Settings
+	recording livekitclient.RecordingSettings
+	blobs     blob.Store
+	// dispatcher runs agents on workers instead of in this process when set
+	dispatcher Dispatcher
+	// limits cap the agents running at once; admitted holds the calls counted against them
+	limits   Limits
+	admitted map[string]bool
+	// queue holds the calls waiting for an agent in order of arrival; waiting maps them, and
+	// those admitted but not joined yet, by call
+	queue   []*waiter
+	waiting map[string]*waiter
+	// newAnnouncer returns the voice of the queue in a call's room
+	newAnnouncer func(ctx context.Context, url, token, callID, sessionID string) announcer
+	// rooms closes the rooms of calls turned away; nil leaves them to the caller
+	rooms RoomCloser
+	// escalator takes the calls agents hand to human agents; holds stops the hold audio of
+	// the calls waiting for one, by call
+	escalation Escalation
+	escalator  Escalator
+	holds      map[string]context.CancelFunc
+	// running counts the goroutines of spawned agents, which Shutdown waits for
+	running sync.WaitGroup
+	// ctx is the parent of every call context; shutdown cancels it
+	ctx      context.Context
+	shutdown context.CancelFunc
 }
 
-// New creates an AgentManager. tts and llm are used by the background agent worker to produce audio.
-func New(s *store.Store, cfg *config.Config, tts interfaces.TTS, llm interfaces.LLM, stt interfaces.STT) *AgentManager {
-	return &AgentManager{
-		agents:  make(map[string]string),
-		clients: make(map[string]*livekitclient.RoomClient),
-		cancels: make(map[string]context.CancelFunc),
-		store:   s,
-		cfg:     cfg,
-		tts:     tts,
-		llm:     llm,
-		stt:     stt,
+// New creates an AgentManager. tts and llm are used by the background agent worker to produce audio;
+// vs decides where the caller's utterances start and end.
+func New(s store.Repository, cfg *config.Config, tts interfaces.TTS, llm interfaces.LLM, stt interfaces.STT, vs vad.Settings) *AgentManager {
+	ctx, shutdown := context.WithCancel(context.Background())
+	m := &AgentManager{
+		agents:    make(map[string]string),
+		clients:   make(map[string]*livekitclient.RoomClient),
+		cancels:   make(map[string]context.CancelFunc),
+		contexts:  make(map[string]context.Context),
+		histories: make(map[string]*conversation.History),
+		admitted:  make(map[string]bool),
+		waiting:   make(map[string]*waiter),
+		holds:     make(map[string]context.CancelFunc),
+		ctx:       ctx,
+		shutdown:  shutdown,
+		store:     s,
+		cfg:       cfg,
+		tts:       tts,
+		llm:       llm,
+		stt:       stt,
+		vad:       vs,
 	}
+	m.newAnnouncer = func(ctx context.Context, url, token, callID, sessionID string) announcer {
+		// the announcer only speaks: it neither transcribes nor answers the caller
+		return livekitclient.NewRoomClient(ctx, url, token, callID, sessionID, nil, nil, tts, nil, vs)
+	}
+	return m
 }
 
 // SpawnAgent creates an agent session for the call, marks it active and connects to LiveKit room.
-// It returns the sessionID and a LiveKit token.
+// It returns the sessionID and a LiveKit token. If the limits are reached the call waits in
+// the queue for an agent, or is turned away with ErrBusy when the queue is full. A call
+// escalated to a human agent that none took goes back to its agent, without a new token.
 func (m *AgentManager) SpawnAgent(callID string) (string, string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if _, ok := m.holds[callID]; ok {
+		m.resumeLocked(callID, m.escalation.ResumeMessage)
+		return m.agents[callID], "", nil
+	}
 	if _, ok := m.agents[callID]; ok {
 		return "", "", fmt.Errorf("agent already exists for call %s", callID)
 	}
+	if _, ok := m.waiting[callID]; ok {
+		return "", "", fmt.Errorf("call %s is already waiting for an agent", callID)
+	}
 
 	agentUser := "ai-agent"
 	sessionID, err := m.store.CreateSession(callID, agentUser, "agent", "new")
 	if err != nil {
 		return "", "", err
 	}
-
-	// generate token for agent to join; use livekit settings from cfg
-	lk := m.cfg.VendorSettings["livekit"]
-	apiKey, apiSecret, url := "", "", ""
-	if lk != nil {
-		apiKey = lk["api_key"]
-		apiSecret = lk["api_secret"]
-		url = lk["url"]
+	if !m.admitLocked() {
+		return m.overflowLocked(callID, sessionID)
 	}
-	if url == "" {
-		return "", "", fmt.Errorf("livekit url not configured")
-	}
-	
-	token, err := livekit.GenerateAccessToken(apiKey, apiSecret, callID, sessionID, 3600)
+	token, err := m.joinLocked(callID, sessionID, sessionID)
 	if err != nil {
 		return "", "", err
 	}
+	return sessionID, token, nil
+}
 
-	// persist the agent token so external agent workers can retrieve it
-	_ = m.store.UpdateSessionToken(sessionID, token)
+// joinLocked connects the agent of an existing session to the call's room with a fresh
+// token and returns the token. Its recording is stored under recordingName. m.mu must be held.
+func (m *AgentManager) joinLocked(callID, sessionID, recordingName string) (string, error) {
+	url, token, err := m.agentToken(callID, sessionID)
+	if err != nil {
+		return "", err
+	}
 
-	// mark active
+	// mark active; the agent counts against the limits until it is forgotten
 	_ = m.store.UpdateSessionStatus(sessionID, "active")
+	m.admitted[callID] = true
 
-	// Create and connect room client
-	ctx, cancel := context.WithCancel(context.Background())
-	roomClient := livekitclient.NewRoomClient(url, token, callID, sessionID, m.stt, m.llm, m.tts)
+	if m.dispatcher != nil {
+		m.dispatchLocked(callID, sessionID, url, token)
+		return token, nil
+	}
+
+	// Create and connect room client; cancelling ctx aborts all of its vendor work
+	ctx, cancel := context.WithCancel(m.ctx)
+	roomClient := livekitclient.NewRoomClient(ctx, url, token, callID, sessionID, m.stt, m.llm, m.tts, m.historyLocked(callID), m.vad)
+	roomClient.SetRecorder(m.store)
+	if m.escalatesLocked() {
+		roomClient.SetEscalation(m.escalationLocked(callID, roomClient))
+	}
+	recording, blobs := m.startRecordingLocked(callID, recordingName), m.blobs
+	if recording != nil {
+		roomClient.SetAudioRecorder(recording.recorder)
+	}
 	
 	m.agents[callID] = sessionID
 	m.clients[callID] = roomClient
 	m.cancels[callID] = cancel
+	m.contexts[callID] = ctx
 
 	// Connect to room in background
+	m.running.Add(1)
 	go func() {
+		defer m.running.Done()
 		if err := roomClient.Connect(); err != nil {
 			log.Printf("Failed to connect agent to room %s: %v", callID, err)
 			m.mu.Lock()
-			delete(m.agents, callID)
-			delete(m.clients, callID)
-			delete(m.cancels, callID)
+			if m.clients[callID] == roomClient {
+				m.forgetLocked(callID)
+			}
 			m.mu.Unlock()
-			_ = m.store.UpdateSessionStatus(sessionID, "ended")
+			stopped := ctx.Err() != nil
+			cancel()
+			recording.finish(m.store, blobs)
+			if stopped {
+				_ = m.store.UpdateSessionStatus(sessionID, "ended")
+			} else {
+				_ = m.store.EndSession(sessionID, ReasonJoinFailed)
+			}
 			return
 		}
 
-		// Wait for context cancellation
-		<-ctx.Done()
+		// Wait until the agent is stopped or the server ends the session
+		select {
+		case <-ctx.Done():
+		case <-roomClient.Done():
+			log.Printf("Agent left room %s", callID)
+			m.mu.Lock()
+			if m.clients[callID] == roomClient {
+				m.forgetLocked(callID)
+			}
+			m.mu.Unlock()
+			cancel()
+		}
 		
 		// Disconnect and cleanup
 		if err := roomClient.Disconnect(); err != nil {
 			log.Printf("Error disconnecting agent from room %s: %v", callID, err)
 		}
+		recording.finish(m.store, blobs)
 		_ = m.store.UpdateSessionStatus(sessionID, "ended")
 	}()
 
-	return sessionID, token, nil
+	return token, nil
+}
+
+// agentToken issues the LiveKit token of an agent session and persists it so external agent
+// workers can retrieve it. It returns the LiveKit URL with the token.
+func (m *AgentManager) agentToken(callID, sessionID string) (url, token string, err error) {
+	lk, err := livekit.SettingsFrom(m.cfg.VendorSettings["livekit"])
+	if err != nil {
+		return "", "", err
+	}
+	if lk.URL == "" {
+		return "", "", fmt.Errorf("livekit url not configured")
+	}
+	if token, err = livekit.ParticipantToken(lk, livekit.RoleAgent, callID, sessionID); err != nil {
+		return "", "", err
+	}
+	_ = m.store.UpdateSessionToken(sessionID, token)
+	return lk.URL, token, nil
 }
 
 // StopAgent stops the agent for the given call and marks it ended.
 func (m *AgentManager) StopAgent(callID string) error {
 	m.mu.Lock()
+	if w, ok := m.waiting[callID]; ok {
+		m.leaveQueueLocked(w)
+		m.mu.Unlock()
+		w.cancel()
+		_ = m.store.UpdateSessionStatus(w.sessionID, "ended")
+		return nil
+	}
 	cancel, ok := m.cancels[callID]
 	sessionID := m.agents[callID]
 	client := m.clients[callID]
-	delete(m.cancels, callID)
-	delete(m.agents, callID)
-	delete(m.clients, callID)
+	m.forgetLocked(callID)
 	m.mu.Unlock()
 	if !ok {
 		return fmt.Errorf("no agent for call %s", callID)
@@ -137,6 +252,10 @@ func (m *AgentManager) StopAgent(callID string) error {
 		if err := client.Disconnect(); err != nil {
 			log.Printf("Error disconnecting client: %v", err)
 		}
+	} else if m.dispatcher != nil {
+		if err := m.dispatcher.Release(callID); err != nil {
+			log.Printf("release agent of call %s: %v", callID, err)
+		}
 	}
 	
 	// session status will be updated by goroutine; but ensure it's ended
@@ -144,41 +263,221 @@ func (m *AgentManager) StopAgent(callID string) error {
 	return nil
 }
 
-// ProcessIncomingAudio accepts raw audio bytes (from an external agent worker or media pipeline)
-// and runs STT -> LLM -> TTS. It returns the transcript produced by STT.
-func (m *AgentManager) ProcessIncomingAudio(sessionID string, audio []byte) (string, error) {
+// Shutdown stops every running agent and waiting call, waits for them to leave their rooms and store their
+// recordings, and aborts all outstanding vendor work.
+func (m *AgentManager) Shutdown() {
+	m.mu.Lock()
+	callIDs := make([]string, 0, len(m.cancels)+len(m.waiting))
+	for callID := range m.cancels {
+		callIDs = append(callIDs, callID)
+	}
+	for callID := range m.waiting {
+		callIDs = append(callIDs, callID)
+	}
+	m.mu.Unlock()
+
+	for _, callID := range callIDs {
+		if err := m.StopAgent(callID); err != nil {
+			log.Printf("stop agent for call %s: %v", callID, err)
+		}
+	}
+	m.running.Wait()
+	m.shutdown()
+}
+
+// ProcessIncomingAudio reads audio (from an external agent worker or media pipeline) and runs
+// STT -> LLM -> TTS. The audio is a 16 kHz mono WAV or raw PCM stream. Voice activity detection
+// splits it into utterances as it is read; each utterance is streamed to STT on its own, so
+// silence is never transcribed and transcription overlaps with the upload. The utterances form
+// one user turn. It returns the transcript produced by STT, which is empty if nobody spoke.
+// Work is aborted when ctx is cancelled, when the call's agent is stopped or on Shutdown.
+// Sessions that are not in the store are refused with an error wrapping store.ErrNotFound.
+func (m *AgentManager) ProcessIncomingAudio(ctx context.Context, sessionID string, r io.Reader) (string, error) {
 	if m.stt == nil {
 		return "", fmt.Errorf("stt not configured")
 	}
 
-	// run STT
-	transcript, _, err := m.stt.Recognize(audio)
+	callID, _, err := m.store.FindSessionByIdentity(sessionID)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := m.callContext(ctx, callID)
+	defer cancel()
+
+	pcm, err := audio.NewSpeechReader(r)
 	if err != nil {
 		return "", err
 	}
 
-	// optionally generate LLM response
+	// run STT on every utterance
+	started := time.Now()
+	results, err := vad.Transcribe(ctx, m.stt, m.vad, pcm)
+	if err != nil {
+		return "", err
+	}
+	heardAt := time.Now()
+	texts := make([]string, 0, len(results))
+	var confidence float32
+	for _, res := range results {
+		texts = append(texts, res.Text)
+		confidence += res.Confidence
+	}
+	transcript := strings.Join(texts, " ")
+	if transcript == "" {
+		return "", nil
+	}
+	var history *conversation.History
+	if m.llm != nil {
+		// taken before the caller's turn is recorded, as it may be rebuilt from the transcript
+		history = m.history(callID)
+	}
+	m.record(store.Turn{
+		CallID:     callID,
+		SessionID:  sessionID,
+		Speaker:    store.SpeakerCaller,
+		Text:       transcript,
+		Confidence: confidence / float32(len(results)),
+		StartedAt:  started,
+		EndedAt:    heardAt,
+		STTLatency: heardAt.Sub(started),
+	})
+
+	// optionally generate LLM response, with the call's conversation so far as context
 	var reply string
 	if m.llm != nil {
-		r, err := m.llm.Generate(transcript)
+		history.Add(interfaces.RoleUser, transcript)
+		r, err := m.llm.Chat(ctx, history.Messages())
 		if err == nil {
 			reply = r
 		}
+		if reply != "" {
+			history.Add(interfaces.RoleAssistant, reply)
+		}
 	}
 	if reply == "" {
 		reply = "I heard you. Let me know if you'd like help."
 	}
+	repliedAt := time.Now()
 
 	// synthesize reply
+	var spokenAt time.Time
 	if m.tts != nil {
-		audioOut, err := m.tts.Speak(reply)
+		audioOut, err := m.tts.Speak(ctx, reply)
+		spokenAt = time.Now()
 		if err == nil && len(audioOut) > 0 {
-			outDir := filepath.Join("out", "agents")
-			_ = os.MkdirAll(outDir, 0755)
-			fname := filepath.Join(outDir, fmt.Sprintf("agent-reply-%s-%d.wav", sessionID, time.Now().Unix()))
-			_ = os.WriteFile(fname, audioOut, 0644)
+			m.storeReply(ctx, sessionID, audioOut, spokenAt.Unix())
 		}
 	}
 
+	agentTurn := store.Turn{
+		CallID:     callID,
+		SessionID:  m.agentSession(callID),
+		Speaker:    store.SpeakerAgent,
+		Text:       reply,
+		StartedAt:  repliedAt,
+		EndedAt:    repliedAt,
+		LLMLatency: repliedAt.Sub(heardAt),
+	}
+	if !spokenAt.IsZero() {
+		agentTurn.EndedAt, agentTurn.TTSLatency = spokenAt, spokenAt.Sub(repliedAt)
+	}
+	m.record(agentTurn)
+
 	return transcript, nil
 }
+
+// record adds a turn to the call's transcript.
+func (m *AgentManager) record(t store.Turn) {
+	if _, err := m.store.AddTurn(t); err != nil {
+		log.Printf("record %s turn for call %s: %v", t.Speaker, t.CallID, err)
+	}
+}
+
+// agentSession returns the session of the call's running agent, or "" if none is running.
+func (m *AgentManager) agentSession(callID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.agents[callID]
+}
+
+// forgetLocked drops the call's agent from the manager and gives its place to the next
+// call in the queue. m.mu must be held.
+func (m *AgentManager) forgetLocked(callID string) {
+	m.dropLocked(callID)
+	m.freeLocked(callID)
+}
+
+// dropLocked drops the call's agent from the manager; it keeps counting against the limits.
+// m.mu must be held.
+func (m *AgentManager) dropLocked(callID string) {
+	delete(m.agents, callID)
+	delete(m.clients, callID)
+	delete(m.cancels, callID)
+	delete(m.contexts, callID)
+	delete(m.histories, callID)
+	if stop, ok := m.holds[callID]; ok {
+		stop()
+		delete(m.holds, callID)
+	}
+}
+
+// storedHistoryLocked rebuilds the conversation of a call from its transcript. m.mu must be
+// held.
+func (m *AgentManager) storedHistoryLocked(callID string) *conversation.History {
+	h := conversation.New(m.systemPromptLocked(), 0)
+	turns, err := m.store.ListTurns(callID)
+	if err != nil {
+		log.Printf("history of call %s: %v", callID, err)
+	}
+	for _, t := range turns {
+		switch {
+		case t.Speaker == store.SpeakerCaller:
+			h.Add(interfaces.RoleUser, t.Text)
+		case t.Interrupted:
+			h.AddInterrupted(t.Text)
+		default:
+			h.Add(interfaces.RoleAssistant, t.Text)
+		}
+	}
+	return h
+}
+
+// historyLocked returns the conversation history for a call, creating it if needed.
+// m.mu must be held.
+func (m *AgentManager) historyLocked(callID string) *conversation.History {
+	h, ok := m.histories[callID]
+	if !ok {
+		h = conversation.New(m.systemPromptLocked(), 0)
+		m.histories[callID] = h
+	}
+	return h
+}
+
+// history returns the conversation history of the call's agent. Calls without a running
+// agent get their conversation rebuilt from the transcript; it is not kept, as nothing would
+// drop it.
+func (m *AgentManager) history(callID string) *conversation.History {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok := m.histories[callID]; ok {
+		return h
+	}
+	return m.storedHistoryLocked(callID)
+}
+
+// callContext derives a context from ctx that is also cancelled when the call's agent is
+// stopped or, for calls without a running agent, when the manager shuts down.
+func (m *AgentManager) callContext(ctx context.Context, callID string) (context.Context, context.CancelFunc) {
+	m.mu.Lock()
+	parent, ok := m.contexts[callID]
+	m.mu.Unlock()
+	if !ok {
+		parent = m.ctx
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(parent, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
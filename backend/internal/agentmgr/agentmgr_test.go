@@ -0,0 +1,120 @@
+package agentmgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// fakeSTT transcribes every utterance as text.
+type fakeSTT struct{ text string }
+
+func (f fakeSTT) Recognize(ctx context.Context, audio []byte, opts ...interfaces.STTOption) (string, float32, error) {
+	return f.text, 1, nil
+}
+
+func (f fakeSTT) RecognizeStream(ctx context.Context, opts ...interfaces.STTOption) (interfaces.STTStream, error) {
+	return &fakeSTTStream{text: f.text, results: make(chan interfaces.STTResult, 1)}, nil
+}
+
+type fakeSTTStream struct {
+	text    string
+	results chan interfaces.STTResult
+}
+
+func (s *fakeSTTStream) Write(pcm []byte) error               { return nil }
+func (s *fakeSTTStream) Results() <-chan interfaces.STTResult { return s.results }
+func (s *fakeSTTStream) Err() error                           { return nil }
+
+func (s *fakeSTTStream) Close() error {
+	s.results <- interfaces.STTResult{Text: s.text, Confidence: 1, Final: true}
+	close(s.results)
+	return nil
+}
+
+// fakeLLM replies with reply and records the conversations it was given.
+type fakeLLM struct {
+	reply string
+	seen  [][]interfaces.Message
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, prompt string, opts ...interfaces.LLMOption) (string, error) {
+	return f.reply, nil
+}
+
+func (f *fakeLLM) GenerateStream(ctx context.Context, prompt string, w io.Writer, opts ...interfaces.LLMOption) error {
+	_, err := io.WriteString(w, f.reply)
+	return err
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, messages []interfaces.Message, opts ...interfaces.LLMOption) (string, error) {
+	f.seen = append(f.seen, messages)
+	return f.reply, nil
+}
+
+func (f *fakeLLM) ChatStream(ctx context.Context, messages []interfaces.Message, w io.Writer, opts ...interfaces.LLMOption) error {
+	f.seen = append(f.seen, messages)
+	_, err := io.WriteString(w, f.reply)
+	return err
+}
+
+// speech is a second of tone followed by a second of silence.
+func speech() []byte {
+	samples := make([]int16, 2*audio.SpeechFormat.SampleRate)
+	for i := range samples[:audio.SpeechFormat.SampleRate] {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/float64(audio.SpeechFormat.SampleRate)))
+	}
+	return audio.SamplesToBytes(samples)
+}
+
+func TestProcessIncomingAudio(t *testing.T) {
+	m, st := newTestManager(t)
+	llm := &fakeLLM{reply: "Your order ships today."}
+	m.stt, m.llm = fakeSTT{text: "where is my order"}, llm
+
+	// audio of sessions that are not in the store is refused
+	if _, err := m.ProcessIncomingAudio(context.Background(), "no-such-session", bytes.NewReader(speech())); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("unknown session: %v, want ErrNotFound", err)
+	}
+
+	// a call without a running agent gets its conversation from the transcript, not kept
+	callID, callerSession, err := st.CreateCall("erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		got, err := m.ProcessIncomingAudio(context.Background(), callerSession, bytes.NewReader(speech()))
+		if err != nil || got != "where is my order" {
+			t.Fatalf("ProcessIncomingAudio = %q, %v", got, err)
+		}
+	}
+	m.mu.Lock()
+	histories := len(m.histories)
+	m.mu.Unlock()
+	if histories != 0 {
+		t.Fatalf("%d histories kept for calls without agents", histories)
+	}
+	turns, err := st.ListTurns(callID)
+	if err != nil || len(turns) != 4 {
+		t.Fatalf("turns = %+v, %v", turns, err)
+	}
+	for _, turn := range turns {
+		if turn.CallID != callID {
+			t.Fatalf("turn recorded for call %q, want %q", turn.CallID, callID)
+		}
+	}
+	// the second utterance is answered with the first exchange as context
+	if len(llm.seen) != 2 {
+		t.Fatalf("LLM asked %d times, want 2", len(llm.seen))
+	}
+	if msgs := llm.seen[1]; len(msgs) != 4 || msgs[1].Content != "where is my order" || msgs[2].Content != "Your order ships today." {
+		t.Fatalf("second conversation = %+v", msgs)
+	}
+}
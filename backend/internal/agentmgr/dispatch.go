@@ -0,0 +1,100 @@
+package agentmgr
+
+import (
+	"context"
+	"log"
+
+	"github.com/jacky-htg/ai-call-center/libs/dispatch"
+)
+
+// Dispatcher runs the agents of calls on workers; *workers.Pool implements it.
+type Dispatcher interface {
+	// Assign hands the call to a worker and returns the worker's id.
+	Assign(ctx context.Context, a dispatch.Assignment) (workerID string, err error)
+	// Release asks the worker running the call's agent to stop it.
+	Release(callID string) error
+}
+
+// SetDispatcher makes agents spawned from now on run on workers through d rather than in
+// this process. Their statuses are to be passed to HandleWorkerStatus.
+func (m *AgentManager) SetDispatcher(d Dispatcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dispatcher = d
+}
+
+// dispatchLocked hands the agent of a session to a worker in the background, with the
+// conversation of the call so far. m.mu must be held.
+func (m *AgentManager) dispatchLocked(callID, sessionID, url, token string) {
+	a := dispatch.Assignment{CallID: callID, SessionID: sessionID, URL: url, Token: token}
+	for _, msg := range m.storedHistoryLocked(callID).Messages()[1:] {
+		a.History = append(a.History, dispatch.HistoryMessage{Role: msg.Role, Content: msg.Content})
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.agents[callID] = sessionID
+	m.cancels[callID] = cancel
+	m.contexts[callID] = ctx
+
+	m.running.Add(1)
+	go func() {
+		defer m.running.Done()
+		workerID, err := m.dispatcher.Assign(ctx, a)
+		if err == nil {
+			log.Printf("agent session %s of call %s runs on worker %s", sessionID, callID, workerID)
+			return
+		}
+		log.Printf("dispatch agent of call %s: %v", callID, err)
+		m.mu.Lock()
+		if m.agents[callID] == sessionID {
+			m.forgetLocked(callID)
+		}
+		m.mu.Unlock()
+		stopped := ctx.Err() != nil
+		cancel()
+		if stopped {
+			_ = m.store.UpdateSessionStatus(sessionID, "ended")
+		} else {
+			_ = m.store.EndSession(sessionID, ReasonJoinFailed)
+		}
+	}()
+}
+
+// HandleWorkerStatus acts on the status a worker reports for an agent: the session of an
+// agent that joined its room is active and that of one that left is ended. Agents lost with
+// their worker are dispatched again with a fresh token.
+func (m *AgentManager) HandleWorkerStatus(s dispatch.Status) {
+	switch s.State {
+	case dispatch.StateJoined:
+		log.Printf("agent session %s joined room %s", s.SessionID, s.CallID)
+		_ = m.store.UpdateSessionStatus(s.SessionID, "active")
+	case dispatch.StateEnded:
+		m.mu.Lock()
+		if m.agents[s.CallID] == s.SessionID {
+			cancel := m.cancels[s.CallID]
+			cancel()
+			if s.Reason == dispatch.ReasonWorkerLost && m.ctx.Err() == nil {
+				// the agent keeps its place within the limits
+				m.dropLocked(s.CallID)
+				_, err := m.joinLocked(s.CallID, s.SessionID, s.SessionID)
+				if err != nil {
+					m.freeLocked(s.CallID)
+				}
+				m.mu.Unlock()
+				if err == nil {
+					log.Printf("agent session %s of call %s lost its worker; dispatched again", s.SessionID, s.CallID)
+					return
+				}
+				log.Printf("dispatch agent of call %s again: %v", s.CallID, err)
+				_ = m.store.EndSession(s.SessionID, ReasonJoinFailed)
+				return
+			}
+			m.forgetLocked(s.CallID)
+		}
+		m.mu.Unlock()
+		if s.Reason == "" {
+			_ = m.store.UpdateSessionStatus(s.SessionID, "ended")
+		} else {
+			_ = m.store.EndSession(s.SessionID, s.Reason)
+		}
+	}
+}
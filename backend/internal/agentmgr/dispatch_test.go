@@ -0,0 +1,106 @@
+package agentmgr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/jacky-htg/ai-call-center/libs/dispatch"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// fakeDispatcher takes every assignment unless err is set.
+type fakeDispatcher struct {
+	err      error
+	assigned chan dispatch.Assignment
+
+	mu       sync.Mutex
+	released []string
+}
+
+func (d *fakeDispatcher) Assign(ctx context.Context, a dispatch.Assignment) (string, error) {
+	if d.err != nil {
+		return "", d.err
+	}
+	d.assigned <- a
+	return "worker-1", nil
+}
+
+func (d *fakeDispatcher) Release(callID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.released = append(d.released, callID)
+	return nil
+}
+
+func TestDispatch(t *testing.T) {
+	m, st := newTestManager(t)
+	d := &fakeDispatcher{assigned: make(chan dispatch.Assignment, 4)}
+	m.SetDispatcher(d)
+
+	callID, _, err := st.CreateCall("erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.AddTurn(store.Turn{CallID: callID, Speaker: store.SpeakerCaller, Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	sessionID, token, err := m.SpawnAgent(callID)
+	if err != nil {
+		t.Fatalf("SpawnAgent: %v", err)
+	}
+	a := <-d.assigned
+	if a.CallID != callID || a.SessionID != sessionID || a.Token != token || len(a.History) != 1 || a.History[0].Content != "hello" {
+		t.Fatalf("assignment = %+v", a)
+	}
+
+	m.HandleWorkerStatus(dispatch.Status{CallID: callID, SessionID: sessionID, State: dispatch.StateJoined})
+	if s, err := st.GetSession(sessionID); err != nil || s.Status != "active" {
+		t.Fatalf("joined agent = %+v, %v", s, err)
+	}
+
+	// an agent lost with its worker is dispatched again with a fresh token
+	m.HandleWorkerStatus(dispatch.Status{CallID: callID, SessionID: sessionID, State: dispatch.StateEnded, Reason: dispatch.ReasonWorkerLost})
+	again := <-d.assigned
+	if again.SessionID != sessionID || again.Token == "" {
+		t.Fatalf("assignment after worker lost = %+v", again)
+	}
+	if m.agentSession(callID) != sessionID {
+		t.Fatal("agent of call forgotten after worker lost")
+	}
+
+	if err := m.StopAgent(callID); err != nil {
+		t.Fatalf("StopAgent: %v", err)
+	}
+	d.mu.Lock()
+	released := d.released
+	d.mu.Unlock()
+	if len(released) != 1 || released[0] != callID {
+		t.Fatalf("released = %v", released)
+	}
+	m.HandleWorkerStatus(dispatch.Status{CallID: callID, SessionID: sessionID, State: dispatch.StateEnded})
+	if s, err := st.GetSession(sessionID); err != nil || s.Status != "ended" || s.EndReason != "" {
+		t.Fatalf("released agent = %+v, %v", s, err)
+	}
+}
+
+func TestDispatch_Failed(t *testing.T) {
+	m, st := newTestManager(t)
+	m.SetDispatcher(&fakeDispatcher{err: errors.New("no worker has capacity")})
+	callID, _, err := st.CreateCall("erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID, _, err := m.SpawnAgent(callID)
+	if err != nil {
+		t.Fatalf("SpawnAgent: %v", err)
+	}
+	m.running.Wait()
+	if s, err := st.GetSession(sessionID); err != nil || s.Status != "ended" || s.EndReason != ReasonJoinFailed {
+		t.Fatalf("undispatched agent = %+v, %v", s, err)
+	}
+	if m.agentSession(callID) != "" {
+		t.Fatal("undispatched agent is still known")
+	}
+}
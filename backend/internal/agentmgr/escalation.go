@@ -0,0 +1,224 @@
+package agentmgr
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/conversation"
+	"github.com/jacky-htg/ai-call-center/backend/internal/livekitclient"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// ReasonEscalated ends the session of an AI agent whose call a human agent took over.
+const ReasonEscalated = "escalated"
+
+// Defaults of escalation.
+const (
+	DefaultHoldMessage   = "Please hold while I transfer you to a colleague."
+	DefaultResumeMessage = "Sorry, none of my colleagues is free right now. I'll keep helping you myself."
+	DefaultHoldRepeat    = 20 * time.Second
+)
+
+// summaryPrompt asks the LLM for the summary handed to the human agent.
+const summaryPrompt = "Summarize this call for the colleague taking it over: what the caller wants, " +
+	"what they told you and what was tried. Use at most three sentences."
+
+// summaryTimeout bounds writing the summary, which keeps the caller from the queue.
+const summaryTimeout = 10 * time.Second
+
+// Escalator queues the calls AI agents hand over for human agents. *acd.Router implements it.
+type Escalator interface {
+	Escalate(callID, queue, summary string) error
+}
+
+// Escalation says when AI agents hand their calls to human agents and what callers hear
+// while they wait for one. The zero value never escalates.
+type Escalation struct {
+	// Queue is the human queue escalated calls wait in; empty disables escalation.
+	Queue string
+	// Keywords, Intent and LowConfidenceStreak trigger escalation; see livekitclient.Escalation.
+	Keywords            []string
+	Intent              bool
+	LowConfidenceStreak int
+	// HoldAudio is played to the waiting caller every HoldRepeat, or HoldMessage is spoken
+	// if there is no audio.
+	HoldAudio   []byte
+	HoldMessage string
+	HoldRepeat  time.Duration
+	// ResumeMessage is spoken when no human took the call and the AI agent takes it back.
+	ResumeMessage string
+}
+
+// SetEscalation makes agents hand their calls to human agents through to as e says.
+// Agents running on workers do not escalate, so the server refuses to start with both.
+func (m *AgentManager) SetEscalation(e Escalation, to Escalator) {
+	if e.HoldMessage == "" {
+		e.HoldMessage = DefaultHoldMessage
+	}
+	if e.HoldRepeat <= 0 {
+		e.HoldRepeat = DefaultHoldRepeat
+	}
+	if e.ResumeMessage == "" {
+		e.ResumeMessage = DefaultResumeMessage
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.escalation, m.escalator = e, to
+}
+
+// escalatesLocked reports whether agents hand calls over. m.mu must be held.
+func (m *AgentManager) escalatesLocked() bool {
+	return m.escalator != nil && m.escalation.Queue != ""
+}
+
+// systemPromptLocked is the system prompt of new conversations, which tells the LLM how to
+// escalate if agents escalate on intent. m.mu must be held.
+func (m *AgentManager) systemPromptLocked() string {
+	if !m.escalatesLocked() || !m.escalation.Intent {
+		return m.cfg.SystemPrompt
+	}
+	p := m.cfg.SystemPrompt
+	if p == "" {
+		p = conversation.DefaultSystemPrompt
+	}
+	return p + " " + livekitclient.EscalationPrompt
+}
+
+// escalationLocked returns the escalation settings of the room client of a call.
+// m.mu must be held.
+func (m *AgentManager) escalationLocked(callID string, rc *livekitclient.RoomClient) livekitclient.Escalation {
+	e := m.escalation
+	return livekitclient.Escalation{
+		Keywords:            e.Keywords,
+		Intent:              e.Intent,
+		LowConfidenceStreak: e.LowConfidenceStreak,
+		OnEscalate:          func(reason string) { m.escalate(callID, rc, reason) },
+	}
+}
+
+// escalate puts the caller on hold and queues the call for a human agent with a summary of
+// the conversation. If the call cannot be queued the agent goes on talking to the caller.
+func (m *AgentManager) escalate(callID string, rc *livekitclient.RoomClient, reason string) {
+	m.mu.Lock()
+	if m.clients[callID] != rc {
+		m.mu.Unlock()
+		return
+	}
+	ctx := m.contexts[callID]
+	hold, stop := context.WithCancel(ctx)
+	m.holds[callID] = stop
+	e, to, history := m.escalation, m.escalator, m.historyLocked(callID)
+	m.running.Add(1)
+	m.mu.Unlock()
+
+	log.Printf("escalating call %s to queue %s: %s", callID, e.Queue, reason)
+	go func() {
+		defer m.running.Done()
+		m.hold(hold, rc, e)
+	}()
+	summary := m.summarize(ctx, history)
+	if err := to.Escalate(callID, e.Queue, summary); err != nil {
+		log.Printf("escalate call %s: %v", callID, err)
+		m.mu.Lock()
+		if m.clients[callID] == rc {
+			m.resumeLocked(callID, "")
+		}
+		m.mu.Unlock()
+	}
+}
+
+// hold plays the hold audio to the caller until ctx is cancelled.
+func (m *AgentManager) hold(ctx context.Context, rc *livekitclient.RoomClient, e Escalation) {
+	sound := e.HoldAudio
+	if len(sound) == 0 {
+		if m.tts == nil {
+			return
+		}
+		var err error
+		if sound, err = m.tts.Speak(ctx, e.HoldMessage); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("hold message: %v", err)
+			}
+			return
+		}
+	}
+	for {
+		if err := rc.Play(ctx, sound); err != nil && ctx.Err() == nil {
+			log.Printf("play hold audio: %v", err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.HoldRepeat):
+		}
+	}
+}
+
+// summarize returns the summary of a conversation handed to the human agent. Without an LLM
+// it is what the caller said.
+func (m *AgentManager) summarize(ctx context.Context, history *conversation.History) string {
+	msgs := history.Messages()
+	if m.llm != nil {
+		ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+		defer cancel()
+		summary, err := m.llm.Chat(ctx, append(msgs, interfaces.Message{Role: interfaces.RoleUser, Content: summaryPrompt}))
+		if summary = strings.TrimSpace(summary); err == nil && summary != "" {
+			return summary
+		}
+		log.Printf("summarize call: %v", err)
+	}
+	var said []string
+	for _, msg := range msgs {
+		if msg.Role == interfaces.RoleUser {
+			said = append(said, msg.Content)
+		}
+	}
+	if len(said) == 0 {
+		return ""
+	}
+	return "The caller said: " + strings.Join(said, " / ")
+}
+
+// HandOver removes the AI agent from a call it escalated, once the human agent has joined.
+// The agents of calls that were not escalated are left alone.
+func (m *AgentManager) HandOver(callID string) {
+	m.mu.Lock()
+	_, escalated := m.holds[callID]
+	sessionID := m.agents[callID]
+	m.mu.Unlock()
+	if !escalated {
+		return
+	}
+	m.endSession(sessionID, ReasonEscalated)
+	if err := m.StopAgent(callID); err != nil {
+		log.Printf("hand over call %s: %v", callID, err)
+	}
+	log.Printf("handed call %s over to a human agent", callID)
+}
+
+// resumeLocked makes the agent of an escalated call talk to the caller again, saying
+// message first if it is not empty. m.mu must be held.
+func (m *AgentManager) resumeLocked(callID, message string) {
+	if stop, ok := m.holds[callID]; ok {
+		stop()
+		delete(m.holds, callID)
+	}
+	rc := m.clients[callID]
+	if rc == nil {
+		return
+	}
+	rc.Resume()
+	if message == "" {
+		return
+	}
+	m.running.Add(1)
+	go func() {
+		defer m.running.Done()
+		if err := rc.Say(message); err != nil {
+			log.Printf("resume call %s: %v", callID, err)
+		}
+	}()
+}
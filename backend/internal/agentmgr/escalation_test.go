@@ -0,0 +1,105 @@
+package agentmgr
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/livekitclient"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// fakeEscalator records the calls handed to human agents.
+type fakeEscalator struct {
+	mu        sync.Mutex
+	err       error
+	summaries map[string]string
+}
+
+func (f *fakeEscalator) Escalate(callID, queue, summary string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.summaries[callID] = queue + ": " + summary
+	return nil
+}
+
+func (m *AgentManager) onHold(callID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.holds[callID]
+	return ok
+}
+
+func TestEscalation(t *testing.T) {
+	m, st := newTestManager(t)
+	esc := &fakeEscalator{summaries: make(map[string]string)}
+	m.SetEscalation(Escalation{Queue: "support", Intent: true}, esc)
+
+	callID := newCall(t, st)
+	sessionID, _, err := m.SpawnAgent(callID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	rc := m.clients[callID]
+	m.mu.Unlock()
+	h := m.history(callID)
+	if system := h.Messages()[0].Content; !strings.HasSuffix(system, livekitclient.EscalationPrompt) {
+		t.Fatalf("system prompt = %q", system)
+	}
+	h.Add(interfaces.RoleUser, "my card was stolen")
+
+	// the call goes to the queue with what the caller said, and the caller is on hold
+	m.escalate(callID, rc, livekitclient.EscalateIntent)
+	if got := esc.summaries[callID]; got != "support: The caller said: my card was stolen" {
+		t.Fatalf("escalated as %q", got)
+	}
+	if !m.onHold(callID) {
+		t.Fatal("caller not on hold")
+	}
+
+	// no human took the call: its agent takes it back
+	again, _, err := m.SpawnAgent(callID)
+	if err != nil || again != sessionID {
+		t.Fatalf("SpawnAgent of an escalated call = %q, %v; want %q", again, err, sessionID)
+	}
+	if m.onHold(callID) {
+		t.Fatal("caller still on hold")
+	}
+
+	// a human joined: the agent leaves the call
+	m.escalate(callID, rc, livekitclient.EscalateKeyword)
+	m.HandOver(callID)
+	if m.agentSession(callID) != "" {
+		t.Fatal("agent still in the call")
+	}
+	if s, err := st.GetSession(sessionID); err != nil || s.Status != "ended" || s.EndReason != ReasonEscalated {
+		t.Fatalf("agent session = %+v, %v", s, err)
+	}
+}
+
+func TestEscalation_Failed(t *testing.T) {
+	m, st := newTestManager(t)
+	m.SetEscalation(Escalation{Queue: "support"}, &fakeEscalator{err: errors.New("queue not found")})
+	callID := newCall(t, st)
+	if _, _, err := m.SpawnAgent(callID); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	rc := m.clients[callID]
+	m.mu.Unlock()
+
+	m.escalate(callID, rc, livekitclient.EscalateKeyword)
+	if m.onHold(callID) {
+		t.Fatal("call that could not be queued left on hold")
+	}
+	// HandOver leaves agents of calls that are not escalated alone
+	m.HandOver(callID)
+	if m.agentSession(callID) == "" {
+		t.Fatal("agent of a call that was not escalated removed")
+	}
+}
@@ -0,0 +1,131 @@
+package agentmgr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/livekitclient"
+	"github.com/jacky-htg/ai-call-center/libs/blob"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// SetRecording makes agents spawned from now on record their calls with rs into the blob
+// store set with SetBlobStore.
+func (m *AgentManager) SetRecording(rs livekitclient.RecordingSettings) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recording = rs
+}
+
+// SetBlobStore sets where recordings and synthesized replies are stored.
+func (m *AgentManager) SetBlobStore(b blob.Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs = b
+}
+
+// callRecording is a recording being written by an agent's room client. It is spooled to a
+// temporary file, since a WAV header can only be completed at the end, and stored when the
+// call ends.
+type callRecording struct {
+	id          string
+	key         string
+	contentType string
+	file        *os.File
+	recorder    *livekitclient.CallRecorder
+}
+
+// startRecordingLocked starts recording the call an agent joins, to be stored under
+// recordings/<callID>/<name><ext>. It returns nil if recording is off or cannot start; the
+// call goes ahead unrecorded. m.mu must be held.
+func (m *AgentManager) startRecordingLocked(callID, name string) *callRecording {
+	rs := m.recording
+	if !rs.Enabled() || m.blobs == nil {
+		return nil
+	}
+	contentType, ext := livekitclient.RecordingContentType(rs.Format)
+	rec := &callRecording{key: path.Join(blob.PrefixRecordings, callID, name+ext), contentType: contentType}
+
+	f, err := os.CreateTemp("", "recording-*"+ext)
+	if err != nil {
+		log.Printf("record call %s: %v", callID, err)
+		return nil
+	}
+	rec.file = f
+	if rec.recorder, err = livekitclient.NewCallRecorder(f, rs.Format, rs.Mode); err != nil {
+		log.Printf("record call %s: %v", callID, err)
+		rec.discard()
+		return nil
+	}
+	channels := 1
+	if rs.Mode == livekitclient.RecordStereo {
+		channels = 2
+	}
+	rec.id, err = m.store.CreateRecording(store.Recording{
+		CallID:      callID,
+		Key:         rec.key,
+		ContentType: contentType,
+		Channels:    channels,
+		SampleRate:  livekitclient.RecordingSampleRate,
+	})
+	if err != nil {
+		log.Printf("record call %s: %v", callID, err)
+		_, _ = rec.recorder.Close()
+		rec.discard()
+		return nil
+	}
+	return rec
+}
+
+// finish completes the file, stores it and records its size and length, or that it failed.
+func (r *callRecording) finish(st store.Repository, blobs blob.Store) {
+	if r == nil {
+		return
+	}
+	defer r.discard()
+	duration, err := r.recorder.Close()
+	var size int64
+	if err == nil {
+		size, err = r.file.Seek(0, io.SeekEnd)
+	}
+	if err == nil {
+		_, err = r.file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		// the call's context is gone by now; the upload must not be cut short with it
+		err = blobs.Put(context.Background(), r.key, r.file, r.contentType)
+	}
+	status := store.RecordingReady
+	if err != nil {
+		log.Printf("recording %s: %v", r.id, err)
+		status = store.RecordingFailed
+	}
+	if err := st.FinishRecording(r.id, size, duration, status); err != nil {
+		log.Printf("finish recording %s: %v", r.id, err)
+	}
+}
+
+// discard removes the spooled file.
+func (r *callRecording) discard() {
+	_ = r.file.Close()
+	_ = os.Remove(r.file.Name())
+}
+
+// storeReply keeps a synthesized reply of the session's agent.
+func (m *AgentManager) storeReply(ctx context.Context, sessionID string, wav []byte, at int64) {
+	m.mu.Lock()
+	blobs := m.blobs
+	m.mu.Unlock()
+	if blobs == nil {
+		return
+	}
+	key := path.Join(blob.PrefixTTS, fmt.Sprintf("agent-reply-%s-%d.wav", sessionID, at))
+	if err := blobs.Put(ctx, key, bytes.NewReader(wav), "audio/wav"); err != nil {
+		log.Printf("store reply of session %s: %v", sessionID, err)
+	}
+}
@@ -0,0 +1,187 @@
+package agentmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/store"
+	roomservice "github.com/jacky-htg/ai-call-center/libs/vendors/livekit"
+)
+
+// Reasons recorded for the calls and sessions the manager ends.
+const (
+	// ReasonRoomClosed ends a call whose LiveKit room no longer exists.
+	ReasonRoomClosed = "room_closed"
+	// ReasonCallEnded ends a session that outlived its call.
+	ReasonCallEnded = "call_ended"
+	// ReasonJoinFailed ends an agent session that could not join or rejoin its room.
+	ReasonJoinFailed = "join_failed"
+	// ReasonReplaced ends an agent session when another agent session of the call takes over.
+	ReasonReplaced = "replaced"
+)
+
+// RoomLister lists LiveKit rooms; the RoomService client of libs/vendors/livekit implements it.
+type RoomLister interface {
+	ListRooms(ctx context.Context, names ...string) ([]roomservice.Room, error)
+}
+
+// Recover reconciles the store with LiveKit when the server starts. Calls and agent sessions
+// the store holds active have no room client behind them after a restart: agents of calls
+// whose room still exists rejoin it with a fresh token and the conversation so far, and calls
+// whose room is gone are ended with their sessions. Nothing is changed if the rooms cannot be
+// listed.
+func (m *AgentManager) Recover(ctx context.Context, rooms RoomLister) error {
+	calls, err := m.activeCalls()
+	if err != nil {
+		return fmt.Errorf("list active calls: %w", err)
+	}
+	agents, err := m.store.ListActiveSessions("agent")
+	if err != nil {
+		return fmt.Errorf("list active agent sessions: %w", err)
+	}
+	// agents may be left behind by calls that have ended
+	agentsOf := make(map[string][]store.Session)
+	for _, a := range agents {
+		agentsOf[a.CallID] = append(agentsOf[a.CallID], a)
+		if _, ok := calls[a.CallID]; ok {
+			continue
+		}
+		c, err := m.store.GetCall(a.CallID)
+		if errors.Is(err, store.ErrNotFound) {
+			c = &store.Call{ID: a.CallID, Status: "ended"}
+		} else if err != nil {
+			return fmt.Errorf("get call of agent session %s: %w", a.ID, err)
+		}
+		calls[a.CallID] = *c
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(calls))
+	for callID := range calls {
+		names = append(names, callID)
+	}
+	sort.Strings(names)
+	live, err := rooms.ListRooms(ctx, names...)
+	if err != nil {
+		return fmt.Errorf("list livekit rooms: %w", err)
+	}
+	open := make(map[string]bool, len(live))
+	for _, r := range live {
+		open[r.Name] = true
+	}
+
+	for _, callID := range names {
+		// recordings in progress were lost with the process that wrote them
+		m.failRecordings(callID)
+		switch {
+		case calls[callID].Status == "ended":
+			for _, a := range agentsOf[callID] {
+				m.endSession(a.ID, ReasonCallEnded)
+			}
+		case !open[callID]:
+			m.endCall(callID, ReasonRoomClosed)
+		default:
+			m.rejoin(callID, agentsOf[callID])
+		}
+	}
+	return nil
+}
+
+// activeCalls returns the calls the store holds active, by id.
+func (m *AgentManager) activeCalls() (map[string]store.Call, error) {
+	calls := make(map[string]store.Call)
+	f := store.CallFilter{Status: "active", Limit: store.MaxPageSize}
+	for {
+		page, next, err := m.store.ListCalls(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page {
+			calls[c.ID] = c
+		}
+		if next == "" {
+			return calls, nil
+		}
+		f.Cursor = next
+	}
+}
+
+// rejoin reconnects the latest agent session of a call whose room still exists; older ones
+// are ended. The agent picks up the conversation from the call's transcript.
+func (m *AgentManager) rejoin(callID string, agents []store.Session) {
+	if len(agents) == 0 {
+		return
+	}
+	latest := agents[len(agents)-1]
+	for _, a := range agents[:len(agents)-1] {
+		m.endSession(a.ID, ReasonReplaced)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sessionID, ok := m.agents[callID]; ok {
+		// an agent was spawned for the call since the server started
+		if sessionID != latest.ID {
+			m.endSession(latest.ID, ReasonReplaced)
+		}
+		return
+	}
+	m.histories[callID] = m.storedHistoryLocked(callID)
+
+	// the session's earlier recording keeps its key; this one gets its own
+	recordingName := fmt.Sprintf("%s-%d", latest.ID, time.Now().Unix())
+	if _, err := m.joinLocked(callID, latest.ID, recordingName); err != nil {
+		log.Printf("recover call %s: rejoin agent session %s: %v", callID, latest.ID, err)
+		delete(m.histories, callID)
+		m.endSession(latest.ID, ReasonJoinFailed)
+		return
+	}
+	log.Printf("recovered call %s: agent session %s rejoined", callID, latest.ID)
+}
+
+// endCall ends a call and its sessions with reason.
+func (m *AgentManager) endCall(callID, reason string) {
+	sessions, err := m.store.ListSessions(callID)
+	if err != nil {
+		log.Printf("end call %s: list sessions: %v", callID, err)
+	}
+	for _, s := range sessions {
+		if s.Status != "ended" {
+			m.endSession(s.ID, reason)
+		}
+	}
+	if err := m.store.EndCall(callID, reason); err != nil {
+		log.Printf("end call %s: %v", callID, err)
+		return
+	}
+	log.Printf("ended call %s: %s", callID, reason)
+}
+
+func (m *AgentManager) endSession(sessionID, reason string) {
+	if err := m.store.EndSession(sessionID, reason); err != nil {
+		log.Printf("end session %s: %v", sessionID, err)
+	}
+}
+
+// failRecordings marks the call's recordings still in progress as failed.
+func (m *AgentManager) failRecordings(callID string) {
+	recordings, err := m.store.ListRecordings(callID)
+	if err != nil {
+		log.Printf("recover recordings of call %s: %v", callID, err)
+		return
+	}
+	for _, r := range recordings {
+		if r.Status != store.RecordingActive {
+			continue
+		}
+		if err := m.store.FinishRecording(r.ID, 0, 0, store.RecordingFailed); err != nil {
+			log.Printf("fail recording %s: %v", r.ID, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package agentmgr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacky-htg/ai-call-center/libs/config"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+	"github.com/jacky-htg/ai-call-center/libs/vad"
+	roomservice "github.com/jacky-htg/ai-call-center/libs/vendors/livekit"
+)
+
+// fakeRooms lists the rooms named in it.
+type fakeRooms struct {
+	open []string
+	err  error
+}
+
+func (f fakeRooms) ListRooms(ctx context.Context, names ...string) ([]roomservice.Room, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var rooms []roomservice.Room
+	for _, name := range f.open {
+		rooms = append(rooms, roomservice.Room{Name: name})
+	}
+	return rooms, nil
+}
+
+// newTestManager returns a manager whose agents try to join a LiveKit server that never
+// answers, so they stay joining until stopped.
+func newTestManager(t *testing.T) (*AgentManager, *store.Store) {
+	t.Helper()
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	lk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(lk.Close)
+	cfg := &config.Config{VendorSettings: map[string]map[string]string{
+		"livekit": {"url": "ws" + lk.URL[len("http"):], "api_key": "key", "api_secret": "secret-secret-secret-secret-secret"},
+	}}
+	m := New(st, cfg, nil, nil, nil, vad.Default())
+	t.Cleanup(m.Shutdown)
+	return m, st
+}
+
+// activeCall creates an active call with its caller and an agent session.
+func activeCall(t *testing.T, st *store.Store) (callID, callerSession, agentSession string) {
+	t.Helper()
+	callID, callerSession, err := st.CreateCall("erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agentSession, err = st.CreateSession(callID, "ai-agent", "agent", "active"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateSessionToken(agentSession, "stale"); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{st.UpdateSessionStatus(callerSession, "active"), st.UpdateCallStatus(callID, "active")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return callID, callerSession, agentSession
+}
+
+func TestRecover(t *testing.T) {
+	m, st := newTestManager(t)
+
+	// the agent of a call whose room is still open rejoins it
+	live, _, liveAgent := activeCall(t, st)
+	for _, turn := range []store.Turn{
+		{CallID: live, Speaker: store.SpeakerCaller, Text: "my order is late"},
+		{CallID: live, Speaker: store.SpeakerAgent, Text: "let me check", Interrupted: true},
+	} {
+		if _, err := st.AddTurn(turn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recID, err := st.CreateRecording(store.Recording{CallID: live, Key: "recordings/" + live + "/a.wav", ContentType: "audio/wav", Channels: 1, SampleRate: 16000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a call whose room is gone is ended
+	gone, goneCaller, goneAgent := activeCall(t, st)
+	// an agent left behind by a call that ended is ended too
+	ended, _, endedAgent := activeCall(t, st)
+	if err := st.UpdateCallStatus(ended, "ended"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Recover(context.Background(), fakeRooms{open: []string{live, ended}}); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+
+	if got := m.agentSession(live); got != liveAgent {
+		t.Fatalf("agent of call %s = %q, want %q", live, got, liveAgent)
+	}
+	if token, err := st.GetSessionToken(liveAgent); err != nil || token == "stale" || token == "" {
+		t.Fatalf("token of rejoined agent = %q, %v; want a fresh one", token, err)
+	}
+	if msgs := m.history(live).Messages(); len(msgs) != 3 || msgs[1].Content != "my order is late" {
+		t.Fatalf("history of rejoined call = %+v", msgs)
+	}
+	if recs, err := st.ListRecordings(live); err != nil || len(recs) != 1 || recs[0].ID != recID || recs[0].Status != store.RecordingFailed {
+		t.Fatalf("recordings of rejoined call = %+v, %v", recs, err)
+	}
+
+	if c, err := st.GetCall(gone); err != nil || c.Status != "ended" || c.EndReason != ReasonRoomClosed {
+		t.Fatalf("call without room = %+v, %v", c, err)
+	}
+	for _, id := range []string{goneCaller, goneAgent} {
+		if s, err := st.GetSession(id); err != nil || s.Status != "ended" || s.EndReason != ReasonRoomClosed {
+			t.Fatalf("session %s of call without room = %+v, %v", id, s, err)
+		}
+	}
+	if s, err := st.GetSession(endedAgent); err != nil || s.Status != "ended" || s.EndReason != ReasonCallEnded {
+		t.Fatalf("agent of ended call = %+v, %v", s, err)
+	}
+	if m.agentSession(ended) != "" {
+		t.Fatal("agent of ended call rejoined")
+	}
+
+	// stopping the rejoined agent ends it as usual
+	m.Shutdown()
+	if s, err := st.GetSession(liveAgent); err != nil || s.Status != "ended" || s.EndReason != "" {
+		t.Fatalf("stopped agent = %+v, %v", s, err)
+	}
+}
+
+func TestRecover_LiveKitUnreachable(t *testing.T) {
+	m, st := newTestManager(t)
+	callID, _, agentSession := activeCall(t, st)
+
+	if err := m.Recover(context.Background(), fakeRooms{err: errors.New("connection refused")}); err == nil {
+		t.Fatal("Recover without LiveKit: expected error")
+	}
+	if c, err := st.GetCall(callID); err != nil || c.Status != "active" {
+		t.Fatalf("call = %+v, %v; want it left active", c, err)
+	}
+	if s, err := st.GetSession(agentSession); err != nil || s.Status != "active" {
+		t.Fatalf("agent session = %+v, %v; want it left active", s, err)
+	}
+}
@@ -1,10 +1,18 @@
 package agents
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"log"
 	"os"
+	"time"
 
+	"github.com/jacky-htg/ai-call-center/backend/internal/transcript"
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/blob"
 	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	"github.com/jacky-htg/ai-call-center/libs/store"
 )
 
 // CallAgent coordinates STT, LLM, and TTS for a single call/session.
@@ -13,6 +21,9 @@ type CallAgent struct {
 	stt    interfaces.STT
 	llm    interfaces.LLM
 	webrtc interfaces.WebRTCProvider
+
+	recorder transcript.Recorder
+	callID   string
 }
 
 // New constructs a CallAgent with concrete components (injected via factory).
@@ -20,48 +31,89 @@ func New(tts interfaces.TTS, stt interfaces.STT, llm interfaces.LLM, webrtc inte
 	return &CallAgent{tts: tts, stt: stt, llm: llm, webrtc: webrtc}
 }
 
+// SetRecorder makes the agent record what it hears and replies as turns of callID.
+func (c *CallAgent) SetRecorder(r transcript.Recorder, callID string) {
+	c.recorder, c.callID = r, callID
+}
+
 // HandleAudioFile runs a simple end-to-end flow using a local audio file:
-// 1) read audio bytes
-// 2) STT -> transcript
-// 3) LLM -> response
-// 4) TTS -> audio bytes (written to output)
-func (c *CallAgent) HandleAudioFile(inputPath, outputPath string) error {
-	data, err := os.ReadFile(inputPath)
+// 1) stream audio frames into STT -> transcript
+// 2) LLM -> response
+// 3) TTS -> audio bytes (stored in out under outputKey)
+// Cancelling ctx aborts any STT/LLM/TTS request in flight.
+func (c *CallAgent) HandleAudioFile(ctx context.Context, inputPath string, out blob.Store, outputKey string) error {
+	inF, err := os.Open(inputPath)
 	if err != nil {
 		return fmt.Errorf("read input audio: %w", err)
 	}
+	defer inF.Close()
 
-	transcript, conf, err := c.stt.Recognize(data)
+	pcm, err := audio.NewSpeechReader(inF)
+	if err != nil {
+		return fmt.Errorf("read input audio: %w", err)
+	}
+	started := time.Now()
+	res, err := audio.Transcribe(ctx, c.stt, pcm, func(p interfaces.STTResult) {
+		fmt.Printf("STT partial [%s]: %s\n", p.End, p.Text)
+	})
 	if err != nil {
 		return fmt.Errorf("stt recognize: %w", err)
 	}
-	fmt.Printf("STT transcript (conf=%.2f): %s\n", conf, transcript)
+	heardAt := time.Now()
+	text := res.Text
+	fmt.Printf("STT transcript (conf=%.2f): %s\n", res.Confidence, text)
+	c.record(store.Turn{
+		Speaker:    store.SpeakerCaller,
+		Text:       text,
+		Confidence: res.Confidence,
+		StartedAt:  started,
+		EndedAt:    heardAt,
+		STTLatency: heardAt.Sub(started),
+	})
 
-	resp, err := c.llm.Generate(transcript)
+	resp, err := c.llm.Generate(ctx, text)
 	if err != nil {
 		return fmt.Errorf("llm generate: %w", err)
 	}
+	repliedAt := time.Now()
 	fmt.Printf("LLM response: %s\n", resp)
 
-	// Prefer streaming TTS to avoid buffering large audio in memory.
-	outF, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("create output file: %w", err)
-	}
-	defer outF.Close()
-
 	// Try to stream; if the TTS implementation fails to stream, fall back to Speak.
-	if err := c.tts.SpeakStream(resp, outF); err != nil {
-		// Fallback: attempt to get full bytes and write them
-		outAudio, err2 := c.tts.Speak(resp)
+	var outAudio bytes.Buffer
+	if err := c.tts.SpeakStream(ctx, resp, &outAudio); err != nil {
+		// Fallback: attempt to get full bytes instead
+		full, err2 := c.tts.Speak(ctx, resp)
 		if err2 != nil {
 			return fmt.Errorf("tts speak (stream failed: %v, fallback failed: %v)", err, err2)
 		}
-		if _, err3 := outF.Write(outAudio); err3 != nil {
-			return fmt.Errorf("write output audio (fallback): %w", err3)
-		}
+		outAudio.Reset()
+		outAudio.Write(full)
 	}
+	if err := out.Put(ctx, outputKey, bytes.NewReader(outAudio.Bytes()), "audio/wav"); err != nil {
+		return fmt.Errorf("store output audio: %w", err)
+	}
+
+	spokenAt := time.Now()
+	c.record(store.Turn{
+		Speaker:    store.SpeakerAgent,
+		Text:       resp,
+		StartedAt:  repliedAt,
+		EndedAt:    spokenAt,
+		LLMLatency: repliedAt.Sub(heardAt),
+		TTSLatency: spokenAt.Sub(repliedAt),
+	})
 
-	fmt.Printf("Wrote output audio to %s\n", outputPath)
+	fmt.Printf("Stored output audio as %s\n", outputKey)
 	return nil
 }
+
+// record adds a turn to the transcript if a recorder is set.
+func (c *CallAgent) record(t store.Turn) {
+	if c.recorder == nil {
+		return
+	}
+	t.CallID = c.callID
+	if _, err := c.recorder.AddTurn(t); err != nil {
+		log.Printf("record %s turn: %v", t.Speaker, err)
+	}
+}
@@ -1,6 +1,7 @@
 package agents
 
 import (
+	"context"
 	"net"
 	"net/url"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/jacky-htg/ai-call-center/backend/internal/factory"
+	"github.com/jacky-htg/ai-call-center/libs/blob"
 	"github.com/jacky-htg/ai-call-center/libs/config"
 )
 
@@ -105,19 +107,20 @@ func TestHandleAudioFile_RealVendors(t *testing.T) {
 		t.Fatalf("input audio not found: %v", err)
 	}
 
-	out := filepath.Join(os.TempDir(), "ai-call-e2e-output.wav")
-	// remove any previous output
-	_ = os.Remove(out)
+	out, err := blob.NewLocal(t.TempDir(), "", nil)
+	if err != nil {
+		t.Fatalf("new blob store: %v", err)
+	}
 
-	if err := ag.HandleAudioFile(in, out); err != nil {
+	if err := ag.HandleAudioFile(context.Background(), in, out, "tts/output.wav"); err != nil {
 		t.Fatalf("HandleAudioFile failed: %v", err)
 	}
 
-	fi, err := os.Stat(out)
+	info, err := out.Stat(context.Background(), "tts/output.wav")
 	if err != nil {
-		t.Fatalf("output file missing: %v", err)
+		t.Fatalf("output blob missing: %v", err)
 	}
-	if fi.Size() == 0 {
-		t.Fatalf("output file is empty")
+	if info.Size == 0 {
+		t.Fatalf("output blob is empty")
 	}
 }
@@ -1,6 +1,7 @@
 package agents
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/jacky-htg/ai-call-center/libs/blob"
+	"github.com/jacky-htg/ai-call-center/libs/store"
 	"github.com/jacky-htg/ai-call-center/libs/vendors/livekit"
 	"github.com/jacky-htg/ai-call-center/libs/vendors/ollama"
 	"github.com/jacky-htg/ai-call-center/libs/vendors/piper"
@@ -60,9 +63,15 @@ func TestHandleAudioFile_E2E_SimulatedVendors(t *testing.T) {
 	tts := piper.NewWithEndpoint(piperSrv.URL)
 	stt := whisper.NewWithEndpoint(whisperSrv.URL)
 	llm := ollama.NewWithEndpointModel(ollamaSrv.URL, "tinyllama")
-	webrtc := livekit.New()
+	webrtc := livekit.New("", "devkey", "secret")
 
 	ag := New(tts, stt, llm, webrtc)
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	ag.SetRecorder(st, "call-1")
 
 	// Input audio file (testdata/jfk.wav should exist in repository)
 	in := filepath.Join("testdata", "jfk.wav")
@@ -70,29 +79,39 @@ func TestHandleAudioFile_E2E_SimulatedVendors(t *testing.T) {
 		t.Skipf("input test audio not present: %v", err)
 	}
 
-	out, err := os.CreateTemp("", "ai-call-out-*.wav")
+	out, err := blob.NewLocal(t.TempDir(), "", nil)
 	if err != nil {
-		t.Fatalf("create temp out: %v", err)
+		t.Fatalf("new blob store: %v", err)
 	}
-	outPath := out.Name()
-	out.Close()
-	defer os.Remove(outPath)
 
-	if err := ag.HandleAudioFile(in, outPath); err != nil {
+	if err := ag.HandleAudioFile(context.Background(), in, out, "tts/output.wav"); err != nil {
 		t.Fatalf("HandleAudioFile failed: %v", err)
 	}
 
-	fi, err := os.Stat(outPath)
+	obj, err := out.Open(context.Background(), "tts/output.wav")
 	if err != nil {
-		t.Fatalf("output file missing: %v", err)
+		t.Fatalf("output blob missing: %v", err)
 	}
-	if fi.Size() == 0 {
-		t.Fatalf("output file is empty")
+	defer obj.Close()
+	if obj.Info().Size == 0 {
+		t.Fatalf("output blob is empty")
 	}
 
 	// quick content check: ensure the output contains the LLM/TTS text marker
-	b, _ := os.ReadFile(outPath)
+	b, _ := io.ReadAll(obj)
 	if !strings.Contains(string(b), "WAVDATA:") {
 		t.Fatalf("unexpected output content: %q", string(b))
 	}
+
+	// the exchange is in the call's transcript
+	turns, err := st.ListTurns("call-1")
+	if err != nil {
+		t.Fatalf("list turns: %v", err)
+	}
+	if len(turns) != 2 || turns[0].Speaker != store.SpeakerCaller || turns[1].Speaker != store.SpeakerAgent {
+		t.Fatalf("turns = %+v", turns)
+	}
+	if !strings.HasPrefix(turns[1].Text, "LLM answer to: ") || turns[1].StartedAt.Before(turns[0].EndedAt) {
+		t.Fatalf("agent turn = %+v", turns[1])
+	}
 }
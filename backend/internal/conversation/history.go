@@ -0,0 +1,67 @@
+package conversation
+
+import (
+	"sync"
+
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// DefaultSystemPrompt is used when no system prompt is configured.
+const DefaultSystemPrompt = "You are a helpful call center agent talking to a caller on the phone. " +
+	"Keep answers short and conversational because they are spoken aloud."
+
+// DefaultMaxMessages bounds how many user/assistant messages are sent to the LLM per turn.
+const DefaultMaxMessages = 20
+
+// History is the chat history of a single call. It is safe for concurrent use.
+type History struct {
+	mu          sync.Mutex
+	system      string
+	messages    []interfaces.Message
+	maxMessages int
+}
+
+// New creates a History with the given system prompt. Only the most recent maxMessages
+// messages are kept; maxMessages <= 0 uses DefaultMaxMessages.
+func New(systemPrompt string, maxMessages int) *History {
+	if systemPrompt == "" {
+		systemPrompt = DefaultSystemPrompt
+	}
+	if maxMessages <= 0 {
+		maxMessages = DefaultMaxMessages
+	}
+	return &History{system: systemPrompt, maxMessages: maxMessages}
+}
+
+// Add appends a message with the given role.
+func (h *History) Add(role, content string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, interfaces.Message{Role: role, Content: content})
+	if over := len(h.messages) - h.maxMessages; over > 0 {
+		h.messages = append([]interfaces.Message(nil), h.messages[over:]...)
+	}
+}
+
+// InterruptedMarker is appended to an assistant message whose playback was cut off by the caller,
+// so the model knows the caller did not hear the rest of the reply.
+const InterruptedMarker = "[interrupted by the caller]"
+
+// AddInterrupted records an assistant reply that the caller interrupted. spoken is the part of
+// the reply that was played before the interruption and may be empty.
+func (h *History) AddInterrupted(spoken string) {
+	content := InterruptedMarker
+	if spoken != "" {
+		content = spoken + " " + InterruptedMarker
+	}
+	h.Add(interfaces.RoleAssistant, content)
+}
+
+// Messages returns the system prompt followed by a copy of the retained messages.
+func (h *History) Messages() []interfaces.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]interfaces.Message, 0, len(h.messages)+1)
+	out = append(out, interfaces.Message{Role: interfaces.RoleSystem, Content: h.system})
+	return append(out, h.messages...)
+}
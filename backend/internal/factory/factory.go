@@ -2,9 +2,21 @@ package factory
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jacky-htg/ai-call-center/backend/internal/agentmgr"
+	"github.com/jacky-htg/ai-call-center/backend/internal/livekitclient"
+	"github.com/jacky-htg/ai-call-center/backend/internal/retention"
+	"github.com/jacky-htg/ai-call-center/libs/blob"
 	"github.com/jacky-htg/ai-call-center/libs/config"
 	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+	"github.com/jacky-htg/ai-call-center/libs/vad"
 	"github.com/jacky-htg/ai-call-center/libs/vendors/livekit"
 	"github.com/jacky-htg/ai-call-center/libs/vendors/ollama"
 	"github.com/jacky-htg/ai-call-center/libs/vendors/piper"
@@ -31,11 +43,14 @@ func NewTTS(cfg *config.Config) (interfaces.TTS, error) {
 func NewSTT(cfg *config.Config) (interfaces.STT, error) {
 	switch cfg.STTVendor {
 	case "whisper":
-		// Allow endpoint override via VendorSettings["whisper"]["endpoint"]
+		// Allow endpoint override via VendorSettings["whisper"]["endpoint"] and
+		// streaming over websocket via VendorSettings["whisper"]["stream_endpoint"]
 		if cfg != nil && cfg.VendorSettings != nil {
 			if ws, ok := cfg.VendorSettings["whisper"]; ok {
-				if ep, ok := ws["endpoint"]; ok && ep != "" {
-					return whisper.NewWithEndpoint(ep), nil
+				ep := ws["endpoint"]
+				streamEp := ws["stream_endpoint"]
+				if ep != "" || streamEp != "" {
+					return whisper.NewWithEndpoints(ep, streamEp), nil
 				}
 			}
 		}
@@ -67,8 +82,341 @@ func NewLLM(cfg *config.Config) (interfaces.LLM, error) {
 func NewWebRTC(cfg *config.Config) (interfaces.WebRTCProvider, error) {
 	switch cfg.WebRTCVendor {
 	case "livekit":
-		return livekit.New(), nil
+		lk := cfg.VendorSettings["livekit"]
+		return livekit.New(lk["url"], lk["api_key"], lk["api_secret"]), nil
 	default:
 		return nil, errors.New("unknown webrtc vendor")
 	}
 }
+
+// NewVAD returns the voice activity detector and end-of-utterance settings for calls.
+// Settings are read from VendorSettings["vad"]; durations are in milliseconds.
+func NewVAD(cfg *config.Config) (vad.Settings, error) {
+	settings := vad.Default()
+	vs := cfg.VendorSettings["vad"]
+
+	switch cfg.VADVendor {
+	case "energy", "":
+		threshold := vad.DefaultThreshold
+		if v := vs["threshold_db"]; v != "" {
+			t, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return vad.Settings{}, fmt.Errorf("parse vad threshold_db: %w", err)
+			}
+			threshold = t
+		}
+		settings.Model = func() vad.Detector { return vad.NewEnergy(threshold) }
+	default:
+		return vad.Settings{}, errors.New("unknown vad vendor")
+	}
+
+	for key, d := range map[string]*time.Duration{
+		"min_speech_ms":    &settings.Config.MinSpeech,
+		"hangover_ms":      &settings.Config.Hangover,
+		"silence_ms":       &settings.Config.Silence,
+		"preroll_ms":       &settings.Config.PreRoll,
+		"max_utterance_ms": &settings.Config.MaxUtterance,
+	} {
+		if v := vs[key]; v != "" {
+			ms, err := strconv.Atoi(v)
+			if err != nil {
+				return vad.Settings{}, fmt.Errorf("parse vad %s: %w", key, err)
+			}
+			*d = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if err := settings.Config.Validate(); err != nil {
+		return vad.Settings{}, err
+	}
+	return settings, nil
+}
+
+// DefaultDatabasePath is the SQLite database used when no DATABASE_URL is configured.
+const DefaultDatabasePath = "data/ai.callcenter.db"
+
+// NewStore opens the configured store and applies any pending schema migrations.
+func NewStore(cfg *config.Config) (store.Repository, error) {
+	driver, dsn, err := Database(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(driver, dsn)
+}
+
+// Database returns the store driver and DSN of cfg. For SQLite the DSN defaults to
+// DefaultDatabasePath and the file's directory is created.
+func Database(cfg *config.Config) (driver, dsn string, err error) {
+	switch cfg.DatabaseDriver {
+	case store.DriverSQLite, "":
+		dsn = cfg.DatabaseURL
+		if dsn == "" {
+			dsn = DefaultDatabasePath
+		}
+		if err := os.MkdirAll(filepath.Dir(dsn), 0755); err != nil {
+			return "", "", fmt.Errorf("create database dir: %w", err)
+		}
+		return store.DriverSQLite, dsn, nil
+	case store.DriverPostgres:
+		if cfg.DatabaseURL == "" {
+			return "", "", errors.New("postgres store needs DATABASE_URL")
+		}
+		return store.DriverPostgres, cfg.DatabaseURL, nil
+	default:
+		return "", "", errors.New("unknown database driver")
+	}
+}
+
+// NewRecording returns the call recording settings of cfg. Recording is off unless a mode
+// is configured.
+func NewRecording(cfg *config.Config) (livekitclient.RecordingSettings, error) {
+	rs := cfg.VendorSettings["recording"]
+	settings := livekitclient.RecordingSettings{Mode: rs["mode"], Format: rs["format"]}
+	if settings.Mode == "" {
+		settings.Mode = livekitclient.RecordOff
+	}
+	if settings.Format == "" {
+		settings.Format = livekitclient.RecordWAV
+	}
+
+	switch settings.Mode {
+	case livekitclient.RecordOff, livekitclient.RecordMixed, livekitclient.RecordStereo:
+	default:
+		return livekitclient.RecordingSettings{}, errors.New("unknown recording mode")
+	}
+	switch settings.Format {
+	case livekitclient.RecordWAV, livekitclient.RecordOpus:
+	default:
+		return livekitclient.RecordingSettings{}, errors.New("unknown recording format")
+	}
+	return settings, nil
+}
+
+// Defaults of the blob store.
+const (
+	DefaultBlobDir          = "data/blobs"
+	DefaultBlobBaseURL      = "http://localhost:8080/blobs/"
+	DefaultTTSRetentionDays = 7
+)
+
+// NewBlobStore returns the store that keeps recordings and TTS audio.
+func NewBlobStore(cfg *config.Config) (blob.Store, error) {
+	bs := cfg.VendorSettings["blob"]
+	switch bs["backend"] {
+	case "local", "":
+		dir := bs["dir"]
+		if dir == "" {
+			dir = DefaultBlobDir
+		}
+		baseURL := bs["base_url"]
+		if baseURL == "" {
+			baseURL = DefaultBlobBaseURL
+		}
+		return blob.NewLocal(dir, baseURL, []byte(bs["url_secret"]))
+	case "s3":
+		var pathStyle bool
+		if v := bs["s3_path_style"]; v != "" {
+			var err error
+			if pathStyle, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("parse s3_path_style: %w", err)
+			}
+		}
+		return blob.NewS3(blob.S3Config{
+			Endpoint:        bs["s3_endpoint"],
+			Region:          bs["s3_region"],
+			Bucket:          bs["s3_bucket"],
+			AccessKeyID:     bs["s3_access_key_id"],
+			SecretAccessKey: bs["s3_secret_access_key"],
+			PathStyle:       pathStyle,
+		})
+	default:
+		return nil, errors.New("unknown blob backend")
+	}
+}
+
+// BlobLifecycle returns the retention of blobs configured in cfg: TTS audio is kept for
+// DefaultTTSRetentionDays unless configured otherwise. Recordings are purged with their calls
+// by the retention job instead.
+func BlobLifecycle(cfg *config.Config) ([]blob.LifecycleRule, error) {
+	days := DefaultTTSRetentionDays
+	if v := cfg.VendorSettings["blob"]["tts_retention_days"]; v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 0 {
+			return nil, fmt.Errorf("invalid blob tts_retention_days %q", v)
+		}
+	}
+	if days == 0 {
+		return nil, nil
+	}
+	return []blob.LifecycleRule{{Prefix: blob.PrefixTTS, ExpireAfter: time.Duration(days) * 24 * time.Hour}}, nil
+}
+
+// Defaults of the retention job.
+const (
+	DefaultTokenRetentionDays    = 1
+	DefaultRetentionIntervalMins = 60
+)
+
+// NewRetention returns the data retention policies of cfg.
+func NewRetention(cfg *config.Config) (retention.Settings, error) {
+	rs := cfg.VendorSettings["retention"]
+	settings := retention.Settings{Interval: DefaultRetentionIntervalMins * time.Minute}
+	if v := rs["interval_minutes"]; v != "" {
+		mins, err := strconv.Atoi(v)
+		if err != nil || mins <= 0 {
+			return retention.Settings{}, fmt.Errorf("invalid retention interval_minutes %q", v)
+		}
+		settings.Interval = time.Duration(mins) * time.Minute
+	}
+
+	for _, c := range []struct {
+		class, action string
+		def           int
+	}{
+		{store.DataMetadata, rs["metadata_action"], 0},
+		{store.DataTranscripts, store.PurgeDelete, 0},
+		{store.DataRecordings, store.PurgeDelete, 0},
+		{store.DataTokens, store.PurgeDelete, DefaultTokenRetentionDays},
+	} {
+		days := c.def
+		if v := rs[c.class+"_days"]; v != "" {
+			var err error
+			if days, err = strconv.Atoi(v); err != nil || days < 0 {
+				return retention.Settings{}, fmt.Errorf("invalid retention %s_days %q", c.class, v)
+			}
+		}
+		if days == 0 {
+			continue
+		}
+		if c.action == "" {
+			c.action = store.PurgeAnonymize
+		}
+		p := retention.Policy{Class: c.class, MaxAge: time.Duration(days) * 24 * time.Hour, Action: c.action}
+		if err := p.Validate(); err != nil {
+			return retention.Settings{}, err
+		}
+		settings.Policies = append(settings.Policies, p)
+	}
+	return settings, nil
+}
+
+// Defaults of the agent queue.
+const (
+	DefaultQueueSize         = 10
+	DefaultQueueMaxWaitSecs  = 120
+	DefaultQueueAnnounceSecs = 30
+)
+
+// NewLimits returns the agent concurrency limits of cfg. Without a limit agents are not
+// capped and the queue is unused.
+func NewLimits(cfg *config.Config) (agentmgr.Limits, error) {
+	as := cfg.VendorSettings["agents"]
+	l := agentmgr.Limits{
+		Vendors:      make(map[string]int),
+		QueueMessage: as["queue_message"],
+		BusyMessage:  as["busy_message"],
+		Overflow:     as["overflow"],
+	}
+	counts := []struct {
+		key string
+		n   *int
+		def int
+	}{
+		{"max_concurrent", &l.MaxAgents, 0},
+		{"queue_size", &l.QueueSize, DefaultQueueSize},
+	}
+	for _, c := range counts {
+		*c.n = c.def
+		if v := as[c.key]; v != "" {
+			var err error
+			if *c.n, err = strconv.Atoi(v); err != nil || *c.n < 0 {
+				return agentmgr.Limits{}, fmt.Errorf("invalid agents %s %q", c.key, v)
+			}
+		}
+	}
+	durations := []struct {
+		key string
+		d   *time.Duration
+		def int
+	}{
+		{"queue_max_wait_seconds", &l.MaxWait, DefaultQueueMaxWaitSecs},
+		{"queue_announce_seconds", &l.AnnounceEvery, DefaultQueueAnnounceSecs},
+	}
+	for _, c := range durations {
+		secs := c.def
+		if v := as[c.key]; v != "" {
+			var err error
+			if secs, err = strconv.Atoi(v); err != nil || secs < 0 {
+				return agentmgr.Limits{}, fmt.Errorf("invalid agents %s %q", c.key, v)
+			}
+		}
+		*c.d = time.Duration(secs) * time.Second
+	}
+	switch l.Overflow {
+	case "":
+		l.Overflow = agentmgr.OverflowBusy
+	case agentmgr.OverflowBusy, agentmgr.OverflowAdmit:
+	default:
+		return agentmgr.Limits{}, fmt.Errorf("unknown agents overflow %q", l.Overflow)
+	}
+
+	for _, vendor := range []string{cfg.STTVendor, cfg.LLMVendor, cfg.TTSVendor} {
+		v := cfg.VendorSettings[vendor]["max_concurrent"]
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return agentmgr.Limits{}, fmt.Errorf("invalid %s max_concurrent %q", vendor, v)
+		}
+		l.Vendors[vendor] = n
+	}
+	return l, nil
+}
+
+// NewEscalation returns when AI agents hand calls to human agents, read from the
+// "escalation" settings. Without a queue calls are never escalated.
+func NewEscalation(cfg *config.Config) (agentmgr.Escalation, error) {
+	es := cfg.VendorSettings["escalation"]
+	e := agentmgr.Escalation{
+		Queue:         es["queue"],
+		Keywords:      livekitclient.DefaultEscalationKeywords,
+		HoldMessage:   es["hold_message"],
+		ResumeMessage: es["resume_message"],
+	}
+	if v := es["keywords"]; v != "" {
+		e.Keywords = nil
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				e.Keywords = append(e.Keywords, k)
+			}
+		}
+	}
+	if v := es["intent"]; v != "" {
+		var err error
+		if e.Intent, err = strconv.ParseBool(v); err != nil {
+			return agentmgr.Escalation{}, fmt.Errorf("invalid escalation intent %q", v)
+		}
+	}
+	if v := es["low_confidence_streak"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return agentmgr.Escalation{}, fmt.Errorf("invalid escalation low_confidence_streak %q", v)
+		}
+		e.LowConfidenceStreak = n
+	}
+	if v := es["hold_repeat_seconds"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return agentmgr.Escalation{}, fmt.Errorf("invalid escalation hold_repeat_seconds %q", v)
+		}
+		e.HoldRepeat = time.Duration(n) * time.Second
+	}
+	if path := es["hold_audio"]; path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return agentmgr.Escalation{}, fmt.Errorf("read hold audio: %w", err)
+		}
+		e.HoldAudio = b
+	}
+	return e, nil
+}
@@ -0,0 +1,41 @@
+package livekitclient
+
+import (
+	"errors"
+	"time"
+)
+
+// WebRTC carries Opus at 48 kHz. The agent decodes the caller to mono and publishes mono.
+const (
+	opusSampleRate    = 48000
+	opusFrameDuration = 20 * time.Millisecond
+	opusFrameSamples  = opusSampleRate / 50 // samples in one opusFrameDuration frame
+	// maxOpusFrameSamples is the longest frame an Opus packet can carry (120ms)
+	maxOpusFrameSamples = opusSampleRate * 120 / 1000
+	// maxOpusPacketBytes is the recommended output buffer size for the encoder
+	maxOpusPacketBytes = 4000
+)
+
+// errOpusUnavailable is returned by the codec constructors when the binary was built without libopus.
+var errOpusUnavailable = errors.New("opus codec not available: build with -tags opus (requires libopus)")
+
+// CheckCodec returns an error if the binary was built without libopus: its agents could
+// neither hear callers nor talk to them. Binaries that join calls check it at startup.
+func CheckCodec() error {
+	_, err := newOpusDecoder()
+	return err
+}
+
+// opusDecoder decodes Opus packets into 48 kHz mono 16-bit PCM.
+type opusDecoder interface {
+	// Decode decodes packet into pcm and returns the number of samples written. A nil packet
+	// asks the decoder to conceal one lost opusFrameDuration frame.
+	Decode(packet []byte, pcm []int16) (int, error)
+}
+
+// opusEncoder encodes 16-bit PCM frames into Opus packets: 48 kHz mono for the agent's
+// track, the recording format for recordings.
+type opusEncoder interface {
+	// Encode encodes one opusFrameDuration frame of (interleaved) pcm into packet and returns its length.
+	Encode(pcm []int16, packet []byte) (int, error)
+}
@@ -0,0 +1,104 @@
+package livekitclient
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Reasons a call is escalated to a human agent.
+const (
+	// EscalateKeyword: the caller said one of the escalation keywords.
+	EscalateKeyword = "keyword"
+	// EscalateIntent: the LLM decided the caller needs a person.
+	EscalateIntent = "intent"
+	// EscalateLowConfidence: too many utterances in a row could not be understood.
+	EscalateLowConfidence = "low_confidence"
+)
+
+// EscalationMarker is written by the LLM, when prompted with EscalationPrompt, to hand the
+// call to a human. It is never spoken.
+const EscalationMarker = "[ESCALATE]"
+
+// EscalationPrompt is appended to the system prompt of agents that escalate on intent.
+const EscalationPrompt = "If the caller asks for a person, or you cannot help them, begin your reply with " +
+	EscalationMarker + " and tell them you are transferring them to a colleague."
+
+// DefaultEscalationKeywords are the phrases that escalate a call unless others are configured.
+var DefaultEscalationKeywords = []string{"human", "real person", "operator", "representative", "speak to someone", "talk to someone"}
+
+// minConfidence is the confidence below which an utterance is not answered.
+const minConfidence = 0.5
+
+// Escalation decides when a caller should be handed from the AI agent to a human agent.
+// The zero value never escalates.
+type Escalation struct {
+	// Keywords escalate when the caller says any of them, as whole words in any case.
+	Keywords []string
+	// Intent escalates when the LLM writes EscalationMarker.
+	Intent bool
+	// LowConfidenceStreak escalates after that many utterances in a row were heard with too
+	// low a confidence to answer; zero disables it.
+	LowConfidenceStreak int
+	// OnEscalate is called once, in its own goroutine, with the reason. The agent stops
+	// answering the caller until Resume.
+	OnEscalate func(reason string)
+}
+
+// SetEscalation makes the client hand the call over as e says. It must be called before
+// Connect.
+func (rc *RoomClient) SetEscalation(e Escalation) { rc.escalation = e }
+
+// Escalated reports whether the call was escalated and the agent stopped answering.
+func (rc *RoomClient) Escalated() bool { return rc.escalated.Load() }
+
+// Resume makes an escalated agent answer the caller again, e.g. when no human took the call.
+func (rc *RoomClient) Resume() {
+	rc.turnMu.Lock()
+	rc.lowConfidence = 0
+	rc.turnMu.Unlock()
+	rc.escalated.Store(false)
+}
+
+// escalate hands the call over for reason, unless it already was.
+func (rc *RoomClient) escalate(reason string) {
+	if rc.escalation.OnEscalate == nil || !rc.escalated.CompareAndSwap(false, true) {
+		return
+	}
+	go rc.escalation.OnEscalate(reason)
+}
+
+// heard tracks the confidence of the caller's utterances and reports whether enough in a
+// row were unintelligible to escalate.
+func (rc *RoomClient) heard(text string, confidence float32) bool {
+	rc.turnMu.Lock()
+	defer rc.turnMu.Unlock()
+	if text != "" && confidence < minConfidence {
+		rc.lowConfidence++
+	} else if text != "" {
+		rc.lowConfidence = 0
+	}
+	n := rc.escalation.LowConfidenceStreak
+	return n > 0 && rc.lowConfidence >= n
+}
+
+// asksForHuman reports whether text contains one of the escalation keywords.
+func (rc *RoomClient) asksForHuman(text string) bool {
+	words := " " + strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}), " ") + " "
+	for _, k := range rc.escalation.Keywords {
+		if k = strings.Join(strings.Fields(strings.ToLower(k)), " "); k != "" && strings.Contains(words, " "+k+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// stripMarker removes EscalationMarker from a piece of the reply, reporting whether it was
+// there.
+func stripMarker(s string) (string, bool) {
+	if !strings.Contains(s, EscalationMarker) {
+		return s, false
+	}
+	return strings.TrimSpace(strings.ReplaceAll(s, EscalationMarker, "")), true
+}
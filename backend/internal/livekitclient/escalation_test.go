@@ -0,0 +1,96 @@
+package livekitclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// heardStream is a closed STT stream holding one final result heard with confidence.
+func heardStream(text string, confidence float32) interfaces.STTStream {
+	s := &fixedStream{results: make(chan interfaces.STTResult, 1)}
+	s.results <- interfaces.STTResult{Text: text, Confidence: confidence, Final: true}
+	close(s.results)
+	return s
+}
+
+// escalations sets up rc to escalate as e says and returns the reasons it escalates for.
+func escalations(rc *RoomClient, e Escalation) <-chan string {
+	reasons := make(chan string, 4)
+	e.OnEscalate = func(reason string) { reasons <- reason }
+	rc.SetEscalation(e)
+	return reasons
+}
+
+func expectEscalation(t *testing.T, reasons <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-reasons:
+		if got != want {
+			t.Fatalf("escalated for %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("not escalated, want %q", want)
+	}
+}
+
+func TestEscalation_Keyword(t *testing.T) {
+	rc := newTestClient(t, "Happy to help.")
+	reasons := escalations(rc, Escalation{Keywords: []string{"Real Person", "operator"}})
+
+	rc.processUtterance(finalStream("Is this a realistic personal plan?"), rc.startTurn())
+	if rc.Escalated() {
+		t.Fatal("escalated on words that only contain a keyword")
+	}
+	turns := len(rc.recorder.(*turnLog).turns)
+
+	rc.processUtterance(finalStream("Can I talk to a real   person, please?"), rc.startTurn())
+	expectEscalation(t, reasons, EscalateKeyword)
+	if !rc.Escalated() {
+		t.Fatal("not escalated")
+	}
+	// the caller's request is recorded but the agent no longer answers
+	if got := rc.recorder.(*turnLog).turns[turns:]; len(got) != 1 {
+		t.Fatalf("turns after escalating = %+v", got)
+	}
+	rc.processUtterance(finalStream("Operator!"), rc.startTurn())
+	select {
+	case reason := <-reasons:
+		t.Fatalf("escalated twice, for %q", reason)
+	default:
+	}
+
+	rc.Resume()
+	rc.processUtterance(finalStream("hello"), rc.startTurn())
+	msgs := rc.history.Messages()
+	if got := msgs[len(msgs)-1]; got.Role != interfaces.RoleAssistant || got.Content != "Happy to help." {
+		t.Fatalf("last message after resuming = %+v", got)
+	}
+}
+
+func TestEscalation_Intent(t *testing.T) {
+	rc := newTestClient(t, EscalationMarker+" Let me transfer you to a colleague.")
+	reasons := escalations(rc, Escalation{Intent: true})
+
+	rc.processUtterance(finalStream("I want to dispute a charge"), rc.startTurn())
+	expectEscalation(t, reasons, EscalateIntent)
+	msgs := rc.history.Messages()
+	if got := msgs[len(msgs)-1]; got.Content != "Let me transfer you to a colleague." {
+		t.Fatalf("last message = %+v", got)
+	}
+}
+
+func TestEscalation_LowConfidence(t *testing.T) {
+	rc := newTestClient(t, "Sure.")
+	reasons := escalations(rc, Escalation{LowConfidenceStreak: 2})
+
+	rc.processUtterance(heardStream("mumble", 0.2), rc.startTurn())
+	rc.processUtterance(heardStream("hello", 0.9), rc.startTurn())
+	rc.processUtterance(heardStream("mumble", 0.2), rc.startTurn())
+	if rc.Escalated() {
+		t.Fatal("escalated without a streak")
+	}
+	rc.processUtterance(heardStream("mumble", 0.3), rc.startTurn())
+	expectEscalation(t, reasons, EscalateLowConfidence)
+}
@@ -0,0 +1,138 @@
+package livekitclient
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// opusFormat is the PCM format on the Opus side of the codec.
+var opusFormat = audio.Format{SampleRate: opusSampleRate, Channels: 1, BitsPerSample: 16}
+
+const (
+	// reorderDepth is how many packets wait for a missing one before it is declared lost.
+	reorderDepth = 4
+	// maxConcealedFrames caps concealment for a gap; longer gaps (e.g. after the sender
+	// was muted) are skipped rather than filled.
+	maxConcealedFrames = 5
+)
+
+// rtpReorderer restores the sequence order of RTP packets. Packets that arrive too late
+// or twice are dropped.
+type rtpReorderer struct {
+	started bool
+	next    uint16
+	pending map[uint16]*rtp.Packet
+}
+
+// push adds p and returns the packets that are now in order. A nil entry stands for a lost packet.
+func (r *rtpReorderer) push(p *rtp.Packet) []*rtp.Packet {
+	if !r.started {
+		r.started = true
+		r.next = p.SequenceNumber
+		r.pending = make(map[uint16]*rtp.Packet)
+	}
+	if int16(p.SequenceNumber-r.next) < 0 {
+		return nil
+	}
+	if _, dup := r.pending[p.SequenceNumber]; dup {
+		return nil
+	}
+	r.pending[p.SequenceNumber] = p
+
+	var out []*rtp.Packet
+	for len(r.pending) > 0 {
+		if pkt, ok := r.pending[r.next]; ok {
+			delete(r.pending, r.next)
+			out = append(out, pkt)
+			r.next++
+			continue
+		}
+		if len(r.pending) < reorderDepth {
+			break
+		}
+		// give up on the gap and resume at the oldest packet we hold
+		gap := uint16(0xffff)
+		for seq := range r.pending {
+			if d := seq - r.next; d < gap {
+				gap = d
+			}
+		}
+		for i := 0; i < int(gap) && i < maxConcealedFrames; i++ {
+			out = append(out, nil)
+		}
+		r.next += gap
+	}
+	return out
+}
+
+// inboundAudio turns the caller's Opus RTP stream into SpeechFormat PCM for STT.
+type inboundAudio struct {
+	dec     opusDecoder
+	reorder rtpReorderer
+	buf     []int16
+}
+
+func newInboundAudio(dec opusDecoder) *inboundAudio {
+	return &inboundAudio{dec: dec, buf: make([]int16, maxOpusFrameSamples)}
+}
+
+// push decodes p, together with any packets it releases from the reorder buffer, and
+// returns the resulting audio as SpeechFormat PCM. Packets that fail to decode are skipped;
+// the first error is returned along with the audio decoded from the other packets.
+func (in *inboundAudio) push(p *rtp.Packet) ([]byte, error) {
+	var samples []int16
+	var firstErr error
+	for _, pkt := range in.reorder.push(p) {
+		var payload []byte
+		if pkt != nil {
+			if len(pkt.Payload) == 0 {
+				continue
+			}
+			payload = pkt.Payload
+		}
+		n, err := in.dec.Decode(payload, in.buf)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("decode opus: %w", err)
+			}
+			continue
+		}
+		samples = append(samples, in.buf[:n]...)
+	}
+	pcm := audio.Resample(samples, opusSampleRate, audio.SpeechFormat.SampleRate)
+	return audio.SamplesToBytes(pcm), firstErr
+}
+
+// encodeSpeech converts TTS output (WAV, or raw SpeechFormat PCM) into Opus samples of
+// opusFrameDuration each, ready for a TrackLocalStaticSample. It also returns the opusFormat
+// PCM of the samples. The last frame is padded with silence.
+func encodeSpeech(enc opusEncoder, data []byte) ([]media.Sample, []int16, error) {
+	pcm, f, err := audio.DecodeWAV(data)
+	if errors.Is(err, audio.ErrNotWAV) {
+		pcm, f, err = data, audio.SpeechFormat, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode tts audio: %w", err)
+	}
+	if pcm, err = audio.Convert(pcm, f, opusFormat); err != nil {
+		return nil, nil, fmt.Errorf("convert tts audio: %w", err)
+	}
+
+	samples := audio.BytesToSamples(pcm)
+	frames := (len(samples) + opusFrameSamples - 1) / opusFrameSamples
+	samples = append(samples, make([]int16, frames*opusFrameSamples-len(samples))...)
+	out := make([]media.Sample, 0, frames)
+	packet := make([]byte, maxOpusPacketBytes)
+	for i := 0; i < len(samples); i += opusFrameSamples {
+		m, err := enc.Encode(samples[i:i+opusFrameSamples], packet)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encode opus: %w", err)
+		}
+		out = append(out, media.Sample{Data: append([]byte(nil), packet[:m]...), Duration: opusFrameDuration})
+	}
+	return out, samples, nil
+}
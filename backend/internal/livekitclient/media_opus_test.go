@@ -0,0 +1,109 @@
+//go:build opus
+
+package livekitclient
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+)
+
+// testdata/speech_opus.rtpdump is 10.8s of speech encoded by libopus (opusenc, 48 kHz mono,
+// 20ms frames), from the speech_8.opus test file of gopkg.in/hraban/opus.v2, sent as RTP
+// with payload type 111.
+
+// rms is the root mean square level of pcm.
+func rms(pcm []int16) float64 {
+	var sum float64
+	for _, s := range pcm {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(pcm)))
+}
+
+// decodeFixture decodes a recorded call through libopus to SpeechFormat PCM.
+func decodeFixture(t *testing.T, name string) ([]int16, int) {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := newOpusDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkts := readFixture(t, f)
+	in := newInboundAudio(dec)
+	var pcm []byte
+	for _, p := range pkts {
+		out, err := in.push(p)
+		if err != nil {
+			t.Fatalf("packet %d: %v", p.SequenceNumber, err)
+		}
+		pcm = append(pcm, out...)
+	}
+	return audio.BytesToSamples(pcm), len(pkts)
+}
+
+func TestInboundAudio_RecordedOpusFixtures(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "*.rtpdump"))
+	if len(files) == 0 {
+		t.Fatal("no recorded fixtures in testdata")
+	}
+	for _, name := range files {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			samples, pkts := decodeFixture(t, name)
+			// every packet except those still held for reordering decodes to at least 20ms
+			frame := audio.SpeechFormat.SampleRate / 50
+			if min := (pkts - reorderDepth) * frame; len(samples) < min {
+				t.Fatalf("decoded %d samples from %d packets, want at least %d", len(samples), pkts, min)
+			}
+			if level := rms(samples); level < 100 {
+				t.Fatalf("decoded audio is silent: rms %.1f", level)
+			}
+		})
+	}
+}
+
+func TestEncodeSpeech_RoundTripsThroughLibopus(t *testing.T) {
+	speech, _ := decodeFixture(t, filepath.Join("testdata", "speech_opus.rtpdump"))
+	enc, err := newOpusEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, _, err := encodeSpeech(enc, audio.SamplesToBytes(speech))
+	if err != nil {
+		t.Fatalf("encodeSpeech: %v", err)
+	}
+	// 16 kHz input framed at 48 kHz: one 20ms packet per 320 input samples, rounded up
+	frame := audio.SpeechFormat.SampleRate / 50
+	if want := (len(speech) + frame - 1) / frame; len(samples) != want {
+		t.Fatalf("encoded %d packets, want %d", len(samples), want)
+	}
+
+	dec, err := newOpusDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]int16, maxOpusFrameSamples)
+	var decoded []int16
+	for i, s := range samples {
+		n, err := dec.Decode(s.Data, buf)
+		if err != nil {
+			t.Fatalf("decode packet %d: %v", i, err)
+		}
+		if n != opusFrameSamples {
+			t.Fatalf("packet %d decoded to %d samples, want %d", i, n, opusFrameSamples)
+		}
+		decoded = append(decoded, buf[:n]...)
+	}
+	// the level survives encoding within a few dB
+	in, out := rms(speech), rms(audio.Resample(decoded, opusSampleRate, audio.SpeechFormat.SampleRate))
+	if ratio := out / in; ratio < 0.5 || ratio > 2 {
+		t.Fatalf("rms %.1f after the round trip, %.1f before", out, in)
+	}
+}
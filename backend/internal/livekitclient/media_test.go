@@ -0,0 +1,180 @@
+package livekitclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/rtpdump"
+)
+
+// Fixtures are rtpdump files (rtptools format, also exported by Wireshark via
+// "RTP Stream Analysis > Save"). The synthetic ones below carry 48 kHz L16 payloads so the
+// pipeline can be exercised without libopus; the recorded Opus calls in testdata go through
+// libopus in media_opus_test.go.
+
+// pcmCodec stands in for Opus: payloads are raw 48 kHz mono 16-bit PCM.
+type pcmCodec struct {
+	concealed int
+	frames    int
+}
+
+func (c *pcmCodec) Decode(packet []byte, pcm []int16) (int, error) {
+	if packet == nil {
+		c.concealed++
+		clear(pcm[:opusFrameSamples])
+		return opusFrameSamples, nil
+	}
+	return copy(pcm, audio.BytesToSamples(packet)), nil
+}
+
+func (c *pcmCodec) Encode(pcm []int16, packet []byte) (int, error) {
+	if len(pcm) != opusFrameSamples {
+		return 0, errors.New("bad frame size")
+	}
+	c.frames++
+	return copy(packet, audio.SamplesToBytes(pcm[:1])), nil
+}
+
+// writeFixture writes packets as an rtpdump stream, 20ms apart.
+func writeFixture(t *testing.T, pkts []*rtp.Packet) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := rtpdump.NewWriter(&buf, rtpdump.Header{Start: time.Unix(0, 0), Source: net.IPv4(127, 0, 0, 1), Port: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range pkts {
+		b, err := p.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WritePacket(rtpdump.Packet{Offset: time.Duration(i) * opusFrameDuration, Payload: b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func readFixture(t *testing.T, r io.Reader) []*rtp.Packet {
+	t.Helper()
+	rd, _, err := rtpdump.NewReader(r)
+	if err != nil {
+		t.Fatalf("read rtpdump header: %v", err)
+	}
+	var pkts []*rtp.Packet
+	for {
+		p, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return pkts
+		}
+		if err != nil {
+			t.Fatalf("read rtpdump packet: %v", err)
+		}
+		if p.IsRTCP {
+			continue
+		}
+		pkt := &rtp.Packet{}
+		if err := pkt.Unmarshal(p.Payload); err != nil {
+			t.Fatalf("unmarshal rtp: %v", err)
+		}
+		pkts = append(pkts, pkt)
+	}
+}
+
+// l16Packet returns a 20ms packet whose samples all equal value.
+func l16Packet(seq uint16, value int16) *rtp.Packet {
+	samples := make([]int16, opusFrameSamples)
+	for i := range samples {
+		samples[i] = value
+	}
+	return &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 111, SequenceNumber: seq, Timestamp: uint32(seq) * opusFrameSamples},
+		Payload: audio.SamplesToBytes(samples),
+	}
+}
+
+func TestInboundAudio_ReordersAndConceals(t *testing.T) {
+	// sequence numbers wrap around; packet 3 is lost, 5/6 swapped, 8 duplicated
+	const base = 0xfffe
+	var sent []*rtp.Packet
+	for _, i := range []int{0, 1, 2, 4, 6, 5, 7, 8, 8, 9, 10, 11, 12} {
+		sent = append(sent, l16Packet(uint16(base+i), int16(100*(i+1))))
+	}
+
+	codec := &pcmCodec{}
+	in := newInboundAudio(codec)
+	var pcm []byte
+	for _, p := range readFixture(t, bytes.NewReader(writeFixture(t, sent))) {
+		out, err := in.push(p)
+		if err != nil {
+			t.Fatalf("push: %v", err)
+		}
+		pcm = append(pcm, out...)
+	}
+
+	samples := audio.BytesToSamples(pcm)
+	frame := audio.SpeechFormat.SampleRate / 50
+	if codec.concealed != 1 {
+		t.Fatalf("concealed %d frames, want 1", codec.concealed)
+	}
+	// everything after the loss is contiguous, so all of 0..12 is released
+	if got := len(samples) / frame; got != 13 {
+		t.Fatalf("got %d frames, want 13", got)
+	}
+	for i := 0; i < 13; i++ {
+		want := int16(100 * (i + 1))
+		if i == 3 {
+			want = 0
+		}
+		if got := samples[i*frame]; got != want {
+			t.Fatalf("frame %d starts with %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestRTPReorderer_SkipsLongGaps(t *testing.T) {
+	var r rtpReorderer
+	r.push(l16Packet(10, 1))
+	var lost, got int
+	for seq := uint16(1000); seq < 1000+reorderDepth; seq++ {
+		for _, p := range r.push(l16Packet(seq, 1)) {
+			if p == nil {
+				lost++
+			} else {
+				got++
+			}
+		}
+	}
+	if lost != maxConcealedFrames || got != reorderDepth {
+		t.Fatalf("lost=%d got=%d, want %d and %d", lost, got, maxConcealedFrames, reorderDepth)
+	}
+	if out := r.push(l16Packet(12, 1)); len(out) != 0 {
+		t.Fatalf("late packet released: %v", out)
+	}
+}
+
+func TestEncodeSpeech_FramesAt48k(t *testing.T) {
+	// 1.01s of 16 kHz TTS output -> 48480 samples at 48 kHz -> 51 frames, the last one padded
+	pcm := make([]byte, audio.SpeechFormat.BytesPerSecond()*101/100)
+	codec := &pcmCodec{}
+	for _, data := range [][]byte{audio.EncodeWAV(pcm, audio.SpeechFormat), pcm} {
+		samples, pcm, err := encodeSpeech(codec, data)
+		if err != nil {
+			t.Fatalf("encodeSpeech: %v", err)
+		}
+		if len(samples) != 51 || len(pcm) != 51*opusFrameSamples {
+			t.Fatalf("got %d samples of %d pcm, want 51 frames", len(samples), len(pcm))
+		}
+		for _, s := range samples {
+			if s.Duration != 20*time.Millisecond {
+				t.Fatalf("sample duration %v", s.Duration)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+//go:build opus
+
+package livekitclient
+
+import (
+	"fmt"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+type libopusDecoder struct {
+	dec *opus.Decoder
+}
+
+func newOpusDecoder() (opusDecoder, error) {
+	dec, err := opus.NewDecoder(opusSampleRate, 1)
+	if err != nil {
+		return nil, fmt.Errorf("create opus decoder: %w", err)
+	}
+	return &libopusDecoder{dec: dec}, nil
+}
+
+func (d *libopusDecoder) Decode(packet []byte, pcm []int16) (int, error) {
+	if packet == nil {
+		if err := d.dec.DecodePLC(pcm[:opusFrameSamples]); err != nil {
+			return 0, err
+		}
+		return opusFrameSamples, nil
+	}
+	return d.dec.Decode(packet, pcm)
+}
+
+type libopusEncoder struct {
+	enc *opus.Encoder
+}
+
+func newOpusEncoder() (opusEncoder, error) {
+	enc, err := opus.NewEncoder(opusSampleRate, 1, opus.AppVoIP)
+	if err != nil {
+		return nil, fmt.Errorf("create opus encoder: %w", err)
+	}
+	return &libopusEncoder{enc: enc}, nil
+}
+
+func (e *libopusEncoder) Encode(pcm []int16, packet []byte) (int, error) {
+	return e.enc.Encode(pcm, packet)
+}
+
+// newFileEncoder returns an encoder for recordings: interleaved PCM of the given rate and
+// channels, optimized for fidelity rather than latency.
+func newFileEncoder(sampleRate, channels int) (opusEncoder, error) {
+	enc, err := opus.NewEncoder(sampleRate, channels, opus.AppAudio)
+	if err != nil {
+		return nil, fmt.Errorf("create opus encoder: %w", err)
+	}
+	return &libopusEncoder{enc: enc}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !opus
+
+package livekitclient
+
+// Without the opus build tag the room client cannot decode or encode call audio. Such
+// builds are only good for tests: the server and agent workers refuse to start (see
+// CheckCodec).
+
+func newOpusDecoder() (opusDecoder, error) { return nil, errOpusUnavailable }
+
+func newOpusEncoder() (opusEncoder, error) { return nil, errOpusUnavailable }
+
+func newFileEncoder(sampleRate, channels int) (opusEncoder, error) { return nil, errOpusUnavailable }
@@ -0,0 +1,346 @@
+package livekitclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+// Recording modes.
+const (
+	RecordOff = "off"
+	// RecordMixed sums both legs into one channel.
+	RecordMixed = "mixed"
+	// RecordStereo puts the caller on the left channel and the agent on the right.
+	RecordStereo = "stereo"
+)
+
+// Recording file formats.
+const (
+	RecordWAV = "wav"
+	// RecordOpus is Ogg/Opus; it needs the opus build tag.
+	RecordOpus = "opus"
+)
+
+// RecordingSettings configures call recording.
+type RecordingSettings struct {
+	Mode   string
+	Format string
+}
+
+// Enabled reports whether calls are recorded.
+func (s RecordingSettings) Enabled() bool {
+	return s.Mode == RecordMixed || s.Mode == RecordStereo
+}
+
+// RecordingContentType returns the MIME type and file extension of a recording format.
+func RecordingContentType(format string) (contentType, ext string) {
+	if format == RecordOpus {
+		return "audio/ogg", ".ogg"
+	}
+	return "audio/wav", ".wav"
+}
+
+// Leg is one side of a call.
+type Leg int
+
+const (
+	// LegCaller carries the audio of the other participants.
+	LegCaller Leg = iota
+	// LegAgent carries the agent's speech.
+	LegAgent
+)
+
+// RecordingSampleRate is the sample rate of recordings, the rate the caller is decoded to
+// for STT.
+const RecordingSampleRate = 16000
+
+const (
+	// recordingLag is how long audio waits for the other leg before it is written.
+	recordingLag = 500 * time.Millisecond
+	// recordingFlushInterval is how often waiting audio is written.
+	recordingFlushInterval = 250 * time.Millisecond
+	// recordingJitter is the largest delay of a chunk that still continues the audio before
+	// it; later chunks are preceded by silence.
+	recordingJitter = 60 * time.Millisecond
+)
+
+// CallRecorder writes both legs of a call into one file. Audio is placed on a common timeline
+// by the wall-clock time it was heard or played, so the pauses within and between turns keep
+// their length. Each leg is expected to carry one stream at a time.
+type CallRecorder struct {
+	format audio.Format
+	sink   recordingSink
+	start  time.Time
+	now    func() time.Time
+
+	mu sync.Mutex
+	// legs hold the audio of each leg that has not been written, starting at written
+	legs    [2][]int16
+	written int64 // samples per channel written to the sink
+	closed  bool
+	err     error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCallRecorder starts recording to w in format (RecordWAV or RecordOpus) and mode
+// (RecordMixed or RecordStereo). WAV files get their final length if w is an io.Seeker.
+// Close must be called to finish the file; w itself is not closed.
+func NewCallRecorder(w io.Writer, format, mode string) (*CallRecorder, error) {
+	return newCallRecorder(w, format, mode, newFileEncoder, time.Now)
+}
+
+func newCallRecorder(w io.Writer, format, mode string, newEncoder func(sampleRate, channels int) (opusEncoder, error), now func() time.Time) (*CallRecorder, error) {
+	f := audio.Format{SampleRate: RecordingSampleRate, Channels: 1, BitsPerSample: 16}
+	switch mode {
+	case RecordMixed:
+	case RecordStereo:
+		f.Channels = 2
+	default:
+		return nil, fmt.Errorf("unknown recording mode %q", mode)
+	}
+
+	var sink recordingSink
+	var err error
+	switch format {
+	case RecordWAV:
+		sink, err = newWAVSink(w, f)
+	case RecordOpus:
+		var enc opusEncoder
+		if enc, err = newEncoder(f.SampleRate, f.Channels); err == nil {
+			sink, err = newOggSink(w, f, enc)
+		}
+	default:
+		err = fmt.Errorf("unknown recording format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &CallRecorder{format: f, sink: sink, start: now(), now: now, stop: make(chan struct{}), done: make(chan struct{})}
+	go r.flushLoop()
+	return r, nil
+}
+
+// Write adds mono RecordingSampleRate samples of leg that started at at.
+func (r *CallRecorder) Write(leg Leg, at time.Time, samples []int16) {
+	if len(samples) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	buf := r.legs[leg]
+	// pad with silence up to where the audio starts; audio that is late (or early by less
+	// than the jitter) just continues the leg
+	if gap := r.offset(at) - r.written - int64(len(buf)); gap > r.samples(recordingJitter) {
+		buf = append(buf, make([]int16, gap)...)
+	}
+	r.legs[leg] = append(buf, samples...)
+}
+
+// Duration returns the length of the audio written so far.
+func (r *CallRecorder) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.durationLocked()
+}
+
+func (r *CallRecorder) durationLocked() time.Duration {
+	return time.Duration(r.written) * time.Second / time.Duration(r.format.SampleRate)
+}
+
+// Close writes the remaining audio and finishes the file. It returns the recording's length.
+func (r *CallRecorder) Close() (time.Duration, error) {
+	r.mu.Lock()
+	if r.closed {
+		defer r.mu.Unlock()
+		return r.durationLocked(), r.err
+	}
+	// stop taking audio, then write what is left once the flush loop is gone
+	r.closed = true
+	r.mu.Unlock()
+	close(r.stop)
+	<-r.done
+
+	r.mu.Lock()
+	end := r.written
+	for _, buf := range r.legs {
+		end = max(end, r.written+int64(len(buf)))
+	}
+	r.flushLocked(end)
+	if err := r.sink.close(); err != nil && r.err == nil {
+		r.err = fmt.Errorf("finish recording: %w", err)
+	}
+	defer r.mu.Unlock()
+	return r.durationLocked(), r.err
+}
+
+func (r *CallRecorder) flushLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(recordingFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			r.flushLocked(r.offset(r.now().Add(-recordingLag)))
+			r.mu.Unlock()
+		}
+	}
+}
+
+// flushLocked writes the audio of both legs up to sample end, filling missing audio with silence.
+func (r *CallRecorder) flushLocked(end int64) {
+	n := int(end - r.written)
+	if n <= 0 || r.err != nil {
+		return
+	}
+	out := make([]int16, n*r.format.Channels)
+	for leg, buf := range r.legs {
+		k := min(n, len(buf))
+		for i, s := range buf[:k] {
+			if r.format.Channels == 2 {
+				out[2*i+leg] = s
+			} else {
+				out[i] = mix(out[i], s)
+			}
+		}
+		r.legs[leg] = append([]int16(nil), buf[k:]...)
+	}
+	if err := r.sink.write(out); err != nil {
+		r.err = fmt.Errorf("write recording: %w", err)
+		return
+	}
+	r.written = end
+}
+
+// offset is the sample at which audio that started at t belongs.
+func (r *CallRecorder) offset(t time.Time) int64 {
+	return r.samples(t.Sub(r.start))
+}
+
+func (r *CallRecorder) samples(d time.Duration) int64 {
+	return int64(d) * int64(r.format.SampleRate) / int64(time.Second)
+}
+
+// mix sums two samples, clipping at full scale.
+func mix(a, b int16) int16 {
+	return int16(max(-32768, min(32767, int32(a)+int32(b))))
+}
+
+// recordingSink encodes interleaved PCM into a recording file.
+type recordingSink interface {
+	write(pcm []int16) error
+	close() error
+}
+
+// wavSink writes a WAV file, whose header is completed on close if the writer can seek.
+type wavSink struct {
+	w   io.Writer
+	f   audio.Format
+	len int
+}
+
+func newWAVSink(w io.Writer, f audio.Format) (*wavSink, error) {
+	if _, err := w.Write(audio.WAVHeader(f, -1)); err != nil {
+		return nil, fmt.Errorf("write wav header: %w", err)
+	}
+	return &wavSink{w: w, f: f}, nil
+}
+
+func (s *wavSink) write(pcm []int16) error {
+	n, err := s.w.Write(audio.SamplesToBytes(pcm))
+	s.len += n
+	return err
+}
+
+func (s *wavSink) close() error {
+	ws, ok := s.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := ws.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(audio.WAVHeader(s.f, s.len)); err != nil {
+		return err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+// oggSink encodes Opus in 20ms frames and writes them as an Ogg/Opus file.
+type oggSink struct {
+	enc     opusEncoder
+	ogg     *oggwriter.OggWriter
+	frame   int // interleaved samples per frame
+	pending []int16
+	packet  []byte
+	rtp     rtp.Header
+}
+
+func newOggSink(w io.Writer, f audio.Format, enc opusEncoder) (*oggSink, error) {
+	// hide Close: the OggWriter closes writers it is given, and w belongs to the caller
+	ogg, err := oggwriter.NewWith(struct{ io.Writer }{w}, uint32(f.SampleRate), uint16(f.Channels))
+	if err != nil {
+		return nil, fmt.Errorf("write ogg header: %w", err)
+	}
+	return &oggSink{
+		enc:    enc,
+		ogg:    ogg,
+		frame:  f.SampleRate / 50 * f.Channels,
+		packet: make([]byte, maxOpusPacketBytes),
+		// granule positions count 48 kHz samples whatever the input rate
+		rtp: rtp.Header{Timestamp: 1},
+	}, nil
+}
+
+func (s *oggSink) write(pcm []int16) error {
+	s.pending = append(s.pending, pcm...)
+	var i int
+	for ; i+s.frame <= len(s.pending); i += s.frame {
+		if err := s.encode(s.pending[i : i+s.frame]); err != nil {
+			return err
+		}
+	}
+	s.pending = append(s.pending[:0], s.pending[i:]...)
+	return nil
+}
+
+func (s *oggSink) encode(frame []int16) error {
+	n, err := s.enc.Encode(frame, s.packet)
+	if err != nil {
+		return fmt.Errorf("encode opus: %w", err)
+	}
+	if n == 0 {
+		return errors.New("encode opus: empty packet")
+	}
+	s.rtp.SequenceNumber++
+	s.rtp.Timestamp += opusFrameSamples
+	return s.ogg.WriteRTP(&rtp.Packet{Header: s.rtp, Payload: s.packet[:n]})
+}
+
+func (s *oggSink) close() error {
+	if len(s.pending) > 0 {
+		frame := make([]int16, s.frame)
+		copy(frame, s.pending)
+		s.pending = nil
+		if err := s.encode(frame); err != nil {
+			return err
+		}
+	}
+	return s.ogg.Close()
+}
@@ -0,0 +1,161 @@
+package livekitclient
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+)
+
+// frozen is a clock that stays at t, so only Close writes audio.
+func frozen(t time.Time) func() time.Time { return func() time.Time { return t } }
+
+func constant(n int, v int16) []int16 {
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+func TestCallRecorder_StereoWAVKeepsTiming(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "call.wav")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	start := time.Unix(1767348000, 0)
+	r, err := newCallRecorder(f, RecordWAV, RecordStereo, newFileEncoder, frozen(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Write(LegCaller, start, constant(100, 1000))
+	// 10ms late: within the jitter, so it continues the first chunk
+	r.Write(LegCaller, start.Add(10*time.Millisecond), constant(100, 1500))
+	r.Write(LegAgent, start.Add(100*time.Millisecond), constant(160, 2000))
+	r.Write(LegCaller, start.Add(200*time.Millisecond), constant(100, 3000))
+	d, err := r.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if want := 3300 * time.Second / RecordingSampleRate; d != want {
+		t.Fatalf("duration %v, want %v", d, want)
+	}
+	// audio written after Close is dropped
+	r.Write(LegAgent, start, constant(10, 1))
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcm, format, err := audio.DecodeWAV(data)
+	if err != nil {
+		t.Fatalf("DecodeWAV: %v", err)
+	}
+	if format.Channels != 2 || format.SampleRate != RecordingSampleRate {
+		t.Fatalf("format %+v", format)
+	}
+	samples := audio.BytesToSamples(pcm)
+	if len(samples) != 2*3300 {
+		t.Fatalf("got %d samples, want %d", len(samples), 2*3300)
+	}
+	for _, c := range []struct {
+		frame       int
+		left, right int16
+	}{{0, 1000, 0}, {150, 1500, 0}, {250, 0, 0}, {1600, 0, 2000}, {1759, 0, 2000}, {1760, 0, 0}, {3200, 3000, 0}, {3299, 3000, 0}} {
+		if l, r := samples[2*c.frame], samples[2*c.frame+1]; l != c.left || r != c.right {
+			t.Errorf("frame %d = %d,%d, want %d,%d", c.frame, l, r, c.left, c.right)
+		}
+	}
+}
+
+func TestCallRecorder_MixedClips(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1767348000, 0)
+	r, err := newCallRecorder(&buf, RecordWAV, RecordMixed, newFileEncoder, frozen(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write(LegCaller, start, constant(10, 30000))
+	r.Write(LegAgent, start, constant(20, -1000))
+	if _, err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	samples := audio.BytesToSamples(buf.Bytes()[audio.WAVHeaderSize:])
+	if len(samples) != 20 || samples[0] != 29000 || samples[19] != -1000 {
+		t.Fatalf("mixed samples %v", samples)
+	}
+
+	buf.Reset()
+	r, err = newCallRecorder(&buf, RecordWAV, RecordMixed, newFileEncoder, frozen(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write(LegCaller, start, constant(1, 30000))
+	r.Write(LegAgent, start, constant(1, 30000))
+	if _, err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if s := audio.BytesToSamples(buf.Bytes()[audio.WAVHeaderSize:]); s[0] != 32767 {
+		t.Fatalf("clipped sample %d", s[0])
+	}
+}
+
+// frameEncoder stands in for Opus in recordings: it checks frame sizes and emits one byte.
+type frameEncoder struct {
+	t      *testing.T
+	size   int
+	frames int
+}
+
+func (e *frameEncoder) Decode(packet []byte, pcm []int16) (int, error) { return 0, nil }
+
+func (e *frameEncoder) Encode(pcm []int16, packet []byte) (int, error) {
+	if len(pcm) != e.size {
+		e.t.Errorf("frame of %d samples, want %d", len(pcm), e.size)
+	}
+	e.frames++
+	packet[0] = 0xfc
+	return 1, nil
+}
+
+func TestCallRecorder_OggOpusFrames(t *testing.T) {
+	var buf bytes.Buffer
+	enc := &frameEncoder{t: t, size: 2 * RecordingSampleRate / 50}
+	newEncoder := func(sampleRate, channels int) (opusEncoder, error) {
+		if sampleRate != RecordingSampleRate || channels != 2 {
+			t.Errorf("encoder for %d Hz, %d channels", sampleRate, channels)
+		}
+		return enc, nil
+	}
+	start := time.Unix(1767348000, 0)
+	r, err := newCallRecorder(&buf, RecordOpus, RecordStereo, newEncoder, frozen(start))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 50ms of caller audio: two whole frames and a padded one
+	r.Write(LegCaller, start, constant(800, 1))
+	if _, err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if enc.frames != 3 {
+		t.Fatalf("encoded %d frames, want 3", enc.frames)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("OggS")) || !bytes.Contains(buf.Bytes(), []byte("OpusHead")) {
+		t.Fatal("not an Ogg/Opus file")
+	}
+}
+
+func TestNewCallRecorder_RejectsUnknownSettings(t *testing.T) {
+	if _, err := NewCallRecorder(&bytes.Buffer{}, RecordWAV, RecordOff); err == nil {
+		t.Error("mode off: expected error")
+	}
+	if _, err := NewCallRecorder(&bytes.Buffer{}, "mp3", RecordMixed); err == nil {
+		t.Error("format mp3: expected error")
+	}
+}
@@ -6,100 +6,171 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/backend/internal/conversation"
+	"github.com/jacky-htg/ai-call-center/backend/internal/transcript"
+	"github.com/jacky-htg/ai-call-center/libs/audio"
 	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/sentence"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+	"github.com/jacky-htg/ai-call-center/libs/vad"
+	"github.com/livekit/protocol/livekit"
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
 )
 
-// RoomClient represents a LiveKit room client that can join as a participant
+// RoomClient represents a LiveKit room client that can join as a participant.
+//
+// It speaks the LiveKit signaling protocol over the /rtc websocket and, like the LiveKit SDKs,
+// uses two peer connections: the publisher, which the client offers and which carries the agent's
+// audio to the server, and the subscriber, which the server offers and which carries the tracks
+// of the other participants to the agent. ICE candidates are trickled over the signal connection.
 type RoomClient struct {
-	url       string
-	token     string
-	roomName  string
-	identity  string
-	conn      *websocket.Conn
-	pc        *webrtc.PeerConnection
-	stt       interfaces.STT
-	llm       interfaces.LLM
-	tts       interfaces.TTS
-	ctx       context.Context
-	cancel    context.CancelFunc
-	mu        sync.Mutex
+	url        string
+	token      string
+	roomName   string
+	identity   string
+	signal     *signalClient
+	publisher  *webrtc.PeerConnection
+	subscriber *webrtc.PeerConnection
+	stt        interfaces.STT
+	llm        interfaces.LLM
+	tts        interfaces.TTS
+	ctx        context.Context
+	cancel     context.CancelFunc
+	mu         sync.Mutex
 	audioTrack *webrtc.TrackLocalStaticSample
+	encoder    opusEncoder
+	newDecoder func() (opusDecoder, error)
+	newEncoder func() (opusEncoder, error)
+	speakMu    sync.Mutex // serializes encoding and publishing of agent speech
+	turnMu     sync.Mutex
+	turn       *turn // agent turn in progress, nil when the agent is idle
+	history    *conversation.History
+	vad        vad.Settings
+	recorder   transcript.Recorder // nil disables transcript recording
+	audioRec   *CallRecorder       // nil disables audio recording
+	escalation Escalation
+	// escalated is set while the call waits for a human and the agent does not answer;
+	// lowConfidence counts the unintelligible utterances in a row, under turnMu
+	escalated     atomic.Bool
+	lowConfidence int
+	// participants maps the sids of the other participants to their identities; supervisors
+	// holds the sids of the supervisors among them and subscribed the sids of the tracks the
+	// agent subscribed to
+	participants map[string]string
+	supervisors  map[string]bool
+	subscribed   map[string]bool
+	// published receives the server's confirmation of AddTrack requests, by track cid
+	published map[string]chan *livekit.TrackInfo
+	// candidates received before the remote description of their peer connection was set;
+	// only touched by the signal loop
+	pendingCandidates map[livekit.SignalTarget][]webrtc.ICECandidateInit
 }
 
-// NewRoomClient creates a new LiveKit room client
-func NewRoomClient(url, token, roomName, identity string, stt interfaces.STT, llm interfaces.LLM, tts interfaces.TTS) *RoomClient {
-	ctx, cancel := context.WithCancel(context.Background())
+// publishTimeout bounds how long Connect waits for the server to accept the agent's track.
+const publishTimeout = 10 * time.Second
+
+// NewRoomClient creates a new LiveKit room client. history holds the conversation of the call;
+// if nil, a fresh history with the default system prompt is used. vs detects the caller's
+// utterances; the zero value uses vad.Default. Cancelling ctx (or calling Disconnect) aborts
+// all STT/LLM/TTS work of the client.
+func NewRoomClient(ctx context.Context, url, token, roomName, identity string, stt interfaces.STT, llm interfaces.LLM, tts interfaces.TTS, history *conversation.History, vs vad.Settings) *RoomClient {
+	ctx, cancel := context.WithCancel(ctx)
+	if history == nil {
+		history = conversation.New("", 0)
+	}
 	return &RoomClient{
-		url:      url,
-		token:    token,
-		roomName: roomName,
-		identity: identity,
-		stt:      stt,
-		llm:      llm,
-		tts:      tts,
-		ctx:      ctx,
-		cancel:   cancel,
+		url:               url,
+		token:             token,
+		roomName:          roomName,
+		identity:          identity,
+		stt:               stt,
+		llm:               llm,
+		tts:               tts,
+		ctx:               ctx,
+		cancel:            cancel,
+		history:           history,
+		vad:               vs,
+		newDecoder:        newOpusDecoder,
+		newEncoder:        newOpusEncoder,
+		published:         make(map[string]chan *livekit.TrackInfo),
+		participants:      make(map[string]string),
+		supervisors:       make(map[string]bool),
+		subscribed:        make(map[string]bool),
+		pendingCandidates: make(map[livekit.SignalTarget][]webrtc.ICECandidateInit),
 	}
 }
 
-// Connect joins the LiveKit room
-func (rc *RoomClient) Connect() error {
-	// Parse URL and convert to WebSocket URL
-	wsURL := rc.url
-	if len(wsURL) >= 5 && wsURL[:5] == "https" {
-		wsURL = "wss" + wsURL[5:]
-	} else if len(wsURL) >= 4 && wsURL[:4] == "http" {
-		wsURL = "ws" + wsURL[4:]
-	}
-	
-	// Append /rtc endpoint
-	if wsURL[len(wsURL)-1] != '/' {
-		wsURL += "/"
-	}
-	wsURL += "rtc?access_token=" + rc.token
+// SetRecorder makes the client record every caller utterance and agent reply as a turn of
+// the call's transcript. It must be called before Connect.
+func (rc *RoomClient) SetRecorder(r transcript.Recorder) { rc.recorder = r }
 
-	log.Printf("Connecting to LiveKit room: %s", wsURL)
-	
-	dialer := websocket.DefaultDialer
-	conn, _, err := dialer.Dial(wsURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to dial websocket: %w", err)
-	}
-	rc.conn = conn
+// SetAudioRecorder makes the client record the caller's and the agent's audio. It must be
+// called before Connect; the caller of SetAudioRecorder closes r after the client is done.
+func (rc *RoomClient) SetAudioRecorder(r *CallRecorder) { rc.audioRec = r }
 
-	// Start message handler
-	go rc.handleMessages()
+// Done is closed when the client has left the room, either through Disconnect, cancellation
+// of its context or because the server closed the session.
+func (rc *RoomClient) Done() <-chan struct{} { return rc.ctx.Done() }
 
-	// Create WebRTC peer connection
-	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{"stun:stun.l.google.com:19302"}},
-		},
-	}
+// Connect joins the LiveKit room and publishes the agent's audio track. The agent subscribes
+// to the audio of the other participants, supervisors excepted, as they publish it.
+func (rc *RoomClient) Connect() error {
+	log.Printf("Connecting to LiveKit room %s at %s", rc.roomName, rc.url)
 
-	pc, err := webrtc.NewPeerConnection(config)
+	sig, join, err := dialSignal(rc.ctx, rc.url, rc.token)
 	if err != nil {
-		return fmt.Errorf("failed to create peer connection: %w", err)
+		return err
+	}
+	rc.signal = sig
+	log.Printf("Joined room %s as %s (server %s)", join.GetRoom().GetName(), join.GetParticipant().GetIdentity(), join.GetServerInfo().GetVersion())
+	rc.updateParticipants(join.GetOtherParticipants())
+	
+	config := webrtc.Configuration{ICEServers: iceServers(join.GetIceServers())}
+	if rc.publisher, err = webrtc.NewPeerConnection(config); err != nil {
+		return fmt.Errorf("failed to create publisher peer connection: %w", err)
 	}
-	rc.pc = pc
+	if rc.subscriber, err = webrtc.NewPeerConnection(config); err != nil {
+		return fmt.Errorf("failed to create subscriber peer connection: %w", err)
+	}
+	rc.publisher.OnICECandidate(rc.trickle(livekit.SignalTarget_PUBLISHER))
+	rc.subscriber.OnICECandidate(rc.trickle(livekit.SignalTarget_SUBSCRIBER))
 
 	// Handle incoming audio tracks
-	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		if track.Kind() == webrtc.RTPCodecTypeAudio {
-			log.Printf("Received audio track: %s", track.ID())
-			go rc.handleAudioTrack(track)
+	rc.subscriber.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeAudio {
+			return
+		}
+		if rc.fromSupervisor(track.StreamID()) {
+			log.Printf("Ignoring supervisor audio track: %s", track.ID())
+			return
 		}
+		log.Printf("Received audio track: %s", track.ID())
+		go rc.handleAudioTrack(track, rc.participantIdentity(track.StreamID()))
 	})
 
-	// Create audio track for publishing agent responses
+	go rc.handleMessages()
+	go rc.keepAlive(time.Duration(join.GetPingInterval()) * time.Second)
+
+	if err := rc.publishAgentTrack(); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully connected to room %s as %s", rc.roomName, rc.identity)
+	return nil
+}
+
+// publishAgentTrack announces the agent's audio track, waits for the server to accept it and
+// offers it on the publisher peer connection.
+func (rc *RoomClient) publishAgentTrack() error {
 	audioTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus},
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: opusSampleRate, Channels: 2},
 		"agent-audio",
 		"agent",
 	)
@@ -108,176 +179,673 @@ func (rc *RoomClient) Connect() error {
 	}
 	rc.audioTrack = audioTrack
 
-	// Add track to peer connection
-	if _, err := pc.AddTrack(audioTrack); err != nil {
-		return fmt.Errorf("failed to add track: %w", err)
+	if rc.encoder, err = rc.newEncoder(); err != nil {
+		log.Printf("Agent audio disabled: %v", err)
 	}
 
-	log.Printf("Successfully connected to room %s as %s", rc.roomName, rc.identity)
-	return nil
+	accepted := make(chan *livekit.TrackInfo, 1)
+	rc.mu.Lock()
+	rc.published[audioTrack.ID()] = accepted
+	rc.mu.Unlock()
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.published, audioTrack.ID())
+		rc.mu.Unlock()
+	}()
+
+	err = rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_AddTrack{AddTrack: &livekit.AddTrackRequest{
+		Cid:    audioTrack.ID(),
+		Name:   "agent-audio",
+		Type:   livekit.TrackType_AUDIO,
+		Source: livekit.TrackSource_MICROPHONE,
+	}}})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case info := <-accepted:
+		log.Printf("Track published: %s", info.GetSid())
+	case <-time.After(publishTimeout):
+		return fmt.Errorf("server did not accept the agent track within %v", publishTimeout)
+	case <-rc.ctx.Done():
+		return rc.ctx.Err()
+	}
+
+	if _, err := rc.publisher.AddTransceiverFromTrack(audioTrack, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+		return fmt.Errorf("failed to add track: %w", err)
+	}
+	offer, err := rc.publisher.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("create publisher offer: %w", err)
+	}
+	if err := rc.publisher.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("set publisher offer: %w", err)
+	}
+	return rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_Offer{Offer: &livekit.SessionDescription{
+		Type: offer.Type.String(),
+		Sdp:  offer.SDP,
+	}}})
 }
 
-// handleMessages processes WebSocket messages from LiveKit
+// handleMessages processes signal messages from LiveKit until the connection closes
 func (rc *RoomClient) handleMessages() {
-	defer rc.conn.Close()
-	
+	defer rc.cancel()
+
 	for {
-		select {
-		case <-rc.ctx.Done():
+		res, err := rc.signal.read()
+		if err != nil {
+			if rc.ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				log.Printf("Signal connection error: %v", err)
+			}
 			return
-		default:
-			_, message, err := rc.conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket error: %v", err)
+		}
+
+		switch msg := res.Message.(type) {
+		case *livekit.SignalResponse_Offer:
+			if err := rc.handleOffer(msg.Offer); err != nil {
+				log.Printf("Failed to answer subscriber offer: %v", err)
+			}
+		case *livekit.SignalResponse_Answer:
+			if err := rc.handleAnswer(msg.Answer); err != nil {
+				log.Printf("Failed to apply publisher answer: %v", err)
+			}
+		case *livekit.SignalResponse_Trickle:
+			if err := rc.handleTrickle(msg.Trickle); err != nil {
+				log.Printf("Failed to add ICE candidate: %v", err)
+			}
+		case *livekit.SignalResponse_TrackPublished:
+			rc.mu.Lock()
+			accepted, ok := rc.published[msg.TrackPublished.GetCid()]
+			rc.mu.Unlock()
+			if ok {
+				select {
+				case accepted <- msg.TrackPublished.GetTrack():
+				default:
 				}
-				return
 			}
-
-			var msg map[string]interface{}
-			if err := json.Unmarshal(message, &msg); err != nil {
-				log.Printf("Failed to unmarshal message: %v", err)
-				continue
+		case *livekit.SignalResponse_Update:
+			rc.updateParticipants(msg.Update.GetParticipants())
+			for _, p := range msg.Update.GetParticipants() {
+				if p.GetState() == livekit.ParticipantInfo_DISCONNECTED {
+					log.Printf("Participant disconnected: %s", p.GetIdentity())
+				} else {
+					log.Printf("Participant updated: %s (%s)", p.GetIdentity(), p.GetState())
+				}
 			}
+		case *livekit.SignalResponse_TrackUnpublished:
+			log.Printf("Track unpublished: %s", msg.TrackUnpublished.GetTrackSid())
+		case *livekit.SignalResponse_Leave:
+			log.Printf("Server closed the session: %s", msg.Leave.GetReason())
+			return
+		}
+	}
+}
 
-			// Handle different message types
-			msgType, _ := msg["type"].(string)
-			switch msgType {
-			case "join":
-				log.Printf("Joined room successfully")
-			case "track_published":
-				log.Printf("Track published: %v", msg)
-			case "participant_connected":
-				log.Printf("Participant connected: %v", msg)
-			case "participant_disconnected":
-				log.Printf("Participant disconnected: %v", msg)
+// updateParticipants tracks the identities of the other participants by sid and subscribes
+// to the audio tracks they publish. The tracks of supervisors are never subscribed to: what
+// they say is meant for the human agent, not for the caller's conversation with the agent.
+func (rc *RoomClient) updateParticipants(ps []*livekit.ParticipantInfo) {
+	var tracks []string
+	rc.mu.Lock()
+	for _, p := range ps {
+		if p.GetState() == livekit.ParticipantInfo_DISCONNECTED {
+			delete(rc.participants, p.GetSid())
+			delete(rc.supervisors, p.GetSid())
+			for _, t := range p.GetTracks() {
+				delete(rc.subscribed, t.GetSid())
 			}
+			continue
+		}
+		rc.participants[p.GetSid()] = p.GetIdentity()
+		if p.GetAttributes()[livekitauth.AttrSupervisorMode] != "" {
+			rc.supervisors[p.GetSid()] = true
+			continue
 		}
+		for _, t := range p.GetTracks() {
+			if t.GetType() == livekit.TrackType_AUDIO && !rc.subscribed[t.GetSid()] {
+				rc.subscribed[t.GetSid()] = true
+				tracks = append(tracks, t.GetSid())
+			}
+		}
+	}
+	rc.mu.Unlock()
+
+	if len(tracks) == 0 {
+		return
+	}
+	err := rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_Subscription{Subscription: &livekit.UpdateSubscription{
+		TrackSids: tracks,
+		Subscribe: true,
+	}}})
+	if err != nil {
+		log.Printf("Failed to subscribe to tracks %v: %v", tracks, err)
 	}
 }
 
-// handleAudioTrack processes incoming audio from user
-func (rc *RoomClient) handleAudioTrack(track *webrtc.TrackRemote) {
-	log.Printf("Starting to handle audio track: %s", track.ID())
+// fromSupervisor reports whether a supervisor publishes the stream. The agent does not
+// subscribe to supervisors, but the server could still offer their tracks.
+func (rc *RoomClient) fromSupervisor(streamID string) bool {
+	sid, _, _ := strings.Cut(streamID, "|")
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.supervisors[sid]
+}
+
+// participantIdentity returns the identity of the participant that publishes the stream. LiveKit
+// names subscribed streams "<participant sid>|<track sid>"; unknown participants yield "".
+func (rc *RoomClient) participantIdentity(streamID string) string {
+	sid, _, _ := strings.Cut(streamID, "|")
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.participants[sid]
+}
+
+// handleOffer answers the server's offer for the subscriber peer connection.
+func (rc *RoomClient) handleOffer(sd *livekit.SessionDescription) error {
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sd.GetSdp()}
+	if err := rc.subscriber.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	if err := rc.addPendingCandidates(livekit.SignalTarget_SUBSCRIBER); err != nil {
+		return err
+	}
+	answer, err := rc.subscriber.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := rc.subscriber.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	return rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_Answer{Answer: &livekit.SessionDescription{
+		Type: answer.Type.String(),
+		Sdp:  answer.SDP,
+		Id:   sd.GetId(),
+	}}})
+}
+
+// handleAnswer applies the server's answer to the publisher offer.
+func (rc *RoomClient) handleAnswer(sd *livekit.SessionDescription) error {
+	answer := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sd.GetSdp()}
+	if err := rc.publisher.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	return rc.addPendingCandidates(livekit.SignalTarget_PUBLISHER)
+}
+
+// handleTrickle adds a remote ICE candidate, holding it back until the peer connection it
+// belongs to has a remote description.
+func (rc *RoomClient) handleTrickle(t *livekit.TrickleRequest) error {
+	var candidate webrtc.ICECandidateInit
+	if err := json.Unmarshal([]byte(t.GetCandidateInit()), &candidate); err != nil {
+		return fmt.Errorf("decode candidate: %w", err)
+	}
+	pc := rc.peer(t.GetTarget())
+	if pc.RemoteDescription() == nil {
+		rc.pendingCandidates[t.GetTarget()] = append(rc.pendingCandidates[t.GetTarget()], candidate)
+		return nil
+	}
+	return pc.AddICECandidate(candidate)
+}
+
+func (rc *RoomClient) addPendingCandidates(target livekit.SignalTarget) error {
+	pending := rc.pendingCandidates[target]
+	delete(rc.pendingCandidates, target)
+	pc := rc.peer(target)
+	for _, c := range pending {
+		if err := pc.AddICECandidate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *RoomClient) peer(target livekit.SignalTarget) *webrtc.PeerConnection {
+	if target == livekit.SignalTarget_SUBSCRIBER {
+		return rc.subscriber
+	}
+	return rc.publisher
+}
 	
-	// Buffer for audio data
-	audioBuffer := make([]byte, 0, 32000) // ~1 second at 16kHz
-	bufferDuration := 2 * time.Second     // Process every 2 seconds
-	ticker := time.NewTicker(bufferDuration)
-	defer ticker.Stop()
+// trickle returns an OnICECandidate handler that sends local candidates of target to the server.
+func (rc *RoomClient) trickle(target livekit.SignalTarget) func(*webrtc.ICECandidate) {
+	return func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return // gathering complete
+		}
+		init, err := json.Marshal(c.ToJSON())
+		if err != nil {
+			log.Printf("Failed to encode ICE candidate: %v", err)
+			return
+		}
+		err = rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_Trickle{Trickle: &livekit.TrickleRequest{
+			CandidateInit: string(init),
+			Target:        target,
+		}}})
+		if err != nil && rc.ctx.Err() == nil {
+			log.Printf("Failed to send ICE candidate: %v", err)
+		}
+	}
+}
 
+// keepAlive pings the server at the interval it asked for in the join response.
+func (rc *RoomClient) keepAlive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-rc.ctx.Done():
 			return
 		case <-ticker.C:
-			if len(audioBuffer) > 0 {
-				// Process audio chunk
-				go rc.processAudioChunk(audioBuffer)
-				audioBuffer = audioBuffer[:0] // Reset buffer
-			}
-		default:
-			// Read RTP packet
-			rtpPacket, _, err := track.ReadRTP()
-			if err != nil {
-				if err == io.EOF {
-					log.Printf("Audio track ended")
-					return
-				}
-				log.Printf("Error reading RTP: %v", err)
+			now := time.Now().UnixMilli()
+			if err := rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_Ping{Ping: now}}); err != nil {
+				log.Printf("Failed to ping LiveKit: %v", err)
 				continue
 			}
-
-			// Convert RTP to raw audio (simplified - in production, use proper codec decoder)
-			// For MVP, we'll accumulate packets and process periodically
-			audioBuffer = append(audioBuffer, rtpPacket.Payload...)
+			_ = rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_PingReq{PingReq: &livekit.Ping{Timestamp: now}}})
 		}
 	}
 }
 
-// processAudioChunk processes an audio chunk through STT -> LLM -> TTS pipeline
-func (rc *RoomClient) processAudioChunk(audio []byte) {
-	if len(audio) == 0 {
-		return
+// iceServers converts the ICE servers of the join response, falling back to a public STUN server.
+func iceServers(servers []*livekit.ICEServer) []webrtc.ICEServer {
+	if len(servers) == 0 {
+		return []webrtc.ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}}
+	}
+	out := make([]webrtc.ICEServer, 0, len(servers))
+	for _, s := range servers {
+		out = append(out, webrtc.ICEServer{URLs: s.GetUrls(), Username: s.GetUsername(), Credential: s.GetCredential()})
 	}
+	return out
+}
 
-	// STT: Convert audio to text
+// handleAudioTrack processes incoming audio from user
+func (rc *RoomClient) handleAudioTrack(track *webrtc.TrackRemote, speaker string) {
+	log.Printf("Starting to handle audio track: %s", track.ID())
 	if rc.stt == nil {
 		return
 	}
-
-	transcript, confidence, err := rc.stt.Recognize(audio)
+	if mime := track.Codec().MimeType; !strings.EqualFold(mime, webrtc.MimeTypeOpus) {
+		log.Printf("Ignoring audio track %s: unsupported codec %s", track.ID(), mime)
+		return
+	}
+	dec, err := rc.newDecoder()
 	if err != nil {
+		log.Printf("Cannot decode audio track %s: %v", track.ID(), err)
+		return
+	}
+	inbound := newInboundAudio(dec)
+	
+	// Each utterance found by the VAD gets its own STT stream; silence between turns is not sent
+	var stream interfaces.STTStream
+	var speechStart time.Time
+	seg := rc.vad.NewSegmenter(vad.Callbacks{
+		OnStart: func() error {
+			speechStart = time.Now()
+			// barge-in: the caller talking over the agent ends the agent's turn
+			if rc.interrupt() {
+				log.Printf("Caller started speaking; interrupting agent")
+			}
+			var err error
+			if stream, err = rc.stt.RecognizeStream(rc.ctx); err != nil {
+				log.Printf("STT stream error: %v", err)
+				stream = nil
+			}
+			return nil
+		},
+		OnAudio: func(pcm []byte) error {
+			if stream != nil {
+				if err := stream.Write(pcm); err != nil {
+					log.Printf("STT stream write error: %v", err)
+				}
+			}
+			return nil
+		},
+		OnEnd: func() error {
+			if stream != nil {
+				if err := stream.Close(); err != nil {
+					log.Printf("STT stream close error: %v", err)
+				}
+				t := rc.startTurn()
+				t.speaker, t.speechStart, t.speechEnd = speaker, speechStart, time.Now()
+				go rc.processUtterance(stream, t)
+				stream = nil
+			}
+			return nil
+		},
+	})
+
+	for {
+		rtpPacket, _, err := track.ReadRTP()
+		if rc.ctx.Err() != nil {
+			if stream != nil {
+				_ = stream.Close()
+				// drain so the stream's goroutines can exit
+				go audio.CollectFinal(stream, nil)
+			}
+			return
+		}
+		if err != nil {
+			if err == io.EOF {
+				log.Printf("Audio track ended")
+				_ = seg.Flush()
+				return
+			}
+			log.Printf("Error reading RTP: %v", err)
+			continue
+		}
+
+		pcm, err := inbound.push(rtpPacket)
+		if err != nil {
+			log.Printf("Audio decode error: %v", err)
+		}
+		if rc.audioRec != nil && len(pcm) > 0 {
+			// the decoded audio ends now
+			samples := audio.BytesToSamples(pcm)
+			heard := time.Duration(len(samples)) * time.Second / time.Duration(audio.SpeechFormat.SampleRate)
+			rc.audioRec.Write(LegCaller, time.Now().Add(-heard), samples)
+		}
+		_, _ = seg.Write(pcm)
+	}
+}
+
+// turn is one agent reply. Its context is cancelled when the caller barges in.
+type turn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// speaker is the identity of the caller whose utterance is answered; speechStart and
+	// speechEnd bound the utterance
+	speaker     string
+	speechStart time.Time
+	speechEnd   time.Time
+}
+
+// startTurn begins the agent turn that answers the utterance that just ended.
+func (rc *RoomClient) startTurn() *turn {
+	ctx, cancel := context.WithCancel(rc.ctx)
+	t := &turn{ctx: ctx, cancel: cancel}
+	rc.turnMu.Lock()
+	if rc.turn != nil {
+		rc.turn.cancel()
+	}
+	rc.turn = t
+	rc.turnMu.Unlock()
+	return t
+}
+
+// endTurn releases t once its reply has been played or abandoned.
+func (rc *RoomClient) endTurn(t *turn) {
+	t.cancel()
+	rc.turnMu.Lock()
+	if rc.turn == t {
+		rc.turn = nil
+	}
+	rc.turnMu.Unlock()
+}
+
+// interrupt handles barge-in: the caller started talking, so the agent stops playback and
+// abandons any LLM/TTS work of the current turn. It reports whether a turn was interrupted.
+func (rc *RoomClient) interrupt() bool {
+	rc.turnMu.Lock()
+	t := rc.turn
+	rc.turn = nil
+	rc.turnMu.Unlock()
+	if t == nil {
+		return false
+	}
+	t.cancel()
+	return true
+}
+
+// processUtterance waits for the final transcript of a closed STT stream and runs it
+// through the LLM -> TTS pipeline. If the caller barges in, t is cancelled: playback stops,
+// generation is aborted and the reply is recorded in the history as cut off.
+func (rc *RoomClient) processUtterance(stream interfaces.STTStream, t *turn) {
+	defer rc.endTurn(t)
+
+	res := audio.CollectFinal(stream, func(p interfaces.STTResult) {
+		log.Printf("User is saying: %s", p.Text)
+	})
+	if err := stream.Err(); err != nil {
 		log.Printf("STT error: %v", err)
 		return
 	}
 
-	if confidence < 0.5 || transcript == "" {
+	transcript, confidence := res.Text, res.Confidence
+	if rc.heard(transcript, confidence) {
+		rc.escalate(EscalateLowConfidence)
+	}
+	if confidence < minConfidence || transcript == "" {
 		return // Low confidence or empty transcript
 	}
 
+	heardAt := time.Now()
 	log.Printf("User said: %s (confidence: %.2f)", transcript, confidence)
+	rc.history.Add(interfaces.RoleUser, transcript)
+	rc.record(store.Turn{
+		SessionID:  t.speaker,
+		Speaker:    store.SpeakerCaller,
+		Text:       transcript,
+		Confidence: confidence,
+		StartedAt:  t.speechStart,
+		EndedAt:    t.speechEnd,
+		STTLatency: latency(t.speechEnd, heardAt),
+	})
+	if rc.asksForHuman(transcript) {
+		rc.escalate(EscalateKeyword)
+	}
+	if rc.escalated.Load() {
+		// the call is being handed to a human; the agent no longer answers
+		return
+	}
+	if t.ctx.Err() != nil {
+		// the caller kept talking before we answered; the next turn answers both utterances
+		return
+	}
+
+	// LLM: stream the response and hand each sentence to TTS as soon as it is complete,
+	// so the caller hears the first sentence while the rest is still being generated
+	sentences := make(chan string, 8)
+	var played []string
+	var firstSentence, firstAudio time.Time
+	spoken := make(chan struct{})
+	go func() {
+		defer close(spoken)
+		for text := range sentences {
+			if t.ctx.Err() != nil {
+				continue // interrupted: drain without speaking
+			}
+			onPlay := func() {
+				if firstAudio.IsZero() {
+					firstAudio = time.Now()
+				}
+			}
+			if rc.speak(t.ctx, text, onPlay) {
+				played = append(played, text)
+			}
+		}
+	}()
+
+	var reply strings.Builder
+	var escalate bool
+	sw := sentence.NewWriter(func(s string) {
+		if rc.escalation.Intent {
+			var marked bool
+			if s, marked = stripMarker(s); marked {
+				escalate = true
+			}
+			if s == "" {
+				return
+			}
+		}
+		log.Printf("Agent response: %s", s)
+		if firstSentence.IsZero() {
+			firstSentence = time.Now()
+		}
+		sentences <- s
+	})
+	out := io.MultiWriter(sw, &reply)
 
-	// LLM: Generate response
-	var response string
 	if rc.llm != nil {
-		response, err = rc.llm.Generate(transcript)
-		if err != nil {
+		if err := rc.llm.ChatStream(t.ctx, rc.history.Messages(), out); err != nil && t.ctx.Err() == nil {
 			log.Printf("LLM error: %v", err)
-			response = "I'm sorry, I didn't catch that."
+			// the caller hears what was generated so far, then the apology, and the history
+			// keeps both
+			sw.Flush()
+			apology := "I'm sorry, I didn't catch that."
+			if strings.TrimSpace(reply.String()) != "" {
+				apology = " " + apology
+			}
+			_, _ = io.WriteString(out, apology)
 		}
 	} else {
-		response = "I heard you say: " + transcript
+		_, _ = io.WriteString(out, "I heard you say: "+transcript)
+	}
+	if t.ctx.Err() == nil {
+		sw.Flush()
 	}
+	close(sentences)
+	<-spoken
+
+	agentTurn := store.Turn{
+		SessionID:  rc.identity,
+		Speaker:    store.SpeakerAgent,
+		StartedAt:  heardAt,
+		EndedAt:    time.Now(),
+		LLMLatency: latency(heardAt, firstSentence),
+		TTSLatency: latency(firstSentence, firstAudio),
+	}
+	if !firstAudio.IsZero() {
+		agentTurn.StartedAt = firstAudio
+	}
+	switch {
+	case rc.ctx.Err() != nil:
+		// call is over; only the transcript keeps what the caller heard
+		agentTurn.Text, agentTurn.Interrupted = strings.Join(played, " "), true
+	case t.ctx.Err() != nil:
+		log.Printf("Agent reply interrupted by caller")
+		rc.history.AddInterrupted(strings.Join(played, " "))
+		agentTurn.Text, agentTurn.Interrupted = strings.Join(played, " "), true
+	default:
+		agentTurn.Text = strings.TrimSpace(reply.String())
+		if rc.escalation.Intent {
+			agentTurn.Text, _ = stripMarker(agentTurn.Text)
+		}
+		rc.history.Add(interfaces.RoleAssistant, agentTurn.Text)
+	}
+	if agentTurn.Text != "" {
+		rc.record(agentTurn)
+	}
+	if escalate {
+		rc.escalate(EscalateIntent)
+	}
+}
 
-	log.Printf("Agent response: %s", response)
+// record adds a turn to the call's transcript if a recorder is set.
+func (rc *RoomClient) record(t store.Turn) {
+	if rc.recorder == nil {
+		return
+	}
+	t.CallID = rc.roomName
+	if _, err := rc.recorder.AddTurn(t); err != nil {
+		log.Printf("Failed to record %s turn: %v", t.Speaker, err)
+	}
+}
 
-	// TTS: Convert response to audio and publish
-	if rc.tts != nil && rc.audioTrack != nil {
-		audioData, err := rc.tts.Speak(response)
-		if err != nil {
+// latency is the time from from to to, or zero if either is unknown.
+func latency(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// speak converts a piece of the agent response to audio and publishes it, calling onPlay
+// when playback starts. It reports whether the audio was played completely.
+func (rc *RoomClient) speak(ctx context.Context, text string, onPlay func()) bool {
+	if rc.tts == nil || rc.audioTrack == nil {
+		return false
+	}
+	audioData, err := rc.tts.Speak(ctx, text)
+	if err != nil {
+		if ctx.Err() == nil {
 			log.Printf("TTS error: %v", err)
-			return
 		}
+		return false
+	}
 
-		// Publish audio to room (simplified - in production, use proper codec encoder)
-		// For MVP, we'll send audio samples
-		if err := rc.publishAudio(audioData); err != nil {
+	onPlay()
+	if err := rc.publishAudio(ctx, audioData); err != nil {
+		if ctx.Err() == nil {
 			log.Printf("Failed to publish audio: %v", err)
 		}
+		return false
+	}
+	return true
+}
+
+// Play publishes audio (WAV or SpeechFormat PCM) in the room outside of the conversation,
+// e.g. hold music. It returns once the audio has been played or ctx is cancelled.
+func (rc *RoomClient) Play(ctx context.Context, audioData []byte) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(rc.ctx, cancel)
+	defer stop()
+	return rc.publishAudio(ctx, audioData)
+}
+
+// Say speaks text in the room outside of the conversation, e.g. to tell a waiting caller
+// their place in the queue. It returns once the audio has been played.
+func (rc *RoomClient) Say(text string) error {
+	if rc.tts == nil {
+		return fmt.Errorf("tts not configured")
 	}
+	audioData, err := rc.tts.Speak(rc.ctx, text)
+	if err != nil {
+		return fmt.Errorf("tts: %w", err)
+	}
+	return rc.publishAudio(rc.ctx, audioData)
 }
 
-// publishAudio publishes audio data to the room
-func (rc *RoomClient) publishAudio(audioData []byte) error {
+// publishAudio encodes TTS output (WAV or SpeechFormat PCM) to Opus and publishes it to the
+// room in real time, one 20ms frame per tick. Playback stops as soon as ctx is cancelled.
+func (rc *RoomClient) publishAudio(ctx context.Context, audioData []byte) error {
 	if rc.audioTrack == nil {
 		return fmt.Errorf("audio track not initialized")
 	}
+	if rc.encoder == nil {
+		return errOpusUnavailable
+	}
 
-	// Convert audio bytes to samples (simplified - assumes PCM format)
-	// In production, you'd need proper audio format conversion
-	// For MVP, we'll send raw samples
-	sampleRate := uint32(16000) // 16kHz
-	sampleDuration := time.Duration(len(audioData)) * time.Second / time.Duration(sampleRate*2) // Assuming 16-bit samples
+	rc.speakMu.Lock()
+	defer rc.speakMu.Unlock()
 
-	// Send audio samples
-	chunkSize := int(sampleRate * 2 / 10) // 100ms chunks
-	for i := 0; i < len(audioData); i += chunkSize {
-		end := i + chunkSize
-		if end > len(audioData) {
-			end = len(audioData)
-		}
+	samples, pcm, err := encodeSpeech(rc.encoder, audioData)
+	if err != nil {
+		return err
+	}
 
-		sample := media.Sample{
-			Data:     audioData[i:end],
-			Duration: sampleDuration / 10, // 100ms
+	ticker := time.NewTicker(opusFrameDuration)
+	defer ticker.Stop()
+	for i, sample := range samples {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if rc.audioRec != nil {
+			frame := pcm[i*opusFrameSamples : (i+1)*opusFrameSamples]
+			rc.audioRec.Write(LegAgent, time.Now(), audio.Resample(frame, opusSampleRate, RecordingSampleRate))
 		}
-
 		if err := rc.audioTrack.WriteSample(sample); err != nil {
 			return fmt.Errorf("failed to write sample: %w", err)
 		}
-
-		time.Sleep(100 * time.Millisecond) // Rate limit
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	return nil
@@ -285,16 +853,28 @@ func (rc *RoomClient) publishAudio(audioData []byte) error {
 
 // Disconnect leaves the room and cleans up
 func (rc *RoomClient) Disconnect() error {
+	if rc.signal != nil && rc.ctx.Err() == nil {
+		err := rc.signal.send(&livekit.SignalRequest{Message: &livekit.SignalRequest_Leave{Leave: &livekit.LeaveRequest{
+			Reason: livekit.DisconnectReason_CLIENT_INITIATED,
+			Action: livekit.LeaveRequest_DISCONNECT,
+		}}})
+		if err != nil {
+			log.Printf("Error sending leave: %v", err)
+		}
+	}
 	rc.cancel()
 	
-	if rc.pc != nil {
-		if err := rc.pc.Close(); err != nil {
+	for _, pc := range []*webrtc.PeerConnection{rc.publisher, rc.subscriber} {
+		if pc == nil {
+			continue
+		}
+		if err := pc.Close(); err != nil {
 			log.Printf("Error closing peer connection: %v", err)
 		}
 	}
 
-	if rc.conn != nil {
-		if err := rc.conn.Close(); err != nil {
+	if rc.signal != nil {
+		if err := rc.signal.close(); err != nil {
 			log.Printf("Error closing websocket: %v", err)
 		}
 	}
@@ -0,0 +1,172 @@
+package livekitclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/conversation"
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+	"github.com/jacky-htg/ai-call-center/libs/vad"
+	"github.com/pion/webrtc/v4"
+)
+
+// fakeLLM streams reply token by token.
+// fakeLLM streams reply word by word, then fails with err if it is set.
+type fakeLLM struct {
+	reply string
+	err   error
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, prompt string, opts ...interfaces.LLMOption) (string, error) {
+	return f.reply, nil
+}
+
+func (f *fakeLLM) GenerateStream(ctx context.Context, prompt string, w io.Writer, opts ...interfaces.LLMOption) error {
+	return f.ChatStream(ctx, nil, w)
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, messages []interfaces.Message, opts ...interfaces.LLMOption) (string, error) {
+	return f.reply, nil
+}
+
+func (f *fakeLLM) ChatStream(ctx context.Context, messages []interfaces.Message, w io.Writer, opts ...interfaces.LLMOption) error {
+	for _, tok := range strings.SplitAfter(f.reply, " ") {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, tok); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+// fakeTTS returns d of silence per sentence.
+type fakeTTS struct{ d time.Duration }
+
+func (f *fakeTTS) Speak(ctx context.Context, text string, opts ...interfaces.TTSOption) ([]byte, error) {
+	return make([]byte, int(f.d.Seconds()*float64(audio.SpeechFormat.BytesPerSecond()))), ctx.Err()
+}
+
+func (f *fakeTTS) SpeakStream(ctx context.Context, text string, w io.Writer, opts ...interfaces.TTSOption) error {
+	b, err := f.Speak(ctx, text, opts...)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// finalStream is a closed STT stream holding one final result.
+func finalStream(text string) interfaces.STTStream {
+	s := &fixedStream{results: make(chan interfaces.STTResult, 1)}
+	s.results <- interfaces.STTResult{Text: text, Confidence: 1, Final: true}
+	close(s.results)
+	return s
+}
+
+type fixedStream struct{ results chan interfaces.STTResult }
+
+func (s *fixedStream) Write(pcm []byte) error               { return nil }
+func (s *fixedStream) Results() <-chan interfaces.STTResult { return s.results }
+func (s *fixedStream) Close() error                         { return nil }
+func (s *fixedStream) Err() error                           { return nil }
+
+// turnLog is an in-memory transcript recorder.
+type turnLog struct {
+	mu    sync.Mutex
+	turns []store.Turn
+}
+
+func (l *turnLog) AddTurn(t store.Turn) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.turns = append(l.turns, t)
+	return int64(len(l.turns)), nil
+}
+
+func newTestClient(t *testing.T, reply string) *RoomClient {
+	t.Helper()
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "agent-audio", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRoomClient(context.Background(), "", "", "room", "agent", nil, &fakeLLM{reply: reply}, &fakeTTS{d: 300 * time.Millisecond}, conversation.New("", 0), vad.Default())
+	rc.audioTrack = track
+	rc.encoder = &pcmCodec{}
+	rc.SetRecorder(&turnLog{})
+	t.Cleanup(func() { rc.cancel() })
+	return rc
+}
+
+func TestProcessUtterance_BargeInStopsPlayback(t *testing.T) {
+	rc := newTestClient(t, "First sentence. Second sentence. Third sentence.")
+
+	done := make(chan struct{})
+	go func() {
+		rc.processUtterance(finalStream("hello"), rc.startTurn())
+		close(done)
+	}()
+
+	// let the first sentence play completely, then talk over the second
+	time.Sleep(450 * time.Millisecond)
+	if !rc.interrupt() {
+		t.Fatal("no turn in progress to interrupt")
+	}
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("playback did not stop after barge-in")
+	}
+
+	msgs := rc.history.Messages()
+	last := msgs[len(msgs)-1]
+	if last.Role != interfaces.RoleAssistant || last.Content != "First sentence. "+conversation.InterruptedMarker {
+		t.Fatalf("last message = %+v", last)
+	}
+	if rc.interrupt() {
+		t.Fatal("turn still registered after it ended")
+	}
+
+	turns := rc.recorder.(*turnLog).turns
+	if len(turns) != 2 || turns[0].Speaker != store.SpeakerCaller || turns[0].Text != "hello" || turns[0].CallID != "room" {
+		t.Fatalf("turns = %+v", turns)
+	}
+	if agent := turns[1]; agent.Speaker != store.SpeakerAgent || agent.Text != "First sentence." || !agent.Interrupted || agent.SessionID != "agent" {
+		t.Fatalf("agent turn = %+v", agent)
+	}
+}
+
+func TestProcessUtterance_LLMErrorKeepsPartialReply(t *testing.T) {
+	rc := newTestClient(t, "Your balance is")
+	rc.llm = &fakeLLM{reply: "Your balance is", err: errors.New("connection reset")}
+	rc.processUtterance(finalStream("what is my balance"), rc.startTurn())
+
+	want := "Your balance is I'm sorry, I didn't catch that."
+	msgs := rc.history.Messages()
+	if got := msgs[len(msgs)-1]; got.Role != interfaces.RoleAssistant || got.Content != want {
+		t.Fatalf("last message = %+v, want %q", got, want)
+	}
+	turns := rc.recorder.(*turnLog).turns
+	if got := turns[len(turns)-1].Text; got != want {
+		t.Fatalf("recorded turn = %q, want %q", got, want)
+	}
+}
+
+func TestProcessUtterance_CompletesWithoutBargeIn(t *testing.T) {
+	reply := "Short answer."
+	rc := newTestClient(t, reply)
+	rc.processUtterance(finalStream("hello"), rc.startTurn())
+
+	msgs := rc.history.Messages()
+	if got := msgs[len(msgs)-1]; got.Role != interfaces.RoleAssistant || got.Content != reply {
+		t.Fatalf("last message = %+v", got)
+	}
+}
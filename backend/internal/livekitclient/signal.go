@@ -0,0 +1,134 @@
+package livekitclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/livekit/protocol/livekit"
+	"google.golang.org/protobuf/proto"
+)
+
+// protocolVersion is the LiveKit signaling protocol version spoken by the client.
+const protocolVersion = 15
+
+// signalClient carries LiveKit signaling: protobuf SignalRequest messages to the server and
+// SignalResponse messages back, each in one binary websocket frame.
+type signalClient struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // serializes writes
+}
+
+// signalURL turns the LiveKit server URL into the /rtc websocket URL for token.
+func signalURL(base, token string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("parse livekit url: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/rtc"
+	q := u.Query()
+	q.Set("access_token", token)
+	q.Set("protocol", strconv.Itoa(protocolVersion))
+	q.Set("sdk", "go")
+	// the client picks the tracks it subscribes to, see RoomClient.updateParticipants
+	q.Set("auto_subscribe", "0")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// dialSignal connects to the LiveKit server and waits for the join response.
+func dialSignal(ctx context.Context, base, token string) (*signalClient, *livekit.JoinResponse, error) {
+	wsURL, err := signalURL(base, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the websocket handshake and the join response do not observe ctx: closing the
+	// connection when ctx is cancelled aborts them
+	var mu sync.Mutex
+	var netConn net.Conn
+	dialer := *websocket.DefaultDialer
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		mu.Lock()
+		netConn = c
+		mu.Unlock()
+		return c, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if netConn != nil {
+			netConn.Close()
+		}
+	})
+	defer stop()
+	conn, resp, err := dialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, nil, fmt.Errorf("failed to dial websocket: %w (status %d)", err, resp.StatusCode)
+		}
+		return nil, nil, fmt.Errorf("failed to dial websocket: %w", err)
+	}
+	c := &signalClient{conn: conn}
+
+	res, err := c.read()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("read join response: %w", err)
+	}
+	switch msg := res.Message.(type) {
+	case *livekit.SignalResponse_Join:
+		return c, msg.Join, nil
+	case *livekit.SignalResponse_Leave:
+		conn.Close()
+		return nil, nil, fmt.Errorf("join refused: %s", msg.Leave.GetReason())
+	default:
+		conn.Close()
+		return nil, nil, fmt.Errorf("unexpected first signal message %T", res.Message)
+	}
+}
+
+func (c *signalClient) send(req *livekit.SignalRequest) error {
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal signal request: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return fmt.Errorf("write signal request: %w", err)
+	}
+	return nil
+}
+
+func (c *signalClient) read() (*livekit.SignalResponse, error) {
+	for {
+		typ, b, err := c.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if typ != websocket.BinaryMessage {
+			continue
+		}
+		res := &livekit.SignalResponse{}
+		if err := proto.Unmarshal(b, res); err != nil {
+			return nil, fmt.Errorf("unmarshal signal response: %w", err)
+		}
+		return res, nil
+	}
+}
+
+func (c *signalClient) close() error {
+	return c.conn.Close()
+}
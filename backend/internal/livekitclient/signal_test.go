@@ -0,0 +1,314 @@
+package livekitclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/vad"
+	"github.com/livekit/protocol/livekit"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeLiveKit is a minimal LiveKit server: it answers the join, accepts one published track,
+// answers the publisher offer, offers a caller track on the subscriber connection and relays
+// ICE candidates. Audio the agent publishes is delivered on received.
+type fakeLiveKit struct {
+	t        *testing.T
+	received chan []byte
+	left     chan *livekit.LeaveRequest
+}
+
+func (f *fakeLiveKit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/rtc" || r.URL.Query().Get("access_token") != "token" || r.URL.Query().Get("protocol") == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	send := func(res *livekit.SignalResponse) {
+		b, _ := proto.Marshal(res)
+		mu.Lock()
+		defer mu.Unlock()
+		_ = conn.WriteMessage(websocket.BinaryMessage, b)
+	}
+	trickle := func(target livekit.SignalTarget) func(*webrtc.ICECandidate) {
+		return func(c *webrtc.ICECandidate) {
+			if c == nil {
+				return
+			}
+			init, _ := json.Marshal(c.ToJSON())
+			send(&livekit.SignalResponse{Message: &livekit.SignalResponse_Trickle{Trickle: &livekit.TrickleRequest{CandidateInit: string(init), Target: target}}})
+		}
+	}
+
+	send(&livekit.SignalResponse{Message: &livekit.SignalResponse_Join{Join: &livekit.JoinResponse{
+		Room:              &livekit.Room{Name: "room"},
+		Participant:       &livekit.ParticipantInfo{Identity: "agent"},
+		SubscriberPrimary: true,
+		PingInterval:      1,
+	}}})
+
+	// pub receives the agent's publisher connection, sub sends the caller's audio to the agent
+	pub, _ := webrtc.NewPeerConnection(webrtc.Configuration{})
+	sub, _ := webrtc.NewPeerConnection(webrtc.Configuration{})
+	defer pub.Close()
+	defer sub.Close()
+	pub.OnICECandidate(trickle(livekit.SignalTarget_PUBLISHER))
+	sub.OnICECandidate(trickle(livekit.SignalTarget_SUBSCRIBER))
+	pub.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			pkt, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			select {
+			case f.received <- pkt.Payload:
+			default:
+			}
+		}
+	})
+
+	caller, _ := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "caller-audio", "caller")
+	if _, err := sub.AddTrack(caller); err != nil {
+		f.t.Errorf("add caller track: %v", err)
+		return
+	}
+	offer, _ := sub.CreateOffer(nil)
+	_ = sub.SetLocalDescription(offer)
+	send(&livekit.SignalResponse{Message: &livekit.SignalResponse_Offer{Offer: &livekit.SessionDescription{Type: "offer", Sdp: offer.SDP, Id: 1}}})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = caller.WriteSample(media.Sample{Data: make([]byte, 320), Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+
+	pending := map[livekit.SignalTarget][]webrtc.ICECandidateInit{}
+	peer := func(target livekit.SignalTarget) *webrtc.PeerConnection {
+		if target == livekit.SignalTarget_SUBSCRIBER {
+			return sub
+		}
+		return pub
+	}
+	flush := func(target livekit.SignalTarget) {
+		for _, c := range pending[target] {
+			_ = peer(target).AddICECandidate(c)
+		}
+		delete(pending, target)
+	}
+
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		req := &livekit.SignalRequest{}
+		if err := proto.Unmarshal(b, req); err != nil {
+			f.t.Errorf("unmarshal signal request: %v", err)
+			return
+		}
+		switch msg := req.Message.(type) {
+		case *livekit.SignalRequest_AddTrack:
+			send(&livekit.SignalResponse{Message: &livekit.SignalResponse_TrackPublished{TrackPublished: &livekit.TrackPublishedResponse{
+				Cid:   msg.AddTrack.GetCid(),
+				Track: &livekit.TrackInfo{Sid: "TR_agent", Name: msg.AddTrack.GetName(), Type: msg.AddTrack.GetType()},
+			}}})
+		case *livekit.SignalRequest_Offer:
+			_ = pub.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: msg.Offer.GetSdp()})
+			flush(livekit.SignalTarget_PUBLISHER)
+			answer, _ := pub.CreateAnswer(nil)
+			_ = pub.SetLocalDescription(answer)
+			send(&livekit.SignalResponse{Message: &livekit.SignalResponse_Answer{Answer: &livekit.SessionDescription{Type: "answer", Sdp: answer.SDP}}})
+		case *livekit.SignalRequest_Answer:
+			if msg.Answer.GetId() != 1 {
+				f.t.Errorf("answer id = %d, want 1", msg.Answer.GetId())
+			}
+			_ = sub.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: msg.Answer.GetSdp()})
+			flush(livekit.SignalTarget_SUBSCRIBER)
+		case *livekit.SignalRequest_Trickle:
+			var c webrtc.ICECandidateInit
+			_ = json.Unmarshal([]byte(msg.Trickle.GetCandidateInit()), &c)
+			target := msg.Trickle.GetTarget()
+			if peer(target).RemoteDescription() == nil {
+				pending[target] = append(pending[target], c)
+			} else {
+				_ = peer(target).AddICECandidate(c)
+			}
+		case *livekit.SignalRequest_Leave:
+			f.left <- msg.Leave
+			return
+		}
+	}
+}
+
+// signalDecoder records that caller audio reached the agent.
+type signalDecoder struct{ got chan struct{} }
+
+func (d *signalDecoder) Decode(packet []byte, pcm []int16) (int, error) {
+	select {
+	case d.got <- struct{}{}:
+	default:
+	}
+	clear(pcm[:opusFrameSamples])
+	return opusFrameSamples, nil
+}
+
+type nopSTT struct{}
+
+func (nopSTT) Recognize(ctx context.Context, audio []byte, opts ...interfaces.STTOption) (string, float32, error) {
+	return "", 0, nil
+}
+
+func (nopSTT) RecognizeStream(ctx context.Context, opts ...interfaces.STTOption) (interfaces.STTStream, error) {
+	return finalStream(""), nil
+}
+
+func TestConnect_NegotiatesMediaBothWays(t *testing.T) {
+	lk := &fakeLiveKit{t: t, received: make(chan []byte, 1), left: make(chan *livekit.LeaveRequest, 1)}
+	srv := httptest.NewServer(lk)
+	defer srv.Close()
+
+	dec := &signalDecoder{got: make(chan struct{}, 1)}
+	rc := NewRoomClient(context.Background(), srv.URL, "token", "room", "agent", nopSTT{}, nil, nil, nil, vad.Default())
+	rc.newDecoder = func() (opusDecoder, error) { return dec, nil }
+	rc.newEncoder = func() (opusEncoder, error) { return &pcmCodec{}, nil }
+	if err := rc.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	// agent speech reaches the room once the publisher connection is up
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			_ = rc.publishAudio(ctx, make([]byte, 3200))
+		}
+	}()
+	select {
+	case <-lk.received:
+	case <-ctx.Done():
+		t.Fatal("no agent audio received by the server")
+	}
+
+	// caller audio reaches the agent through the subscriber connection
+	select {
+	case <-dec.got:
+	case <-ctx.Done():
+		t.Fatal("no caller audio decoded by the agent")
+	}
+	cancel()
+
+	if err := rc.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	select {
+	case leave := <-lk.left:
+		if leave.GetReason() != livekit.DisconnectReason_CLIENT_INITIATED {
+			t.Fatalf("leave reason = %s", leave.GetReason())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive leave")
+	}
+	<-rc.Done()
+}
+
+func TestConnect_RejectedToken(t *testing.T) {
+	srv := httptest.NewServer(&fakeLiveKit{t: t})
+	defer srv.Close()
+	rc := NewRoomClient(context.Background(), srv.URL, "wrong", "room", "agent", nil, nil, nil, nil, vad.Default())
+	if err := rc.Connect(); err == nil {
+		t.Fatal("expected connect to fail")
+	}
+}
+
+func TestUpdateParticipants_IgnoresSupervisors(t *testing.T) {
+	requests := make(chan *livekit.SignalRequest, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if r.URL.Query().Get("auto_subscribe") != "0" {
+			t.Errorf("auto_subscribe = %q, want 0", r.URL.Query().Get("auto_subscribe"))
+		}
+		b, _ := proto.Marshal(&livekit.SignalResponse{Message: &livekit.SignalResponse_Join{Join: &livekit.JoinResponse{}}})
+		_ = conn.WriteMessage(websocket.BinaryMessage, b)
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			req := &livekit.SignalRequest{}
+			if err := proto.Unmarshal(b, req); err == nil {
+				requests <- req
+			}
+		}
+	}))
+	defer srv.Close()
+	sig, _, err := dialSignal(context.Background(), srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sig.close()
+	rc := NewRoomClient(context.Background(), srv.URL, "token", "room", "agent", nopSTT{}, nil, nil, nil, vad.Default())
+	rc.signal = sig
+
+	audioTrack := func(sid string) []*livekit.TrackInfo {
+		return []*livekit.TrackInfo{{Sid: sid, Type: livekit.TrackType_AUDIO}}
+	}
+	rc.updateParticipants([]*livekit.ParticipantInfo{
+		{Sid: "PA_caller", Identity: "caller", Tracks: audioTrack("TR_caller"), Attributes: map[string]string{"session_type": "caller"}},
+		{Sid: "PA_sup", Identity: "sup-1", Tracks: audioTrack("TR_sup"), Attributes: map[string]string{"session_type": "supervisor", livekitauth.AttrSupervisorMode: "barge"}},
+	})
+	// an update repeating the caller's track does not subscribe again
+	rc.updateParticipants([]*livekit.ParticipantInfo{
+		{Sid: "PA_caller", Identity: "caller", Tracks: append(audioTrack("TR_caller"), audioTrack("TR_caller2")...)},
+	})
+
+	var subscribed []string
+	for len(subscribed) < 2 {
+		select {
+		case req := <-requests:
+			s := req.GetSubscription()
+			if s == nil || !s.GetSubscribe() {
+				t.Fatalf("unexpected request %v", req)
+			}
+			subscribed = append(subscribed, s.GetTrackSids()...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("subscribed to %v, want the caller's two tracks", subscribed)
+		}
+	}
+	if len(subscribed) != 2 || subscribed[0] != "TR_caller" || subscribed[1] != "TR_caller2" {
+		t.Fatalf("subscribed to %v", subscribed)
+	}
+
+	// tracks the server offers anyway are not fed to the pipeline if a supervisor publishes them
+	if !rc.fromSupervisor("PA_sup|TR_sup") || rc.fromSupervisor("PA_caller|TR_caller") {
+		t.Fatal("supervisor stream not told apart from the caller's")
+	}
+}
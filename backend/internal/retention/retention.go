@@ -0,0 +1,174 @@
+// Package retention enforces how long the data of calls is kept: a background job deletes
+// or anonymizes what has expired and leaves an audit trail of it in the store.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/blob"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// Policy keeps one class of call data (store.DataMetadata, DataTranscripts, DataRecordings
+// or DataTokens) for MaxAge after the call ends, then applies Action to it.
+type Policy struct {
+	Class  string
+	MaxAge time.Duration
+	// Action is store.PurgeDelete, or store.PurgeAnonymize for metadata.
+	Action string
+}
+
+// Validate reports whether the policy can be enforced.
+func (p Policy) Validate() error {
+	switch p.Class {
+	case store.DataMetadata:
+		if p.Action != store.PurgeDelete && p.Action != store.PurgeAnonymize {
+			return fmt.Errorf("retention of %s: unknown action %q", p.Class, p.Action)
+		}
+	case store.DataTranscripts, store.DataRecordings, store.DataTokens:
+		if p.Action != store.PurgeDelete {
+			return fmt.Errorf("retention of %s: unknown action %q", p.Class, p.Action)
+		}
+	default:
+		return fmt.Errorf("retention of unknown data class %q", p.Class)
+	}
+	if p.MaxAge <= 0 {
+		return fmt.Errorf("retention of %s: max age must be positive", p.Class)
+	}
+	return nil
+}
+
+// Settings configures the purge job.
+type Settings struct {
+	Policies []Policy
+	// Interval is the time between purges.
+	Interval time.Duration
+}
+
+// DefaultBatchSize is how many calls a purge handles per query.
+const DefaultBatchSize = 100
+
+// Purger applies retention policies to the store and the blob store.
+type Purger struct {
+	store    store.Repository
+	blobs    blob.Store
+	policies []Policy
+	batch    int
+	now      func() time.Time
+}
+
+// New returns a purger of the policies. Tokens are purged first and metadata last, whatever
+// their order, so deleting a call's metadata finds the rest of it already gone.
+func New(st store.Repository, blobs blob.Store, policies []Policy) *Purger {
+	ordered := make([]Policy, 0, len(policies))
+	for _, class := range []string{store.DataTokens, store.DataTranscripts, store.DataRecordings, store.DataMetadata} {
+		for _, p := range policies {
+			if p.Class == class {
+				ordered = append(ordered, p)
+			}
+		}
+	}
+	return &Purger{store: st, blobs: blobs, policies: ordered, batch: DefaultBatchSize, now: time.Now}
+}
+
+// Run applies every policy once and returns how many calls each data class was purged from.
+// A call that cannot be purged is skipped until the next run; the first such error is
+// returned once all policies have been applied.
+func (p *Purger) Run(ctx context.Context) (map[string]int, error) {
+	purged := make(map[string]int)
+	var firstErr error
+	for _, policy := range p.policies {
+		n, err := p.apply(ctx, policy)
+		purged[policy.Class] += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return purged, ctx.Err()
+			}
+			log.Printf("retention of %s: %v", policy.Class, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return purged, firstErr
+}
+
+// apply purges the calls whose data of the policy's class has expired, a batch at a time.
+func (p *Purger) apply(ctx context.Context, policy Policy) (int, error) {
+	cutoff := p.now().Add(-policy.MaxAge)
+	var purged int
+	var errs []error
+	failed := make(map[string]bool)
+	for {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		// calls that failed stay expired, so ask for enough to get past them
+		ids, err := p.store.ExpiredCalls(policy.Class, policy.Action, cutoff, p.batch+len(failed))
+		if err != nil {
+			return purged, errors.Join(append(errs, err)...)
+		}
+		progress := false
+		for _, callID := range ids {
+			if failed[callID] {
+				continue
+			}
+			if err := p.purgeCall(ctx, callID, policy, cutoff); err != nil {
+				failed[callID] = true
+				errs = append(errs, err)
+				continue
+			}
+			purged++
+			progress = true
+		}
+		if !progress || len(ids) < p.batch+len(failed) {
+			return purged, errors.Join(errs...)
+		}
+	}
+}
+
+// purgeCall purges one call's data. Files of recordings are deleted before their rows, so a
+// failure leaves the rows for the next run to retry.
+func (p *Purger) purgeCall(ctx context.Context, callID string, policy Policy, cutoff time.Time) error {
+	if policy.Class == store.DataRecordings || (policy.Class == store.DataMetadata && policy.Action == store.PurgeDelete) {
+		recordings, err := p.store.ListRecordings(callID)
+		if err != nil {
+			return fmt.Errorf("list recordings of call %s: %w", callID, err)
+		}
+		for _, r := range recordings {
+			if r.Status == store.RecordingActive {
+				continue
+			}
+			if err := p.blobs.Delete(ctx, r.Key); err != nil {
+				return fmt.Errorf("delete recording %s of call %s: %w", r.ID, callID, err)
+			}
+		}
+	}
+	if _, err := p.store.PurgeCall(callID, policy.Class, policy.Action, cutoff); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Start runs the purge now and then every interval until ctx is cancelled.
+func (p *Purger) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, _ := p.Run(ctx)
+		for class, n := range purged {
+			if n > 0 {
+				log.Printf("retention: purged %s of %d calls", class, n)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/blob"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tc := range []struct {
+		p  Policy
+		ok bool
+	}{
+		{Policy{store.DataMetadata, day, store.PurgeAnonymize}, true},
+		{Policy{store.DataMetadata, day, store.PurgeDelete}, true},
+		{Policy{store.DataRecordings, day, store.PurgeDelete}, true},
+		{Policy{store.DataTranscripts, day, store.PurgeAnonymize}, false},
+		{Policy{store.DataTokens, 0, store.PurgeDelete}, false},
+		{Policy{"voicemail", day, store.PurgeDelete}, false},
+	} {
+		if err := tc.p.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v: Validate() = %v", tc.p, err)
+		}
+	}
+}
+
+func TestPurgerRun(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	blobs, err := blob.NewLocal(t.TempDir(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls, keys []string
+	for i := 0; i < 3; i++ {
+		callID, sessionID, err := st.CreateCall("carol")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.UpdateSessionToken(sessionID, "tok"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.AddTurn(store.Turn{CallID: callID, SessionID: sessionID, Speaker: store.SpeakerCaller, Text: "my card number is 4111"}); err != nil {
+			t.Fatal(err)
+		}
+		key := blob.PrefixRecordings + callID + "/" + sessionID + ".wav"
+		if err := blobs.Put(ctx, key, strings.NewReader("RIFF"), "audio/wav"); err != nil {
+			t.Fatal(err)
+		}
+		recID, err := st.CreateRecording(store.Recording{CallID: callID, Key: key, ContentType: "audio/wav", Channels: 1, SampleRate: 16000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.FinishRecording(recID, 4, time.Second, store.RecordingReady); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.UpdateCallStatus(callID, "ended"); err != nil {
+			t.Fatal(err)
+		}
+		calls, keys = append(calls, callID), append(keys, key)
+	}
+
+	day := 24 * time.Hour
+	p := New(st, blobs, []Policy{
+		{Class: store.DataMetadata, MaxAge: day, Action: store.PurgeAnonymize},
+		{Class: store.DataRecordings, MaxAge: day, Action: store.PurgeDelete},
+		{Class: store.DataTranscripts, MaxAge: 3 * day, Action: store.PurgeDelete},
+	})
+	p.batch = 2
+
+	// nothing has expired yet
+	if purged, err := p.Run(ctx); err != nil || purged[store.DataRecordings] != 0 {
+		t.Fatalf("Run() = %v, %v", purged, err)
+	}
+
+	// two days on, the recordings and caller ids have expired but not the transcripts
+	p.now = func() time.Time { return time.Now().Add(2 * day) }
+	purged, err := p.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if purged[store.DataRecordings] != 3 || purged[store.DataMetadata] != 3 || purged[store.DataTranscripts] != 0 {
+		t.Fatalf("purged = %v", purged)
+	}
+	for i, callID := range calls {
+		if _, err := blobs.Stat(ctx, keys[i]); !errors.Is(err, blob.ErrNotFound) {
+			t.Fatalf("recording of call %s: Stat error = %v, want ErrNotFound", callID, err)
+		}
+		if recs, err := st.ListRecordings(callID); err != nil || len(recs) != 0 {
+			t.Fatalf("recordings of call %s = %+v, %v", callID, recs, err)
+		}
+		if c, err := st.GetCall(callID); err != nil || c.CallerID != "" {
+			t.Fatalf("call %s = %+v, %v; want caller id anonymized", callID, c, err)
+		}
+		if turns, err := st.ListTurns(callID); err != nil || len(turns) != 1 {
+			t.Fatalf("turns of call %s = %d, %v; want kept", callID, len(turns), err)
+		}
+	}
+
+	audit, err := st.ListPurgeAudit(time.Time{}, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 6 {
+		t.Fatalf("audit trail has %d entries, want 6: %+v", len(audit), audit)
+	}
+	// recordings are purged before metadata
+	if audit[0].DataClass != store.DataRecordings || audit[5].DataClass != store.DataMetadata || audit[5].Action != store.PurgeAnonymize {
+		t.Fatalf("audit trail = %+v", audit)
+	}
+
+	// a second run finds nothing left to purge
+	if purged, err := p.Run(ctx); err != nil || purged[store.DataRecordings]+purged[store.DataMetadata] != 0 {
+		t.Fatalf("second Run() = %v, %v", purged, err)
+	}
+}
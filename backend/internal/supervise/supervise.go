@@ -0,0 +1,230 @@
+// Package supervise lets supervisors monitor live calls. A supervisor listens without being
+// seen or heard, whispers to the human agent on the call, or barges in as a full
+// participant; every time they join a call in a mode is recorded as a session of type
+// "supervisor" whose mode says which. Whispers go through a room of their own that only the
+// supervisor and the human agent join, so nobody else on the call can hear them.
+package supervise
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/acd"
+	"github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+	roomservice "github.com/jacky-htg/ai-call-center/libs/vendors/livekit"
+)
+
+// SessionType is the type of the sessions of supervisors.
+const SessionType = "supervisor"
+
+// ReasonSwitched ends the session of a supervisor who joined the call again in another mode.
+const ReasonSwitched = "switched_mode"
+
+var (
+	// ErrUnknownMode is returned for a mode that is not listen, whisper or barge.
+	ErrUnknownMode = errors.New("unknown supervisor mode")
+	// ErrCallEnded is returned when a supervisor asks to join a call that ended.
+	ErrCallEnded = errors.New("call ended")
+	// ErrNoHuman is returned when a supervisor asks to whisper on a call no human agent is on.
+	ErrNoHuman = errors.New("no human agent on the call")
+)
+
+// Rooms removes participants from call rooms and closes whisper rooms; the RoomService
+// client of libs/vendors/livekit implements it.
+type Rooms interface {
+	RemoveParticipant(ctx context.Context, room, identity string) error
+	DeleteRoom(ctx context.Context, room string) error
+}
+
+// Session is a supervisor's session in a call with the token they join its room with.
+type Session struct {
+	ID           string
+	CallID       string
+	SupervisorID string
+	Mode         livekit.SupervisorMode
+	// WhisperTo is the session of the human agent a whispering supervisor talks to, in
+	// WhisperRoom with WhisperToken.
+	WhisperTo    string
+	WhisperRoom  string
+	WhisperToken string
+	Token        string
+	URL          string
+}
+
+// Whisper is a supervisor whispering to a human agent, with the token the agent joins the
+// whisper's room with to hear them.
+type Whisper struct {
+	SessionID    string
+	SupervisorID string
+	Room         string
+	Token        string
+	URL          string
+}
+
+// Supervisor issues supervisors their sessions in calls and human agents the whispers of
+// the supervisors on their calls.
+type Supervisor struct {
+	store store.Repository
+	lk    livekit.Settings
+	rooms Rooms
+}
+
+// New returns a supervisor issuing tokens for the LiveKit server of lk. Supervisors stay in
+// the rooms of the modes they switched from until SetRooms.
+func New(st store.Repository, lk livekit.Settings) *Supervisor {
+	return &Supervisor{store: st, lk: lk}
+}
+
+// SetRooms removes supervisors from the rooms of the modes they switched from and closes
+// whisper rooms that are done.
+func (s *Supervisor) SetRooms(r Rooms) { s.rooms = r }
+
+// Join records supervisorID joining a call in mode and returns their session. Sessions the
+// supervisor still has in the call end, as they switched modes.
+func (s *Supervisor) Join(callID, supervisorID string, mode livekit.SupervisorMode) (*Session, error) {
+	switch mode {
+	case livekit.SuperviseListen, livekit.SuperviseWhisper, livekit.SuperviseBarge:
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownMode, mode)
+	}
+	call, err := s.store.GetCall(callID)
+	if err != nil {
+		return nil, err
+	}
+	if call.Status == "ended" {
+		return nil, fmt.Errorf("call %s: %w", callID, ErrCallEnded)
+	}
+	sessions, err := s.store.ListSessions(callID)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions of call %s: %w", callID, err)
+	}
+	whisperTo := ""
+	if mode == livekit.SuperviseWhisper {
+		for _, sess := range sessions {
+			if sess.Type == acd.SessionType && sess.Status == "active" {
+				whisperTo = sess.ID
+			}
+		}
+		if whisperTo == "" {
+			return nil, fmt.Errorf("call %s: %w", callID, ErrNoHuman)
+		}
+	}
+
+	sessionID, err := s.store.CreateSession(callID, supervisorID, SessionType, "new")
+	if err != nil {
+		return nil, fmt.Errorf("create supervisor session: %w", err)
+	}
+	if err := s.store.UpdateSessionMode(sessionID, string(mode)); err != nil {
+		s.endSession(sessionID, "")
+		return nil, fmt.Errorf("record supervisor mode: %w", err)
+	}
+	token, err := livekit.SupervisorToken(s.lk, mode, callID, sessionID, whisperTo)
+	if err != nil {
+		s.endSession(sessionID, "")
+		return nil, err
+	}
+	_ = s.store.UpdateSessionToken(sessionID, token)
+	out := &Session{
+		ID:           sessionID,
+		CallID:       callID,
+		SupervisorID: supervisorID,
+		Mode:         mode,
+		WhisperTo:    whisperTo,
+		Token:        token,
+		URL:          s.lk.URL,
+	}
+	if mode == livekit.SuperviseWhisper {
+		out.WhisperRoom = livekit.WhisperRoom(sessionID)
+		if out.WhisperToken, err = livekit.WhisperToken(s.lk, callID, sessionID, whisperTo); err != nil {
+			s.endSession(sessionID, "")
+			return nil, err
+		}
+	}
+
+	for _, sess := range sessions {
+		if sess.Type == SessionType && sess.UserID == supervisorID && sess.Status != "ended" {
+			s.leave(callID, sess)
+		}
+	}
+	log.Printf("supervisor %s joins call %s to %s", supervisorID, callID, mode)
+	return out, nil
+}
+
+// Whispers returns the supervisors whispering on the call of the human agent with session
+// humanSession, with the tokens the agent hears them with. Agents whose session ended hear
+// none.
+func (s *Supervisor) Whispers(humanSession string) ([]Whisper, error) {
+	human, err := s.store.GetSession(humanSession)
+	if err != nil {
+		return nil, err
+	}
+	if human.Type != acd.SessionType || human.Status == "ended" {
+		return nil, nil
+	}
+	sessions, err := s.store.ListSessions(human.CallID)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions of call %s: %w", human.CallID, err)
+	}
+	var out []Whisper
+	for _, sess := range sessions {
+		if sess.Type != SessionType || sess.Mode != string(livekit.SuperviseWhisper) || sess.Status == "ended" {
+			continue
+		}
+		token, err := livekit.WhisperListenerToken(s.lk, human.CallID, sess.ID, humanSession)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Whisper{SessionID: sess.ID, SupervisorID: sess.UserID, Room: livekit.WhisperRoom(sess.ID), Token: token, URL: s.lk.URL})
+	}
+	return out, nil
+}
+
+// CallEnded closes the whisper rooms of the call that ended.
+func (s *Supervisor) CallEnded(ctx context.Context, callID string) {
+	if s.rooms == nil {
+		return
+	}
+	sessions, err := s.store.ListSessions(callID)
+	if err != nil {
+		log.Printf("list sessions of call %s: %v", callID, err)
+		return
+	}
+	for _, sess := range sessions {
+		if sess.Type == SessionType && sess.Mode == string(livekit.SuperviseWhisper) {
+			s.closeWhisper(ctx, sess.ID)
+		}
+	}
+}
+
+// leave ends a session a supervisor switched modes from and removes it from the room, and
+// from its whisper room.
+func (s *Supervisor) leave(callID string, sess store.Session) {
+	s.endSession(sess.ID, ReasonSwitched)
+	if s.rooms == nil {
+		return
+	}
+	err := s.rooms.RemoveParticipant(context.Background(), callID, sess.ID)
+	if err != nil && !roomservice.IsNotFound(err) {
+		log.Printf("remove supervisor session %s from call %s: %v", sess.ID, callID, err)
+	}
+	if sess.Mode == string(livekit.SuperviseWhisper) {
+		s.closeWhisper(context.Background(), sess.ID)
+	}
+}
+
+// closeWhisper closes the whisper room of the supervisor session, if it is open.
+func (s *Supervisor) closeWhisper(ctx context.Context, sessionID string) {
+	room := livekit.WhisperRoom(sessionID)
+	if err := s.rooms.DeleteRoom(ctx, room); err != nil && !roomservice.IsNotFound(err) {
+		log.Printf("close whisper room %s: %v", room, err)
+	}
+}
+
+func (s *Supervisor) endSession(sessionID, reason string) {
+	if err := s.store.EndSession(sessionID, reason); err != nil {
+		log.Printf("end supervisor session %s: %v", sessionID, err)
+	}
+}
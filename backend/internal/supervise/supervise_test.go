@@ -0,0 +1,133 @@
+package supervise
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/backend/internal/acd"
+	"github.com/jacky-htg/ai-call-center/libs/livekit"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// fakeRooms records who was removed from which room and the rooms closed.
+type fakeRooms struct {
+	removed []string
+	deleted []string
+}
+
+func (f *fakeRooms) RemoveParticipant(ctx context.Context, room, identity string) error {
+	f.removed = append(f.removed, room+"/"+identity)
+	return nil
+}
+
+func (f *fakeRooms) DeleteRoom(ctx context.Context, room string) error {
+	f.deleted = append(f.deleted, room)
+	return nil
+}
+
+func newTestSupervisor(t *testing.T) (*Supervisor, *store.Store, *fakeRooms) {
+	t.Helper()
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	s := New(st, livekit.Settings{URL: "ws://livekit", APIKey: "devkey", APISecret: "secret", TokenTTL: time.Hour})
+	rooms := &fakeRooms{}
+	s.SetRooms(rooms)
+	return s, st, rooms
+}
+
+func TestJoin(t *testing.T) {
+	s, st, rooms := newTestSupervisor(t)
+	callID, _, err := st.CreateCall("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// listening needs no human agent
+	listen, err := s.Join(callID, "sup-1", livekit.SuperviseListen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess, err := st.GetSession(listen.ID); err != nil || sess.Type != SessionType || sess.UserID != "sup-1" || sess.Mode != "listen" {
+		t.Fatalf("listen session = %+v, %v", sess, err)
+	}
+	if c, err := livekit.ParseToken("devkey", "secret", listen.Token); err != nil || c.Subject != listen.ID || !c.Video.Hidden {
+		t.Fatalf("listen token claims = %+v, %v", c, err)
+	}
+	if listen.WhisperRoom != "" || listen.WhisperToken != "" {
+		t.Fatalf("listen session has a whisper room: %+v", listen)
+	}
+
+	if _, err := s.Join(callID, "sup-1", livekit.SuperviseWhisper); !errors.Is(err, ErrNoHuman) {
+		t.Fatalf("whisper without a human agent: %v, want ErrNoHuman", err)
+	}
+	human, err := st.CreateSession(callID, "hana", acd.SessionType, "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	whisper, err := s.Join(callID, "sup-1", livekit.SuperviseWhisper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if whisper.WhisperTo != human {
+		t.Fatalf("whispers to %q, want %q", whisper.WhisperTo, human)
+	}
+	// switching modes ends the listening session and takes it out of the room
+	if sess, err := st.GetSession(listen.ID); err != nil || sess.Status != "ended" || sess.EndReason != ReasonSwitched {
+		t.Fatalf("listen session after switching = %+v, %v", sess, err)
+	}
+	if !slices.Equal(rooms.removed, []string{callID + "/" + listen.ID}) {
+		t.Fatalf("removed %v", rooms.removed)
+	}
+
+	// the whisper cannot be published in the call's room: it goes to a room of its own that
+	// only the human agent is let into to listen
+	if c, err := livekit.ParseToken("devkey", "secret", whisper.Token); err != nil || c.Video.Room != callID || *c.Video.CanPublish {
+		t.Fatalf("whisper call token claims = %+v, %v", c, err)
+	}
+	if c, err := livekit.ParseToken("devkey", "secret", whisper.WhisperToken); err != nil || c.Video.Room != whisper.WhisperRoom || whisper.WhisperRoom == callID || !*c.Video.CanPublish {
+		t.Fatalf("whisper room token claims = %+v, %v", c, err)
+	}
+	whispers, err := s.Whispers(human)
+	if err != nil || len(whispers) != 1 || whispers[0].SessionID != whisper.ID || whispers[0].Room != whisper.WhisperRoom {
+		t.Fatalf("Whispers = %+v, %v", whispers, err)
+	}
+	if c, err := livekit.ParseToken("devkey", "secret", whispers[0].Token); err != nil || c.Subject != human || c.Video.Room != whisper.WhisperRoom || *c.Video.CanPublish {
+		t.Fatalf("human agent's whisper token claims = %+v, %v", c, err)
+	}
+	// barging in ends the whisper and closes its room
+	if _, err := s.Join(callID, "sup-1", livekit.SuperviseBarge); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rooms.deleted, []string{whisper.WhisperRoom}) {
+		t.Fatalf("deleted rooms %v", rooms.deleted)
+	}
+	if whispers, err := s.Whispers(human); err != nil || len(whispers) != 0 {
+		t.Fatalf("Whispers after barging in = %+v, %v", whispers, err)
+	}
+
+	if _, err := s.Join(callID, "sup-1", "coach"); !errors.Is(err, ErrUnknownMode) {
+		t.Fatalf("unknown mode: %v", err)
+	}
+	if _, err := s.Join("missing", "sup-1", livekit.SuperviseBarge); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("missing call: %v", err)
+	}
+	if err := st.UpdateCallStatus(callID, "ended"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Join(callID, "sup-1", livekit.SuperviseBarge); !errors.Is(err, ErrCallEnded) {
+		t.Fatalf("ended call: %v", err)
+	}
+	// whisper rooms go with the call
+	rooms.deleted = nil
+	s.CallEnded(context.Background(), callID)
+	if !slices.Equal(rooms.deleted, []string{whisper.WhisperRoom}) {
+		t.Fatalf("deleted rooms after the call ended %v", rooms.deleted)
+	}
+}
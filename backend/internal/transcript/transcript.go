@@ -0,0 +1,156 @@
+// Package transcript records the turns of a call and renders them as JSON, plain text,
+// SRT or WebVTT.
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// Recorder persists turns; *store.Store implements it.
+type Recorder interface {
+	AddTurn(t store.Turn) (int64, error)
+}
+
+// Output formats.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+	FormatSRT  = "srt"
+	FormatVTT  = "vtt"
+)
+
+// ContentType returns the MIME type of format, and false for unknown formats.
+func ContentType(format string) (string, bool) {
+	switch format {
+	case FormatJSON:
+		return "application/json", true
+	case FormatText:
+		return "text/plain; charset=utf-8", true
+	case FormatSRT:
+		return "application/x-subrip; charset=utf-8", true
+	case FormatVTT:
+		return "text/vtt; charset=utf-8", true
+	}
+	return "", false
+}
+
+// Write renders the turns of callID in format. Cue and line times are offsets from the
+// start of the first turn.
+func Write(w io.Writer, format, callID string, turns []store.Turn) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, callID, turns)
+	case FormatText:
+		return writeText(w, turns)
+	case FormatSRT:
+		return writeCues(w, turns, "", ",", func(t store.Turn) string { return label(t) + ": " + t.Text })
+	case FormatVTT:
+		return writeCues(w, turns, "WEBVTT\n\n", ".", func(t store.Turn) string {
+			return "<v " + label(t) + ">" + vttEscaper.Replace(t.Text)
+		})
+	}
+	return fmt.Errorf("unknown transcript format %q", format)
+}
+
+// vttEscaper escapes cue text, which WebVTT parses for tags and entities. Escaping ">"
+// also keeps "-->" out of cues.
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+type jsonTurn struct {
+	Speaker      string    `json:"speaker"`
+	SessionID    string    `json:"session_id"`
+	Text         string    `json:"text"`
+	Confidence   float32   `json:"confidence,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at"`
+	STTLatencyMs int64     `json:"stt_latency_ms,omitempty"`
+	LLMLatencyMs int64     `json:"llm_latency_ms,omitempty"`
+	TTSLatencyMs int64     `json:"tts_latency_ms,omitempty"`
+	Interrupted  bool      `json:"interrupted,omitempty"`
+}
+
+func writeJSON(w io.Writer, callID string, turns []store.Turn) error {
+	out := struct {
+		CallID string     `json:"call_id"`
+		Turns  []jsonTurn `json:"turns"`
+	}{CallID: callID, Turns: make([]jsonTurn, 0, len(turns))}
+	for _, t := range turns {
+		out.Turns = append(out.Turns, jsonTurn{
+			Speaker:      t.Speaker,
+			SessionID:    t.SessionID,
+			Text:         t.Text,
+			Confidence:   t.Confidence,
+			StartedAt:    t.StartedAt.UTC(),
+			EndedAt:      t.EndedAt.UTC(),
+			STTLatencyMs: t.STTLatency.Milliseconds(),
+			LLMLatencyMs: t.LLMLatency.Milliseconds(),
+			TTSLatencyMs: t.TTSLatency.Milliseconds(),
+			Interrupted:  t.Interrupted,
+		})
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+func writeText(w io.Writer, turns []store.Turn) error {
+	for _, t := range turns {
+		text := strings.Join(strings.Fields(t.Text), " ")
+		line := fmt.Sprintf("[%s] %s: %s", clock(offset(turns, t.StartedAt), ".")[:8], label(t), text)
+		if t.Interrupted {
+			line += " (interrupted)"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCues writes SRT-style numbered cues, preceded by header. sep separates seconds from
+// milliseconds in cue times.
+func writeCues(w io.Writer, turns []store.Turn, header, sep string, text func(store.Turn) string) error {
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	for i, t := range turns {
+		start, end := offset(turns, t.StartedAt), offset(turns, t.EndedAt)
+		if end <= start {
+			end = start + time.Second
+		}
+		// blank lines end a cue, so keep each turn on one line
+		body := strings.Join(strings.Fields(text(t)), " ")
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, clock(start, sep), clock(end, sep), body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offset is the time of at relative to the start of the first turn.
+func offset(turns []store.Turn, at time.Time) time.Duration {
+	if len(turns) == 0 || at.Before(turns[0].StartedAt) {
+		return 0
+	}
+	return at.Sub(turns[0].StartedAt)
+}
+
+// clock formats d as HH:MM:SS<sep>mmm.
+func clock(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+func label(t store.Turn) string {
+	switch t.Speaker {
+	case store.SpeakerCaller:
+		return "Caller"
+	case store.SpeakerAgent:
+		return "Agent"
+	}
+	return t.Speaker
+}
@@ -0,0 +1,130 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// storedTurns records a short exchange in a fresh store and reads it back.
+func storedTurns(t *testing.T) []store.Turn {
+	t.Helper()
+	st, err := store.Open(store.DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	start := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	for _, turn := range []store.Turn{
+		// recorded out of order: the transcript is ordered by start time
+		{CallID: "call-1", SessionID: "agent-1", Speaker: store.SpeakerAgent, Text: "Hello, how can I help?", StartedAt: start.Add(3200 * time.Millisecond), EndedAt: start.Add(5 * time.Second), LLMLatency: 400 * time.Millisecond, TTSLatency: 250 * time.Millisecond},
+		{CallID: "call-1", SessionID: "caller-1", Speaker: store.SpeakerCaller, Text: "Hi there", Confidence: 0.9, StartedAt: start.Add(time.Second), EndedAt: start.Add(2500 * time.Millisecond), STTLatency: 300 * time.Millisecond},
+		{CallID: "call-1", SessionID: "caller-1", Speaker: store.SpeakerCaller, Text: "I need\n\nmy bill", Confidence: 0.8, StartedAt: start.Add(61 * time.Second), EndedAt: start.Add(63 * time.Second)},
+		{CallID: "call-1", SessionID: "agent-1", Speaker: store.SpeakerAgent, Text: "Sure, your", StartedAt: start.Add(64 * time.Second), EndedAt: start.Add(65 * time.Second), Interrupted: true},
+		{CallID: "call-2", Speaker: store.SpeakerCaller, Text: "other call", StartedAt: start, EndedAt: start},
+	} {
+		if _, err := st.AddTurn(turn); err != nil {
+			t.Fatalf("AddTurn: %v", err)
+		}
+	}
+	turns, err := st.ListTurns("call-1")
+	if err != nil {
+		t.Fatalf("ListTurns: %v", err)
+	}
+	if len(turns) != 4 {
+		t.Fatalf("got %d turns, want 4", len(turns))
+	}
+	return turns
+}
+
+func render(t *testing.T, format string, turns []store.Turn) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, format, "call-1", turns); err != nil {
+		t.Fatalf("Write(%s): %v", format, err)
+	}
+	return buf.String()
+}
+
+func TestWrite_Text(t *testing.T) {
+	want := "[00:00:00] Caller: Hi there\n" +
+		"[00:00:02] Agent: Hello, how can I help?\n" +
+		"[00:01:00] Caller: I need my bill\n" +
+		"[00:01:03] Agent: Sure, your (interrupted)\n"
+	if got := render(t, FormatText, storedTurns(t)); got != want {
+		t.Fatalf("text transcript:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWrite_SRT(t *testing.T) {
+	got := render(t, FormatSRT, storedTurns(t))
+	want := "1\n00:00:00,000 --> 00:00:01,500\nCaller: Hi there\n\n" +
+		"2\n00:00:02,200 --> 00:00:04,000\nAgent: Hello, how can I help?\n\n" +
+		"3\n00:01:00,000 --> 00:01:02,000\nCaller: I need my bill\n\n"
+	if !strings.HasPrefix(got, want) {
+		t.Fatalf("srt transcript:\n%s\nwant prefix:\n%s", got, want)
+	}
+}
+
+func TestWrite_VTT(t *testing.T) {
+	got := render(t, FormatVTT, storedTurns(t))
+	if !strings.HasPrefix(got, "WEBVTT\n\n1\n00:00:00.000 --> 00:00:01.500\n<v Caller>Hi there\n\n") {
+		t.Fatalf("vtt transcript:\n%s", got)
+	}
+}
+
+func TestWrite_VTTEscapesCueText(t *testing.T) {
+	start := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	turns := []store.Turn{{Speaker: store.SpeakerCaller, Text: "Is <b> & 1 --> 2 < 3?", StartedAt: start, EndedAt: start.Add(time.Second)}}
+	got := render(t, FormatVTT, turns)
+	if want := "<v Caller>Is &lt;b&gt; &amp; 1 --&gt; 2 &lt; 3?\n"; !strings.Contains(got, want) {
+		t.Fatalf("vtt transcript:\n%s\nwant cue %q", got, want)
+	}
+}
+
+func TestWrite_JSON(t *testing.T) {
+	var out struct {
+		CallID string `json:"call_id"`
+		Turns  []struct {
+			Speaker      string    `json:"speaker"`
+			SessionID    string    `json:"session_id"`
+			Text         string    `json:"text"`
+			Confidence   float32   `json:"confidence"`
+			StartedAt    time.Time `json:"started_at"`
+			STTLatencyMs int64     `json:"stt_latency_ms"`
+			LLMLatencyMs int64     `json:"llm_latency_ms"`
+			Interrupted  bool      `json:"interrupted"`
+		} `json:"turns"`
+	}
+	if err := json.Unmarshal([]byte(render(t, FormatJSON, storedTurns(t))), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.CallID != "call-1" || len(out.Turns) != 4 {
+		t.Fatalf("transcript = %+v", out)
+	}
+	first, second := out.Turns[0], out.Turns[1]
+	if first.Speaker != "caller" || first.SessionID != "caller-1" || first.Confidence != 0.9 || first.STTLatencyMs != 300 {
+		t.Fatalf("first turn = %+v", first)
+	}
+	if second.Speaker != "agent" || second.LLMLatencyMs != 400 || !second.StartedAt.Equal(time.Date(2026, 1, 2, 10, 0, 3, 200e6, time.UTC)) {
+		t.Fatalf("second turn = %+v", second)
+	}
+	if !out.Turns[3].Interrupted {
+		t.Fatalf("last turn not marked interrupted")
+	}
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, "docx", "call-1", nil); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+	if _, ok := ContentType("docx"); ok {
+		t.Fatal("expected no content type for unknown format")
+	}
+}
@@ -0,0 +1,422 @@
+// Package workers keeps track of the agent workers connected to the backend over the dispatch
+// protocol and assigns calls to them by load.
+package workers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/backend/internal/transcript"
+	"github.com/jacky-htg/ai-call-center/libs/dispatch"
+)
+
+// ErrNoCapacity is returned by Assign when no worker took the call in time.
+var ErrNoCapacity = errors.New("no worker has capacity")
+
+// DefaultAssignTimeout is how long Assign waits for a worker to take a call.
+const DefaultAssignTimeout = 10 * time.Second
+
+const (
+	// registerTimeout is how long a new connection has to register.
+	registerTimeout = 10 * time.Second
+	// pingInterval is how often connections are pinged; one that stays silent for two
+	// intervals is dropped.
+	pingInterval = 30 * time.Second
+	writeTimeout = 10 * time.Second
+	// sendBuffer is how many messages may wait for a worker before it counts as stuck.
+	sendBuffer = 64
+)
+
+// Info describes a connected worker.
+type Info struct {
+	ID          string
+	Capacity    int
+	Active      int
+	ConnectedAt time.Time
+}
+
+// Pool is the set of connected workers. It serves their connections and assigns them the
+// calls whose agents they run.
+type Pool struct {
+	secret        string
+	recorder      transcript.Recorder
+	onStatus      func(dispatch.Status)
+	assignTimeout time.Duration
+	upgrader      websocket.Upgrader
+
+	mu      sync.Mutex
+	workers map[string]*worker
+	// calls maps a call to the worker running or being offered its agent
+	calls map[string]*worker
+	// changed is closed and replaced when capacity may have become free
+	changed chan struct{}
+}
+
+// worker is a connected worker.
+type worker struct {
+	id          string
+	capacity    int
+	connectedAt time.Time
+	// calls maps the calls assigned to the worker to their agent sessions
+	calls map[string]string
+	// answers receive the worker's answer to the assignments it has not answered yet
+	answers map[string]chan dispatch.Status
+	send    chan dispatch.Message
+	done    chan struct{}
+}
+
+// New returns a pool whose workers authenticate with secret as a bearer token; without a
+// secret no worker may connect, as assignments carry agent tokens and conversations. Turns reported by workers are recorded with rec, and the
+// joined and ended statuses of their agents passed to onStatus.
+func New(secret string, rec transcript.Recorder, onStatus func(dispatch.Status)) *Pool {
+	return &Pool{
+		secret:        secret,
+		recorder:      rec,
+		onStatus:      onStatus,
+		assignTimeout: DefaultAssignTimeout,
+		workers:       make(map[string]*worker),
+		calls:         make(map[string]*worker),
+		changed:       make(chan struct{}),
+	}
+}
+
+// Workers describes the connected workers, ordered by id.
+func (p *Pool) Workers() []Info {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	infos := make([]Info, 0, len(p.workers))
+	for _, w := range p.workers {
+		infos = append(infos, Info{ID: w.id, Capacity: w.capacity, Active: len(w.calls), ConnectedAt: w.connectedAt})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Assign offers the call to the least loaded worker with room for it, and to the next one if
+// it declines, until one accepts. It waits for capacity to free up for as long as the
+// assignment timeout allows and returns the id of the worker that took the call.
+func (p *Pool) Assign(ctx context.Context, a dispatch.Assignment) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.assignTimeout)
+	defer cancel()
+	declined := make(map[string]bool)
+	for {
+		p.mu.Lock()
+		if _, ok := p.calls[a.CallID]; ok {
+			p.mu.Unlock()
+			return "", fmt.Errorf("call %s is already assigned", a.CallID)
+		}
+		w := p.pickLocked(declined)
+		if w == nil {
+			changed := p.changed
+			p.mu.Unlock()
+			select {
+			case <-changed:
+				// a worker connected or freed capacity; offer it to everyone again
+				clear(declined)
+				continue
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return "", ErrNoCapacity
+				}
+				return "", ctx.Err()
+			}
+		}
+		answer := make(chan dispatch.Status, 1)
+		w.calls[a.CallID] = a.SessionID
+		w.answers[a.CallID] = answer
+		p.calls[a.CallID] = w
+		p.mu.Unlock()
+
+		var reason string
+		if !w.queue(dispatch.Message{Type: dispatch.TypeAssign, Assign: &a}) {
+			reason = "unreachable"
+		} else {
+			select {
+			case s := <-answer:
+				if s.State == dispatch.StateAccepted {
+					return w.id, nil
+				}
+				reason = s.Reason
+			case <-w.done:
+				reason = "disconnected"
+			case <-ctx.Done():
+				// it may still accept; make sure it lets the call go
+				w.queue(dispatch.Message{Type: dispatch.TypeRelease, Release: &dispatch.Release{CallID: a.CallID}})
+				reason = "no answer"
+			}
+		}
+		p.unassign(w, a.CallID)
+		log.Printf("worker %s did not take call %s: %s", w.id, a.CallID, reason)
+		declined[w.id] = true
+	}
+}
+
+// pickLocked returns the worker with the lowest share of its capacity in use that has not
+// declined the call, or nil if none has room. p.mu must be held.
+func (p *Pool) pickLocked(declined map[string]bool) *worker {
+	var best *worker
+	for _, w := range p.workers {
+		if declined[w.id] || len(w.calls) >= w.capacity {
+			continue
+		}
+		if best == nil || less(w, best) {
+			best = w
+		}
+	}
+	return best
+}
+
+// less orders workers by load, then by spare capacity, then by id.
+func less(a, b *worker) bool {
+	// compare len(a.calls)/a.capacity with len(b.calls)/b.capacity without dividing
+	la, lb := len(a.calls)*b.capacity, len(b.calls)*a.capacity
+	if la != lb {
+		return la < lb
+	}
+	if fa, fb := a.capacity-len(a.calls), b.capacity-len(b.calls); fa != fb {
+		return fa > fb
+	}
+	return a.id < b.id
+}
+
+// Release asks the worker running the call's agent to stop it. The agent's ended status
+// follows.
+func (p *Pool) Release(callID string) error {
+	p.mu.Lock()
+	w, ok := p.calls[callID]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("call %s is not assigned to a worker", callID)
+	}
+	if !w.queue(dispatch.Message{Type: dispatch.TypeRelease, Release: &dispatch.Release{CallID: callID}}) {
+		return fmt.Errorf("release call %s: worker %s is unreachable", callID, w.id)
+	}
+	return nil
+}
+
+// unassign forgets that the call is assigned to w.
+func (p *Pool) unassign(w *worker, callID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(w.answers, callID)
+	if p.calls[callID] == w {
+		delete(p.calls, callID)
+		delete(w.calls, callID)
+		p.notifyLocked()
+	}
+}
+
+// notifyLocked wakes up the assignments waiting for capacity. p.mu must be held.
+func (p *Pool) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// ServeHTTP serves a worker's connection at dispatch.Path.
+func (p *Pool) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if p.secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+p.secret)) != 1 {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	conn, err := p.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(registerTimeout))
+	var m dispatch.Message
+	if err := conn.ReadJSON(&m); err != nil {
+		return
+	}
+	if err := m.Validate(); err != nil || m.Type != dispatch.TypeRegister {
+		closeWith(conn, websocket.ClosePolicyViolation, "register first")
+		return
+	}
+	w, err := p.add(m.Register)
+	if err != nil {
+		closeWith(conn, websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	log.Printf("worker %s connected with capacity %d", w.id, w.capacity)
+	defer p.remove(w)
+
+	go w.write(conn)
+	w.queue(dispatch.Message{Type: dispatch.TypeRegistered, Registered: &dispatch.Registered{WorkerID: w.id}})
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		var m dispatch.Message
+		if err := conn.ReadJSON(&m); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("worker %s: %v", w.id, err)
+			}
+			return
+		}
+		if err := m.Validate(); err != nil {
+			log.Printf("worker %s: %v", w.id, err)
+			continue
+		}
+		p.handle(w, m)
+	}
+}
+
+// add registers a worker.
+func (p *Pool) add(reg *dispatch.Register) (*worker, error) {
+	id := reg.WorkerID
+	if id == "" {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		id = "worker-" + hex.EncodeToString(b)
+	}
+	w := &worker{
+		id:          id,
+		capacity:    reg.Capacity,
+		connectedAt: time.Now(),
+		calls:       make(map[string]string),
+		answers:     make(map[string]chan dispatch.Status),
+		send:        make(chan dispatch.Message, sendBuffer),
+		done:        make(chan struct{}),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.workers[id]; ok {
+		return nil, fmt.Errorf("worker %s is already connected", id)
+	}
+	p.workers[id] = w
+	p.notifyLocked()
+	return w, nil
+}
+
+// remove forgets a worker that disconnected and reports the agents it ran as ended.
+func (p *Pool) remove(w *worker) {
+	p.mu.Lock()
+	delete(p.workers, w.id)
+	close(w.done)
+	var lost []dispatch.Status
+	for callID, sessionID := range w.calls {
+		if p.calls[callID] != w {
+			continue
+		}
+		delete(p.calls, callID)
+		// assignments still waiting for an answer are offered elsewhere by Assign
+		if _, pending := w.answers[callID]; !pending {
+			lost = append(lost, dispatch.Status{CallID: callID, SessionID: sessionID, State: dispatch.StateEnded, Reason: dispatch.ReasonWorkerLost})
+		}
+	}
+	p.mu.Unlock()
+	log.Printf("worker %s disconnected with %d agents", w.id, len(lost))
+	for _, s := range lost {
+		p.onStatus(s)
+	}
+}
+
+// handle acts on a message from a worker.
+func (p *Pool) handle(w *worker, m dispatch.Message) {
+	switch m.Type {
+	case dispatch.TypeLoad:
+		p.mu.Lock()
+		w.capacity = m.Load.Capacity
+		p.notifyLocked()
+		p.mu.Unlock()
+
+	case dispatch.TypeStatus:
+		s := *m.Status
+		p.mu.Lock()
+		assigned := p.calls[s.CallID] == w
+		s.SessionID = w.calls[s.CallID]
+		answer, pending := w.answers[s.CallID]
+		answered := s.State == dispatch.StateAccepted || s.State == dispatch.StateRejected
+		if pending && answered {
+			delete(w.answers, s.CallID)
+		}
+		p.mu.Unlock()
+		if !assigned {
+			log.Printf("worker %s: %s status of call %s it does not run", w.id, s.State, s.CallID)
+			return
+		}
+		switch s.State {
+		case dispatch.StateAccepted, dispatch.StateRejected:
+			if pending {
+				answer <- s
+			}
+		case dispatch.StateJoined:
+			p.onStatus(s)
+		case dispatch.StateEnded:
+			p.unassign(w, s.CallID)
+			p.onStatus(s)
+		}
+
+	case dispatch.TypeTurn:
+		p.mu.Lock()
+		_, ok := w.calls[m.Turn.CallID]
+		p.mu.Unlock()
+		if !ok {
+			log.Printf("worker %s: turn of call %s it does not run", w.id, m.Turn.CallID)
+			return
+		}
+		if _, err := p.recorder.AddTurn(*m.Turn); err != nil {
+			log.Printf("record %s turn for call %s: %v", m.Turn.Speaker, m.Turn.CallID, err)
+		}
+
+	default:
+		log.Printf("worker %s: unexpected %s message", w.id, m.Type)
+	}
+}
+
+// queue hands a message to the worker's writer. It reports false if the worker is gone or
+// too far behind to take it.
+func (w *worker) queue(m dispatch.Message) bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+	}
+	select {
+	case w.send <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// write sends the worker its queued messages and pings until it disconnects.
+func (w *worker) write(conn *websocket.Conn) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-w.done:
+			return
+		case m := <-w.send:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = conn.WriteJSON(m)
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+		}
+		if err != nil {
+			// unblocks the reader, which removes the worker
+			conn.Close()
+			return
+		}
+	}
+}
+
+func closeWith(conn *websocket.Conn, code int, text string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(writeTimeout))
+}
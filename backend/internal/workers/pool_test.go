@@ -0,0 +1,258 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/libs/dispatch"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+const testSecret = "worker-secret"
+
+// turns records the turns workers report.
+type turns struct {
+	mu    sync.Mutex
+	turns []store.Turn
+}
+
+func (r *turns) AddTurn(t store.Turn) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.turns = append(r.turns, t)
+	return int64(len(r.turns)), nil
+}
+
+// testPool serves a pool whose statuses are sent on the returned channel.
+func testPool(t *testing.T) (*Pool, *httptest.Server, *turns, chan dispatch.Status) {
+	t.Helper()
+	rec := &turns{}
+	statuses := make(chan dispatch.Status, 16)
+	p := New(testSecret, rec, func(s dispatch.Status) { statuses <- s })
+	p.assignTimeout = time.Second
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	return p, srv, rec, statuses
+}
+
+// fakeWorker is a worker that answers assignments with accept.
+type fakeWorker struct {
+	ws       *websocket.Conn
+	mu       sync.Mutex
+	accept   bool
+	assigned chan dispatch.Assignment
+	released chan string
+}
+
+func connectWorker(t *testing.T, p *Pool, srv *httptest.Server, id string, capacity int, accept bool) *fakeWorker {
+	t.Helper()
+	header := http.Header{"Authorization": {"Bearer " + testSecret}}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("connect worker %s: %v", id, err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	w := &fakeWorker{ws: ws, accept: accept, assigned: make(chan dispatch.Assignment, 16), released: make(chan string, 16)}
+	w.send(t, dispatch.Message{Type: dispatch.TypeRegister, Register: &dispatch.Register{WorkerID: id, Capacity: capacity}})
+	var m dispatch.Message
+	if err := ws.ReadJSON(&m); err != nil || m.Type != dispatch.TypeRegistered || m.Registered.WorkerID != id {
+		t.Fatalf("register worker %s: %+v, %v", id, m, err)
+	}
+	go func() {
+		for {
+			var m dispatch.Message
+			if err := ws.ReadJSON(&m); err != nil {
+				return
+			}
+			switch m.Type {
+			case dispatch.TypeAssign:
+				s := dispatch.Status{CallID: m.Assign.CallID, State: dispatch.StateAccepted}
+				if !w.accept {
+					s.State, s.Reason = dispatch.StateRejected, dispatch.ReasonFull
+				}
+				w.send(t, dispatch.Message{Type: dispatch.TypeStatus, Status: &s})
+				if w.accept {
+					w.assigned <- *m.Assign
+				}
+			case dispatch.TypeRelease:
+				w.released <- m.Release.CallID
+			}
+		}
+	}()
+	// the pool knows the worker once it has been registered
+	waitFor(t, func() bool {
+		for _, info := range p.Workers() {
+			if info.ID == id {
+				return true
+			}
+		}
+		return false
+	})
+	return w
+}
+
+func (w *fakeWorker) send(t *testing.T, m dispatch.Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.ws.WriteJSON(m); err != nil {
+		t.Errorf("send %s: %v", m.Type, err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func assignment(callID string) dispatch.Assignment {
+	return dispatch.Assignment{CallID: callID, SessionID: "agent-" + callID, URL: "ws://livekit", Token: "token"}
+}
+
+func TestPoolAssign_ByLoad(t *testing.T) {
+	p, srv, _, _ := testPool(t)
+	connectWorker(t, p, srv, "a", 1, true)
+	connectWorker(t, p, srv, "b", 3, true)
+
+	// equal load goes to the most spare capacity, then the lowest share of capacity in use
+	for i, want := range []string{"b", "a", "b", "b"} {
+		callID := string(rune('1' + i))
+		got, err := p.Assign(context.Background(), assignment(callID))
+		if err != nil || got != want {
+			t.Fatalf("Assign call %s = %q, %v; want %q", callID, got, err, want)
+		}
+	}
+	if _, err := p.Assign(context.Background(), assignment("5")); !errors.Is(err, ErrNoCapacity) {
+		t.Fatalf("Assign to full workers: err = %v, want ErrNoCapacity", err)
+	}
+	if _, err := p.Assign(context.Background(), assignment("1")); err == nil {
+		t.Fatal("Assign of an assigned call: expected error")
+	}
+	infos := p.Workers()
+	if len(infos) != 2 || infos[0].Active != 1 || infos[1].Active != 3 {
+		t.Fatalf("Workers = %+v", infos)
+	}
+}
+
+func TestPoolAssign_Rejected(t *testing.T) {
+	p, srv, _, _ := testPool(t)
+	connectWorker(t, p, srv, "a", 4, false)
+	b := connectWorker(t, p, srv, "b", 1, true)
+
+	got, err := p.Assign(context.Background(), assignment("1"))
+	if err != nil || got != "b" {
+		t.Fatalf("Assign = %q, %v; want the worker that accepts", got, err)
+	}
+	if a := <-b.assigned; a.SessionID != "agent-1" || a.Token != "token" {
+		t.Fatalf("assignment = %+v", a)
+	}
+	if infos := p.Workers(); infos[0].Active != 0 {
+		t.Fatalf("worker that rejected the call runs %d agents", infos[0].Active)
+	}
+}
+
+func TestPoolAssign_WaitsForCapacity(t *testing.T) {
+	p, srv, _, statuses := testPool(t)
+	w := connectWorker(t, p, srv, "a", 1, true)
+	if _, err := p.Assign(context.Background(), assignment("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Assign(context.Background(), assignment("2"))
+		done <- err
+	}()
+	// the first agent ends, which frees the worker for the second call
+	w.send(t, dispatch.Message{Type: dispatch.TypeStatus, Status: &dispatch.Status{CallID: "1", State: dispatch.StateEnded}})
+	if s := <-statuses; s.State != dispatch.StateEnded || s.SessionID != "agent-1" {
+		t.Fatalf("status = %+v", s)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Assign once capacity is free: %v", err)
+	}
+}
+
+func TestPool_StatusesAndTurns(t *testing.T) {
+	p, srv, rec, statuses := testPool(t)
+	w := connectWorker(t, p, srv, "a", 1, true)
+	if _, err := p.Assign(context.Background(), assignment("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	w.send(t, dispatch.Message{Type: dispatch.TypeStatus, Status: &dispatch.Status{CallID: "1", State: dispatch.StateJoined}})
+	if s := <-statuses; s.State != dispatch.StateJoined || s.SessionID != "agent-1" {
+		t.Fatalf("status = %+v", s)
+	}
+	// turns of calls the worker does not run are dropped
+	w.send(t, dispatch.Message{Type: dispatch.TypeTurn, Turn: &store.Turn{CallID: "2", Speaker: store.SpeakerCaller, Text: "not mine"}})
+	w.send(t, dispatch.Message{Type: dispatch.TypeTurn, Turn: &store.Turn{CallID: "1", Speaker: store.SpeakerCaller, Text: "hello"}})
+	waitFor(t, func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.turns) > 0
+	})
+	if rec.turns[0].Text != "hello" {
+		t.Fatalf("recorded turns = %+v", rec.turns)
+	}
+
+	if err := p.Release("1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if callID := <-w.released; callID != "1" {
+		t.Fatalf("released %q", callID)
+	}
+	if err := p.Release("2"); err == nil {
+		t.Fatal("Release of an unassigned call: expected error")
+	}
+}
+
+func TestPool_WorkerLost(t *testing.T) {
+	p, srv, _, statuses := testPool(t)
+	w := connectWorker(t, p, srv, "a", 2, true)
+	if _, err := p.Assign(context.Background(), assignment("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	w.ws.Close()
+	s := <-statuses
+	if s.CallID != "1" || s.SessionID != "agent-1" || s.State != dispatch.StateEnded || s.Reason != dispatch.ReasonWorkerLost {
+		t.Fatalf("status = %+v", s)
+	}
+	waitFor(t, func() bool { return len(p.Workers()) == 0 })
+	// the worker may reconnect under the same id
+	connectWorker(t, p, srv, "a", 2, true)
+	if got, err := p.Assign(context.Background(), assignment("1")); err != nil || got != "a" {
+		t.Fatalf("Assign after reconnect = %q, %v", got, err)
+	}
+}
+
+func TestPool_Unauthorized(t *testing.T) {
+	_, srv, _, _ := testPool(t)
+	header := http.Header{"Authorization": {"Bearer wrong"}}
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("connect with wrong secret: %v, %+v", err, resp)
+	}
+
+	// without a secret configured no worker gets in, not even one sending an empty one
+	srv = httptest.NewServer(New("", &turns{}, func(dispatch.Status) {}))
+	defer srv.Close()
+	header = http.Header{"Authorization": {"Bearer "}}
+	_, resp, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("connect without a secret configured: %v, %+v", err, resp)
+	}
+}
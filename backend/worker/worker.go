@@ -0,0 +1,357 @@
+// Package worker runs AI agents for a backend that dispatches calls to its workers. A worker
+// keeps a connection to the backend over the dispatch protocol and runs the RoomClient
+// pipeline for every call it is assigned, reporting back what its agents do.
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/backend/internal/conversation"
+	"github.com/jacky-htg/ai-call-center/backend/internal/factory"
+	"github.com/jacky-htg/ai-call-center/backend/internal/livekitclient"
+	"github.com/jacky-htg/ai-call-center/backend/internal/transcript"
+	"github.com/jacky-htg/ai-call-center/libs/config"
+	"github.com/jacky-htg/ai-call-center/libs/dispatch"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// DefaultCapacity is how many calls a worker runs at once unless configured otherwise.
+const DefaultCapacity = 4
+
+const (
+	// pongWait is how long the worker waits to hear from the backend before reconnecting;
+	// the backend pings more often than that.
+	pongWait     = 75 * time.Second
+	writeTimeout = 10 * time.Second
+	// reconnect delays grow from minBackoff to maxBackoff while the backend is unreachable.
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Options configure a worker.
+type Options struct {
+	// BackendURL is the http(s) base URL of the backend.
+	BackendURL string
+	// Secret authenticates the worker to the backend.
+	Secret string
+	// ID names the worker; empty lets the backend pick a name.
+	ID string
+	// Capacity is how many calls the worker runs at once.
+	Capacity int
+}
+
+// room is the part of a livekitclient.RoomClient a worker drives.
+type room interface {
+	Connect() error
+	Disconnect() error
+	Done() <-chan struct{}
+}
+
+// Worker takes calls from the backend and runs their agents.
+type Worker struct {
+	opts Options
+	// newRoom returns the room client of an assignment, recording its turns with rec
+	newRoom func(ctx context.Context, a dispatch.Assignment, rec transcript.Recorder) room
+
+	mu sync.Mutex
+	// agents maps the calls the worker runs to the function that stops their agent
+	agents map[string]context.CancelFunc
+	// conn is the current connection to the backend
+	conn *conn
+}
+
+// CheckCodec returns an error if the binary cannot decode and encode call audio because it
+// was built without the opus tag.
+func CheckCodec() error { return livekitclient.CheckCodec() }
+
+// New returns a worker running agents with the vendors configured in cfg.
+func New(cfg *config.Config, opts Options) (*Worker, error) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultCapacity
+	}
+	tts, err := factory.NewTTS(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("new tts: %w", err)
+	}
+	stt, err := factory.NewSTT(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("new stt: %w", err)
+	}
+	llm, err := factory.NewLLM(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("new llm: %w", err)
+	}
+	vs, err := factory.NewVAD(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("new vad: %w", err)
+	}
+	w := newWorker(opts)
+	w.newRoom = func(ctx context.Context, a dispatch.Assignment, rec transcript.Recorder) room {
+		history := conversation.New(cfg.SystemPrompt, 0)
+		for _, m := range a.History {
+			history.Add(m.Role, m.Content)
+		}
+		rc := livekitclient.NewRoomClient(ctx, a.URL, a.Token, a.CallID, a.SessionID, stt, llm, tts, history, vs)
+		rc.SetRecorder(rec)
+		return rc
+	}
+	return w, nil
+}
+
+func newWorker(opts Options) *Worker {
+	return &Worker{opts: opts, agents: make(map[string]context.CancelFunc)}
+}
+
+// Run keeps the worker connected to the backend until ctx is cancelled, reconnecting when
+// the connection drops. Agents do not outlive their connection: the backend hands their
+// calls to other workers when it loses one.
+func (w *Worker) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		registered, err := w.serve(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if registered {
+			backoff = minBackoff
+		}
+		log.Printf("worker: %v; reconnecting in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// serve connects to the backend, registers and takes assignments until the connection
+// ends. It reports whether the worker got registered.
+func (w *Worker) serve(ctx context.Context) (bool, error) {
+	u, err := connectURL(w.opts.BackendURL)
+	if err != nil {
+		return false, err
+	}
+	header := http.Header{}
+	if w.opts.Secret != "" {
+		header.Set("Authorization", "Bearer "+w.opts.Secret)
+	}
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, u, header)
+	if err != nil {
+		if resp != nil {
+			return false, fmt.Errorf("connect to backend: %w (status %d)", err, resp.StatusCode)
+		}
+		return false, fmt.Errorf("connect to backend: %w", err)
+	}
+	c := &conn{ws: ws}
+	defer ws.Close()
+
+	// the connection's agents stop with it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var agents sync.WaitGroup
+	defer agents.Wait()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	w.mu.Lock()
+	reg := dispatch.Register{WorkerID: w.opts.ID, Capacity: w.opts.Capacity}
+	w.mu.Unlock()
+	if err := c.send(dispatch.Message{Type: dispatch.TypeRegister, Register: &reg}); err != nil {
+		return false, fmt.Errorf("register: %w", err)
+	}
+	ws.SetPingHandler(func(data string) error {
+		_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+	})
+	var m dispatch.Message
+	_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+	if err := ws.ReadJSON(&m); err != nil {
+		return false, fmt.Errorf("register: %w", err)
+	}
+	if err := m.Validate(); err != nil || m.Type != dispatch.TypeRegistered {
+		return false, fmt.Errorf("register: unexpected %s message", m.Type)
+	}
+	log.Printf("worker %s registered with capacity %d", m.Registered.WorkerID, reg.Capacity)
+	w.mu.Lock()
+	w.conn = c
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.conn = nil
+		w.mu.Unlock()
+	}()
+
+	for {
+		_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+		var m dispatch.Message
+		if err := ws.ReadJSON(&m); err != nil {
+			return true, fmt.Errorf("connection to backend: %w", err)
+		}
+		if err := m.Validate(); err != nil {
+			log.Printf("worker: %v", err)
+			continue
+		}
+		switch m.Type {
+		case dispatch.TypeAssign:
+			w.assign(ctx, c, *m.Assign, &agents)
+		case dispatch.TypeRelease:
+			w.release(m.Release.CallID)
+		default:
+			log.Printf("worker: unexpected %s message", m.Type)
+		}
+	}
+}
+
+// SetCapacity changes how many calls the worker runs at once and advertises it to the
+// backend. Zero drains the worker: it takes no new calls and its agents run to the end.
+func (w *Worker) SetCapacity(n int) {
+	w.mu.Lock()
+	w.opts.Capacity = n
+	c := w.conn
+	w.mu.Unlock()
+	if c == nil {
+		return
+	}
+	if err := c.send(dispatch.Message{Type: dispatch.TypeLoad, Load: &dispatch.Load{Capacity: n}}); err != nil {
+		log.Printf("advertise capacity %d: %v", n, err)
+	}
+}
+
+// Active returns how many agents the worker runs.
+func (w *Worker) Active() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.agents)
+}
+
+// assign answers an assignment and, if the worker has room for it, runs its agent.
+func (w *Worker) assign(ctx context.Context, c *conn, a dispatch.Assignment, agents *sync.WaitGroup) {
+	status := dispatch.Status{CallID: a.CallID, SessionID: a.SessionID, State: dispatch.StateAccepted}
+	w.mu.Lock()
+	_, running := w.agents[a.CallID]
+	switch {
+	case running:
+		status.State, status.Reason = dispatch.StateRejected, dispatch.ReasonDuplicate
+	case len(w.agents) >= w.opts.Capacity:
+		status.State, status.Reason = dispatch.StateRejected, dispatch.ReasonFull
+	}
+	var agentCtx context.Context
+	var cancel context.CancelFunc
+	if status.State == dispatch.StateAccepted {
+		agentCtx, cancel = context.WithCancel(ctx)
+		w.agents[a.CallID] = cancel
+	}
+	w.mu.Unlock()
+	c.report(status)
+	if status.State != dispatch.StateAccepted {
+		return
+	}
+
+	agents.Add(1)
+	go func() {
+		defer agents.Done()
+		defer func() {
+			w.mu.Lock()
+			delete(w.agents, a.CallID)
+			w.mu.Unlock()
+			cancel()
+		}()
+		w.run(agentCtx, c, a)
+	}()
+}
+
+// run runs the agent of an assignment until it is released or leaves the room.
+func (w *Worker) run(ctx context.Context, c *conn, a dispatch.Assignment) {
+	status := func(state, reason string) {
+		c.report(dispatch.Status{CallID: a.CallID, SessionID: a.SessionID, State: state, Reason: reason})
+	}
+	rc := w.newRoom(ctx, a, turnSender{c})
+	if err := rc.Connect(); err != nil {
+		log.Printf("agent of call %s: join room: %v", a.CallID, err)
+		reason := dispatch.ReasonJoinFailed
+		if ctx.Err() != nil {
+			// released while joining
+			reason = ""
+		}
+		status(dispatch.StateEnded, reason)
+		return
+	}
+	status(dispatch.StateJoined, "")
+	select {
+	case <-ctx.Done():
+	case <-rc.Done():
+		log.Printf("agent of call %s left the room", a.CallID)
+	}
+	if err := rc.Disconnect(); err != nil {
+		log.Printf("agent of call %s: leave room: %v", a.CallID, err)
+	}
+	status(dispatch.StateEnded, "")
+}
+
+// release stops the agent of a call.
+func (w *Worker) release(callID string) {
+	w.mu.Lock()
+	cancel, ok := w.agents[callID]
+	w.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// connectURL returns the websocket URL of the backend's worker endpoint.
+func connectURL(backend string) (string, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return "", fmt.Errorf("parse backend url: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("backend url %q must be http(s)", backend)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + dispatch.Path
+	return u.String(), nil
+}
+
+// conn is a connection to the backend. Writes are serialized; messages that cannot be sent
+// are dropped, since the backend treats a lost connection as the end of its agents.
+type conn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *conn) send(m dispatch.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.ws.WriteJSON(m)
+}
+
+func (c *conn) report(s dispatch.Status) {
+	if err := c.send(dispatch.Message{Type: dispatch.TypeStatus, Status: &s}); err != nil {
+		log.Printf("report %s of call %s: %v", s.State, s.CallID, err)
+	}
+}
+
+// turnSender records the turns of an agent's conversation with the backend.
+type turnSender struct{ c *conn }
+
+func (t turnSender) AddTurn(turn store.Turn) (int64, error) {
+	if err := t.c.send(dispatch.Message{Type: dispatch.TypeTurn, Turn: &turn}); err != nil {
+		return 0, fmt.Errorf("send turn to backend: %w", err)
+	}
+	return 0, nil
+}
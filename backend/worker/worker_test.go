@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/backend/internal/transcript"
+	"github.com/jacky-htg/ai-call-center/libs/dispatch"
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// fakeRoom joins unless joinErr is set and stays until disconnected.
+type fakeRoom struct {
+	joinErr error
+	rec     transcript.Recorder
+	done    chan struct{}
+}
+
+func (r *fakeRoom) Connect() error    { return r.joinErr }
+func (r *fakeRoom) Disconnect() error { return nil }
+func (r *fakeRoom) Done() <-chan struct{} {
+	return r.done
+}
+
+// fakeBackend accepts one worker connection and hands it to the test.
+func fakeBackend(t *testing.T, secret string) (*httptest.Server, chan *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != dispatch.Path || r.Header.Get("Authorization") != "Bearer "+secret {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- ws
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, conns
+}
+
+func read(t *testing.T, ws *websocket.Conn, typ string) dispatch.Message {
+	t.Helper()
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var m dispatch.Message
+	if err := ws.ReadJSON(&m); err != nil {
+		t.Fatalf("read %s: %v", typ, err)
+	}
+	if m.Type != typ {
+		t.Fatalf("got %s message %+v, want %s", m.Type, m, typ)
+	}
+	return m
+}
+
+func readStatus(t *testing.T, ws *websocket.Conn, callID, state, reason string) {
+	t.Helper()
+	s := read(t, ws, dispatch.TypeStatus).Status
+	if s.CallID != callID || s.State != state || s.Reason != reason {
+		t.Fatalf("status = %+v, want %s %s %q", s, callID, state, reason)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	srv, conns := fakeBackend(t, "secret")
+	w := newWorker(Options{BackendURL: srv.URL, Secret: "secret", ID: "w1", Capacity: 1})
+	rooms := make(chan *fakeRoom, 4)
+	w.newRoom = func(ctx context.Context, a dispatch.Assignment, rec transcript.Recorder) room {
+		r := &fakeRoom{rec: rec, done: make(chan struct{})}
+		if a.CallID == "broken" {
+			r.joinErr = errors.New("no room")
+		}
+		rooms <- r
+		return r
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- w.Run(ctx) }()
+
+	ws := <-conns
+	if reg := read(t, ws, dispatch.TypeRegister).Register; reg.WorkerID != "w1" || reg.Capacity != 1 {
+		t.Fatalf("register = %+v", reg)
+	}
+	send := func(m dispatch.Message) {
+		if err := ws.WriteJSON(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assign := func(callID string) {
+		send(dispatch.Message{Type: dispatch.TypeAssign, Assign: &dispatch.Assignment{CallID: callID, SessionID: "s-" + callID}})
+	}
+	send(dispatch.Message{Type: dispatch.TypeRegistered, Registered: &dispatch.Registered{WorkerID: "w1"}})
+
+	// an agent that cannot join its room ends
+	assign("broken")
+	readStatus(t, ws, "broken", dispatch.StateAccepted, "")
+	<-rooms
+	readStatus(t, ws, "broken", dispatch.StateEnded, dispatch.ReasonJoinFailed)
+
+	assign("1")
+	readStatus(t, ws, "1", dispatch.StateAccepted, "")
+	r := <-rooms
+	readStatus(t, ws, "1", dispatch.StateJoined, "")
+	if w.Active() != 1 {
+		t.Fatalf("Active = %d, want 1", w.Active())
+	}
+	// the worker runs as many agents as it has capacity for
+	assign("2")
+	readStatus(t, ws, "2", dispatch.StateRejected, dispatch.ReasonFull)
+
+	// turns go to the backend
+	if _, err := r.rec.AddTurn(store.Turn{CallID: "1", Speaker: store.SpeakerCaller, Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if turn := read(t, ws, dispatch.TypeTurn).Turn; turn.Text != "hi" {
+		t.Fatalf("turn = %+v", turn)
+	}
+
+	send(dispatch.Message{Type: dispatch.TypeRelease, Release: &dispatch.Release{CallID: "1"}})
+	readStatus(t, ws, "1", dispatch.StateEnded, "")
+
+	// draining advertises no capacity
+	w.SetCapacity(0)
+	if load := read(t, ws, dispatch.TypeLoad).Load; load.Capacity != 0 {
+		t.Fatalf("load = %+v", load)
+	}
+
+	cancel()
+	if err := <-stopped; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v", err)
+	}
+}
+
+func TestConnectURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://localhost:8080":    "ws://localhost:8080" + dispatch.Path,
+		"https://example.com/api/": "wss://example.com/api" + dispatch.Path,
+	} {
+		if got, err := connectURL(in); err != nil || got != want {
+			t.Errorf("connectURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := connectURL("ftp://example.com"); err == nil {
+		t.Error("connectURL of a non-http url: expected error")
+	}
+}
@@ -0,0 +1,106 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Convert converts 16-bit PCM from one sample rate and channel count to another.
+// Channels are mixed down to mono (or mono duplicated) before resampling.
+// Downsampling averages the input samples covered by each output sample, which
+// acts as a simple anti-aliasing filter; upsampling interpolates linearly.
+func Convert(pcm []byte, from, to Format) ([]byte, error) {
+	if from.BitsPerSample != 16 || to.BitsPerSample != 16 {
+		return nil, fmt.Errorf("convert: only 16-bit pcm is supported")
+	}
+	if from.Channels <= 0 || to.Channels <= 0 || from.SampleRate <= 0 || to.SampleRate <= 0 {
+		return nil, fmt.Errorf("convert: invalid format")
+	}
+	if from == to {
+		return pcm, nil
+	}
+	mono := Resample(toMono(BytesToSamples(pcm), from.Channels), from.SampleRate, to.SampleRate)
+	if to.Channels == 1 {
+		return SamplesToBytes(mono), nil
+	}
+	out := make([]int16, len(mono)*to.Channels)
+	for i, s := range mono {
+		for c := 0; c < to.Channels; c++ {
+			out[i*to.Channels+c] = s
+		}
+	}
+	return SamplesToBytes(out), nil
+}
+
+// Resample converts mono samples from one sample rate to another.
+func Resample(in []int16, fromRate, toRate int) []int16 {
+	if fromRate == toRate || len(in) == 0 {
+		return in
+	}
+	n := int(int64(len(in)) * int64(toRate) / int64(fromRate))
+	out := make([]int16, n)
+	step := float64(fromRate) / float64(toRate)
+	if step > 1 {
+		for i := range out {
+			start := int(float64(i) * step)
+			end := int(float64(i+1) * step)
+			if end > len(in) {
+				end = len(in)
+			}
+			if end <= start {
+				end = start + 1
+			}
+			var sum int
+			for _, s := range in[start:end] {
+				sum += int(s)
+			}
+			out[i] = int16(sum / (end - start))
+		}
+		return out
+	}
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		frac := pos - float64(j)
+		a := float64(in[j])
+		b := a
+		if j+1 < len(in) {
+			b = float64(in[j+1])
+		}
+		out[i] = int16(a + (b-a)*frac)
+	}
+	return out
+}
+
+func toMono(in []int16, channels int) []int16 {
+	if channels == 1 {
+		return in
+	}
+	out := make([]int16, len(in)/channels)
+	for i := range out {
+		var sum int
+		for c := 0; c < channels; c++ {
+			sum += int(in[i*channels+c])
+		}
+		out[i] = int16(sum / channels)
+	}
+	return out
+}
+
+// BytesToSamples decodes little-endian 16-bit PCM. A trailing odd byte is dropped.
+func BytesToSamples(pcm []byte) []int16 {
+	out := make([]int16, len(pcm)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	return out
+}
+
+// SamplesToBytes encodes samples as little-endian 16-bit PCM.
+func SamplesToBytes(samples []int16) []byte {
+	out := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(s))
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// FrameDuration is the size of the PCM frames pushed into STT streams.
+const FrameDuration = 20 * time.Millisecond
+
+// FrameBytes returns the size in bytes of one FrameDuration frame of f.
+func (f Format) FrameBytes() int {
+	return int(FrameDuration.Seconds() * float64(f.BytesPerSecond()))
+}
+
+// Transcribe pushes SpeechFormat PCM read from r into a new stream of stt in
+// FrameDuration frames and returns the combined final result once r is drained.
+// onPartial, if non-nil, is called for every partial result as it arrives.
+// Cancelling ctx aborts the recognition.
+func Transcribe(ctx context.Context, stt interfaces.STT, r io.Reader, onPartial func(interfaces.STTResult)) (interfaces.STTResult, error) {
+	stream, err := stt.RecognizeStream(ctx)
+	if err != nil {
+		return interfaces.STTResult{}, fmt.Errorf("open stt stream: %w", err)
+	}
+
+	done := make(chan interfaces.STTResult, 1)
+	go func() {
+		done <- CollectFinal(stream, onPartial)
+	}()
+
+	frame := make([]byte, SpeechFormat.FrameBytes())
+	for {
+		n, rerr := io.ReadFull(r, frame)
+		if n > 0 {
+			if err := stream.Write(frame[:n]); err != nil {
+				_ = stream.Close()
+				<-done
+				return interfaces.STTResult{}, err
+			}
+		}
+		if rerr != nil {
+			if errors.Is(rerr, io.EOF) || errors.Is(rerr, io.ErrUnexpectedEOF) {
+				break
+			}
+			_ = stream.Close()
+			<-done
+			return interfaces.STTResult{}, fmt.Errorf("read audio: %w", rerr)
+		}
+	}
+	if err := stream.Close(); err != nil {
+		return interfaces.STTResult{}, err
+	}
+	res := <-done
+	if err := stream.Err(); err != nil {
+		return interfaces.STTResult{}, err
+	}
+	return res, nil
+}
+
+// CollectFinal drains the results of stream and merges every final result into
+// one. It returns when the stream's result channel is closed.
+func CollectFinal(stream interfaces.STTStream, onPartial func(interfaces.STTResult)) interfaces.STTResult {
+	var out interfaces.STTResult
+	var texts []string
+	finals := 0
+	for res := range stream.Results() {
+		if !res.Final {
+			if onPartial != nil {
+				onPartial(res)
+			}
+			continue
+		}
+		if finals == 0 {
+			out.Start = res.Start
+			out.Confidence = res.Confidence
+		} else if res.Confidence < out.Confidence {
+			out.Confidence = res.Confidence
+		}
+		out.End = res.End
+		out.Words = append(out.Words, res.Words...)
+		if t := strings.TrimSpace(res.Text); t != "" {
+			texts = append(texts, t)
+		}
+		finals++
+	}
+	out.Text = strings.Join(texts, " ")
+	out.Final = finals > 0
+	return out
+}
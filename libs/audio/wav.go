@@ -0,0 +1,207 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Format describes linear PCM audio.
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+// SpeechFormat is the format expected by streaming STT implementations:
+// 16 kHz, mono, 16-bit little-endian PCM.
+var SpeechFormat = Format{SampleRate: 16000, Channels: 1, BitsPerSample: 16}
+
+// BytesPerSecond returns the number of PCM bytes for one second of audio.
+func (f Format) BytesPerSecond() int {
+	return f.SampleRate * f.Channels * f.BitsPerSample / 8
+}
+
+// ErrNotWAV is returned when the input does not start with a RIFF/WAVE header.
+var ErrNotWAV = errors.New("not a wav file")
+
+// ErrUnsupportedAudio is returned by NewSpeechReader for compressed or other container
+// formats (MP3, Ogg, WebM, ...) and for WAV files it cannot convert to SpeechFormat.
+var ErrUnsupportedAudio = errors.New("unsupported audio")
+
+// EncodeWAV wraps raw PCM bytes in a canonical 44-byte WAV header.
+func EncodeWAV(pcm []byte, f Format) []byte {
+	b := make([]byte, 0, WAVHeaderSize+len(pcm))
+	b = append(b, WAVHeader(f, len(pcm))...)
+	return append(b, pcm...)
+}
+
+// WAVHeaderSize is the length of the header written by WAVHeader.
+const WAVHeaderSize = 44
+
+// WAVHeader returns the canonical 44-byte header of a WAV file holding dataLen bytes of PCM.
+// Streaming writers that do not know the length yet can pass -1, which marks it as unknown.
+func WAVHeader(f Format, dataLen int) []byte {
+	size := uint32(0xffffffff - 36)
+	if dataLen >= 0 && dataLen < int(size) {
+		size = uint32(dataLen)
+	}
+	var b bytes.Buffer
+	b.Grow(WAVHeaderSize)
+	blockAlign := f.Channels * f.BitsPerSample / 8
+	b.WriteString("RIFF")
+	_ = binary.Write(&b, binary.LittleEndian, 36+size)
+	b.WriteString("WAVE")
+	b.WriteString("fmt ")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(16))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(1)) // PCM
+	_ = binary.Write(&b, binary.LittleEndian, uint16(f.Channels))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(f.SampleRate))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(f.BytesPerSecond()))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(blockAlign))
+	_ = binary.Write(&b, binary.LittleEndian, uint16(f.BitsPerSample))
+	b.WriteString("data")
+	_ = binary.Write(&b, binary.LittleEndian, size)
+	return b.Bytes()
+}
+
+// DecodeWAV returns the PCM payload and format of an in-memory WAV file.
+func DecodeWAV(data []byte) ([]byte, Format, error) {
+	r, f, err := NewPCMReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, Format{}, err
+	}
+	pcm, err := io.ReadAll(r)
+	if err != nil {
+		return nil, Format{}, err
+	}
+	return pcm, f, nil
+}
+
+// NewPCMReader parses a WAV header from r and returns a reader positioned at the
+// start of the PCM data chunk. Streaming WAV writers often leave the data size
+// unset, so the returned reader reads until EOF rather than trusting the header.
+// If r does not contain a WAV header, ErrNotWAV is returned.
+func NewPCMReader(r io.Reader) (io.Reader, Format, error) {
+	br := bufio.NewReader(r)
+	if !isWAV(br) {
+		return nil, Format{}, ErrNotWAV
+	}
+	f, err := readWAVHeader(br)
+	if err != nil {
+		return nil, Format{}, err
+	}
+	return br, f, nil
+}
+
+// NewSpeechReader returns a reader of SpeechFormat PCM from r. WAV input is unwrapped, and
+// converted to SpeechFormat if it is 16-bit PCM at another rate or channel count. Known
+// compressed and container formats are rejected with ErrUnsupportedAudio; anything else is
+// assumed to be raw SpeechFormat PCM.
+func NewSpeechReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if !isWAV(br) {
+		if name := container(br); name != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAudio, name)
+		}
+		return br, nil
+	}
+	f, err := readWAVHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedAudio, err)
+	}
+	if f == SpeechFormat {
+		return br, nil
+	}
+	if f.BitsPerSample != 16 || f.Channels <= 0 || f.SampleRate <= 0 {
+		return nil, fmt.Errorf("%w: wav format %dHz/%dch/%dbit", ErrUnsupportedAudio, f.SampleRate, f.Channels, f.BitsPerSample)
+	}
+	pcm, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("read wav: %w", err)
+	}
+	// Convert works on whole frames
+	pcm = pcm[:len(pcm)-len(pcm)%(2*f.Channels)]
+	if pcm, err = Convert(pcm, f, SpeechFormat); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(pcm), nil
+}
+
+// container names the audio or media container br starts with, or returns "" if it does
+// not start with one it knows. Bare MPEG frames are not sniffed: their sync word is also a
+// valid first PCM sample, such as -1.
+func container(br *bufio.Reader) string {
+	hdr, _ := br.Peek(12)
+	switch {
+	case bytes.HasPrefix(hdr, []byte("RIFF")):
+		return "riff"
+	case bytes.HasPrefix(hdr, []byte("ID3")):
+		return "mp3"
+	case bytes.HasPrefix(hdr, []byte("OggS")):
+		return "ogg"
+	case bytes.HasPrefix(hdr, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(hdr, []byte("#!AMR")):
+		return "amr"
+	case bytes.HasPrefix(hdr, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "webm"
+	case len(hdr) >= 8 && string(hdr[4:8]) == "ftyp":
+		return "mp4"
+	}
+	return ""
+}
+
+func isWAV(br *bufio.Reader) bool {
+	hdr, err := br.Peek(12)
+	return err == nil && string(hdr[0:4]) == "RIFF" && string(hdr[8:12]) == "WAVE"
+}
+
+// readWAVHeader consumes the RIFF header and all chunks up to and including the
+// data chunk header.
+func readWAVHeader(br *bufio.Reader) (Format, error) {
+	if _, err := br.Discard(12); err != nil {
+		return Format{}, err
+	}
+
+	var f Format
+	for {
+		var id [4]byte
+		var size uint32
+		if _, err := io.ReadFull(br, id[:]); err != nil {
+			return Format{}, fmt.Errorf("read wav chunk id: %w", err)
+		}
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return Format{}, fmt.Errorf("read wav chunk size: %w", err)
+		}
+		switch string(id[:]) {
+		case "fmt ":
+			if size < 16 {
+				return Format{}, fmt.Errorf("wav fmt chunk too short: %d", size)
+			}
+			buf := make([]byte, size+size%2)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return Format{}, fmt.Errorf("read wav fmt chunk: %w", err)
+			}
+			if tag := binary.LittleEndian.Uint16(buf[0:2]); tag != 1 && tag != 0xFFFE {
+				return Format{}, fmt.Errorf("unsupported wav encoding %d", tag)
+			}
+			f.Channels = int(binary.LittleEndian.Uint16(buf[2:4]))
+			f.SampleRate = int(binary.LittleEndian.Uint32(buf[4:8]))
+			f.BitsPerSample = int(binary.LittleEndian.Uint16(buf[14:16]))
+		case "data":
+			if f.SampleRate == 0 {
+				return Format{}, errors.New("wav data chunk before fmt chunk")
+			}
+			return f, nil
+		default:
+			// skip LIST, fact and other metadata chunks (padded to even size)
+			if _, err := br.Discard(int(size + size%2)); err != nil {
+				return Format{}, fmt.Errorf("skip wav chunk %q: %w", string(id[:]), err)
+			}
+		}
+	}
+}
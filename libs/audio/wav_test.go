@@ -0,0 +1,54 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestNewSpeechReader(t *testing.T) {
+	speech := SamplesToBytes([]int16{1, 2, 3, 4})
+
+	// speech-format wav and raw pcm pass through, even pcm starting like an mpeg frame
+	for _, in := range [][]byte{EncodeWAV(speech, SpeechFormat), speech, SamplesToBytes([]int16{-1, 2}), SamplesToBytes([]int16{-257, 2})} {
+		r, err := NewSpeechReader(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := in
+		if bytes.HasPrefix(in, []byte("RIFF")) {
+			want = speech
+		}
+		if got, _ := io.ReadAll(r); !bytes.Equal(got, want) {
+			t.Fatalf("read %v, want %v", got, want)
+		}
+	}
+
+	// 48 kHz stereo is mixed down and resampled
+	stereo := make([]int16, 2*4800)
+	for i := range stereo {
+		stereo[i] = 1000
+	}
+	r, err := NewSpeechReader(bytes.NewReader(EncodeWAV(SamplesToBytes(stereo), Format{SampleRate: 48000, Channels: 2, BitsPerSample: 16})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcm, _ := io.ReadAll(r)
+	if got := BytesToSamples(pcm); len(got) != 1600 || got[0] != 1000 {
+		t.Fatalf("converted to %d samples starting with %v, want 1600 of 1000", len(got), got[:1])
+	}
+
+	for name, in := range map[string][]byte{
+		"mp3":      append([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), speech...),
+		"ogg":      append([]byte("OggS\x00\x02"), speech...),
+		"webm":     append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42}, speech...),
+		"8-bit":    EncodeWAV([]byte{128, 129, 130}, Format{SampleRate: 8000, Channels: 1, BitsPerSample: 8}),
+		"bad wav":  []byte("RIFF\x00\x00\x00\x00WAVEdata\x00\x00\x00\x00"),
+		"not wave": []byte("RIFF\x00\x00\x00\x00AVI LIST"),
+	} {
+		if _, err := NewSpeechReader(bytes.NewReader(in)); !errors.Is(err, ErrUnsupportedAudio) {
+			t.Fatalf("%s: error %v, want ErrUnsupportedAudio", name, err)
+		}
+	}
+}
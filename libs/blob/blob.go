@@ -0,0 +1,80 @@
+// Package blob stores audio files and other artifacts as blobs addressed by key, on the
+// local filesystem or in an S3-compatible object store.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for a key without a blob.
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a stored blob.
+type Info struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Object is an open blob. It can seek, so it can be served with HTTP range support.
+type Object interface {
+	io.ReadSeekCloser
+	Info() Info
+}
+
+// LifecycleRule expires the blobs under Prefix once they are older than ExpireAfter.
+type LifecycleRule struct {
+	Prefix      string
+	ExpireAfter time.Duration
+}
+
+// Store keeps blobs. Keys are slash-separated paths such as "recordings/<call>/<file>.wav".
+type Store interface {
+	// Put stores the content of r under key, replacing any blob there. contentType may be
+	// empty.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open opens the blob under key.
+	Open(ctx context.Context, key string) (Object, error)
+	// Stat describes the blob under key.
+	Stat(ctx context.Context, key string) (Info, error)
+	// Delete removes the blob under key; deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL that downloads the blob without further credentials until
+	// expiry has passed.
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// SetLifecycle replaces the rules that expire old blobs.
+	SetLifecycle(ctx context.Context, rules []LifecycleRule) error
+}
+
+// Expirer is implemented by stores that enforce their lifecycle rules themselves rather
+// than leaving it to the storage service; Expire must be called periodically.
+type Expirer interface {
+	// Expire deletes the blobs that have outlived their lifecycle rule and returns how
+	// many it deleted.
+	Expire(ctx context.Context) (int, error)
+}
+
+// Key-space prefixes of the server's blobs.
+const (
+	PrefixRecordings = "recordings/"
+	PrefixTTS        = "tts/"
+)
+
+// checkKey rejects keys that are empty, absolute or climb out of the store.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	l, err := NewLocal(t.TempDir(), srv.URL+"/blobs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/blobs/", http.StripPrefix("/blobs/", l.Handler()))
+	testStore(t, l)
+}
+
+// testStore exercises a store that starts empty.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	key := "recordings/call 1/agent+1.wav"
+	data := []byte("RIFF....WAVEfmt 0123456789")
+
+	if _, err := s.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat before Put error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Open before Put error = %v, want ErrNotFound", err)
+	}
+	// a reader that cannot seek, then one that can replacing it
+	if err := s.Put(ctx, key, io.MultiReader(bytes.NewReader([]byte("old"))), "audio/wav"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put(ctx, key, bytes.NewReader(data), "audio/wav"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != int64(len(data)) || info.ContentType != "audio/wav" || info.ModTime.IsZero() {
+		t.Fatalf("info = %+v", info)
+	}
+
+	obj, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(obj, head); err != nil || string(head) != "RIFF" {
+		t.Fatalf("read head = %q, %v", head, err)
+	}
+	if _, err := obj.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if tail, err := io.ReadAll(obj); err != nil || string(tail) != "0123456789" {
+		t.Fatalf("read tail = %q, %v", tail, err)
+	}
+	if err := obj.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	signed, err := s.SignedURL(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	if got := fetch(t, signed, http.StatusOK); !bytes.Equal(got, data) {
+		t.Fatalf("signed download = %q", got)
+	}
+	fetch(t, strings.Replace(signed, "recordings", "recording", 1), http.StatusForbidden)
+
+	if err := s.Put(ctx, "tts/empty.wav", bytes.NewReader(nil), "audio/wav"); err != nil {
+		t.Fatalf("Put empty: %v", err)
+	}
+	if info, err := s.Stat(ctx, "tts/empty.wav"); err != nil || info.Size != 0 {
+		t.Fatalf("Stat empty = %+v, %v", info, err)
+	}
+
+	for _, bad := range []string{"", "/abs", "a/../b", "dir/"} {
+		if err := s.Put(ctx, bad, bytes.NewReader(data), ""); err == nil {
+			t.Errorf("Put(%q): expected error", bad)
+		}
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete twice: %v", err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat after Delete error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "tts/empty.wav"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+}
+
+func fetch(t *testing.T, url string, status int) []byte {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("GET %s: status %d, want %d: %s", url, resp.StatusCode, status, body)
+	}
+	return body
+}
+
+func TestLocal_SignedURLExpires(t *testing.T) {
+	l, err := NewLocal(t.TempDir(), "http://blobs.test/", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := l.Put(ctx, "tts/a.wav", strings.NewReader("a"), ""); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := l.SignedURL(ctx, "tts/a.wav", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, signed, nil)
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/")
+		l.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("status %d before expiry", code)
+	}
+	l.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if code := get(); code != http.StatusForbidden {
+		t.Fatalf("status %d after expiry", code)
+	}
+}
+
+func TestLocal_ExpiresByLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"tts/old.wav", "tts/new.wav", "recordings/c1/old.wav"} {
+		if err := l.Put(ctx, key, strings.NewReader(key), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{"tts/old.wav", "recordings/c1/old.wav"} {
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.SetLifecycle(ctx, []LifecycleRule{{Prefix: PrefixTTS, ExpireAfter: 24 * time.Hour}, {Prefix: PrefixRecordings}}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := l.Expire(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("Expire = %d, %v; want 1 blob", n, err)
+	}
+	if _, err := l.Stat(ctx, "tts/old.wav"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired blob: %v", err)
+	}
+	for _, key := range []string{"tts/new.wav", "recordings/c1/old.wav"} {
+		if _, err := l.Stat(ctx, key); err != nil {
+			t.Fatalf("kept blob %s: %v", key, err)
+		}
+	}
+
+	// a rule of 1h now expires the recording, and its emptied directory goes with it
+	if err := l.SetLifecycle(ctx, []LifecycleRule{{Prefix: PrefixRecordings, ExpireAfter: time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := l.Expire(ctx); err != nil || n != 1 {
+		t.Fatalf("Expire = %d, %v; want 1 blob", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "recordings")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("recordings dir left behind: %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tmpPrefix marks files being written by Put; they are not blobs.
+const tmpPrefix = ".tmp-"
+
+// Local stores blobs as files under a directory. Signed URLs point at its Handler, which
+// the server mounts at baseURL.
+type Local struct {
+	dir     string
+	baseURL string
+	secret  []byte
+	now     func() time.Time
+
+	mu    sync.Mutex
+	rules []LifecycleRule
+}
+
+var (
+	_ Store   = (*Local)(nil)
+	_ Expirer = (*Local)(nil)
+)
+
+// NewLocal stores blobs under dir. baseURL is the URL Handler is served at, e.g.
+// http://localhost:8080/blobs/; secret signs URLs. Without a secret a random one is used,
+// so URLs stop working when the process restarts.
+func NewLocal(dir, baseURL string, secret []byte) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create blob dir: %w", err)
+	}
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	if baseURL != "" && !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &Local{dir: dir, baseURL: baseURL, secret: secret, now: time.Now}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file that replaces the old one when complete, so
+// readers never see a partial blob. The content type is derived from the key's extension.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), tmpPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, ctxReader{ctx, r})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	return nil
+}
+
+// Open opens the blob's file.
+func (l *Local) Open(ctx context.Context, key string) (Object, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, l.notFound(key, err)
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		f.Close()
+		return nil, l.notFound(key, err)
+	}
+	return localObject{f, l.info(key, fi)}, nil
+}
+
+// Stat describes the blob's file.
+func (l *Local) Stat(ctx context.Context, key string) (Info, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return Info{}, l.notFound(key, err)
+	}
+	return l.info(key, fi), nil
+}
+
+// Delete removes the blob's file and the directories it leaves empty.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	l.pruneDirs(filepath.Dir(name))
+	return nil
+}
+
+// SignedURL returns a URL of Handler that serves the blob until expiry has passed.
+func (l *Local) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	if l.baseURL == "" {
+		return "", errors.New("signed urls need a blob base url")
+	}
+	expires := strconv.FormatInt(l.now().Add(expiry).Unix(), 10)
+	return l.baseURL + uriEncode(key, false) + "?expires=" + expires + "&signature=" + l.sign(key, expires), nil
+}
+
+func (l *Local) sign(key, expires string) string {
+	return hex.EncodeToString(hmacSHA256(l.secret, key+"\n"+expires))
+}
+
+// Handler serves the blobs of signed URLs. It expects the request path to be the key, so
+// it is mounted with http.StripPrefix.
+func (l *Local) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := r.URL.Path
+		expires := r.URL.Query().Get("expires")
+		until, err := strconv.ParseInt(expires, 10, 64)
+		sig, _ := hex.DecodeString(r.URL.Query().Get("signature"))
+		want, _ := hex.DecodeString(l.sign(key, expires))
+		if err != nil || !hmac.Equal(sig, want) || l.now().Unix() > until {
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+		obj, err := l.Open(r.Context(), key)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer obj.Close()
+		info := obj.Info()
+		w.Header().Set("Content-Type", info.ContentType)
+		http.ServeContent(w, r, "", info.ModTime, obj)
+	})
+}
+
+// SetLifecycle replaces the rules Expire enforces.
+func (l *Local) SetLifecycle(ctx context.Context, rules []LifecycleRule) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = append([]LifecycleRule(nil), rules...)
+	return nil
+}
+
+// Expire deletes the blobs last written longer ago than their rule allows.
+func (l *Local) Expire(ctx context.Context) (int, error) {
+	l.mu.Lock()
+	rules := l.rules
+	l.mu.Unlock()
+
+	now := l.now()
+	var removed int
+	err := filepath.WalkDir(l.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tmpPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		for _, rule := range rules {
+			if rule.ExpireAfter <= 0 || !strings.HasPrefix(key, rule.Prefix) {
+				continue
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if now.Sub(fi.ModTime()) > rule.ExpireAfter {
+				if err := l.Delete(ctx, key); err != nil {
+					return err
+				}
+				removed++
+			}
+			break
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return removed, err
+}
+
+// pruneDirs removes dir and its parents up to the store's directory while they are empty.
+func (l *Local) pruneDirs(dir string) {
+	root := filepath.Clean(l.dir)
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (l *Local) info(key string, fi fs.FileInfo) Info {
+	return Info{Key: key, Size: fi.Size(), ContentType: contentTypeOf(key), ModTime: fi.ModTime()}
+}
+
+func (l *Local) notFound(key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return err
+}
+
+// contentTypeOf guesses a blob's content type from the extension of its key.
+func contentTypeOf(key string) string {
+	switch ext := path.Ext(key); ext {
+	case ".wav":
+		return "audio/wav"
+	case ".ogg":
+		return "audio/ogg"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+		return "application/octet-stream"
+	}
+}
+
+type localObject struct {
+	*os.File
+	info Info
+}
+
+func (o localObject) Info() Info { return o.info }
+
+// ctxReader stops reading once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
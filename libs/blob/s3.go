@@ -0,0 +1,337 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config locates a bucket of an S3-compatible service such as AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the service URL, e.g. https://s3.eu-west-1.amazonaws.com or
+	// http://localhost:9000.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the URL path, as MinIO expects, rather than as a
+	// subdomain of the endpoint.
+	PathStyle bool
+}
+
+// S3 stores blobs as objects of an S3 bucket. Its lifecycle rules are enforced by the service.
+type S3 struct {
+	endpoint *url.URL
+	bucket   string
+	path     bool
+	signer   signer
+	client   *http.Client
+	now      func() time.Time
+}
+
+var _ Store = (*S3)(nil)
+
+// NewS3 returns a store on the bucket of cfg. It does not contact the service.
+func NewS3(cfg S3Config) (*S3, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket required")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		endpoint: u,
+		bucket:   cfg.Bucket,
+		path:     cfg.PathStyle,
+		signer:   signer{accessKey: cfg.AccessKeyID, secretKey: cfg.SecretAccessKey, region: region, service: "s3"},
+		client:   http.DefaultClient,
+		now:      time.Now,
+	}, nil
+}
+
+// url returns the URL of the object under key, or of the bucket if key is empty.
+func (s *S3) url(key string) *url.URL {
+	u := *s.endpoint
+	u.RawQuery = ""
+	p := strings.TrimSuffix(u.Path, "/")
+	if s.path {
+		p += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	p += "/" + key
+	u.Path, u.RawPath = p, uriEncode(p, false)
+	return &u
+}
+
+func (s *S3) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		// without a length the body would be sent chunked, which the service refuses
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	s.signer.sign(req, payloadHash, s.now())
+	return s.client.Do(req)
+}
+
+// Put uploads the blob. Readers that can seek are streamed; others are read into memory
+// first, since the service needs the length up front.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	size, err := remaining(r)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("put %s: %w", key, err)
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.url(key), io.NopCloser(r), size, unsignedPayload, header)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "put", key)
+	}
+	return nil
+}
+
+// remaining returns the bytes left in r if it can seek, else -1.
+func remaining(r io.Reader) (int64, error) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return -1, nil
+	}
+	cur, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1, nil
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := seeker.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - cur, nil
+}
+
+// Open returns the object; reads fetch it with ranged requests from the current offset.
+func (s *S3) Open(ctx context.Context, key string) (Object, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{s: s, ctx: ctx, info: info}, nil
+}
+
+// Stat describes the object.
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	if err := checkKey(key); err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.url(key), nil, 0, emptyPayload, nil)
+	if err != nil {
+		return Info{}, fmt.Errorf("stat %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, responseError(resp, "stat", key)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Key: key, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type"), ModTime: modTime}, nil
+}
+
+// Delete removes the object.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.url(key), nil, 0, emptyPayload, nil)
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp, "delete", key)
+	}
+	return nil
+}
+
+// SignedURL returns a presigned GET URL of the object. The service caps expiry at 7 days.
+func (s *S3) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return s.signer.presign(http.MethodGet, s.url(key), s.now(), expiry).String(), nil
+}
+
+type lifecycleConfig struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []lifecycleRule `xml:"Rule"`
+}
+
+type lifecycleRule struct {
+	ID     string `xml:"ID"`
+	Prefix string `xml:"Filter>Prefix"`
+	Status string `xml:"Status"`
+	Days   int    `xml:"Expiration>Days"`
+}
+
+// SetLifecycle replaces the bucket's lifecycle configuration. The service expires objects
+// in whole days, so ExpireAfter is rounded up to days.
+func (s *S3) SetLifecycle(ctx context.Context, rules []LifecycleRule) error {
+	u := s.url("")
+	u.RawQuery = "lifecycle="
+	var cfg lifecycleConfig
+	for i, r := range rules {
+		if r.ExpireAfter <= 0 {
+			continue
+		}
+		days := int((r.ExpireAfter + 24*time.Hour - 1) / (24 * time.Hour))
+		cfg.Rules = append(cfg.Rules, lifecycleRule{ID: "expire-" + strconv.Itoa(i), Prefix: r.Prefix, Status: "Enabled", Days: days})
+	}
+
+	var resp *http.Response
+	var err error
+	if len(cfg.Rules) == 0 {
+		resp, err = s.do(ctx, http.MethodDelete, u, nil, 0, emptyPayload, nil)
+	} else {
+		body, merr := xml.Marshal(cfg)
+		if merr != nil {
+			return merr
+		}
+		sum := sha256.Sum256(body)
+		md := md5.Sum(body)
+		header := http.Header{}
+		header.Set("Content-Type", "application/xml")
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md[:]))
+		resp, err = s.do(ctx, http.MethodPut, u, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(sum[:]), header)
+	}
+	if err != nil {
+		return fmt.Errorf("set lifecycle: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return responseError(resp, "set lifecycle of", s.bucket)
+	}
+	return nil
+}
+
+// responseError turns an error response into an error; missing objects are ErrNotFound.
+func responseError(resp *http.Response, op, key string) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = xml.Unmarshal(body, &e)
+	if resp.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "NoSuchKey") {
+		return fmt.Errorf("%s %s: %w", op, key, ErrNotFound)
+	}
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	if e.Message != "" {
+		return fmt.Errorf("%s %s: s3 %s: %s", op, key, e.Code, e.Message)
+	}
+	return fmt.Errorf("%s %s: s3 %s", op, key, e.Code)
+}
+
+// s3Object reads an object from its offset with a ranged GET, reopened after each seek.
+type s3Object struct {
+	s    *S3
+	ctx  context.Context
+	info Info
+	off  int64
+	body io.ReadCloser
+}
+
+func (o *s3Object) Info() Info { return o.info }
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.off >= o.info.Size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		header := http.Header{}
+		header.Set("Range", "bytes="+strconv.FormatInt(o.off, 10)+"-")
+		resp, err := o.s.do(o.ctx, http.MethodGet, o.s.url(o.info.Key), nil, 0, emptyPayload, header)
+		if err != nil {
+			return 0, fmt.Errorf("get %s: %w", o.info.Key, err)
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.off == 0) {
+			defer resp.Body.Close()
+			return 0, responseError(resp, "get", o.info.Key)
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.info.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of blob")
+	}
+	if offset != o.off && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.off = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
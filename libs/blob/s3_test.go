@@ -0,0 +1,208 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a stand-in for an S3-compatible service such as MinIO: it serves path-style
+// buckets from memory and checks every request's Signature Version 4.
+type fakeS3 struct {
+	bucket string
+	signer signer
+
+	mu        sync.Mutex
+	objects   map[string]fakeObject
+	lifecycle []byte
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+func newFakeS3(bucket string, s signer) *fakeS3 {
+	return &fakeS3{bucket: bucket, signer: s, objects: make(map[string]fakeObject)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(r) {
+		f.error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if _, ok := r.URL.Query()["lifecycle"]; ok {
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			sum := md5.Sum(body)
+			if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+				f.error(w, http.StatusBadRequest, "InvalidDigest")
+				return
+			}
+			f.lifecycle = body
+		case http.MethodDelete:
+			f.lifecycle = nil
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 || len(r.TransferEncoding) > 0 {
+			f.error(w, http.StatusLengthRequired, "MissingContentLength")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[path] = fakeObject{data: body, contentType: r.Header.Get("Content-Type"), modTime: time.Now()}
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[path]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		http.ServeContent(w, r, "", obj.modTime, bytes.NewReader(obj.data))
+	case http.MethodDelete:
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code><Message>fake</Message></Error>")
+}
+
+// authorized checks the Authorization header, or the query of a presigned URL.
+func (f *fakeS3) authorized(r *http.Request) bool {
+	u := &url.URL{Path: r.URL.Path, RawPath: uriEncode(r.URL.Path, false)}
+	q := r.URL.Query()
+	if sig := q.Get("X-Amz-Signature"); sig != "" {
+		t, err := time.Parse(amzDateFormat, q.Get("X-Amz-Date"))
+		expires, _ := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || time.Since(t) > time.Duration(expires)*time.Second {
+			return false
+		}
+		q.Del("X-Amz-Signature")
+		u.RawQuery = q.Encode()
+		return sig == f.signer.signature(t, canonicalRequest(r.Method, u, r.Host, nil, []string{"host"}, unsignedPayload))
+	}
+
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), sigAlgorithm+" ")
+	fields := map[string]string{}
+	for _, field := range strings.Split(auth, ", ") {
+		k, v, _ := strings.Cut(field, "=")
+		fields[k] = v
+	}
+	t, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil || fields["Credential"] != f.signer.accessKey+"/"+f.signer.scope(t) {
+		return false
+	}
+	u.RawQuery = r.URL.RawQuery
+	headers := strings.Split(fields["SignedHeaders"], ";")
+	canonical := canonicalRequest(r.Method, u, r.Host, r.Header, headers, r.Header.Get("X-Amz-Content-Sha256"))
+	return fields["Signature"] == f.signer.signature(t, canonical)
+}
+
+func newTestS3(t *testing.T) (*S3, *fakeS3) {
+	cfg := S3Config{Region: "eu-west-1", Bucket: "calls", AccessKeyID: "minio", SecretAccessKey: "minio-secret", PathStyle: true}
+	fake := newFakeS3(cfg.Bucket, signer{accessKey: cfg.AccessKeyID, secretKey: cfg.SecretAccessKey, region: cfg.Region, service: "s3"})
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	cfg.Endpoint = srv.URL
+	s, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fake
+}
+
+func TestS3(t *testing.T) {
+	s, _ := newTestS3(t)
+	testStore(t, s)
+}
+
+func TestS3_WrongCredentials(t *testing.T) {
+	s, _ := newTestS3(t)
+	s.signer.secretKey = "wrong"
+	err := s.Put(context.Background(), "tts/a.wav", strings.NewReader("a"), "")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with wrong secret error = %v", err)
+	}
+}
+
+func TestS3_SetLifecycle(t *testing.T) {
+	s, fake := newTestS3(t)
+	ctx := context.Background()
+	rules := []LifecycleRule{{Prefix: PrefixRecordings, ExpireAfter: 90 * 24 * time.Hour}, {Prefix: PrefixTTS, ExpireAfter: time.Hour}, {Prefix: "kept/"}}
+	if err := s.SetLifecycle(ctx, rules); err != nil {
+		t.Fatalf("SetLifecycle: %v", err)
+	}
+	want := `<LifecycleConfiguration>` +
+		`<Rule><ID>expire-0</ID><Filter><Prefix>recordings/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>90</Days></Expiration></Rule>` +
+		`<Rule><ID>expire-1</ID><Filter><Prefix>tts/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule>` +
+		`</LifecycleConfiguration>`
+	if got := string(fake.lifecycle); got != want {
+		t.Fatalf("lifecycle = %s\nwant %s", got, want)
+	}
+	if err := s.SetLifecycle(ctx, nil); err != nil || fake.lifecycle != nil {
+		t.Fatalf("clearing lifecycle: %v, %s", err, fake.lifecycle)
+	}
+}
+
+func TestS3_VirtualHostURLs(t *testing.T) {
+	s, err := NewS3(S3Config{Endpoint: "https://s3.eu-west-1.amazonaws.com", Bucket: "calls"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.url("recordings/a b.wav").String(), "https://calls.s3.eu-west-1.amazonaws.com/recordings/a%20b.wav"; got != want {
+		t.Fatalf("url = %s, want %s", got, want)
+	}
+	if _, err := NewS3(S3Config{Endpoint: "s3.amazonaws.com", Bucket: "calls"}); err == nil {
+		t.Fatal("endpoint without scheme: expected error")
+	}
+}
+
+// TestS3_Service runs against a real bucket, e.g. of a local MinIO, configured by
+// BLOB_TEST_S3_ENDPOINT, BLOB_TEST_S3_BUCKET, BLOB_TEST_S3_ACCESS_KEY_ID and
+// BLOB_TEST_S3_SECRET_ACCESS_KEY. The bucket should be a scratch one.
+func TestS3_Service(t *testing.T) {
+	endpoint := os.Getenv("BLOB_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("BLOB_TEST_S3_ENDPOINT not set")
+	}
+	s, err := NewS3(S3Config{
+		Endpoint:        endpoint,
+		Region:          os.Getenv("BLOB_TEST_S3_REGION"),
+		Bucket:          os.Getenv("BLOB_TEST_S3_BUCKET"),
+		AccessKeyID:     os.Getenv("BLOB_TEST_S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("BLOB_TEST_S3_SECRET_ACCESS_KEY"),
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
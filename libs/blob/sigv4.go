@@ -0,0 +1,146 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigAlgorithm    = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// emptyPayload is the SHA-256 of no bytes.
+	emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// signer signs requests with AWS Signature Version 4.
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+}
+
+// sign sets the X-Amz-Date and Authorization headers of req. It signs the host, Content-Type,
+// Content-MD5 and X-Amz-* headers; payloadHash is the hex SHA-256 of the body or
+// unsignedPayload.
+func (s signer) sign(req *http.Request, payloadHash string, t time.Time) {
+	req.Header.Set("X-Amz-Date", t.UTC().Format(amzDateFormat))
+	headers := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "content-md5" {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+	sig := s.signature(t, canonicalRequest(req.Method, req.URL, requestHost(req), req.Header, headers, payloadHash))
+	req.Header.Set("Authorization", sigAlgorithm+" Credential="+s.accessKey+"/"+s.scope(t)+
+		", SignedHeaders="+strings.Join(headers, ";")+", Signature="+sig)
+}
+
+// presign returns u with a query that authorizes method on it until expires has passed.
+func (s signer) presign(method string, u *url.URL, t time.Time, expires time.Duration) *url.URL {
+	signed := *u
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", sigAlgorithm)
+	q.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(t))
+	q.Set("X-Amz-Date", t.UTC().Format(amzDateFormat))
+	q.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	q.Set("X-Amz-SignedHeaders", "host")
+	signed.RawQuery = canonicalQuery(q)
+	sig := s.signature(t, canonicalRequest(method, &signed, u.Host, nil, []string{"host"}, unsignedPayload))
+	signed.RawQuery += "&X-Amz-Signature=" + sig
+	return &signed
+}
+
+func (s signer) scope(t time.Time) string {
+	return t.UTC().Format("20060102") + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+func (s signer) signature(t time.Time, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := sigAlgorithm + "\n" + t.UTC().Format(amzDateFormat) + "\n" + s.scope(t) + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.UTC().Format("20060102"))
+	for _, part := range []string{s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// canonicalRequest is the request in the form that is signed; headers are the lower-case
+// names of the signed headers in order.
+func canonicalRequest(method string, u *url.URL, host string, h http.Header, headers []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(method + "\n")
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path + "\n")
+	b.WriteString(canonicalQuery(u.Query()) + "\n")
+	for _, name := range headers {
+		value := host
+		if name != "host" {
+			value = strings.Join(h.Values(name), ",")
+		}
+		b.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	b.WriteString("\n" + strings.Join(headers, ";") + "\n" + payloadHash)
+	return b.String()
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters and, unless encodeSlash,
+// slashes.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
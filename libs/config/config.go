@@ -15,16 +15,77 @@ type Config struct {
 	STTVendor    string `json:"stt_vendor"`
 	LLMVendor    string `json:"llm_vendor"`
 	WebRTCVendor string `json:"webrtc_vendor"`
+	VADVendor    string `json:"vad_vendor"`
 
 	// Generic map for vendor-specific settings
 	VendorSettings map[string]map[string]string `json:"vendor_settings"`
+
+	// SystemPrompt is the system message that opens every AI agent conversation.
+	SystemPrompt string `json:"system_prompt"`
+	// AgentDispatch says where AI agents run: "inprocess" in the server or "workers" on the
+	// agent workers connected to it.
+	AgentDispatch string `json:"agent_dispatch"`
+
+	// DatabaseDriver selects the store backend: "sqlite" or "postgres".
+	DatabaseDriver string `json:"database_driver"`
+	// DatabaseURL is the SQLite file path or the Postgres connection string.
+	DatabaseURL string `json:"database_url"`
 }
 
 // LoadFromEnv constructs a Config reading from environment variables.
 // Supported env vars:
 //
-//	TTS_VENDOR, STT_VENDOR, LLM_VENDOR, WEBRTC_VENDOR
+//	TTS_VENDOR, STT_VENDOR, LLM_VENDOR, WEBRTC_VENDOR, VAD_VENDOR
+//	DATABASE_DRIVER - store backend, sqlite (default) or postgres
+//	DATABASE_URL - SQLite file path or Postgres connection string; DATABASE_PATH is
+//	  accepted for SQLite (default data/ai.callcenter.db)
 //	WHISPER_ENDPOINT - optional override for whisper STT endpoint (e.g. http://localhost:7070/inference)
+//	WHISPER_STREAM_ENDPOINT - optional websocket endpoint for streaming STT (e.g. ws://localhost:7070/stream)
+//	LIVEKIT_TOKEN_TTL_SECONDS - optional validity of issued LiveKit access tokens (default 3600)
+//	AGENT_SYSTEM_PROMPT - optional system prompt for AI agent conversations
+//	AGENT_DISPATCH - where AI agents run: inprocess (default) in the server, or workers on the
+//	  agent workers connected to it, which authenticate with AGENT_TOKEN_ENDPOINT_SECRET
+//	  (required for workers)
+//	VAD_THRESHOLD_DB - optional speech level threshold of the energy VAD in dBFS (e.g. -40)
+//	VAD_MIN_SPEECH_MS, VAD_HANGOVER_MS, VAD_SILENCE_MS, VAD_PREROLL_MS, VAD_MAX_UTTERANCE_MS -
+//	  optional end-of-utterance detection settings in milliseconds
+//	RECORDING_MODE - call recording: off (default), mixed or stereo (caller left, agent right)
+//	RECORDING_FORMAT - recording file format, wav (default) or opus (Ogg/Opus)
+//	BLOB_BACKEND - storage of recordings and TTS audio: local (default) or s3
+//	BLOB_DIR - directory of the local backend (default data/blobs)
+//	BLOB_BASE_URL - public URL of the server's /blobs/ endpoint that serves signed URLs of the
+//	  local backend (default http://localhost:8080/blobs/)
+//	BLOB_URL_SECRET - key that signs URLs of the local backend (default: random per process)
+//	BLOB_TTS_RETENTION_DAYS - age at which stored TTS audio is deleted (default 7)
+//	S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY - bucket of the
+//	  s3 backend, e.g. http://localhost:9000 for MinIO
+//	S3_PATH_STYLE - true to address the bucket in the URL path, as MinIO expects
+//	RETENTION_METADATA_DAYS, RETENTION_TRANSCRIPTS_DAYS, RETENTION_RECORDINGS_DAYS,
+//	  RETENTION_TOKENS_DAYS - days after a call ends that its calls and sessions, turns,
+//	  recordings and LiveKit tokens are kept; unset keeps them, except tokens (default 1)
+//	RETENTION_METADATA_ACTION - anonymize (default) or delete expired calls and sessions
+//	RETENTION_INTERVAL_MINUTES - time between purges of expired data (default 60)
+//	AGENT_MAX_CONCURRENT - AI agents running at once; unset or 0 does not cap them
+//	<VENDOR>_MAX_CONCURRENT - AI agents using the selected STT, LLM or TTS vendor at once,
+//	  e.g. WHISPER_MAX_CONCURRENT, OLLAMA_MAX_CONCURRENT, PIPER_MAX_CONCURRENT
+//	AGENT_QUEUE_SIZE - calls that may wait for an agent beyond the limits (default 10)
+//	AGENT_QUEUE_MAX_WAIT_SECONDS - wait after which a call overflows; 0 waits forever (default 120)
+//	AGENT_QUEUE_ANNOUNCE_SECONDS - time between announcements of the position in the queue
+//	  (default 30)
+//	AGENT_QUEUE_MESSAGE - announcement of the position, which replaces {position}
+//	AGENT_BUSY_MESSAGE - what callers that are turned away hear
+//	AGENT_OVERFLOW - what happens to calls the queue cannot take or that waited too long: busy
+//	  (default) says AGENT_BUSY_MESSAGE and ends the call, admit gives them an agent anyway
+//	ESCALATION_QUEUE - human queue AI agents hand calls over to; unset never escalates;
+//	  must be unset with AGENT_DISPATCH=workers, whose agents do not escalate
+//	ESCALATION_KEYWORDS - comma separated phrases of the caller that escalate, e.g.
+//	  "real person,operator" (default: common requests for a person)
+//	ESCALATION_INTENT - true to let the LLM escalate calls it cannot handle
+//	ESCALATION_LOW_CONFIDENCE_STREAK - unintelligible utterances in a row that escalate;
+//	  unset or 0 never escalates on them
+//	ESCALATION_HOLD_AUDIO - WAV file played to callers waiting for a human, instead of
+//	  ESCALATION_HOLD_MESSAGE, every ESCALATION_HOLD_REPEAT_SECONDS (default 20)
+//	ESCALATION_RESUME_MESSAGE - what callers hear when no human took the call in time
 //
 // Additional vendor-specific variables may be added in the future.
 func LoadFromEnv() *Config {
@@ -33,13 +94,24 @@ func LoadFromEnv() *Config {
 		STTVendor:      getEnv("STT_VENDOR", "whisper"),
 		LLMVendor:      getEnv("LLM_VENDOR", "ollama"),
 		WebRTCVendor:   getEnv("WEBRTC_VENDOR", "livekit"),
+		VADVendor:      getEnv("VAD_VENDOR", "energy"),
 		VendorSettings: make(map[string]map[string]string),
+		SystemPrompt:   getEnv("AGENT_SYSTEM_PROMPT", ""),
+		AgentDispatch:  getEnv("AGENT_DISPATCH", "inprocess"),
+		DatabaseDriver: getEnv("DATABASE_DRIVER", "sqlite"),
+		DatabaseURL:    getEnv("DATABASE_URL", getEnv("DATABASE_PATH", "")),
 	}
 
 	// Whisper endpoint override
 	if ep := getEnv("WHISPER_ENDPOINT", ""); ep != "" {
 		cfg.VendorSettings["whisper"] = map[string]string{"endpoint": ep}
 	}
+	if ep := getEnv("WHISPER_STREAM_ENDPOINT", ""); ep != "" {
+		if _, ok := cfg.VendorSettings["whisper"]; !ok {
+			cfg.VendorSettings["whisper"] = make(map[string]string)
+		}
+		cfg.VendorSettings["whisper"]["stream_endpoint"] = ep
+	}
 
 	// Ollama optional overrides
 	if ep := getEnv("OLLAMA_ENDPOINT", ""); ep != "" {
@@ -89,6 +161,127 @@ func LoadFromEnv() *Config {
 		}
 		cfg.VendorSettings["livekit"]["api_secret"] = s
 	}
+	if ttl := getEnv("LIVEKIT_TOKEN_TTL_SECONDS", ""); ttl != "" {
+		if _, ok := cfg.VendorSettings["livekit"]; !ok {
+			cfg.VendorSettings["livekit"] = make(map[string]string)
+		}
+		cfg.VendorSettings["livekit"]["token_ttl_seconds"] = ttl
+	}
+
+	// Voice activity detection settings
+	for env, key := range map[string]string{
+		"VAD_THRESHOLD_DB":     "threshold_db",
+		"VAD_MIN_SPEECH_MS":    "min_speech_ms",
+		"VAD_HANGOVER_MS":      "hangover_ms",
+		"VAD_SILENCE_MS":       "silence_ms",
+		"VAD_PREROLL_MS":       "preroll_ms",
+		"VAD_MAX_UTTERANCE_MS": "max_utterance_ms",
+	} {
+		if v := getEnv(env, ""); v != "" {
+			if _, ok := cfg.VendorSettings["vad"]; !ok {
+				cfg.VendorSettings["vad"] = make(map[string]string)
+			}
+			cfg.VendorSettings["vad"][key] = v
+		}
+	}
+
+	// Call recording settings
+	for env, key := range map[string]string{
+		"RECORDING_MODE":   "mode",
+		"RECORDING_FORMAT": "format",
+	} {
+		if v := getEnv(env, ""); v != "" {
+			if _, ok := cfg.VendorSettings["recording"]; !ok {
+				cfg.VendorSettings["recording"] = make(map[string]string)
+			}
+			cfg.VendorSettings["recording"][key] = v
+		}
+	}
+
+	// Blob storage settings
+	for env, key := range map[string]string{
+		"BLOB_BACKEND":            "backend",
+		"BLOB_DIR":                "dir",
+		"BLOB_BASE_URL":           "base_url",
+		"BLOB_URL_SECRET":         "url_secret",
+		"BLOB_TTS_RETENTION_DAYS": "tts_retention_days",
+		"S3_ENDPOINT":             "s3_endpoint",
+		"S3_REGION":               "s3_region",
+		"S3_BUCKET":               "s3_bucket",
+		"S3_ACCESS_KEY_ID":        "s3_access_key_id",
+		"S3_SECRET_ACCESS_KEY":    "s3_secret_access_key",
+		"S3_PATH_STYLE":           "s3_path_style",
+	} {
+		if v := getEnv(env, ""); v != "" {
+			if _, ok := cfg.VendorSettings["blob"]; !ok {
+				cfg.VendorSettings["blob"] = make(map[string]string)
+			}
+			cfg.VendorSettings["blob"][key] = v
+		}
+	}
+
+	// Data retention settings
+	for env, key := range map[string]string{
+		"RETENTION_METADATA_DAYS":    "metadata_days",
+		"RETENTION_METADATA_ACTION":  "metadata_action",
+		"RETENTION_TRANSCRIPTS_DAYS": "transcripts_days",
+		"RETENTION_RECORDINGS_DAYS":  "recordings_days",
+		"RETENTION_TOKENS_DAYS":      "tokens_days",
+		"RETENTION_INTERVAL_MINUTES": "interval_minutes",
+	} {
+		if v := getEnv(env, ""); v != "" {
+			if _, ok := cfg.VendorSettings["retention"]; !ok {
+				cfg.VendorSettings["retention"] = make(map[string]string)
+			}
+			cfg.VendorSettings["retention"][key] = v
+		}
+	}
+
+	// AI agent limits and queue
+	for env, key := range map[string]string{
+		"AGENT_MAX_CONCURRENT":         "max_concurrent",
+		"AGENT_QUEUE_SIZE":             "queue_size",
+		"AGENT_QUEUE_MAX_WAIT_SECONDS": "queue_max_wait_seconds",
+		"AGENT_QUEUE_ANNOUNCE_SECONDS": "queue_announce_seconds",
+		"AGENT_QUEUE_MESSAGE":          "queue_message",
+		"AGENT_BUSY_MESSAGE":           "busy_message",
+		"AGENT_OVERFLOW":               "overflow",
+	} {
+		if v := getEnv(env, ""); v != "" {
+			if _, ok := cfg.VendorSettings["agents"]; !ok {
+				cfg.VendorSettings["agents"] = make(map[string]string)
+			}
+			cfg.VendorSettings["agents"][key] = v
+		}
+	}
+	for _, vendor := range []string{cfg.STTVendor, cfg.LLMVendor, cfg.TTSVendor} {
+		env := strings.ToUpper(strings.ReplaceAll(vendor, "-", "_")) + "_MAX_CONCURRENT"
+		if v := getEnv(env, ""); v != "" {
+			if _, ok := cfg.VendorSettings[vendor]; !ok {
+				cfg.VendorSettings[vendor] = make(map[string]string)
+			}
+			cfg.VendorSettings[vendor]["max_concurrent"] = v
+		}
+	}
+
+	// escalation from AI agents to human agents
+	for env, key := range map[string]string{
+		"ESCALATION_QUEUE":                 "queue",
+		"ESCALATION_KEYWORDS":              "keywords",
+		"ESCALATION_INTENT":                "intent",
+		"ESCALATION_LOW_CONFIDENCE_STREAK": "low_confidence_streak",
+		"ESCALATION_HOLD_AUDIO":            "hold_audio",
+		"ESCALATION_HOLD_MESSAGE":          "hold_message",
+		"ESCALATION_HOLD_REPEAT_SECONDS":   "hold_repeat_seconds",
+		"ESCALATION_RESUME_MESSAGE":        "resume_message",
+	} {
+		if v := getEnv(env, ""); v != "" {
+			if _, ok := cfg.VendorSettings["escalation"]; !ok {
+				cfg.VendorSettings["escalation"] = make(map[string]string)
+			}
+			cfg.VendorSettings["escalation"][key] = v
+		}
+	}
 
 	return cfg
 }
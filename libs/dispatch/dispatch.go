@@ -0,0 +1,144 @@
+// Package dispatch is the protocol between the backend and the agent workers that run AI
+// agents for it. A worker opens a websocket to the backend at Path, authenticated with a
+// bearer secret, and registers with its capacity. The backend then assigns it calls, which it
+// accepts or rejects and whose agent it runs; it reports what each agent does and the turns
+// of its conversation. Every message is a Message in a text frame of its own.
+package dispatch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jacky-htg/ai-call-center/libs/store"
+)
+
+// Path is where the backend serves worker connections.
+const Path = "/workers/connect"
+
+// Types of Message.
+const (
+	// TypeRegister opens a connection, worker to backend; Register is set.
+	TypeRegister = "register"
+	// TypeRegistered confirms the registration, backend to worker; Registered is set.
+	TypeRegistered = "registered"
+	// TypeLoad advertises a change of the worker's capacity, worker to backend; Load is set.
+	TypeLoad = "load"
+	// TypeAssign asks the worker to run the agent of a call, backend to worker; Assign is set.
+	TypeAssign = "assign"
+	// TypeRelease asks the worker to stop the agent of a call, backend to worker; Release is set.
+	TypeRelease = "release"
+	// TypeStatus reports on an agent, worker to backend; Status is set.
+	TypeStatus = "status"
+	// TypeTurn records a turn of an agent's conversation, worker to backend; Turn is set.
+	TypeTurn = "turn"
+)
+
+// Message is a frame of the protocol. Type says which of the other fields is set.
+type Message struct {
+	Type       string      `json:"type"`
+	Register   *Register   `json:"register,omitempty"`
+	Registered *Registered `json:"registered,omitempty"`
+	Load       *Load       `json:"load,omitempty"`
+	Assign     *Assignment `json:"assign,omitempty"`
+	Release    *Release    `json:"release,omitempty"`
+	Status     *Status     `json:"status,omitempty"`
+	Turn       *store.Turn `json:"turn,omitempty"`
+}
+
+// Register introduces a worker. WorkerID may be empty for the backend to pick one.
+type Register struct {
+	WorkerID string `json:"worker_id,omitempty"`
+	// Capacity is how many agents the worker runs at once.
+	Capacity int `json:"capacity"`
+}
+
+// Registered is the id the backend knows the worker by.
+type Registered struct {
+	WorkerID string `json:"worker_id"`
+}
+
+// Load is the worker's new capacity.
+type Load struct {
+	Capacity int `json:"capacity"`
+}
+
+// Assignment is a call whose agent a worker is to run: it joins the LiveKit room at URL
+// with Token as the agent session, picking up the conversation from History.
+type Assignment struct {
+	CallID    string           `json:"call_id"`
+	SessionID string           `json:"session_id"`
+	URL       string           `json:"url"`
+	Token     string           `json:"token"`
+	History   []HistoryMessage `json:"history,omitempty"`
+}
+
+// HistoryMessage is a message of the conversation so far: a caller's (RoleUser) or an
+// agent's (RoleAssistant) turn.
+type HistoryMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Release names the call whose agent is to stop.
+type Release struct {
+	CallID string `json:"call_id"`
+}
+
+// States of an agent reported in a Status.
+const (
+	// StateAccepted acknowledges an assignment the worker has room for.
+	StateAccepted = "accepted"
+	// StateRejected declines an assignment; Reason says why.
+	StateRejected = "rejected"
+	// StateJoined reports the agent is in the room.
+	StateJoined = "joined"
+	// StateEnded reports the agent has left the room; Reason says why if it was not released.
+	StateEnded = "ended"
+)
+
+// Reasons of a Status.
+const (
+	// ReasonFull rejects an assignment when the worker runs as many agents as it can.
+	ReasonFull = "full"
+	// ReasonDuplicate rejects an assignment of a call whose agent the worker already runs.
+	ReasonDuplicate = "duplicate"
+	// ReasonJoinFailed ends an agent that could not join its room.
+	ReasonJoinFailed = "join_failed"
+	// ReasonWorkerLost ends the agents of a worker that disconnected; the backend reports it.
+	ReasonWorkerLost = "worker_lost"
+)
+
+// Status reports the state of the agent of a call.
+type Status struct {
+	CallID    string `json:"call_id"`
+	SessionID string `json:"session_id"`
+	State     string `json:"state"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Validate reports whether the message has the field its type calls for.
+func (m Message) Validate() error {
+	var ok bool
+	switch m.Type {
+	case TypeRegister:
+		ok = m.Register != nil && m.Register.Capacity >= 0
+	case TypeRegistered:
+		ok = m.Registered != nil && m.Registered.WorkerID != ""
+	case TypeLoad:
+		ok = m.Load != nil && m.Load.Capacity >= 0
+	case TypeAssign:
+		ok = m.Assign != nil && m.Assign.CallID != "" && m.Assign.SessionID != ""
+	case TypeRelease:
+		ok = m.Release != nil && m.Release.CallID != ""
+	case TypeStatus:
+		ok = m.Status != nil && m.Status.CallID != ""
+	case TypeTurn:
+		ok = m.Turn != nil && m.Turn.CallID != ""
+	default:
+		return fmt.Errorf("unknown message type %q", m.Type)
+	}
+	if !ok {
+		return errors.New("invalid " + m.Type + " message")
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package dispatch
+
+import "testing"
+
+func TestMessageValidate(t *testing.T) {
+	valid := []Message{
+		{Type: TypeRegister, Register: &Register{Capacity: 0}},
+		{Type: TypeAssign, Assign: &Assignment{CallID: "c", SessionID: "s"}},
+		{Type: TypeStatus, Status: &Status{CallID: "c", State: StateJoined}},
+	}
+	for _, m := range valid {
+		if err := m.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", m, err)
+		}
+	}
+	invalid := []Message{
+		{Type: "hello"},
+		{Type: TypeRegister},
+		{Type: TypeLoad, Load: &Load{Capacity: -1}},
+		{Type: TypeAssign, Assign: &Assignment{CallID: "c"}},
+		{Type: TypeTurn, Status: &Status{CallID: "c"}},
+	}
+	for _, m := range invalid {
+		if err := m.Validate(); err == nil {
+			t.Errorf("Validate(%+v): expected error", m)
+		}
+	}
+}
@@ -4,6 +4,8 @@ go 1.25.1
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/lib/pq v1.10.9
 	modernc.org/sqlite v1.42.2
 )
 
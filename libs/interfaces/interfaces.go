@@ -1,38 +1,100 @@
 package interfaces
 
-import "io"
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// All methods take a context: cancelling it (caller hangup, barge-in, shutdown) must abort any
+// outstanding vendor work and make the method return promptly.
 
 // TTS is the text-to-speech interface. Implementations should be swappable.
 type TTS interface {
 	// Speak converts text into audio bytes (e.g., encoded PCM or an audio format)
-	Speak(text string, opts ...TTSOption) ([]byte, error)
+	Speak(ctx context.Context, text string, opts ...TTSOption) ([]byte, error)
 	// SpeakStream writes audio bytes for the given text to the provided writer as they are produced.
 	// Implementations that can stream should provide this for low-latency playback.
-	SpeakStream(text string, w io.Writer, opts ...TTSOption) error
+	SpeakStream(ctx context.Context, text string, w io.Writer, opts ...TTSOption) error
 }
 
 // STT is the speech-to-text interface.
 type STT interface {
 	// Recognize converts audio bytes into text (returns transcript and confidence)
-	Recognize(audio []byte, opts ...STTOption) (string, float32, error)
+	Recognize(ctx context.Context, audio []byte, opts ...STTOption) (string, float32, error)
+	// RecognizeStream opens a streaming recognition session. Audio is pushed as
+	// 16 kHz mono 16-bit little-endian PCM frames and results arrive as they are produced.
+	// Cancelling ctx aborts the stream; Err then reports the context error.
+	RecognizeStream(ctx context.Context, opts ...STTOption) (STTStream, error)
+}
+
+// STTResult is a transcription result produced by a streaming recognizer.
+// Partial results may be revised by later results; a Final result is not.
+type STTResult struct {
+	Text       string
+	Confidence float32
+	Final      bool
+	// Start and End are offsets of the recognized speech from the start of the stream.
+	Start time.Duration
+	End   time.Duration
+	// Words holds per-word timings when requested with WithWordTimestamps.
+	Words []Word
+}
+
+// Word is a single recognized word with its timing relative to the start of the stream.
+type Word struct {
+	Text       string
+	Start      time.Duration
+	End        time.Duration
+	Confidence float32
+}
+
+// STTStream is an open streaming recognition session.
+type STTStream interface {
+	// Write pushes a frame of PCM audio into the stream.
+	Write(pcm []byte) error
+	// Results returns the channel on which partial and final results are delivered.
+	// The channel is closed once the stream has finished.
+	Results() <-chan STTResult
+	// Close signals the end of audio. Pending results are flushed before Results is closed.
+	Close() error
+	// Err returns the error that terminated the stream, if any. It is valid after Results is closed.
+	Err() error
 }
 
 // LLM is the language model interface.
 type LLM interface {
 	// Generate takes a prompt and returns a generated text response
-	Generate(prompt string, opts ...LLMOption) (string, error)
+	Generate(ctx context.Context, prompt string, opts ...LLMOption) (string, error)
+	// GenerateStream writes the response text to the provided writer as tokens are produced.
+	// Implementations that can stream should provide this so speech can start before generation ends.
+	GenerateStream(ctx context.Context, prompt string, w io.Writer, opts ...LLMOption) error
+	// Chat takes a conversation and returns the next assistant message
+	Chat(ctx context.Context, messages []Message, opts ...LLMOption) (string, error)
+	// ChatStream writes the next assistant message to the provided writer as tokens are produced.
+	ChatStream(ctx context.Context, messages []Message, w io.Writer, opts ...LLMOption) error
+}
+
+// Message roles understood by LLM.Chat.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Message is a single entry in a chat conversation.
+type Message struct {
+	Role    string
+	Content string
+	// ToolName identifies the tool whose output a RoleTool message carries.
+	ToolName string
 }
 
 // WebRTCProvider represents actions needed to manage a WebRTC session (signaling/rooms)
 type WebRTCProvider interface {
 	// StartSession creates/initializes a session and returns a session ID or error
-	StartSession(opts ...WebRTCOption) (string, error)
+	StartSession(ctx context.Context, opts ...WebRTCOption) (string, error)
 	// StopSession cleanly closes the session
-	StopSession(sessionID string) error
+	StopSession(ctx context.Context, sessionID string) error
 }
-
-// Option types are intentionally small placeholders to allow vendor-specific options.
-type TTSOption func(*map[string]any)
-type STTOption func(*map[string]any)
-type LLMOption func(*map[string]any)
-type WebRTCOption func(*map[string]any)
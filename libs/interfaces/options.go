@@ -0,0 +1,170 @@
+package interfaces
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnsupportedOption is returned (wrapped) by vendor adapters when a caller sets an
+// option the vendor cannot honour. Adapters never silently ignore options.
+var ErrUnsupportedOption = errors.New("unsupported option")
+
+// Unsupported returns an error wrapping ErrUnsupportedOption for the given vendor and option name.
+func Unsupported(vendor, option string) error {
+	return fmt.Errorf("%w: %s does not support %s", ErrUnsupportedOption, vendor, option)
+}
+
+// Audio output formats for TTSOptions.Format.
+const (
+	// FormatWAV is a RIFF/WAVE file with 16-bit PCM samples.
+	FormatWAV = "wav"
+	// FormatPCM is headerless 16-bit little-endian PCM.
+	FormatPCM = "pcm"
+)
+
+// TTSOptions holds the settings configured by TTSOption functions. Zero values mean
+// "vendor default".
+type TTSOptions struct {
+	// Voice selects a vendor-specific voice or speaker.
+	Voice string
+	// SpeakingRate scales speech speed; 1.0 is normal, 2.0 twice as fast.
+	SpeakingRate float64
+	// SampleRate is the sample rate in Hz of the produced audio.
+	SampleRate int
+	// Format is the container of the produced audio, FormatWAV or FormatPCM.
+	Format string
+}
+
+// TTSOption configures a TTS request.
+type TTSOption func(*TTSOptions)
+
+// WithVoice selects the voice used for synthesis.
+func WithVoice(voice string) TTSOption { return func(o *TTSOptions) { o.Voice = voice } }
+
+// WithSpeakingRate scales the speed of speech; 1.0 is the voice's normal rate.
+func WithSpeakingRate(rate float64) TTSOption {
+	return func(o *TTSOptions) { o.SpeakingRate = rate }
+}
+
+// WithSampleRate requests audio at the given sample rate in Hz.
+func WithSampleRate(hz int) TTSOption { return func(o *TTSOptions) { o.SampleRate = hz } }
+
+// WithOutputFormat requests audio in FormatWAV or FormatPCM.
+func WithOutputFormat(format string) TTSOption { return func(o *TTSOptions) { o.Format = format } }
+
+// ApplyTTSOptions returns the options configured by opts.
+func ApplyTTSOptions(opts ...TTSOption) TTSOptions {
+	var o TTSOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// STTOptions holds the settings configured by STTOption functions.
+type STTOptions struct {
+	// Language is a BCP-47 / ISO-639-1 language code such as "en"; empty lets the vendor detect it.
+	Language string
+	// Prompt biases recognition towards expected vocabulary (names, products, jargon).
+	Prompt string
+	// WordTimestamps requests per-word timings in STTResult.Words.
+	WordTimestamps bool
+}
+
+// STTOption configures an STT request.
+type STTOption func(*STTOptions)
+
+// WithLanguage sets the spoken language instead of relying on detection.
+func WithLanguage(lang string) STTOption { return func(o *STTOptions) { o.Language = lang } }
+
+// WithPromptHint passes vocabulary hints to the recognizer.
+func WithPromptHint(prompt string) STTOption { return func(o *STTOptions) { o.Prompt = prompt } }
+
+// WithWordTimestamps requests per-word timings in streaming results.
+func WithWordTimestamps() STTOption { return func(o *STTOptions) { o.WordTimestamps = true } }
+
+// ApplySTTOptions returns the options configured by opts.
+func ApplySTTOptions(opts ...STTOption) STTOptions {
+	var o STTOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// LLMOptions holds the settings configured by LLMOption functions.
+type LLMOptions struct {
+	// Temperature controls sampling randomness; nil uses the model default.
+	Temperature *float64
+	// MaxTokens caps the number of generated tokens; 0 means no explicit limit.
+	MaxTokens int
+	// Stop lists sequences that end generation when produced.
+	Stop []string
+	// Model overrides the adapter's configured model for this request.
+	Model string
+}
+
+// LLMOption configures an LLM request.
+type LLMOption func(*LLMOptions)
+
+// WithTemperature sets the sampling temperature.
+func WithTemperature(t float64) LLMOption { return func(o *LLMOptions) { o.Temperature = &t } }
+
+// WithMaxTokens caps the length of the generated response.
+func WithMaxTokens(n int) LLMOption { return func(o *LLMOptions) { o.MaxTokens = n } }
+
+// WithStop sets stop sequences that end generation.
+func WithStop(seqs ...string) LLMOption { return func(o *LLMOptions) { o.Stop = seqs } }
+
+// WithModel overrides the model used for this request.
+func WithModel(model string) LLMOption { return func(o *LLMOptions) { o.Model = model } }
+
+// ApplyLLMOptions returns the options configured by opts.
+func ApplyLLMOptions(opts ...LLMOption) LLMOptions {
+	var o LLMOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WebRTCOptions holds the settings configured by WebRTCOption functions.
+type WebRTCOptions struct {
+	// Room is the name of the room to create; empty lets the provider pick one.
+	Room string
+	// EmptyTimeout closes the room after it has been empty for this long.
+	EmptyTimeout time.Duration
+	// MaxParticipants limits how many participants can join; 0 means unlimited.
+	MaxParticipants int
+	// Metadata is attached to the room.
+	Metadata string
+}
+
+// WebRTCOption configures a WebRTC session.
+type WebRTCOption func(*WebRTCOptions)
+
+// WithRoom sets the room name of the session.
+func WithRoom(name string) WebRTCOption { return func(o *WebRTCOptions) { o.Room = name } }
+
+// WithEmptyTimeout closes the room after it has been empty for d.
+func WithEmptyTimeout(d time.Duration) WebRTCOption {
+	return func(o *WebRTCOptions) { o.EmptyTimeout = d }
+}
+
+// WithMaxParticipants limits the number of participants in the room.
+func WithMaxParticipants(n int) WebRTCOption {
+	return func(o *WebRTCOptions) { o.MaxParticipants = n }
+}
+
+// WithRoomMetadata attaches metadata to the room.
+func WithRoomMetadata(md string) WebRTCOption { return func(o *WebRTCOptions) { o.Metadata = md } }
+
+// ApplyWebRTCOptions returns the options configured by opts.
+func ApplyWebRTCOptions(opts ...WebRTCOption) WebRTCOptions {
+	var o WebRTCOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,89 @@
+package livekit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SupervisorMode is how a supervisor takes part in a live call.
+type SupervisorMode string
+
+const (
+	// SuperviseListen: the supervisor listens without being seen or heard.
+	SuperviseListen SupervisorMode = "listen"
+	// SuperviseWhisper: the supervisor listens like SuperviseListen and talks to the human
+	// agent only, in the WhisperRoom of their session.
+	SuperviseWhisper SupervisorMode = "whisper"
+	// SuperviseBarge: the supervisor joins the call as a full participant.
+	SuperviseBarge SupervisorMode = "barge"
+)
+
+// Attributes of supervisor participants.
+const (
+	AttrSupervisorMode = "supervisor_mode"
+	// AttrWhisperTo is the identity (session id) of the human agent a supervisor whispers to.
+	AttrWhisperTo = "whisper_to"
+)
+
+// SupervisorGrant returns the grant a supervisor needs in room to take part in mode.
+func SupervisorGrant(mode SupervisorMode, room string) (VideoGrant, error) {
+	switch mode {
+	case SuperviseListen, SuperviseWhisper:
+		// whispers are not published in the call's room, where anyone could subscribe to them
+		return GrantFor(RoleSupervisor, room)
+	case SuperviseBarge:
+		// a barging supervisor talks like the agent on the call
+		return GrantFor(RoleHuman, room)
+	default:
+		return VideoGrant{}, fmt.Errorf("livekit: unknown supervisor mode %q", mode)
+	}
+}
+
+// SupervisorToken issues the token a supervisor joins a call's room with in mode, as session
+// sessionID. whisperTo is the identity of the human agent a whispering supervisor talks to;
+// the whisper itself needs WhisperToken.
+func SupervisorToken(s Settings, mode SupervisorMode, callID, sessionID, whisperTo string) (string, error) {
+	grant, err := SupervisorGrant(mode, callID)
+	if err != nil {
+		return "", err
+	}
+	attrs := map[string]string{AttrSupervisorMode: string(mode)}
+	if mode == SuperviseWhisper {
+		if whisperTo == "" {
+			return "", errors.New("livekit: whisper needs the identity of the human agent")
+		}
+		attrs[AttrWhisperTo] = whisperTo
+	}
+	return participantToken(s, RoleSupervisor, grant, callID, sessionID, attrs)
+}
+
+// whisperRoomPrefix starts the names of whisper rooms.
+const whisperRoomPrefix = "whisper-"
+
+// WhisperRoom is the room a supervisor with session sessionID whispers to the human agent
+// in. Only the two of them can join it, so nobody else on the call can hear the whisper.
+func WhisperRoom(sessionID string) string { return whisperRoomPrefix + sessionID }
+
+// IsWhisperRoom reports whether room is a WhisperRoom rather than the room of a call.
+func IsWhisperRoom(room string) bool { return strings.HasPrefix(room, whisperRoomPrefix) }
+
+// WhisperToken issues the token the supervisor with session sessionID joins their
+// WhisperRoom with to talk to the human agent whisperTo of call callID.
+func WhisperToken(s Settings, callID, sessionID, whisperTo string) (string, error) {
+	if whisperTo == "" {
+		return "", errors.New("livekit: whisper needs the identity of the human agent")
+	}
+	yes, no := true, false
+	grant := VideoGrant{RoomJoin: true, Room: WhisperRoom(sessionID), CanPublish: &yes, CanPublishSources: []string{"microphone"}, CanPublishData: &no, CanSubscribe: &no}
+	attrs := map[string]string{AttrSupervisorMode: string(SuperviseWhisper), AttrWhisperTo: whisperTo}
+	return participantToken(s, RoleSupervisor, grant, callID, sessionID, attrs)
+}
+
+// WhisperListenerToken issues the token the human agent with session humanSession joins
+// the WhisperRoom of the supervisor session sessionID with, to hear the supervisor.
+func WhisperListenerToken(s Settings, callID, sessionID, humanSession string) (string, error) {
+	yes, no := true, false
+	grant := VideoGrant{RoomJoin: true, Room: WhisperRoom(sessionID), CanPublish: &no, CanPublishData: &no, CanSubscribe: &yes}
+	return participantToken(s, RoleHuman, grant, callID, humanSession, nil)
+}
@@ -3,48 +3,273 @@ package livekit
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
-// GenerateAccessToken creates a LiveKit-compatible access token using HMAC-SHA256.
-// This is a lightweight implementation that produces a JWT with a 'video' grant
-// containing the room name. It uses apiKey as the 'iss' claim and signs with apiSecret.
-func GenerateAccessToken(apiKey, apiSecret, room, identity string, ttlSeconds int) (string, error) {
-	if apiKey == "" || apiSecret == "" {
-		return "", fmt.Errorf("livekit api key/secret required")
+// DefaultTokenTTL is how long access tokens are valid unless configured otherwise.
+const DefaultTokenTTL = time.Hour
+
+// Settings are the LiveKit settings read from config.VendorSettings["livekit"].
+type Settings struct {
+	URL       string
+	APIKey    string
+	APISecret string
+	// TokenTTL is the validity of issued access tokens; clients refresh before it runs out.
+	TokenTTL time.Duration
+}
+
+// SettingsFrom parses the "livekit" vendor settings: url, api_key, api_secret and
+// token_ttl_seconds. A nil map yields empty settings with the default TTL.
+func SettingsFrom(vs map[string]string) (Settings, error) {
+	s := Settings{URL: vs["url"], APIKey: vs["api_key"], APISecret: vs["api_secret"], TokenTTL: DefaultTokenTTL}
+	if v := vs["token_ttl_seconds"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return Settings{}, fmt.Errorf("livekit: invalid token_ttl_seconds %q", v)
+		}
+		s.TokenTTL = time.Duration(n) * time.Second
 	}
-	if ttlSeconds <= 0 {
-		ttlSeconds = 3600
+	return s, nil
+}
+
+// Role is the part a participant plays in a call; it decides the participant's grants.
+type Role string
+
+const (
+	RoleCaller     Role = "caller"
+	RoleAgent      Role = "agent"
+	RoleHuman      Role = "human"
+	RoleSupervisor Role = "supervisor"
+	RoleRecorder   Role = "recorder"
+)
+
+// VideoGrant is the "video" claim of a LiveKit access token. The Can* permissions are
+// pointers because LiveKit treats a missing permission as granted.
+type VideoGrant struct {
+	RoomCreate bool   `json:"roomCreate,omitempty"`
+	RoomList   bool   `json:"roomList,omitempty"`
+	RoomAdmin  bool   `json:"roomAdmin,omitempty"`
+	RoomRecord bool   `json:"roomRecord,omitempty"`
+	RoomJoin   bool   `json:"roomJoin,omitempty"`
+	Room       string `json:"room,omitempty"`
+
+	CanPublish        *bool    `json:"canPublish,omitempty"`
+	CanSubscribe      *bool    `json:"canSubscribe,omitempty"`
+	CanPublishData    *bool    `json:"canPublishData,omitempty"`
+	CanPublishSources []string `json:"canPublishSources,omitempty"`
+
+	// Hidden participants are not visible to others in the room.
+	Hidden   bool `json:"hidden,omitempty"`
+	Recorder bool `json:"recorder,omitempty"`
+}
+
+// GrantFor returns the grant a participant with role needs in room. Callers talk to AI or
+// human agents; supervisors listen without being seen; recorders only subscribe.
+func GrantFor(role Role, room string) (VideoGrant, error) {
+	if room == "" {
+		return VideoGrant{}, errors.New("livekit: room required")
+	}
+	yes, no := true, false
+	g := VideoGrant{RoomJoin: true, Room: room, CanSubscribe: &yes}
+	switch role {
+	case RoleCaller, RoleAgent, RoleHuman:
+		g.CanPublish, g.CanPublishData = &yes, &yes
+		g.CanPublishSources = []string{"microphone"}
+	case RoleSupervisor:
+		g.CanPublish, g.CanPublishData = &no, &yes
+		g.Hidden = true
+	case RoleRecorder:
+		g.CanPublish, g.CanPublishData = &no, &no
+		g.Hidden, g.Recorder = true, true
+	default:
+		return VideoGrant{}, fmt.Errorf("livekit: unknown role %q", role)
 	}
+	return g, nil
+}
+
+// Claims are the LiveKit claims of an access token. The participant identity is the subject.
+type Claims struct {
+	jwt.RegisteredClaims
+	Name       string            `json:"name,omitempty"`
+	Video      *VideoGrant       `json:"video,omitempty"`
+	Metadata   string            `json:"metadata,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Kind is the participant kind, e.g. "agent" for AI agents.
+	Kind string `json:"kind,omitempty"`
+	// Sha256 is the base64 SHA-256 of the request body a webhook token was issued for.
+	Sha256 string `json:"sha256,omitempty"`
+}
 
-	now := time.Now().Unix()
-	exp := time.Now().Add(time.Duration(ttlSeconds) * time.Second).Unix()
+// AccessToken builds a signed LiveKit access token.
+type AccessToken struct {
+	apiKey    string
+	apiSecret string
+	claims    Claims
+	ttl       time.Duration
+}
+
+// NewAccessToken starts a token signed with the API key and secret.
+func NewAccessToken(apiKey, apiSecret string) *AccessToken {
+	return &AccessToken{apiKey: apiKey, apiSecret: apiSecret, ttl: DefaultTokenTTL}
+}
+
+// SetIdentity sets the participant identity.
+func (t *AccessToken) SetIdentity(identity string) *AccessToken {
+	t.claims.Subject = identity
+	return t
+}
+
+// SetName sets the participant's display name.
+func (t *AccessToken) SetName(name string) *AccessToken {
+	t.claims.Name = name
+	return t
+}
+
+// SetVideoGrant sets the room permissions.
+func (t *AccessToken) SetVideoGrant(g VideoGrant) *AccessToken {
+	t.claims.Video = &g
+	return t
+}
+
+// SetMetadata sets the participant metadata seen by others in the room.
+func (t *AccessToken) SetMetadata(md string) *AccessToken {
+	t.claims.Metadata = md
+	return t
+}
+
+// SetAttributes sets the participant attributes.
+func (t *AccessToken) SetAttributes(attrs map[string]string) *AccessToken {
+	t.claims.Attributes = attrs
+	return t
+}
+
+// SetKind sets the participant kind.
+func (t *AccessToken) SetKind(kind string) *AccessToken {
+	t.claims.Kind = kind
+	return t
+}
+
+// SetSha256 binds the token to a request body by its base64 SHA-256, as webhooks do.
+func (t *AccessToken) SetSha256(sum string) *AccessToken {
+	t.claims.Sha256 = sum
+	return t
+}
+
+// SetTTL sets how long the token is valid.
+func (t *AccessToken) SetTTL(ttl time.Duration) *AccessToken {
+	t.ttl = ttl
+	return t
+}
+
+// ToJWT signs the token with HMAC-SHA256, using the API key as issuer.
+func (t *AccessToken) ToJWT() (string, error) {
+	if t.apiKey == "" || t.apiSecret == "" {
+		return "", fmt.Errorf("livekit api key/secret required")
+	}
+	if t.claims.Video != nil && t.claims.Video.RoomJoin && t.claims.Subject == "" {
+		return "", errors.New("livekit: identity required to join a room")
+	}
+	ttl := t.ttl
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
 
 	// random jti
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
 		return "", fmt.Errorf("generate jti: %w", err)
 	}
-	jti := hex.EncodeToString(b)
+	now := time.Now()
+	c := t.claims
+	c.ID = hex.EncodeToString(b)
+	c.Issuer = t.apiKey
+	c.NotBefore = jwt.NewNumericDate(now)
+	c.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
 
-	claims := jwt.MapClaims{
-		"jti":   jti,
-		"iss":   apiKey,
-		"nbf":   now,
-		"exp":   exp,
-		"sub":   "",
-		"name":  identity,
-		"video": map[string]interface{}{"room": room},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// sign with apiSecret
-	signed, err := token.SignedString([]byte(apiSecret))
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(t.apiSecret))
 	if err != nil {
 		return "", fmt.Errorf("sign token: %w", err)
 	}
 	return signed, nil
 }
+
+// ParticipantMetadata is the JSON metadata attached to call participants.
+type ParticipantMetadata struct {
+	SessionType Role   `json:"session_type"`
+	CallID      string `json:"call_id"`
+	SessionID   string `json:"session_id"`
+}
+
+// ParticipantToken issues the token a call participant joins with: the session id is the
+// identity, the call id is the room, and the grant, metadata and attributes follow role.
+func ParticipantToken(s Settings, role Role, callID, sessionID string) (string, error) {
+	grant, err := GrantFor(role, callID)
+	if err != nil {
+		return "", err
+	}
+	return participantToken(s, role, grant, callID, sessionID, nil)
+}
+
+// participantToken issues a call participant's token with grant, adding attrs to the
+// attributes every participant has.
+func participantToken(s Settings, role Role, grant VideoGrant, callID, sessionID string, attrs map[string]string) (string, error) {
+	md, err := json.Marshal(ParticipantMetadata{SessionType: role, CallID: callID, SessionID: sessionID})
+	if err != nil {
+		return "", fmt.Errorf("marshal participant metadata: %w", err)
+	}
+	attributes := map[string]string{"session_type": string(role), "call_id": callID}
+	for k, v := range attrs {
+		attributes[k] = v
+	}
+	t := NewAccessToken(s.APIKey, s.APISecret).
+		SetIdentity(sessionID).
+		SetName(string(role)).
+		SetVideoGrant(grant).
+		SetMetadata(string(md)).
+		SetAttributes(attributes).
+		SetTTL(s.TokenTTL)
+	if role == RoleAgent {
+		t.SetKind("agent")
+	}
+	return t.ToJWT()
+}
+
+// ParseToken verifies a token signed with the API key and secret and returns its claims.
+func ParseToken(apiKey, apiSecret, token string) (*Claims, error) {
+	if apiKey == "" || apiSecret == "" {
+		return nil, fmt.Errorf("livekit api key/secret required")
+	}
+	c := &Claims{}
+	_, err := jwt.ParseWithClaims(token, c, func(*jwt.Token) (any, error) {
+		return []byte(apiSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(apiKey), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("verify token: %w", err)
+	}
+	return c, nil
+}
+
+// RefreshToken verifies a token that has not expired yet and issues a new one with the
+// same identity, grants and metadata, valid for ttl from now.
+func RefreshToken(apiKey, apiSecret, token string, ttl time.Duration) (string, error) {
+	c, err := ParseToken(apiKey, apiSecret, token)
+	if err != nil {
+		return "", err
+	}
+	t := NewAccessToken(apiKey, apiSecret).SetTTL(ttl)
+	t.claims = Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: c.Subject},
+		Name:             c.Name,
+		Video:            c.Video,
+		Metadata:         c.Metadata,
+		Attributes:       c.Attributes,
+		Kind:             c.Kind,
+	}
+	return t.ToJWT()
+}
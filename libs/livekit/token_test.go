@@ -0,0 +1,203 @@
+package livekit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var testSettings = Settings{URL: "ws://localhost:7880", APIKey: "devkey", APISecret: "secret", TokenTTL: time.Minute}
+
+func TestParticipantToken_Claims(t *testing.T) {
+	token, err := ParticipantToken(testSettings, RoleAgent, "call-1", "session-1")
+	if err != nil {
+		t.Fatalf("ParticipantToken: %v", err)
+	}
+	c, err := ParseToken("devkey", "secret", token)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if c.Subject != "session-1" || c.Issuer != "devkey" || c.Kind != "agent" {
+		t.Fatalf("claims = %+v", c)
+	}
+	if ttl := c.ExpiresAt.Sub(c.NotBefore.Time); ttl != time.Minute {
+		t.Fatalf("ttl = %v, want 1m", ttl)
+	}
+	g := c.Video
+	if g == nil || !g.RoomJoin || g.Room != "call-1" || !*g.CanPublish || !*g.CanSubscribe || g.Hidden {
+		t.Fatalf("grant = %+v", g)
+	}
+	var md ParticipantMetadata
+	if err := json.Unmarshal([]byte(c.Metadata), &md); err != nil {
+		t.Fatalf("metadata %q: %v", c.Metadata, err)
+	}
+	if md.SessionType != RoleAgent || md.CallID != "call-1" || md.SessionID != "session-1" {
+		t.Fatalf("metadata = %+v", md)
+	}
+	if c.Attributes["call_id"] != "call-1" || c.Attributes["session_type"] != "agent" {
+		t.Fatalf("attributes = %v", c.Attributes)
+	}
+}
+
+func TestGrantFor_Roles(t *testing.T) {
+	sup, err := GrantFor(RoleSupervisor, "call-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *sup.CanPublish || !*sup.CanSubscribe || !sup.Hidden {
+		t.Fatalf("supervisor grant = %+v", sup)
+	}
+	rec, err := GrantFor(RoleRecorder, "call-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *rec.CanPublish || *rec.CanPublishData || !rec.Hidden || !rec.Recorder {
+		t.Fatalf("recorder grant = %+v", rec)
+	}
+	human, err := GrantFor(RoleHuman, "call-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*human.CanPublish || human.Hidden {
+		t.Fatalf("human grant = %+v", human)
+	}
+	if _, err := GrantFor("janitor", "call-1"); err == nil {
+		t.Fatal("expected error for unknown role")
+	}
+	if _, err := GrantFor(RoleCaller, ""); err == nil {
+		t.Fatal("expected error without room")
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	old, err := NewAccessToken("devkey", "secret").
+		SetIdentity("session-1").
+		SetVideoGrant(VideoGrant{RoomJoin: true, Room: "call-1"}).
+		SetMetadata("md").
+		SetTTL(2 * time.Second).
+		ToJWT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := RefreshToken("devkey", "secret", old, time.Hour)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	c, err := ParseToken("devkey", "secret", fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "session-1" || c.Video.Room != "call-1" || c.Metadata != "md" {
+		t.Fatalf("refreshed claims = %+v", c)
+	}
+	if time.Until(c.ExpiresAt.Time) < 59*time.Minute {
+		t.Fatalf("refreshed token expires at %v", c.ExpiresAt)
+	}
+
+	if _, err := RefreshToken("devkey", "other-secret", old, time.Hour); err == nil {
+		t.Fatal("expected error for token signed with another secret")
+	}
+	if _, err := RefreshToken("otherkey", "secret", old, time.Hour); err == nil {
+		t.Fatal("expected error for token issued by another key")
+	}
+}
+
+func TestRefreshToken_Expired(t *testing.T) {
+	old, err := NewAccessToken("devkey", "secret").SetIdentity("session-1").SetTTL(time.Second).ToJWT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	if _, err := RefreshToken("devkey", "secret", old, time.Hour); err == nil {
+		t.Fatal("expected expired token to be rejected")
+	}
+}
+
+func TestSettingsFrom(t *testing.T) {
+	s, err := SettingsFrom(nil)
+	if err != nil || s.TokenTTL != DefaultTokenTTL {
+		t.Fatalf("SettingsFrom(nil) = %+v, %v", s, err)
+	}
+	s, err = SettingsFrom(map[string]string{"url": "ws://lk", "token_ttl_seconds": "7200"})
+	if err != nil || s.URL != "ws://lk" || s.TokenTTL != 2*time.Hour {
+		t.Fatalf("SettingsFrom = %+v, %v", s, err)
+	}
+	if _, err := SettingsFrom(map[string]string{"token_ttl_seconds": "-1"}); err == nil {
+		t.Fatal("expected error for negative ttl")
+	}
+}
+
+func TestSupervisorToken_Modes(t *testing.T) {
+	for _, tc := range []struct {
+		mode    SupervisorMode
+		publish bool
+		hidden  bool
+	}{
+		{SuperviseListen, false, true},
+		{SuperviseWhisper, false, true},
+		{SuperviseBarge, true, false},
+	} {
+		whisperTo := ""
+		if tc.mode == SuperviseWhisper {
+			whisperTo = "human-1"
+		}
+		token, err := SupervisorToken(testSettings, tc.mode, "call-1", "sup-1", whisperTo)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.mode, err)
+		}
+		c, err := ParseToken("devkey", "secret", token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g := c.Video; g.Room != "call-1" || *g.CanPublish != tc.publish || !*g.CanSubscribe || g.Hidden != tc.hidden {
+			t.Fatalf("%s grant = %+v", tc.mode, g)
+		}
+		a := c.Attributes
+		if a["session_type"] != "supervisor" || a[AttrSupervisorMode] != string(tc.mode) || a[AttrWhisperTo] != whisperTo {
+			t.Fatalf("%s attributes = %v", tc.mode, a)
+		}
+	}
+	if _, err := SupervisorToken(testSettings, SuperviseWhisper, "call-1", "sup-1", ""); err == nil {
+		t.Fatal("expected error whispering to nobody")
+	}
+	if _, err := SupervisorToken(testSettings, "coach", "call-1", "sup-1", ""); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestWhisperTokens(t *testing.T) {
+	room := WhisperRoom("sup-1")
+	if !IsWhisperRoom(room) || IsWhisperRoom("call-1") {
+		t.Fatalf("IsWhisperRoom(%q) = %v", room, IsWhisperRoom(room))
+	}
+
+	// the supervisor only talks in the whisper room, the human agent only listens
+	token, err := WhisperToken(testSettings, "call-1", "sup-1", "human-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseToken("devkey", "secret", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := c.Video; g.Room != room || !*g.CanPublish || *g.CanSubscribe || c.Subject != "sup-1" {
+		t.Fatalf("supervisor grant = %+v as %q", g, c.Subject)
+	}
+	if a := c.Attributes; a[AttrSupervisorMode] != "whisper" || a[AttrWhisperTo] != "human-1" || a["call_id"] != "call-1" {
+		t.Fatalf("supervisor attributes = %v", a)
+	}
+	if _, err := WhisperToken(testSettings, "call-1", "sup-1", ""); err == nil {
+		t.Fatal("expected error whispering to nobody")
+	}
+
+	token, err = WhisperListenerToken(testSettings, "call-1", "sup-1", "human-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err = ParseToken("devkey", "secret", token); err != nil {
+		t.Fatal(err)
+	}
+	if g := c.Video; g.Room != room || *g.CanPublish || !*g.CanSubscribe || c.Subject != "human-1" {
+		t.Fatalf("human agent grant = %+v as %q", g, c.Subject)
+	}
+}
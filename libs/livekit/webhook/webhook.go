@@ -0,0 +1,223 @@
+// Package webhook receives LiveKit webhooks. LiveKit signs every delivery with a JWT in the
+// Authorization header, issued by the API key and carrying the SHA-256 of the body.
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/livekit"
+)
+
+// Event types sent by LiveKit.
+const (
+	EventRoomStarted       = "room_started"
+	EventRoomFinished      = "room_finished"
+	EventParticipantJoined = "participant_joined"
+	EventParticipantLeft   = "participant_left"
+	EventTrackPublished    = "track_published"
+	EventTrackUnpublished  = "track_unpublished"
+	EventEgressStarted     = "egress_started"
+	EventEgressUpdated     = "egress_updated"
+	EventEgressEnded       = "egress_ended"
+	EventIngressStarted    = "ingress_started"
+	EventIngressEnded      = "ingress_ended"
+)
+
+// DefaultMaxAge is how old an event may be before it is rejected as a replay.
+const DefaultMaxAge = 5 * time.Minute
+
+// maxBodyBytes bounds the webhook bodies that are read.
+const maxBodyBytes = 1 << 20
+
+var (
+	ErrMissingAuth = errors.New("webhook: missing authorization")
+	ErrBodyHash    = errors.New("webhook: body does not match signed hash")
+	ErrMissingID   = errors.New("webhook: event id missing")
+	ErrStale       = errors.New("webhook: event outside the accepted time window")
+	ErrReplayed    = errors.New("webhook: event already received")
+)
+
+// Timestamp is a Unix time in seconds. LiveKit encodes 64-bit integers as JSON strings, so
+// both strings and numbers are accepted.
+type Timestamp int64
+
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*t = 0
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s: %w", b, err)
+	}
+	*t = Timestamp(n)
+	return nil
+}
+
+// Time returns t as a time.Time; zero stays zero.
+func (t Timestamp) Time() time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(t), 0)
+}
+
+// Room is the room an event refers to.
+type Room struct {
+	Sid             string    `json:"sid"`
+	Name            string    `json:"name"`
+	EmptyTimeout    uint32    `json:"emptyTimeout"`
+	MaxParticipants uint32    `json:"maxParticipants"`
+	CreationTime    Timestamp `json:"creationTime"`
+	Metadata        string    `json:"metadata"`
+	NumParticipants uint32    `json:"numParticipants"`
+}
+
+// Participant is the participant an event refers to.
+type Participant struct {
+	Sid        string            `json:"sid"`
+	Identity   string            `json:"identity"`
+	Name       string            `json:"name"`
+	State      string            `json:"state"`
+	Metadata   string            `json:"metadata"`
+	Attributes map[string]string `json:"attributes"`
+	JoinedAt   Timestamp         `json:"joinedAt"`
+	Kind       string            `json:"kind"`
+}
+
+// Track is the track an event refers to.
+type Track struct {
+	Sid    string `json:"sid"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Muted  bool   `json:"muted"`
+}
+
+// Egress is the egress (recording) an event refers to.
+type Egress struct {
+	EgressID  string    `json:"egressId"`
+	RoomName  string    `json:"roomName"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error"`
+	StartedAt Timestamp `json:"startedAt"`
+	EndedAt   Timestamp `json:"endedAt"`
+}
+
+// Event is a LiveKit webhook event. Only the fields relevant to Event are set.
+type Event struct {
+	Event       string       `json:"event"`
+	ID          string       `json:"id"`
+	CreatedAt   Timestamp    `json:"createdAt"`
+	Room        *Room        `json:"room,omitempty"`
+	Participant *Participant `json:"participant,omitempty"`
+	Track       *Track       `json:"track,omitempty"`
+	EgressInfo  *Egress      `json:"egressInfo,omitempty"`
+}
+
+// Decode parses an event body without verifying it.
+func Decode(body []byte) (*Event, error) {
+	var evt Event
+	if err := json.Unmarshal(body, &evt); err != nil {
+		return nil, fmt.Errorf("decode webhook event: %w", err)
+	}
+	if evt.Event == "" {
+		return nil, errors.New("decode webhook event: event type missing")
+	}
+	return &evt, nil
+}
+
+// Receiver verifies webhook deliveries and rejects replays. Events older than MaxAge are
+// refused outright, and the ids of accepted events are remembered until then by this
+// receiver alone.
+type Receiver struct {
+	apiKey    string
+	apiSecret string
+	// MaxAge is the accepted clock difference between the event's creation and its receipt.
+	// The ids of accepted events are kept in memory only, so within MaxAge an event can still
+	// be replayed to another replica of the server or to this one after a restart; keep it
+	// short where that matters.
+	MaxAge time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+// NewReceiver returns a receiver for webhooks signed with the API key and secret.
+func NewReceiver(apiKey, apiSecret string) *Receiver {
+	return &Receiver{apiKey: apiKey, apiSecret: apiSecret, MaxAge: DefaultMaxAge, seen: make(map[string]time.Time), now: time.Now}
+}
+
+// Receive reads the request body and verifies it with the Authorization header.
+func (r *Receiver) Receive(req *http.Request) (*Event, error) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read webhook body: %w", err)
+	}
+	return r.Verify(body, req.Header.Get("Authorization"))
+}
+
+// Verify checks that auth is a valid token from the API key whose sha256 claim matches body,
+// decodes the event and rejects it if it is stale or was already received.
+func (r *Receiver) Verify(body []byte, auth string) (*Event, error) {
+	auth = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	if auth == "" {
+		return nil, ErrMissingAuth
+	}
+	claims, err := livekit.ParseToken(r.apiKey, r.apiSecret, auth)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	want, err := base64.StdEncoding.DecodeString(claims.Sha256)
+	if err != nil || subtle.ConstantTimeCompare(sum[:], want) != 1 {
+		return nil, ErrBodyHash
+	}
+
+	evt, err := Decode(bytes.TrimSpace(body))
+	if err != nil {
+		return nil, err
+	}
+	if evt.ID == "" {
+		return nil, ErrMissingID
+	}
+	if err := r.checkReplay(evt); err != nil {
+		return nil, err
+	}
+	return evt, nil
+}
+
+// checkReplay records evt.ID, failing if the event is too old or its id was seen before.
+func (r *Receiver) checkReplay(evt *Event) error {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// an id seen more than 2*MaxAge ago can only come back on an event that is now stale
+	for id, at := range r.seen {
+		if now.Sub(at) > 2*r.MaxAge {
+			delete(r.seen, id)
+		}
+	}
+	if created := evt.CreatedAt.Time(); created.IsZero() || now.Sub(created) > r.MaxAge || created.Sub(now) > r.MaxAge {
+		return ErrStale
+	}
+	if _, ok := r.seen[evt.ID]; ok {
+		return ErrReplayed
+	}
+	r.seen[evt.ID] = now
+	return nil
+}
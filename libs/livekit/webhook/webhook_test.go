@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/livekit"
+)
+
+// sign returns the Authorization header LiveKit would send with body.
+func sign(t *testing.T, secret string, body []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(body)
+	token, err := livekit.NewAccessToken("devkey", secret).SetSha256(base64.StdEncoding.EncodeToString(sum[:])).SetTTL(5 * time.Minute).ToJWT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func eventBody(id string, created time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"event":"participant_joined","id":%q,"createdAt":"%d",
+		"room":{"sid":"RM_1","name":"call-1","creationTime":"%d"},
+		"participant":{"sid":"PA_1","identity":"session-1","state":"ACTIVE","joinedAt":%d,"attributes":{"session_type":"caller"}}}`,
+		id, created.Unix(), created.Unix(), created.Unix()))
+}
+
+func TestReceive_DecodesVerifiedEvent(t *testing.T) {
+	r := NewReceiver("devkey", "secret")
+	now := time.Now()
+	body := eventBody("EV_1", now)
+	req := httptest.NewRequest("POST", "/webhook/livekit", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", sign(t, "secret", body))
+
+	evt, err := r.Receive(req)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if evt.Event != EventParticipantJoined || evt.ID != "EV_1" || evt.CreatedAt.Time().Unix() != now.Unix() {
+		t.Fatalf("event = %+v", evt)
+	}
+	if evt.Room == nil || evt.Room.Name != "call-1" {
+		t.Fatalf("room = %+v", evt.Room)
+	}
+	p := evt.Participant
+	if p == nil || p.Identity != "session-1" || p.JoinedAt.Time().Unix() != now.Unix() || p.Attributes["session_type"] != "caller" {
+		t.Fatalf("participant = %+v", p)
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	now := time.Now()
+	body := eventBody("EV_1", now)
+
+	cases := []struct {
+		name string
+		body []byte
+		auth string
+		want error
+	}{
+		{"missing auth", body, "", ErrMissingAuth},
+		{"tampered body", []byte(strings.Replace(string(body), "session-1", "session-2", 1)), sign(t, "secret", body), ErrBodyHash},
+		{"stale", eventBody("EV_2", now.Add(-10*time.Minute)), sign(t, "secret", eventBody("EV_2", now.Add(-10*time.Minute))), ErrStale},
+		{"no id", eventBody("", now), sign(t, "secret", eventBody("", now)), ErrMissingID},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewReceiver("devkey", "secret").Verify(tc.body, tc.auth)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := NewReceiver("devkey", "secret").Verify(body, sign(t, "other-secret", body)); err == nil {
+		t.Fatal("expected token signed with another secret to be rejected")
+	}
+}
+
+func TestVerify_RejectsReplay(t *testing.T) {
+	r := NewReceiver("devkey", "secret")
+	body := eventBody("EV_1", time.Now())
+	if _, err := r.Verify(body, sign(t, "secret", body)); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	// a replay carries a fresh, valid token but the same event
+	if _, err := r.Verify(body, "Bearer "+sign(t, "secret", body)); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("replay: err = %v, want ErrReplayed", err)
+	}
+
+	// ids are forgotten once events that old are refused anyway
+	r.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, err := r.Verify(body, sign(t, "secret", body)); !errors.Is(err, ErrStale) {
+		t.Fatalf("late replay: err = %v, want ErrStale", err)
+	}
+	if len(r.seen) != 0 {
+		t.Fatalf("seen ids not pruned: %v", r.seen)
+	}
+}
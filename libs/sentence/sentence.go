@@ -0,0 +1,65 @@
+// Package sentence splits streamed LLM output into sentences so speech synthesis
+// can start on the first sentence while the rest of the reply is still generated.
+package sentence
+
+import (
+	"strings"
+	"sync"
+)
+
+// Writer is an io.Writer that buffers text and calls emit once for each complete
+// sentence. A sentence ends at '.', '!', '?' or a newline followed by whitespace,
+// so decimals such as "3.5" are not split. Call Flush when the stream is finished
+// to emit any trailing text.
+type Writer struct {
+	mu   sync.Mutex
+	buf  strings.Builder
+	emit func(string)
+}
+
+// NewWriter returns a Writer that calls emit with each trimmed, non-empty sentence.
+func NewWriter(emit func(string)) *Writer {
+	return &Writer{emit: emit}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	text := w.buf.String()
+	cut := 0
+	for i := 0; i+1 < len(text); i++ {
+		if isTerminator(text[i]) && isSpace(text[i+1]) {
+			w.send(text[cut : i+1])
+			cut = i + 1
+		}
+	}
+	if cut > 0 {
+		rest := text[cut:]
+		w.buf.Reset()
+		w.buf.WriteString(rest)
+	}
+	return len(p), nil
+}
+
+// Flush emits any buffered text that did not end with a sentence terminator.
+func (w *Writer) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.send(w.buf.String())
+	w.buf.Reset()
+}
+
+func (w *Writer) send(s string) {
+	if s = strings.TrimSpace(s); s != "" {
+		w.emit(s)
+	}
+}
+
+func isTerminator(c byte) bool {
+	return c == '.' || c == '!' || c == '?' || c == '\n'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r'
+}
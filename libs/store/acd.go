@@ -0,0 +1,212 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Human agent states.
+const (
+	HumanAvailable = "available"
+	HumanBusy      = "busy"
+	HumanWrapUp    = "wrap_up"
+	HumanAway      = "away"
+)
+
+// HumanAgent is a person who answers calls routed to them from the queues.
+type HumanAgent struct {
+	ID     string
+	Name   string
+	Skills []string
+	State  string
+	// StateSince is when the agent entered State, with second precision.
+	StateSince time.Time
+	CreatedAt  time.Time
+}
+
+// Queue is a named queue calls wait in for a human agent that has all its skills.
+type Queue struct {
+	Name   string
+	Skills []string
+	// Timeout is how long a call waits for an agent; zero waits until the caller hangs up.
+	Timeout time.Duration
+	// WrapUp is how long an agent stays in wrap-up after a call of the queue.
+	WrapUp time.Duration
+	// Fallback says what happens to a call that times out; see the acd package.
+	Fallback  string
+	CreatedAt time.Time
+}
+
+const humanAgentColumns = `id, name, skills, state, state_since, created_at`
+
+const queueColumns = `name, skills, timeout_seconds, wrap_up_seconds, fallback, created_at`
+
+// CreateHumanAgent registers a human agent and returns its id, generated unless a.ID is set.
+// The agent starts away unless a.State says otherwise.
+func (s *Store) CreateHumanAgent(a HumanAgent) (string, error) {
+	if a.Name == "" {
+		return "", errors.New("name required")
+	}
+	if a.ID == "" {
+		id, err := genID()
+		if err != nil {
+			return "", err
+		}
+		a.ID = id
+	}
+	if a.State == "" {
+		a.State = HumanAway
+	}
+	now := time.Now().Unix()
+	_, err := s.q().Exec(`INSERT INTO human_agents(id, name, skills, state, state_since, created_at) VALUES(?,?,?,?,?,?)`,
+		a.ID, a.Name, joinSkills(a.Skills), a.State, now, now)
+	if err != nil {
+		return "", err
+	}
+	return a.ID, nil
+}
+
+// GetHumanAgent returns the human agent with the id.
+func (s *Store) GetHumanAgent(id string) (*HumanAgent, error) {
+	a, err := scanHumanAgent(s.q().QueryRow(`SELECT `+humanAgentColumns+` FROM human_agents WHERE id = ?`, id))
+	if err != nil {
+		return nil, notFound(err, "human agent", id)
+	}
+	return a, nil
+}
+
+// ListHumanAgents returns every human agent in the order they were registered.
+func (s *Store) ListHumanAgents() ([]HumanAgent, error) {
+	rows, err := s.q().Query(`SELECT ` + humanAgentColumns + ` FROM human_agents ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var agents []HumanAgent
+	for rows.Next() {
+		a, err := scanHumanAgent(rows)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, *a)
+	}
+	return agents, rows.Err()
+}
+
+// UpdateHumanAgentState moves a human agent to state as of now.
+func (s *Store) UpdateHumanAgentState(id, state string) error {
+	res, err := s.q().Exec(`UPDATE human_agents SET state = ?, state_since = ? WHERE id = ?`, state, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("human agent %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// SaveQueue creates the queue or replaces the settings of the queue with its name.
+func (s *Store) SaveQueue(q Queue) error {
+	if q.Name == "" {
+		return errors.New("name required")
+	}
+	_, err := s.q().Exec(`INSERT INTO queues(name, skills, timeout_seconds, wrap_up_seconds, fallback, created_at) VALUES(?,?,?,?,?,?)
+		ON CONFLICT(name) DO UPDATE SET skills = excluded.skills, timeout_seconds = excluded.timeout_seconds,
+		wrap_up_seconds = excluded.wrap_up_seconds, fallback = excluded.fallback`,
+		q.Name, joinSkills(q.Skills), int64(q.Timeout/time.Second), int64(q.WrapUp/time.Second), q.Fallback, time.Now().Unix())
+	return err
+}
+
+// GetQueue returns the queue with the name.
+func (s *Store) GetQueue(name string) (*Queue, error) {
+	q, err := scanQueue(s.q().QueryRow(`SELECT `+queueColumns+` FROM queues WHERE name = ?`, name))
+	if err != nil {
+		return nil, notFound(err, "queue", name)
+	}
+	return q, nil
+}
+
+// ListQueues returns every queue by name.
+func (s *Store) ListQueues() ([]Queue, error) {
+	rows, err := s.q().Query(`SELECT ` + queueColumns + ` FROM queues ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var queues []Queue
+	for rows.Next() {
+		q, err := scanQueue(rows)
+		if err != nil {
+			return nil, err
+		}
+		queues = append(queues, *q)
+	}
+	return queues, rows.Err()
+}
+
+// UpdateCallQueue routes a call to the queue, or to an AI agent if queue is empty.
+func (s *Store) UpdateCallQueue(callID, queue string) error {
+	var v any
+	if queue != "" {
+		v = queue
+	}
+	res, err := s.q().Exec(`UPDATE calls SET queue = ? WHERE id = ?`, v, callID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("call %s: %w", callID, ErrNotFound)
+	}
+	return nil
+}
+
+func scanHumanAgent(row scanner) (*HumanAgent, error) {
+	var a HumanAgent
+	var skills string
+	var since, created sql.NullInt64
+	if err := row.Scan(&a.ID, &a.Name, &skills, &a.State, &since, &created); err != nil {
+		return nil, err
+	}
+	a.Skills = splitSkills(skills)
+	a.StateSince, a.CreatedAt = unixTime(since), unixTime(created)
+	return &a, nil
+}
+
+func scanQueue(row scanner) (*Queue, error) {
+	var q Queue
+	var skills string
+	var timeout, wrapUp int64
+	var created sql.NullInt64
+	if err := row.Scan(&q.Name, &skills, &timeout, &wrapUp, &q.Fallback, &created); err != nil {
+		return nil, err
+	}
+	q.Skills = splitSkills(skills)
+	q.Timeout, q.WrapUp = time.Duration(timeout)*time.Second, time.Duration(wrapUp)*time.Second
+	q.CreatedAt = unixTime(created)
+	return &q, nil
+}
+
+// Skills are stored sorted and comma separated.
+func joinSkills(skills []string) string {
+	var clean []string
+	for _, sk := range skills {
+		if sk = strings.TrimSpace(sk); sk != "" && !slices.Contains(clean, sk) {
+			clean = append(clean, sk)
+		}
+	}
+	slices.Sort(clean)
+	return strings.Join(clean, ",")
+}
+
+func splitSkills(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes of ListCalls.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrBadCursor is returned by ListCalls for a cursor it did not issue.
+var ErrBadCursor = errors.New("invalid cursor")
+
+// Call is a conversation between a caller and the call center.
+type Call struct {
+	ID       string
+	CallerID string
+	Status   string
+	// CreatedAt and EndedAt have second precision; EndedAt is zero until the call ends.
+	CreatedAt time.Time
+	EndedAt   time.Time
+	// EndReason says why the call ended, if it was recorded.
+	EndReason string
+	// Queue is the queue the call waits in for a human agent; empty calls go to an AI agent.
+	Queue string
+}
+
+// CallFilter selects and orders the calls returned by ListCalls. Zero fields do not filter.
+type CallFilter struct {
+	Status   string
+	CallerID string
+	// From and To bound the creation time of the calls to [From, To).
+	From time.Time
+	To   time.Time
+	// Ascending lists the oldest calls first; by default the newest come first.
+	Ascending bool
+	// Limit is the page size, DefaultPageSize if zero and at most MaxPageSize.
+	Limit int
+	// Cursor continues the listing after the page that returned it.
+	Cursor string
+}
+
+const callColumns = `id, caller_id, status, created_at, ended_at, end_reason, queue`
+
+const sessionColumns = `id, call_id, user_id, type, status, created_at, ended_at, end_reason, mode`
+
+// GetCall returns the call with the id.
+func (s *Store) GetCall(callID string) (*Call, error) {
+	c, err := scanCall(s.q().QueryRow(`SELECT `+callColumns+` FROM calls WHERE id = ?`, callID))
+	if err != nil {
+		return nil, notFound(err, "call", callID)
+	}
+	return c, nil
+}
+
+// ListCalls pages through the calls matching f, ordered by creation time.
+func (s *Store) ListCalls(f CallFilter) ([]Call, string, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	order, after := "DESC", "<"
+	if f.Ascending {
+		order, after = "ASC", ">"
+	}
+
+	var where []string
+	var args []any
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.CallerID != "" {
+		where = append(where, "caller_id = ?")
+		args = append(args, f.CallerID)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From.Unix())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.To.Unix())
+	}
+	if f.Cursor != "" {
+		created, id, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		where = append(where, fmt.Sprintf("(created_at %s ? OR (created_at = ? AND id %s ?))", after, after))
+		args = append(args, created, created, id)
+	}
+
+	query := `SELECT ` + callColumns + ` FROM calls`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	// one extra row tells whether there is a next page
+	query += fmt.Sprintf(` ORDER BY created_at %s, id %s LIMIT ?`, order, order)
+	args = append(args, limit+1)
+
+	rows, err := s.q().Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var calls []Call
+	for rows.Next() {
+		c, err := scanCall(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		calls = append(calls, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(calls) > limit {
+		calls = calls[:limit]
+		last := calls[limit-1]
+		next = encodeCursor(last.CreatedAt.Unix(), last.ID)
+	}
+	return calls, next, nil
+}
+
+// ListSessions returns the sessions of a call in the order they were created.
+func (s *Store) ListSessions(callID string) ([]Session, error) {
+	return s.querySessions(`SELECT `+sessionColumns+` FROM sessions WHERE call_id = ? ORDER BY created_at, id`, callID)
+}
+
+// ListActiveSessions returns the active sessions of type typ across all calls, oldest first.
+func (s *Store) ListActiveSessions(typ string) ([]Session, error) {
+	return s.querySessions(`SELECT `+sessionColumns+` FROM sessions WHERE type = ? AND status = 'active' ORDER BY created_at, id`, typ)
+}
+
+func (s *Store) querySessions(query string, args ...any) ([]Session, error) {
+	rows, err := s.q().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	return sessions, rows.Err()
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCall(row scanner) (*Call, error) {
+	var c Call
+	var callerID, status, reason, queue sql.NullString
+	var created, ended sql.NullInt64
+	if err := row.Scan(&c.ID, &callerID, &status, &created, &ended, &reason, &queue); err != nil {
+		return nil, err
+	}
+	c.CallerID, c.Status, c.EndReason, c.Queue = callerID.String, status.String, reason.String, queue.String
+	c.CreatedAt, c.EndedAt = unixTime(created), unixTime(ended)
+	return &c, nil
+}
+
+func scanSession(row scanner) (*Session, error) {
+	var sess Session
+	var callID, userID, typ, status, reason, mode sql.NullString
+	var created, ended sql.NullInt64
+	if err := row.Scan(&sess.ID, &callID, &userID, &typ, &status, &created, &ended, &reason, &mode); err != nil {
+		return nil, err
+	}
+	sess.CallID, sess.UserID, sess.Type, sess.Status = callID.String, userID.String, typ.String, status.String
+	sess.EndReason, sess.Mode = reason.String, mode.String
+	sess.CreatedAt, sess.EndedAt = unixTime(created), unixTime(ended)
+	return &sess, nil
+}
+
+// unixTime converts Unix seconds to a time; NULL is the zero time.
+func unixTime(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+	return time.Unix(v.Int64, 0)
+}
+
+// A cursor is the creation time and id of the last call of a page, so the next page starts
+// after it even if calls were added meanwhile.
+func encodeCursor(created int64, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(created, 10) + ":" + id))
+}
+
+func decodeCursor(cursor string) (int64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrBadCursor
+	}
+	ts, id, ok := strings.Cut(string(b), ":")
+	created, err := strconv.ParseInt(ts, 10, 64)
+	if !ok || err != nil || id == "" {
+		return 0, "", ErrBadCursor
+	}
+	return created, id, nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect holds what differs between the SQL databases a Store runs on. Queries are written
+// with ? placeholders and rewritten for databases that number them.
+type dialect struct {
+	driver string
+	// numbered databases take $1, $2, ... placeholders.
+	numbered bool
+	// tableExists and columnExists count the tables or columns with a name.
+	tableExists  string
+	columnExists string
+	// lock, if set, serializes migrations across connections for the rest of a transaction.
+	lock string
+	// baselineTable, if set, marks a database created before migrations were versioned.
+	baselineTable string
+	// dsn, if set, adds the options every connection needs to a DSN.
+	dsn func(string) string
+}
+
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case DriverSQLite:
+		return sqliteDialect, nil
+	case DriverPostgres:
+		return postgresDialect, nil
+	}
+	return dialect{}, fmt.Errorf("unknown database driver %q", driver)
+}
+
+// rebind rewrites the ? placeholders of query for the dialect.
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// bound rebinds the queries it runs for its dialect.
+type bound struct {
+	q querier
+	d dialect
+}
+
+func (b bound) Exec(query string, args ...any) (sql.Result, error) {
+	return b.q.Exec(b.d.rebind(query), args...)
+}
+
+func (b bound) Query(query string, args ...any) (*sql.Rows, error) {
+	return b.q.Query(b.d.rebind(query), args...)
+}
+
+func (b bound) QueryRow(query string, args ...any) *sql.Row {
+	return b.q.QueryRow(b.d.rebind(query), args...)
+}
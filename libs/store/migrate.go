@@ -0,0 +1,314 @@
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change. Up applies it and Down reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations returns the schema migrations of the store's database in version order. They
+// are read from migrations/<driver>, where version N is NNNN_name.up.sql and
+// NNNN_name.down.sql; both drivers keep the same versions.
+func (s *Store) Migrations() ([]Migration, error) {
+	dir := path.Join("migrations", s.dialect.driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		num, label, ok2 := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || !ok2 || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+		b, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both up and down", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// ensureMigrationTable creates schema_migrations. A SQLite database that predates it but
+// already has tables is baselined: the migrations whose changes are present are recorded as
+// applied, so they are not run again.
+func (s *Store) ensureMigrationTable() error {
+	exists, err := s.tableExists(s.q(), "schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	q := s.bind(tx)
+	if err := s.lock(q); err != nil {
+		return err
+	}
+	// another server may have created it while we waited for the lock
+	if exists, err := s.tableExists(q, "schema_migrations"); err != nil || exists {
+		return err
+	}
+	legacy := false
+	if s.dialect.baselineTable != "" {
+		if legacy, err = s.tableExists(q, s.dialect.baselineTable); err != nil {
+			return err
+		}
+	}
+	if _, err := q.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at BIGINT NOT NULL)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	if legacy {
+		hasToken, err := s.columnExists(q, "sessions", "token")
+		if err != nil {
+			return err
+		}
+		hasTurns, err := s.tableExists(q, "turns")
+		if err != nil {
+			return err
+		}
+		baseline := []struct {
+			version int
+			name    string
+			present bool
+		}{
+			{1, "initial", true},
+			{2, "session_token", hasToken},
+			{3, "turns", hasTurns},
+		}
+		now := time.Now().Unix()
+		for _, b := range baseline {
+			if !b.present {
+				break
+			}
+			if _, err := q.Exec(`INSERT INTO schema_migrations(version, name, applied_at) VALUES(?,?,?)`, b.version, b.name, now); err != nil {
+				return fmt.Errorf("baseline migration %d: %w", b.version, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for an empty database.
+func (s *Store) SchemaVersion() (int, error) {
+	if err := s.ensureMigrationTable(); err != nil {
+		return 0, err
+	}
+	var v sql.NullInt64
+	if err := s.q().QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+func (s *Store) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time)
+	rows, err := s.q().Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at int64
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(at, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		at, ok := applied[m.Version]
+		status[i] = MigrationStatus{Migration: m, Applied: ok, AppliedAt: at}
+	}
+	return status, nil
+}
+
+// MigrateUp applies the pending migrations up to and including version target; a target
+// of 0 applies all of them. Migrating up to the current version does nothing; to an older
+// one is refused. Each migration runs in its own transaction.
+func (s *Store) MigrateUp(target int) error {
+	migrations, current, err := s.migrationState()
+	if err != nil {
+		return err
+	}
+	if target == 0 {
+		target = len(migrations)
+	}
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("unknown migration version %d", target)
+	}
+	if target < current {
+		return fmt.Errorf("cannot migrate up to version %d from %d", target, current)
+	}
+	for _, m := range migrations[current:target] {
+		if err := s.apply(m.Version, m.Name, m.Up, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the applied migrations above version target, newest first. Each
+// migration runs in its own transaction.
+func (s *Store) MigrateDown(target int) error {
+	migrations, current, err := s.migrationState()
+	if err != nil {
+		return err
+	}
+	if target < 0 || target > current {
+		return fmt.Errorf("cannot migrate down to version %d from %d", target, current)
+	}
+	for i := current - 1; i >= target; i-- {
+		m := migrations[i]
+		if err := s.apply(m.Version, m.Name, m.Down, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrationState returns the known migrations and the database's version, refusing to
+// touch databases written by a newer binary.
+func (s *Store) migrationState() ([]Migration, int, error) {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return nil, 0, err
+	}
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return nil, 0, err
+	}
+	if current > len(migrations) {
+		return nil, 0, fmt.Errorf("database schema version %d is newer than the latest known migration %d", current, len(migrations))
+	}
+	return migrations, current, nil
+}
+
+// apply runs one migration step and records it in schema_migrations within one transaction.
+// A step another server already took while this one waited for the lock is skipped.
+func (s *Store) apply(version int, name, stmts string, up bool) error {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	q := s.bind(tx)
+	if err := s.lock(q); err != nil {
+		return err
+	}
+	var n int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&n); err != nil {
+		return err
+	}
+	if applied := n > 0; applied == up {
+		return nil
+	}
+	// statements run unbound: migrations take no arguments
+	if _, err := tx.Exec(stmts); err != nil {
+		return fmt.Errorf("migration %d (%s) %s: %w", version, name, direction, err)
+	}
+	if up {
+		_, err = q.Exec(`INSERT INTO schema_migrations(version, name, applied_at) VALUES(?,?,?)`, version, name, time.Now().Unix())
+	} else {
+		_, err = q.Exec(`DELETE FROM schema_migrations WHERE version = ?`, version)
+	}
+	if err != nil {
+		return fmt.Errorf("record migration %d: %w", version, err)
+	}
+	return tx.Commit()
+}
+
+// lock serializes migrations for the rest of the transaction, where the database supports it.
+func (s *Store) lock(q querier) error {
+	if s.dialect.lock == "" {
+		return nil
+	}
+	if _, err := q.Exec(s.dialect.lock); err != nil {
+		return fmt.Errorf("lock schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) tableExists(q querier, name string) (bool, error) {
+	var n int
+	err := q.QueryRow(s.dialect.tableExists, name).Scan(&n)
+	return n > 0, err
+}
+
+func (s *Store) columnExists(q querier, table, column string) (bool, error) {
+	var n int
+	err := q.QueryRow(s.dialect.columnExists, table, column).Scan(&n)
+	return n > 0, err
+}
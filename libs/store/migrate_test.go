@@ -0,0 +1,153 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func openTemp(t *testing.T) *Store {
+	t.Helper()
+	s, err := OpenUnmigrated(DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func version(t *testing.T, s *Store) int {
+	t.Helper()
+	v, err := s.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion: %v", err)
+	}
+	return v
+}
+
+func TestMigrate_UpAndDown(t *testing.T) {
+	s := openTemp(t)
+	migrations, err := s.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := len(migrations)
+	if v := version(t, s); v != 0 {
+		t.Fatalf("fresh database at version %d", v)
+	}
+
+	if err := s.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if v := version(t, s); v != latest {
+		t.Fatalf("version = %d, want %d", v, latest)
+	}
+	if _, _, err := s.CreateCall("alice"); err != nil {
+		t.Fatalf("CreateCall after migrating: %v", err)
+	}
+	// migrating again is a no-op
+	if err := s.MigrateUp(0); err != nil {
+		t.Fatalf("second MigrateUp: %v", err)
+	}
+	if err := s.MigrateUp(latest); err != nil {
+		t.Fatalf("MigrateUp(%d) at version %d: %v", latest, latest, err)
+	}
+	// migrating up to an older version is refused, not a downgrade
+	if err := s.MigrateUp(1); err == nil {
+		t.Fatalf("MigrateUp(1) at version %d succeeded", latest)
+	}
+	if err := s.MigrateUp(-1); err == nil {
+		t.Fatal("MigrateUp(-1) succeeded")
+	}
+	if v := version(t, s); v != latest {
+		t.Fatalf("version after refused MigrateUp = %d, want %d", v, latest)
+	}
+
+	if err := s.MigrateDown(1); err != nil {
+		t.Fatalf("MigrateDown(1): %v", err)
+	}
+	if ok, _ := s.tableExists(s.q(), "turns"); ok {
+		t.Fatal("turns table still present after migrating down")
+	}
+	if ok, _ := s.columnExists(s.q(), "sessions", "token"); ok {
+		t.Fatal("sessions.token still present after migrating down")
+	}
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if m.Applied != (m.Version == 1) {
+			t.Fatalf("migration %d applied = %v", m.Version, m.Applied)
+		}
+	}
+
+	if err := s.MigrateDown(0); err != nil {
+		t.Fatalf("MigrateDown(0): %v", err)
+	}
+	if ok, _ := s.tableExists(s.q(), "calls"); ok {
+		t.Fatal("calls table still present after migrating down to 0")
+	}
+	if err := s.MigrateUp(latest); err != nil {
+		t.Fatalf("MigrateUp(%d): %v", latest, err)
+	}
+}
+
+func TestMigrate_BaselinesLegacyDatabase(t *testing.T) {
+	s := openTemp(t)
+	// the schema created by the server before migrations were versioned, minus turns
+	for _, q := range []string{
+		`CREATE TABLE users (id TEXT PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE calls (id TEXT PRIMARY KEY, caller_id TEXT, status TEXT, created_at INTEGER)`,
+		`CREATE TABLE sessions (id TEXT PRIMARY KEY, call_id TEXT, user_id TEXT, type TEXT, status TEXT, created_at INTEGER, token TEXT)`,
+		`INSERT INTO calls(id, caller_id, status, created_at) VALUES('c1', 'bob', 'active', 1)`,
+	} {
+		if _, err := s.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := version(t, s); v != 2 {
+		t.Fatalf("legacy database baselined at version %d, want 2", v)
+	}
+	if err := s.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if ok, _ := s.tableExists(s.q(), "turns"); !ok {
+		t.Fatal("turns table not created")
+	}
+	if ok, err := s.CallExists("c1"); err != nil || !ok {
+		t.Fatalf("existing call lost: %v %v", ok, err)
+	}
+}
+
+func TestMigrate_FailedMigrationRollsBack(t *testing.T) {
+	s := openTemp(t)
+	if err := s.MigrateUp(2); err != nil {
+		t.Fatal(err)
+	}
+	// an existing index named turns_call_id makes migration 3 fail after creating turns
+	if _, err := s.db.Exec(`CREATE INDEX turns_call_id ON calls(id)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MigrateUp(0); err == nil {
+		t.Fatal("expected migration 3 to fail")
+	}
+	if v := version(t, s); v != 2 {
+		t.Fatalf("version = %d after failed migration, want 2", v)
+	}
+	if ok, _ := s.tableExists(s.q(), "turns"); ok {
+		t.Fatal("turns table left behind by failed migration")
+	}
+}
+
+func TestMigrate_RefusesNewerDatabase(t *testing.T) {
+	s := openTemp(t)
+	if err := s.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO schema_migrations(version, name, applied_at) VALUES(99, 'future', 0)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MigrateUp(0); err == nil {
+		t.Fatal("expected an error for a database newer than the binary")
+	}
+}
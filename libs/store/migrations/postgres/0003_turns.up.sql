@@ -0,0 +1,2 @@
+CREATE TABLE turns (id BIGSERIAL PRIMARY KEY, call_id TEXT, session_id TEXT, speaker TEXT, text TEXT, confidence REAL, started_at_ms BIGINT, ended_at_ms BIGINT, stt_latency_ms BIGINT, llm_latency_ms BIGINT, tts_latency_ms BIGINT, interrupted BOOLEAN NOT NULL DEFAULT FALSE);
+CREATE INDEX turns_call_id ON turns(call_id, started_at_ms);
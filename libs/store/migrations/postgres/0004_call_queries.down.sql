@@ -0,0 +1,5 @@
+DROP INDEX sessions_call_id;
+DROP INDEX calls_caller_id;
+DROP INDEX calls_created_at;
+ALTER TABLE sessions DROP COLUMN ended_at;
+ALTER TABLE calls DROP COLUMN ended_at;
@@ -0,0 +1,5 @@
+ALTER TABLE calls ADD COLUMN ended_at BIGINT;
+ALTER TABLE sessions ADD COLUMN ended_at BIGINT;
+CREATE INDEX calls_created_at ON calls(created_at, id);
+CREATE INDEX calls_caller_id ON calls(caller_id, created_at);
+CREATE INDEX sessions_call_id ON sessions(call_id, created_at);
@@ -0,0 +1,2 @@
+CREATE TABLE purge_audit (id BIGSERIAL PRIMARY KEY, purged_at BIGINT NOT NULL, call_id TEXT NOT NULL, data_class TEXT NOT NULL, action TEXT NOT NULL, items BIGINT NOT NULL, cutoff BIGINT NOT NULL);
+CREATE INDEX purge_audit_purged_at ON purge_audit(purged_at, id);
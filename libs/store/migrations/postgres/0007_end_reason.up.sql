@@ -0,0 +1,3 @@
+ALTER TABLE calls ADD COLUMN end_reason TEXT;
+ALTER TABLE sessions ADD COLUMN end_reason TEXT;
+CREATE INDEX sessions_type_status ON sessions(type, status);
@@ -0,0 +1,3 @@
+ALTER TABLE calls ADD COLUMN queue TEXT;
+CREATE TABLE human_agents (id TEXT PRIMARY KEY, name TEXT NOT NULL, skills TEXT NOT NULL DEFAULT '', state TEXT NOT NULL, state_since BIGINT NOT NULL, created_at BIGINT NOT NULL);
+CREATE TABLE queues (name TEXT PRIMARY KEY, skills TEXT NOT NULL DEFAULT '', timeout_seconds BIGINT NOT NULL DEFAULT 0, wrap_up_seconds BIGINT NOT NULL DEFAULT 0, fallback TEXT NOT NULL DEFAULT '', created_at BIGINT NOT NULL);
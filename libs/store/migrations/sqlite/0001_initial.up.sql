@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS users (id TEXT PRIMARY KEY, name TEXT);
+CREATE TABLE IF NOT EXISTS calls (id TEXT PRIMARY KEY, caller_id TEXT, status TEXT, created_at INTEGER);
+CREATE TABLE IF NOT EXISTS sessions (id TEXT PRIMARY KEY, call_id TEXT, user_id TEXT, type TEXT, status TEXT, created_at INTEGER);
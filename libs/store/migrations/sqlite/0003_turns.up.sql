@@ -0,0 +1,2 @@
+CREATE TABLE turns (id INTEGER PRIMARY KEY AUTOINCREMENT, call_id TEXT, session_id TEXT, speaker TEXT, text TEXT, confidence REAL, started_at_ms INTEGER, ended_at_ms INTEGER, stt_latency_ms INTEGER, llm_latency_ms INTEGER, tts_latency_ms INTEGER, interrupted INTEGER);
+CREATE INDEX turns_call_id ON turns(call_id, started_at_ms);
@@ -0,0 +1,2 @@
+CREATE TABLE recordings (id TEXT PRIMARY KEY, call_id TEXT NOT NULL, key TEXT NOT NULL, content_type TEXT NOT NULL, channels INTEGER NOT NULL, sample_rate INTEGER NOT NULL, status TEXT NOT NULL, size_bytes INTEGER NOT NULL DEFAULT 0, duration_ms INTEGER NOT NULL DEFAULT 0, started_at INTEGER NOT NULL, ended_at INTEGER);
+CREATE INDEX recordings_call_id ON recordings(call_id, started_at);
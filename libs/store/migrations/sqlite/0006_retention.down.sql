@@ -0,0 +1 @@
+DROP TABLE purge_audit;
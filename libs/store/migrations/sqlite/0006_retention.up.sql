@@ -0,0 +1,2 @@
+CREATE TABLE purge_audit (id INTEGER PRIMARY KEY AUTOINCREMENT, purged_at INTEGER NOT NULL, call_id TEXT NOT NULL, data_class TEXT NOT NULL, action TEXT NOT NULL, items INTEGER NOT NULL, cutoff INTEGER NOT NULL);
+CREATE INDEX purge_audit_purged_at ON purge_audit(purged_at, id);
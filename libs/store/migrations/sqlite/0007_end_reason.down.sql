@@ -0,0 +1,3 @@
+DROP INDEX sessions_type_status;
+ALTER TABLE sessions DROP COLUMN end_reason;
+ALTER TABLE calls DROP COLUMN end_reason;
@@ -0,0 +1,3 @@
+DROP TABLE queues;
+DROP TABLE human_agents;
+ALTER TABLE calls DROP COLUMN queue;
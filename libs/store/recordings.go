@@ -0,0 +1,106 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Recording statuses.
+const (
+	RecordingActive = "recording"
+	RecordingReady  = "ready"
+	RecordingFailed = "failed"
+)
+
+// Recording is the audio file of a call.
+type Recording struct {
+	ID     string
+	CallID string
+	// Key locates the file in the recording storage.
+	Key         string
+	ContentType string
+	Channels    int
+	SampleRate  int
+	Status      string
+	SizeBytes   int64
+	Duration    time.Duration
+	StartedAt   time.Time
+	// EndedAt is zero while the call is being recorded.
+	EndedAt time.Time
+}
+
+const recordingColumns = `id, call_id, key, content_type, channels, sample_rate, status, size_bytes, duration_ms, started_at, ended_at`
+
+// CreateRecording registers a recording that has started and returns its id. The id, status
+// and times of r are ignored.
+func (s *Store) CreateRecording(r Recording) (string, error) {
+	if r.CallID == "" {
+		return "", errors.New("call_id required")
+	}
+	id, err := genID()
+	if err != nil {
+		return "", err
+	}
+	_, err = s.q().Exec(`INSERT INTO recordings(id, call_id, key, content_type, channels, sample_rate, status, started_at) VALUES(?,?,?,?,?,?,?,?)`,
+		id, r.CallID, r.Key, r.ContentType, r.Channels, r.SampleRate, RecordingActive, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// FinishRecording records the outcome of a recording: RecordingReady with the file's size and
+// length, or RecordingFailed.
+func (s *Store) FinishRecording(id string, size int64, duration time.Duration, status string) error {
+	res, err := s.q().Exec(`UPDATE recordings SET status = ?, size_bytes = ?, duration_ms = ?, ended_at = ? WHERE id = ?`,
+		status, size, duration.Milliseconds(), time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("recording %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// GetCallRecording returns the latest ready recording of a call.
+func (s *Store) GetCallRecording(callID string) (*Recording, error) {
+	r, err := scanRecording(s.q().QueryRow(`SELECT `+recordingColumns+` FROM recordings WHERE call_id = ? AND status = ? ORDER BY started_at DESC, id DESC LIMIT 1`, callID, RecordingReady))
+	if err != nil {
+		return nil, notFound(err, "recording of call", callID)
+	}
+	return r, nil
+}
+
+// ListRecordings returns every recording of a call in the order they started.
+func (s *Store) ListRecordings(callID string) ([]Recording, error) {
+	rows, err := s.q().Query(`SELECT `+recordingColumns+` FROM recordings WHERE call_id = ? ORDER BY started_at, id`, callID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recordings []Recording
+	for rows.Next() {
+		r, err := scanRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, *r)
+	}
+	return recordings, rows.Err()
+}
+
+func scanRecording(row scanner) (*Recording, error) {
+	var r Recording
+	var durationMs int64
+	var started, ended sql.NullInt64
+	if err := row.Scan(&r.ID, &r.CallID, &r.Key, &r.ContentType, &r.Channels, &r.SampleRate, &r.Status, &r.SizeBytes, &durationMs, &started, &ended); err != nil {
+		return nil, err
+	}
+	r.Duration = time.Duration(durationMs) * time.Millisecond
+	r.StartedAt, r.EndedAt = unixTime(started), unixTime(ended)
+	return &r, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when the requested call, session or recording does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Session is a participant's presence in a call: the caller, an AI agent or a human.
+type Session struct {
+	ID        string
+	CallID    string
+	UserID    string
+	Type      string
+	Status    string
+	CreatedAt time.Time
+	// EndedAt is zero until the session ends.
+	EndedAt time.Time
+	// EndReason says why the session ended, if it was recorded.
+	EndReason string
+	// Mode is how the participant takes part, e.g. whether a supervisor listens or barges in.
+	Mode string
+}
+
+// Repository persists calls, their sessions, transcripts and recordings, the audit trail of
+// data purged from them, and the human agents and queues calls are routed to. *Store implements it on SQLite and Postgres.
+type Repository interface {
+	// CreateCall creates a call and the caller's session, returning both ids.
+	CreateCall(callerID string) (callID, sessionID string, err error)
+	UpdateCallStatus(callID, status string) error
+	// EndCall ends a call, recording why unless a reason was already recorded.
+	EndCall(callID, reason string) error
+	CallExists(callID string) (bool, error)
+	GetCall(callID string) (*Call, error)
+	// ListCalls returns a page of the calls matching f and the cursor of the next page,
+	// empty on the last page.
+	ListCalls(f CallFilter) (calls []Call, next string, err error)
+
+	CreateSession(callID, userID, typ, status string) (string, error)
+	GetSession(sessionID string) (*Session, error)
+	// ListSessions returns the sessions of a call in the order they were created.
+	ListSessions(callID string) ([]Session, error)
+	// ListActiveSessions returns the active sessions of type typ, oldest first.
+	ListActiveSessions(typ string) ([]Session, error)
+	UpdateSessionStatus(sessionID, status string) error
+	// EndSession ends a session, recording why unless a reason was already recorded.
+	EndSession(sessionID, reason string) error
+	UpdateSessionToken(sessionID, token string) error
+	UpdateSessionMode(sessionID, mode string) error
+	GetSessionToken(sessionID string) (string, error)
+	// FindSessionByIdentity resolves a LiveKit participant identity (the session id) to
+	// its call id and session status.
+	FindSessionByIdentity(identity string) (callID, status string, err error)
+
+	AddTurn(t Turn) (int64, error)
+	ListTurns(callID string) ([]Turn, error)
+
+	CreateRecording(r Recording) (string, error)
+	FinishRecording(id string, size int64, duration time.Duration, status string) error
+	// GetCallRecording returns the latest ready recording of a call.
+	GetCallRecording(callID string) (*Recording, error)
+	ListRecordings(callID string) ([]Recording, error)
+
+	// ExpiredCalls and PurgeCall enforce retention policies; see Store.
+	ExpiredCalls(class, action string, cutoff time.Time, limit int) ([]string, error)
+	PurgeCall(callID, class, action string, cutoff time.Time) (int64, error)
+	ListPurgeAudit(since time.Time, afterID int64, limit int) ([]PurgeRecord, error)
+
+	// Human agents and the queues calls wait in for them; see the acd package.
+	CreateHumanAgent(a HumanAgent) (string, error)
+	GetHumanAgent(id string) (*HumanAgent, error)
+	ListHumanAgents() ([]HumanAgent, error)
+	UpdateHumanAgentState(id, state string) error
+	SaveQueue(q Queue) error
+	GetQueue(name string) (*Queue, error)
+	ListQueues() ([]Queue, error)
+	UpdateCallQueue(callID, queue string) error
+
+	Close() error
+}
+
+var _ Repository = (*Store)(nil)
@@ -0,0 +1,465 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRepository_SQLite(t *testing.T) {
+	s, err := Open(DriverSQLite, filepath.Join(t.TempDir(), "calls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testRepository(t, s)
+	testCallQueries(t, s)
+	testRetention(t, s)
+	testACD(t, s)
+}
+
+// TestRepository_Postgres runs against the database in STORE_TEST_POSTGRES_DSN, e.g.
+// postgres://postgres@localhost/callcenter_test?sslmode=disable. The database should be a
+// scratch one: the test drops its tables when done.
+func TestRepository_Postgres(t *testing.T) {
+	dsn := os.Getenv("STORE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("STORE_TEST_POSTGRES_DSN not set")
+	}
+	s, err := Open(DriverPostgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	defer func() {
+		if err := s.MigrateDown(0); err != nil {
+			t.Errorf("MigrateDown: %v", err)
+		}
+	}()
+	testRepository(t, s)
+	testCallQueries(t, s)
+	testRetention(t, s)
+	testACD(t, s)
+}
+
+func testRepository(t *testing.T, repo Repository) {
+	callID, callerSession, err := repo.CreateCall("alice")
+	if err != nil {
+		t.Fatalf("CreateCall: %v", err)
+	}
+	if ok, err := repo.CallExists(callID); err != nil || !ok {
+		t.Fatalf("CallExists = %v, %v", ok, err)
+	}
+	if ok, err := repo.CallExists("missing"); err != nil || ok {
+		t.Fatalf("CallExists(missing) = %v, %v", ok, err)
+	}
+
+	sess, err := repo.GetSession(callerSession)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if sess.CallID != callID || sess.UserID != "alice" || sess.Type != "caller" || sess.Status != "new" || sess.CreatedAt.IsZero() || !sess.EndedAt.IsZero() {
+		t.Fatalf("caller session = %+v", sess)
+	}
+	if _, err := repo.GetSession("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetSession(missing) error = %v, want ErrNotFound", err)
+	}
+
+	agentSession, err := repo.CreateSession(callID, "ai-agent", "agent", "new")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := repo.UpdateSessionToken(agentSession, "tok"); err != nil {
+		t.Fatalf("UpdateSessionToken: %v", err)
+	}
+	if token, err := repo.GetSessionToken(agentSession); err != nil || token != "tok" {
+		t.Fatalf("GetSessionToken = %q, %v", token, err)
+	}
+	if token, err := repo.GetSessionToken(callerSession); err != nil || token != "" {
+		t.Fatalf("GetSessionToken without token = %q, %v", token, err)
+	}
+	if err := repo.UpdateSessionMode(agentSession, "listen"); err != nil {
+		t.Fatalf("UpdateSessionMode: %v", err)
+	}
+	if sess, err := repo.GetSession(agentSession); err != nil || sess.Mode != "listen" {
+		t.Fatalf("session with mode = %+v, %v", sess, err)
+	}
+	if err := repo.UpdateSessionMode("missing", "listen"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateSessionMode(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := repo.UpdateSessionStatus(agentSession, "active"); err != nil {
+		t.Fatalf("UpdateSessionStatus: %v", err)
+	}
+	if gotCall, status, err := repo.FindSessionByIdentity(agentSession); err != nil || gotCall != callID || status != "active" {
+		t.Fatalf("FindSessionByIdentity = %q, %q, %v", gotCall, status, err)
+	}
+	if _, _, err := repo.FindSessionByIdentity("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("FindSessionByIdentity(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := repo.UpdateSessionStatus("missing", "ended"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateSessionStatus(missing) error = %v, want ErrNotFound", err)
+	}
+	if active, err := repo.ListActiveSessions("agent"); err != nil || len(active) != 1 || active[0].ID != agentSession {
+		t.Fatalf("ListActiveSessions(agent) = %+v, %v", active, err)
+	}
+	if err := repo.EndSession(agentSession, "room_closed"); err != nil {
+		t.Fatalf("EndSession: %v", err)
+	}
+	// the first reason sticks
+	if err := repo.EndSession(agentSession, "join_failed"); err != nil {
+		t.Fatalf("EndSession again: %v", err)
+	}
+	if sess, err := repo.GetSession(agentSession); err != nil || sess.Status != "ended" || sess.EndReason != "room_closed" || sess.EndedAt.IsZero() {
+		t.Fatalf("ended session = %+v, %v", sess, err)
+	}
+	if active, err := repo.ListActiveSessions("agent"); err != nil || len(active) != 0 {
+		t.Fatalf("ListActiveSessions(agent) after end = %+v, %v", active, err)
+	}
+	if err := repo.EndSession("missing", "room_closed"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("EndSession(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := repo.UpdateCallStatus(callID, "ended"); err != nil {
+		t.Fatalf("UpdateCallStatus: %v", err)
+	}
+	if err := repo.UpdateCallStatus("missing", "ended"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateCallStatus(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := repo.EndCall(callID, "room_closed"); err != nil {
+		t.Fatalf("EndCall: %v", err)
+	}
+	if c, err := repo.GetCall(callID); err != nil || c.Status != "ended" || c.EndReason != "room_closed" {
+		t.Fatalf("ended call = %+v, %v", c, err)
+	}
+	if err := repo.EndCall("missing", "room_closed"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("EndCall(missing) error = %v, want ErrNotFound", err)
+	}
+
+	start := time.UnixMilli(1767348000000)
+	first, err := repo.AddTurn(Turn{CallID: callID, SessionID: agentSession, Speaker: SpeakerAgent, Text: "How can I help?", StartedAt: start.Add(2 * time.Second), EndedAt: start.Add(3 * time.Second), LLMLatency: 400 * time.Millisecond, Interrupted: true})
+	if err != nil {
+		t.Fatalf("AddTurn: %v", err)
+	}
+	second, err := repo.AddTurn(Turn{CallID: callID, SessionID: callerSession, Speaker: SpeakerCaller, Text: "Hi", Confidence: 0.5, StartedAt: start, EndedAt: start.Add(time.Second), STTLatency: 250 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("AddTurn: %v", err)
+	}
+	if first == 0 || second <= first {
+		t.Fatalf("turn ids %d, %d", first, second)
+	}
+	turns, err := repo.ListTurns(callID)
+	if err != nil {
+		t.Fatalf("ListTurns: %v", err)
+	}
+	if len(turns) != 2 {
+		t.Fatalf("got %d turns, want 2", len(turns))
+	}
+	caller, agent := turns[0], turns[1]
+	if caller.ID != second || caller.Text != "Hi" || caller.Confidence != 0.5 || !caller.StartedAt.Equal(start) || caller.STTLatency != 250*time.Millisecond || caller.Interrupted {
+		t.Fatalf("caller turn = %+v", caller)
+	}
+	if agent.ID != first || agent.Speaker != SpeakerAgent || agent.LLMLatency != 400*time.Millisecond || !agent.Interrupted {
+		t.Fatalf("agent turn = %+v", agent)
+	}
+	if _, err := repo.AddTurn(Turn{Speaker: SpeakerCaller}); err == nil {
+		t.Fatal("AddTurn without call id: expected error")
+	}
+
+	if _, err := repo.GetCallRecording(callID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetCallRecording without recordings error = %v, want ErrNotFound", err)
+	}
+	recID, err := repo.CreateRecording(Recording{CallID: callID, Key: callID + "/a.wav", ContentType: "audio/wav", Channels: 2, SampleRate: 16000})
+	if err != nil {
+		t.Fatalf("CreateRecording: %v", err)
+	}
+	if _, err := repo.GetCallRecording(callID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetCallRecording while recording error = %v, want ErrNotFound", err)
+	}
+	if err := repo.FinishRecording(recID, 1044, 1500*time.Millisecond, RecordingReady); err != nil {
+		t.Fatalf("FinishRecording: %v", err)
+	}
+	rec, err := repo.GetCallRecording(callID)
+	if err != nil {
+		t.Fatalf("GetCallRecording: %v", err)
+	}
+	if rec.ID != recID || rec.Key != callID+"/a.wav" || rec.Channels != 2 || rec.SizeBytes != 1044 || rec.Duration != 1500*time.Millisecond || rec.Status != RecordingReady || rec.EndedAt.IsZero() {
+		t.Fatalf("recording = %+v", rec)
+	}
+	if err := repo.FinishRecording("missing", 0, 0, RecordingFailed); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("FinishRecording(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+// testCallQueries lists calls with filters and pages; it expects no calls besides those of
+// testRepository, which belong to alice.
+func testCallQueries(t *testing.T, s *Store) {
+	base := time.Unix(1767348000, 0)
+	var ids []string
+	for i, caller := range []string{"bob", "carol", "bob", "bob", "carol"} {
+		callID, sessionID, err := s.CreateCall(caller)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// spread the calls a minute apart
+		if _, err := s.q().Exec(`UPDATE calls SET created_at = ? WHERE id = ?`, base.Add(time.Duration(i)*time.Minute).Unix(), callID); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			if err := s.UpdateSessionStatus(sessionID, "ended"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.UpdateCallStatus(callID, "ended"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids = append(ids, callID)
+	}
+
+	c, err := s.GetCall(ids[0])
+	if err != nil {
+		t.Fatalf("GetCall: %v", err)
+	}
+	if c.CallerID != "bob" || c.Status != "ended" || !c.CreatedAt.Equal(base) || c.EndedAt.IsZero() {
+		t.Fatalf("call = %+v", c)
+	}
+	if _, err := s.GetCall("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetCall(missing) error = %v, want ErrNotFound", err)
+	}
+	sessions, err := s.ListSessions(ids[0])
+	if err != nil || len(sessions) != 1 || sessions[0].Type != "caller" || sessions[0].EndedAt.IsZero() {
+		t.Fatalf("ListSessions = %+v, %v", sessions, err)
+	}
+
+	list := func(f CallFilter) []string {
+		t.Helper()
+		var got []string
+		for pages := 0; ; pages++ {
+			calls, next, err := s.ListCalls(f)
+			if err != nil {
+				t.Fatalf("ListCalls(%+v): %v", f, err)
+			}
+			if f.Limit > 0 && len(calls) > f.Limit {
+				t.Fatalf("page of %d calls, limit %d", len(calls), f.Limit)
+			}
+			for _, c := range calls {
+				got = append(got, c.ID)
+			}
+			if next == "" {
+				return got
+			}
+			if pages > 10 {
+				t.Fatal("too many pages")
+			}
+			f.Cursor = next
+		}
+	}
+	if got, want := list(CallFilter{CallerID: "bob", Limit: 2}), []string{ids[3], ids[2], ids[0]}; !slices.Equal(got, want) {
+		t.Fatalf("bob's calls newest first = %v, want %v", got, want)
+	}
+	if got, want := list(CallFilter{CallerID: "bob", Ascending: true, Limit: 1}), []string{ids[0], ids[2], ids[3]}; !slices.Equal(got, want) {
+		t.Fatalf("bob's calls oldest first = %v, want %v", got, want)
+	}
+	if got, want := list(CallFilter{Status: "ended", From: base, To: base.Add(time.Hour), Limit: 1}), []string{ids[1], ids[0]}; !slices.Equal(got, want) {
+		t.Fatalf("ended calls = %v, want %v", got, want)
+	}
+	if got, want := list(CallFilter{From: base.Add(time.Minute), To: base.Add(4 * time.Minute), Ascending: true}), ids[1:4]; !slices.Equal(got, want) {
+		t.Fatalf("calls in range = %v, want %v", got, want)
+	}
+	if _, _, err := s.ListCalls(CallFilter{Cursor: "not a cursor"}); !errors.Is(err, ErrBadCursor) {
+		t.Fatalf("bad cursor error = %v, want ErrBadCursor", err)
+	}
+}
+
+// testRetention purges calls from 2020, older than any other test data.
+func testRetention(t *testing.T, s *Store) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cutoff := old.Add(24 * time.Hour)
+	var calls []string
+	for i := 0; i < 3; i++ {
+		callID, sessionID, err := s.CreateCall("dave")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpdateSessionToken(sessionID, "tok"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.AddTurn(Turn{CallID: callID, SessionID: sessionID, Speaker: SpeakerCaller, Text: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+		recID, err := s.CreateRecording(Recording{CallID: callID, Key: "recordings/" + callID + "/a.wav", ContentType: "audio/wav", Channels: 1, SampleRate: 16000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.FinishRecording(recID, 44, 0, RecordingReady); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpdateCallStatus(callID, "ended"); err != nil {
+			t.Fatal(err)
+		}
+		calls = append(calls, callID)
+	}
+	// the first two calls ended in 2020; the last one started then but never ended
+	for i, callID := range calls {
+		ended := any(old.Add(time.Duration(i) * time.Hour).Unix())
+		if i == 2 {
+			ended = nil
+		}
+		if _, err := s.q().Exec(`UPDATE calls SET created_at = ?, ended_at = ? WHERE id = ?`, old.Unix(), ended, callID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expired := func(class, action string) []string {
+		t.Helper()
+		ids, err := s.ExpiredCalls(class, action, cutoff, 10)
+		if err != nil {
+			t.Fatalf("ExpiredCalls(%s, %s): %v", class, action, err)
+		}
+		return ids
+	}
+	purge := func(callID, class, action string, want int64) {
+		t.Helper()
+		if n, err := s.PurgeCall(callID, class, action, cutoff); err != nil || n != want {
+			t.Fatalf("PurgeCall(%s, %s) = %d, %v; want %d", class, action, n, err, want)
+		}
+	}
+	if got := expired(DataTokens, PurgeDelete); !slices.Equal(got, []string{calls[2], calls[0], calls[1]}) && !slices.Equal(got, []string{calls[0], calls[2], calls[1]}) {
+		t.Fatalf("calls with expired tokens = %v, want %v", got, calls)
+	}
+	if ids, err := s.ExpiredCalls(DataTokens, PurgeDelete, old, 10); err != nil || len(ids) != 0 {
+		t.Fatalf("calls expired before 2020 = %v, %v", ids, err)
+	}
+	if _, err := s.ExpiredCalls(DataTranscripts, PurgeAnonymize, cutoff, 10); err == nil {
+		t.Fatal("anonymizing transcripts: expected error")
+	}
+
+	purge(calls[0], DataTokens, PurgeDelete, 1)
+	purge(calls[0], DataTokens, PurgeDelete, 0)
+	if token, err := s.GetSessionToken(mustCallerSession(t, s, calls[0])); err != nil || token != "" {
+		t.Fatalf("purged token = %q, %v", token, err)
+	}
+	purge(calls[0], DataTranscripts, PurgeDelete, 1)
+	if turns, err := s.ListTurns(calls[0]); err != nil || len(turns) != 0 {
+		t.Fatalf("purged turns = %+v, %v", turns, err)
+	}
+	purge(calls[0], DataRecordings, PurgeDelete, 1)
+	if _, err := s.GetCallRecording(calls[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("purged recording: %v", err)
+	}
+	purge(calls[0], DataMetadata, PurgeAnonymize, 2)
+	if c, err := s.GetCall(calls[0]); err != nil || c.CallerID != "" {
+		t.Fatalf("anonymized call = %+v, %v", c, err)
+	}
+	if sess, err := s.GetSession(mustCallerSession(t, s, calls[0])); err != nil || sess.UserID != "" {
+		t.Fatalf("anonymized session = %+v, %v", sess, err)
+	}
+	if got := expired(DataMetadata, PurgeAnonymize); slices.Contains(got, calls[0]) || len(got) != 2 {
+		t.Fatalf("calls to anonymize = %v", got)
+	}
+
+	// deleting metadata takes the rest of the call with it
+	purge(calls[1], DataMetadata, PurgeDelete, 4)
+	if _, err := s.GetCall(calls[1]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted call: %v", err)
+	}
+	if recs, err := s.ListRecordings(calls[1]); err != nil || len(recs) != 0 {
+		t.Fatalf("recordings of deleted call = %+v, %v", recs, err)
+	}
+
+	audit, err := s.ListPurgeAudit(time.Now().Add(-time.Minute), 0, 10)
+	if err != nil {
+		t.Fatalf("ListPurgeAudit: %v", err)
+	}
+	if len(audit) != 5 {
+		t.Fatalf("got %d audit records, want 5: %+v", len(audit), audit)
+	}
+	last := audit[4]
+	if last.CallID != calls[1] || last.DataClass != DataMetadata || last.Action != PurgeDelete || last.Items != 4 || !last.Cutoff.Equal(cutoff) || last.PurgedAt.IsZero() {
+		t.Fatalf("audit record = %+v", last)
+	}
+	if page, err := s.ListPurgeAudit(time.Time{}, audit[3].ID, 10); err != nil || len(page) != 1 || page[0].ID != last.ID {
+		t.Fatalf("audit after %d = %+v, %v", audit[3].ID, page, err)
+	}
+}
+
+func testACD(t *testing.T, s *Store) {
+	id, err := s.CreateHumanAgent(HumanAgent{Name: "Hana", Skills: []string{"spanish", " billing", "billing"}})
+	if err != nil {
+		t.Fatalf("CreateHumanAgent: %v", err)
+	}
+	a, err := s.GetHumanAgent(id)
+	if err != nil || a.Name != "Hana" || a.State != HumanAway || !slices.Equal(a.Skills, []string{"billing", "spanish"}) || a.StateSince.IsZero() {
+		t.Fatalf("GetHumanAgent = %+v, %v", a, err)
+	}
+	if _, err := s.CreateHumanAgent(HumanAgent{ID: "ivo", Name: "Ivo", State: HumanAvailable}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateHumanAgentState(id, HumanAvailable); err != nil {
+		t.Fatalf("UpdateHumanAgentState: %v", err)
+	}
+	if err := s.UpdateHumanAgentState("missing", HumanAvailable); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateHumanAgentState(missing) error = %v, want ErrNotFound", err)
+	}
+	agents, err := s.ListHumanAgents()
+	if err != nil || len(agents) != 2 || agents[0].State != HumanAvailable || agents[1].State != HumanAvailable || agents[1].Skills != nil {
+		t.Fatalf("ListHumanAgents = %+v, %v", agents, err)
+	}
+	if _, err := s.GetHumanAgent("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetHumanAgent(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.SaveQueue(Queue{Name: "support", Skills: []string{"billing"}, Timeout: time.Minute, Fallback: "ai"}); err != nil {
+		t.Fatalf("SaveQueue: %v", err)
+	}
+	if err := s.SaveQueue(Queue{Name: "support", Timeout: 2 * time.Minute, WrapUp: 10 * time.Second, Fallback: "hangup"}); err != nil {
+		t.Fatalf("SaveQueue again: %v", err)
+	}
+	if err := s.SaveQueue(Queue{Name: "sales"}); err != nil {
+		t.Fatal(err)
+	}
+	q, err := s.GetQueue("support")
+	if err != nil || q.Skills != nil || q.Timeout != 2*time.Minute || q.WrapUp != 10*time.Second || q.Fallback != "hangup" {
+		t.Fatalf("GetQueue = %+v, %v", q, err)
+	}
+	if queues, err := s.ListQueues(); err != nil || len(queues) != 2 || queues[0].Name != "sales" {
+		t.Fatalf("ListQueues = %+v, %v", queues, err)
+	}
+	if _, err := s.GetQueue("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetQueue(missing) error = %v, want ErrNotFound", err)
+	}
+
+	callID, _, err := s.CreateCall("judy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateCallQueue(callID, "support"); err != nil {
+		t.Fatalf("UpdateCallQueue: %v", err)
+	}
+	if c, err := s.GetCall(callID); err != nil || c.Queue != "support" {
+		t.Fatalf("queued call = %+v, %v", c, err)
+	}
+	if err := s.UpdateCallQueue("missing", "support"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateCallQueue(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func mustCallerSession(t *testing.T, s *Store, callID string) string {
+	t.Helper()
+	sessions, err := s.ListSessions(callID)
+	if err != nil || len(sessions) == 0 {
+		t.Fatalf("sessions of %s = %+v, %v", callID, sessions, err)
+	}
+	return sessions[0].ID
+}
+
+func TestRebind(t *testing.T) {
+	q := `UPDATE sessions SET status = ? WHERE id = ?`
+	if got := sqliteDialect.rebind(q); got != q {
+		t.Fatalf("sqlite rebind = %q", got)
+	}
+	if got, want := postgresDialect.rebind(q), `UPDATE sessions SET status = $1 WHERE id = $2`; got != want {
+		t.Fatalf("postgres rebind = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Data classes of a call that retention policies apply to.
+const (
+	// DataMetadata is the call and its sessions.
+	DataMetadata = "metadata"
+	// DataTranscripts is the call's turns.
+	DataTranscripts = "transcripts"
+	// DataRecordings is the call's finished recordings; their files are deleted by the caller.
+	DataRecordings = "recordings"
+	// DataTokens is the LiveKit tokens stored with the call's sessions.
+	DataTokens = "tokens"
+)
+
+// Purge actions. Only metadata can be anonymized: the caller's identity is blanked and the
+// rest of the call kept.
+const (
+	PurgeDelete    = "delete"
+	PurgeAnonymize = "anonymize"
+)
+
+// PurgeRecord is an entry of the audit trail of purged data.
+type PurgeRecord struct {
+	ID        int64
+	PurgedAt  time.Time
+	CallID    string
+	DataClass string
+	Action    string
+	// Items is the number of rows deleted or anonymized.
+	Items int64
+	// Cutoff is the time the call had to end before for its data to expire.
+	Cutoff time.Time
+}
+
+// callAge is the time a call's data starts to age: its end, or its start if it never ended.
+const callAge = `COALESCE(c.ended_at, c.created_at)`
+
+// ExpiredCalls returns up to limit calls older than cutoff that still hold data of class
+// for action to purge, oldest first.
+func (s *Store) ExpiredCalls(class, action string, cutoff time.Time, limit int) ([]string, error) {
+	var holds string
+	switch class + "/" + action {
+	case DataMetadata + "/" + PurgeDelete:
+		holds = `1 = 1`
+	case DataMetadata + "/" + PurgeAnonymize:
+		holds = `(c.caller_id <> '' OR EXISTS (SELECT 1 FROM sessions s WHERE s.call_id = c.id AND s.type = 'caller' AND s.user_id <> ''))`
+	case DataTranscripts + "/" + PurgeDelete:
+		holds = `EXISTS (SELECT 1 FROM turns t WHERE t.call_id = c.id)`
+	case DataRecordings + "/" + PurgeDelete:
+		holds = `EXISTS (SELECT 1 FROM recordings r WHERE r.call_id = c.id AND r.status <> '` + RecordingActive + `')`
+	case DataTokens + "/" + PurgeDelete:
+		holds = `EXISTS (SELECT 1 FROM sessions s WHERE s.call_id = c.id AND s.token <> '')`
+	default:
+		return nil, fmt.Errorf("cannot %s %s", action, class)
+	}
+	rows, err := s.q().Query(`SELECT c.id FROM calls c WHERE `+callAge+` < ? AND `+holds+` ORDER BY `+callAge+`, c.id LIMIT ?`, cutoff.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PurgeCall deletes or anonymizes the call's data of class and adds it to the audit trail,
+// in one transaction. It returns the number of rows purged; nothing is audited if there
+// were none. Deleting metadata deletes all data of the call, so the files of its recordings
+// must be deleted first.
+func (s *Store) PurgeCall(callID, class, action string, cutoff time.Time) (int64, error) {
+	var stmts []string
+	switch class + "/" + action {
+	case DataMetadata + "/" + PurgeDelete:
+		stmts = []string{
+			`DELETE FROM turns WHERE call_id = ?`,
+			`DELETE FROM recordings WHERE call_id = ?`,
+			`DELETE FROM sessions WHERE call_id = ?`,
+			`DELETE FROM calls WHERE id = ?`,
+		}
+	case DataMetadata + "/" + PurgeAnonymize:
+		stmts = []string{
+			`UPDATE calls SET caller_id = '' WHERE id = ? AND caller_id <> ''`,
+			`UPDATE sessions SET user_id = '' WHERE call_id = ? AND type = 'caller' AND user_id <> ''`,
+		}
+	case DataTranscripts + "/" + PurgeDelete:
+		stmts = []string{`DELETE FROM turns WHERE call_id = ?`}
+	case DataRecordings + "/" + PurgeDelete:
+		stmts = []string{`DELETE FROM recordings WHERE call_id = ? AND status <> '` + RecordingActive + `'`}
+	case DataTokens + "/" + PurgeDelete:
+		stmts = []string{`UPDATE sessions SET token = NULL WHERE call_id = ? AND token <> ''`}
+	default:
+		return 0, fmt.Errorf("cannot %s %s", action, class)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	q := s.bind(tx)
+	var items int64
+	for _, stmt := range stmts {
+		res, err := q.Exec(stmt, callID)
+		if err != nil {
+			return 0, fmt.Errorf("purge %s of call %s: %w", class, callID, err)
+		}
+		n, _ := res.RowsAffected()
+		items += n
+	}
+	if items == 0 {
+		return 0, nil
+	}
+	if _, err := q.Exec(`INSERT INTO purge_audit(purged_at, call_id, data_class, action, items, cutoff) VALUES(?,?,?,?,?,?)`,
+		time.Now().Unix(), callID, class, action, items, cutoff.Unix()); err != nil {
+		return 0, fmt.Errorf("audit purge of call %s: %w", callID, err)
+	}
+	return items, tx.Commit()
+}
+
+// ListPurgeAudit returns up to limit entries of the audit trail with ids above afterID that
+// were purged at or after since, oldest first.
+func (s *Store) ListPurgeAudit(since time.Time, afterID int64, limit int) ([]PurgeRecord, error) {
+	rows, err := s.q().Query(`SELECT id, purged_at, call_id, data_class, action, items, cutoff FROM purge_audit WHERE purged_at >= ? AND id > ? ORDER BY id LIMIT ?`,
+		since.Unix(), afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []PurgeRecord
+	for rows.Next() {
+		var r PurgeRecord
+		var purged, cutoff int64
+		if err := rows.Scan(&r.ID, &purged, &r.CallID, &r.DataClass, &r.Action, &r.Items, &cutoff); err != nil {
+			return nil, err
+		}
+		r.PurgedAt, r.Cutoff = time.Unix(purged, 0), time.Unix(cutoff, 0)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
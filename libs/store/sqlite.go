@@ -0,0 +1,35 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// DriverSQLite stores everything in a single SQLite file; the DSN is its path.
+const DriverSQLite = "sqlite"
+
+// sqliteBusyTimeout is how many milliseconds a write waits for that of another connection
+// instead of failing with SQLITE_BUSY.
+const sqliteBusyTimeout = 5000
+
+var sqliteDialect = dialect{
+	driver:        DriverSQLite,
+	tableExists:   `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+	columnExists:  `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+	baselineTable: "calls",
+	dsn:           sqliteDSN,
+}
+
+// sqliteDSN sets the busy timeout of the connections to dsn unless it sets one itself.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "busy_timeout") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "_pragma=busy_timeout(" + strconv.Itoa(sqliteBusyTimeout) + ")"
+}
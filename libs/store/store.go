@@ -7,50 +7,64 @@ import (
 	"errors"
 	"fmt"
 	"time"
-
-	_ "modernc.org/sqlite"
 )
 
+// Store is the SQL implementation of Repository.
 type Store struct {
-	DB *sql.DB
+	db      *sql.DB
+	dialect dialect
 }
 
-func Open(path string) (*Store, error) {
-	db, err := sql.Open("sqlite", path)
+// Open connects to the database of driver (DriverSQLite or DriverPostgres) at dsn and
+// applies any pending schema migrations.
+func Open(driver, dsn string) (*Store, error) {
+	s, err := OpenUnmigrated(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
-	s := &Store{DB: db}
-	if err := s.migrate(); err != nil {
-		return nil, err
+	if err := s.MigrateUp(0); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrate %s database: %w", driver, err)
 	}
 	return s, nil
 }
 
+// OpenUnmigrated connects to the database without touching its schema.
+func OpenUnmigrated(driver, dsn string) (*Store, error) {
+	d, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	if d.dsn != nil {
+		dsn = d.dsn(dsn)
+	}
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, dialect: d}, nil
+}
+
+// Driver returns the name of the database driver the store runs on.
+func (s *Store) Driver() string {
+	return s.dialect.driver
+}
+
 func (s *Store) Close() error {
-	if s.DB == nil {
+	if s.db == nil {
 		return nil
 	}
-	return s.DB.Close()
+	return s.db.Close()
 }
 
-func (s *Store) migrate() error {
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS users (id TEXT PRIMARY KEY, name TEXT);`,
-		`CREATE TABLE IF NOT EXISTS calls (id TEXT PRIMARY KEY, caller_id TEXT, status TEXT, created_at INTEGER);`,
-		`CREATE TABLE IF NOT EXISTS sessions (id TEXT PRIMARY KEY, call_id TEXT, user_id TEXT, type TEXT, status TEXT, created_at INTEGER);`,
-	}
-	for _, q := range stmts {
-		if _, err := s.DB.Exec(q); err != nil {
-			return err
-		}
-	}
+// q returns the database with its queries rewritten for the store's dialect.
+func (s *Store) q() querier {
+	return s.bind(s.db)
+}
 
-	// Add token column to sessions if not present (SQLite will error if exists; ignore)
-	if _, err := s.DB.Exec(`ALTER TABLE sessions ADD COLUMN token TEXT;`); err != nil {
-		// ignore "duplicate column name" or other errors - simple migration strategy
-	}
-	return nil
+// bind returns q with its queries rewritten for the store's dialect.
+func (s *Store) bind(q querier) querier {
+	return bound{q: q, d: s.dialect}
 }
 
 func genID() (string, error) {
@@ -75,16 +89,17 @@ func (s *Store) CreateCall(callerID string) (string, string, error) {
 		return "", "", err
 	}
 
-	tx, err := s.DB.Begin()
+	tx, err := s.db.Begin()
 	if err != nil {
 		return "", "", err
 	}
 	now := time.Now().Unix()
-	if _, err := tx.Exec(`INSERT INTO calls(id, caller_id, status, created_at) VALUES(?,?,?,?)`, callID, callerID, "new", now); err != nil {
+	q := s.bind(tx)
+	if _, err := q.Exec(`INSERT INTO calls(id, caller_id, status, created_at) VALUES(?,?,?,?)`, callID, callerID, "new", now); err != nil {
 		tx.Rollback()
 		return "", "", err
 	}
-	if _, err := tx.Exec(`INSERT INTO sessions(id, call_id, user_id, type, status, created_at) VALUES(?,?,?,?,?,?)`, sessionID, callID, callerID, "caller", "new", now); err != nil {
+	if _, err := q.Exec(`INSERT INTO sessions(id, call_id, user_id, type, status, created_at) VALUES(?,?,?,?,?,?)`, sessionID, callID, callerID, "caller", "new", now); err != nil {
 		tx.Rollback()
 		return "", "", err
 	}
@@ -100,33 +115,70 @@ func (s *Store) CreateSession(callID, userID, typ, status string) (string, error
 		return "", err
 	}
 	now := time.Now().Unix()
-	if _, err := s.DB.Exec(`INSERT INTO sessions(id, call_id, user_id, type, status, created_at) VALUES(?,?,?,?,?,?)`, id, callID, userID, typ, status, now); err != nil {
+	if _, err := s.q().Exec(`INSERT INTO sessions(id, call_id, user_id, type, status, created_at) VALUES(?,?,?,?,?,?)`, id, callID, userID, typ, status, now); err != nil {
 		return "", err
 	}
 	return id, nil
 }
 
+// GetSession returns the session with the id.
+func (s *Store) GetSession(sessionID string) (*Session, error) {
+	sess, err := scanSession(s.q().QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, sessionID))
+	if err != nil {
+		return nil, notFound(err, "session", sessionID)
+	}
+	return sess, nil
+}
+
+// UpdateSessionStatus sets the status of a session; the first "ended" also records its end.
 func (s *Store) UpdateSessionStatus(sessionID, status string) error {
-	res, err := s.DB.Exec(`UPDATE sessions SET status = ? WHERE id = ?`, status, sessionID)
+	res, err := s.q().Exec(`UPDATE sessions SET status = ?, ended_at = COALESCE(ended_at, ?) WHERE id = ?`, status, endedAt(status), sessionID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
+	}
+	return nil
+}
+
+// EndSession ends a session with reason, like UpdateSessionStatus(sessionID, "ended").
+func (s *Store) EndSession(sessionID, reason string) error {
+	res, err := s.q().Exec(`UPDATE sessions SET status = 'ended', ended_at = COALESCE(ended_at, ?), end_reason = COALESCE(end_reason, ?) WHERE id = ?`,
+		time.Now().Unix(), reason, sessionID)
 	if err != nil {
 		return err
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
-		return fmt.Errorf("session not found: %s", sessionID)
+		return fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
 	}
 	return nil
 }
 
 // UpdateSessionToken stores a token (e.g., LiveKit access token) for the session.
 func (s *Store) UpdateSessionToken(sessionID, token string) error {
-	res, err := s.DB.Exec(`UPDATE sessions SET token = ? WHERE id = ?`, token, sessionID)
+	res, err := s.q().Exec(`UPDATE sessions SET token = ? WHERE id = ?`, token, sessionID)
 	if err != nil {
 		return err
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
-		return fmt.Errorf("session not found: %s", sessionID)
+		return fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
+	}
+	return nil
+}
+
+// UpdateSessionMode records how the participant of a session takes part in the call.
+func (s *Store) UpdateSessionMode(sessionID, mode string) error {
+	res, err := s.q().Exec(`UPDATE sessions SET mode = ? WHERE id = ?`, mode, sessionID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
 	}
 	return nil
 }
@@ -134,9 +186,9 @@ func (s *Store) UpdateSessionToken(sessionID, token string) error {
 // GetSessionToken retrieves the stored token for a session.
 func (s *Store) GetSessionToken(sessionID string) (string, error) {
 	var token sql.NullString
-	row := s.DB.QueryRow(`SELECT token FROM sessions WHERE id = ?`, sessionID)
+	row := s.q().QueryRow(`SELECT token FROM sessions WHERE id = ?`, sessionID)
 	if err := row.Scan(&token); err != nil {
-		return "", err
+		return "", notFound(err, "session", sessionID)
 	}
 	if token.Valid {
 		return token.String, nil
@@ -144,14 +196,29 @@ func (s *Store) GetSessionToken(sessionID string) (string, error) {
 	return "", nil
 }
 
+// UpdateCallStatus sets the status of a call; the first "ended" also records its end.
 func (s *Store) UpdateCallStatus(callID, status string) error {
-	res, err := s.DB.Exec(`UPDATE calls SET status = ? WHERE id = ?`, status, callID)
+	res, err := s.q().Exec(`UPDATE calls SET status = ?, ended_at = COALESCE(ended_at, ?) WHERE id = ?`, status, endedAt(status), callID)
 	if err != nil {
 		return err
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
-		return fmt.Errorf("call not found: %s", callID)
+		return fmt.Errorf("call %s: %w", callID, ErrNotFound)
+	}
+	return nil
+}
+
+// EndCall ends a call with reason, like UpdateCallStatus(callID, "ended").
+func (s *Store) EndCall(callID, reason string) error {
+	res, err := s.q().Exec(`UPDATE calls SET status = 'ended', ended_at = COALESCE(ended_at, ?), end_reason = COALESCE(end_reason, ?) WHERE id = ?`,
+		time.Now().Unix(), reason, callID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("call %s: %w", callID, ErrNotFound)
 	}
 	return nil
 }
@@ -159,9 +226,25 @@ func (s *Store) UpdateCallStatus(callID, status string) error {
 func (s *Store) FindSessionByIdentity(identity string) (string, string, error) {
 	// identity is session id which maps to sessions.id
 	var callID, status string
-	row := s.DB.QueryRow(`SELECT call_id, status FROM sessions WHERE id = ?`, identity)
+	row := s.q().QueryRow(`SELECT call_id, status FROM sessions WHERE id = ?`, identity)
 	if err := row.Scan(&callID, &status); err != nil {
-		return "", "", err
+		return "", "", notFound(err, "session", identity)
 	}
 	return callID, status, nil
 }
+
+// notFound reports a missing row as ErrNotFound.
+func notFound(err error, what, id string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s %s: %w", what, id, ErrNotFound)
+	}
+	return err
+}
+
+// endedAt is the ended_at to record for a new status: now for "ended", else nothing.
+func endedAt(status string) any {
+	if status == "ended" {
+		return time.Now().Unix()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// Speakers of a turn.
+const (
+	SpeakerCaller = "caller"
+	SpeakerAgent  = "agent"
+)
+
+// Turn is one utterance in the conversation of a call: what the caller said, or what the
+// agent replied. Latencies that do not apply to the speaker are zero.
+type Turn struct {
+	ID        int64
+	CallID    string
+	SessionID string
+	Speaker   string
+	Text      string
+	// Confidence is the STT confidence of a caller turn.
+	Confidence float32
+	StartedAt  time.Time
+	EndedAt    time.Time
+	// STTLatency is the time from the end of speech to the final transcript.
+	STTLatency time.Duration
+	// LLMLatency is the time from the transcript to the first sentence of the reply.
+	LLMLatency time.Duration
+	// TTSLatency is the time from the first sentence of the reply to its first audio.
+	TTSLatency time.Duration
+	// Interrupted marks an agent reply the caller talked over; Text is the part that was played.
+	Interrupted bool
+}
+
+// AddTurn appends a turn to the call's transcript and returns its id.
+func (s *Store) AddTurn(t Turn) (int64, error) {
+	if t.CallID == "" {
+		return 0, errors.New("call_id required")
+	}
+	var id int64
+	err := s.q().QueryRow(`INSERT INTO turns(call_id, session_id, speaker, text, confidence, started_at_ms, ended_at_ms, stt_latency_ms, llm_latency_ms, tts_latency_ms, interrupted) VALUES(?,?,?,?,?,?,?,?,?,?,?) RETURNING id`,
+		t.CallID, t.SessionID, t.Speaker, t.Text, t.Confidence, t.StartedAt.UnixMilli(), t.EndedAt.UnixMilli(),
+		t.STTLatency.Milliseconds(), t.LLMLatency.Milliseconds(), t.TTSLatency.Milliseconds(), t.Interrupted).Scan(&id)
+	return id, err
+}
+
+// ListTurns returns the transcript of a call in the order it was spoken.
+func (s *Store) ListTurns(callID string) ([]Turn, error) {
+	rows, err := s.q().Query(`SELECT id, call_id, session_id, speaker, text, confidence, started_at_ms, ended_at_ms, stt_latency_ms, llm_latency_ms, tts_latency_ms, interrupted FROM turns WHERE call_id = ? ORDER BY started_at_ms, id`, callID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var turns []Turn
+	for rows.Next() {
+		var t Turn
+		var started, ended, stt, llm, tts int64
+		if err := rows.Scan(&t.ID, &t.CallID, &t.SessionID, &t.Speaker, &t.Text, &t.Confidence, &started, &ended, &stt, &llm, &tts, &t.Interrupted); err != nil {
+			return nil, err
+		}
+		t.StartedAt = time.UnixMilli(started)
+		t.EndedAt = time.UnixMilli(ended)
+		t.STTLatency = time.Duration(stt) * time.Millisecond
+		t.LLMLatency = time.Duration(llm) * time.Millisecond
+		t.TTSLatency = time.Duration(tts) * time.Millisecond
+		turns = append(turns, t)
+	}
+	return turns, rows.Err()
+}
+
+// CallExists reports whether a call with the id exists.
+func (s *Store) CallExists(callID string) (bool, error) {
+	var n int
+	if err := s.q().QueryRow(`SELECT COUNT(*) FROM calls WHERE id = ?`, callID).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,56 @@
+package vad
+
+import (
+	"math"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+)
+
+// DefaultThreshold is the default minimum speech level in dBFS.
+const DefaultThreshold = -40.0
+
+// silenceLevel is the level reported for digital silence, in dBFS.
+const silenceLevel = -100.0
+
+// Energy is a Detector that classifies frames by loudness. A frame is speech when its RMS
+// level is above Threshold and at least Margin above the background noise floor. The floor
+// follows quieter frames immediately and louder ones slowly, so steady noise such as a fan
+// or line hum stops counting as speech after a few seconds.
+type Energy struct {
+	Threshold float64 // dBFS
+	Margin    float64 // dB
+	floor     float64
+}
+
+// NewEnergy returns an energy detector with the given threshold in dBFS.
+func NewEnergy(threshold float64) *Energy {
+	return &Energy{Threshold: threshold, Margin: 6, floor: silenceLevel}
+}
+
+func (e *Energy) IsSpeech(frame []byte) (bool, error) {
+	level := Level(frame)
+	if level < e.floor {
+		e.floor = level
+	} else {
+		e.floor += (level - e.floor) * 0.005
+	}
+	return level >= e.Threshold && level >= e.floor+e.Margin, nil
+}
+
+// Level returns the RMS level of 16-bit PCM in dBFS.
+func Level(pcm []byte) float64 {
+	samples := audio.BytesToSamples(pcm)
+	if len(samples) == 0 {
+		return silenceLevel
+	}
+	var sum float64
+	for _, s := range samples {
+		v := float64(s) / 32768
+		sum += v * v
+	}
+	rms := math.Sqrt(sum / float64(len(samples)))
+	if rms == 0 {
+		return silenceLevel
+	}
+	return math.Max(20*math.Log10(rms), silenceLevel)
+}
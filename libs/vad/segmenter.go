@@ -0,0 +1,173 @@
+package vad
+
+import (
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+)
+
+// Callbacks receive the utterances found by a Segmenter. They are called synchronously from
+// Write and Flush; an error returned by a callback is returned from that call. Nil callbacks
+// are skipped.
+type Callbacks struct {
+	// OnStart is called when speech starts.
+	OnStart func() error
+	// OnAudio receives the audio of the current utterance in order, including pre-roll and hangover.
+	OnAudio func(pcm []byte) error
+	// OnEnd is called at the end of the turn.
+	OnEnd func() error
+}
+
+// Segmenter is an io.Writer that splits SpeechFormat PCM into utterances. Writes may have any
+// length; audio is classified in audio.FrameDuration frames.
+type Segmenter struct {
+	det Detector
+	cb  Callbacks
+
+	minSpeech, hangover, silence, preRoll, maxUtterance int // in frames
+
+	frameBytes int
+	partial    []byte
+	active     bool
+	run        [][]byte // speech frames seen while waiting for minSpeech
+	ring       [][]byte // pre-roll candidates
+	gap        [][]byte // silent frames after the hangover, kept in case speech resumes
+	silent     int      // consecutive silent frames in the current utterance
+	length     int      // frames in the current utterance
+}
+
+// NewSegmenter returns a Segmenter classifying frames with d. Invalid configs fall back to
+// DefaultConfig.
+func NewSegmenter(d Detector, cfg Config, cb Callbacks) *Segmenter {
+	if cfg.Validate() != nil {
+		cfg = DefaultConfig()
+	}
+	return &Segmenter{
+		det:          d,
+		cb:           cb,
+		minSpeech:    max(frames(cfg.MinSpeech), 1),
+		hangover:     frames(cfg.Hangover),
+		silence:      frames(cfg.Silence),
+		preRoll:      frames(cfg.PreRoll),
+		maxUtterance: frames(cfg.MaxUtterance),
+		frameBytes:   audio.SpeechFormat.FrameBytes(),
+	}
+}
+
+// Write classifies p and reports utterances through the callbacks.
+func (s *Segmenter) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	for len(s.partial) >= s.frameBytes {
+		frame := append([]byte(nil), s.partial[:s.frameBytes]...)
+		s.partial = s.partial[s.frameBytes:]
+		if err := s.frame(frame); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush ends the current utterance, if any, at the end of the input. A trailing partial frame
+// is included in the utterance.
+func (s *Segmenter) Flush() error {
+	rest := s.partial
+	s.partial = nil
+	if !s.active {
+		return nil
+	}
+	if len(rest) > 0 {
+		if err := s.audio(rest); err != nil {
+			return err
+		}
+	}
+	return s.end()
+}
+
+// Active reports whether an utterance is in progress.
+func (s *Segmenter) Active() bool { return s.active }
+
+func (s *Segmenter) frame(frame []byte) error {
+	speech, err := s.det.IsSpeech(frame)
+	if err != nil {
+		return err
+	}
+
+	if !s.active {
+		if !speech {
+			s.ring = append(s.ring, s.run...)
+			s.ring = append(s.ring, frame)
+			s.run = nil
+			if n := len(s.ring) - s.preRoll; n > 0 {
+				s.ring = s.ring[n:]
+			}
+			return nil
+		}
+		s.run = append(s.run, frame)
+		if len(s.run) < s.minSpeech {
+			return nil
+		}
+		return s.start()
+	}
+
+	s.length++
+	if speech {
+		s.silent = 0
+		if err := s.audio(s.gap...); err != nil {
+			return err
+		}
+		s.gap = nil
+		if err := s.audio(frame); err != nil {
+			return err
+		}
+	} else {
+		s.silent++
+		if s.silent <= s.hangover {
+			if err := s.audio(frame); err != nil {
+				return err
+			}
+		} else {
+			s.gap = append(s.gap, frame)
+		}
+		if s.silent >= s.silence {
+			return s.end()
+		}
+	}
+	if s.maxUtterance > 0 && s.length >= s.maxUtterance {
+		return s.end()
+	}
+	return nil
+}
+
+func (s *Segmenter) start() error {
+	s.active = true
+	s.silent = 0
+	s.length = len(s.run)
+	pending := append(s.ring, s.run...)
+	s.ring, s.run = nil, nil
+	if s.cb.OnStart != nil {
+		if err := s.cb.OnStart(); err != nil {
+			return err
+		}
+	}
+	return s.audio(pending...)
+}
+
+func (s *Segmenter) end() error {
+	s.active = false
+	s.gap = nil
+	s.silent = 0
+	s.length = 0
+	if s.cb.OnEnd != nil {
+		return s.cb.OnEnd()
+	}
+	return nil
+}
+
+func (s *Segmenter) audio(frames ...[]byte) error {
+	if s.cb.OnAudio == nil || len(frames) == 0 {
+		return nil
+	}
+	var pcm []byte
+	for _, f := range frames {
+		pcm = append(pcm, f...)
+	}
+	return s.cb.OnAudio(pcm)
+}
@@ -0,0 +1,66 @@
+package vad
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// Transcribe reads SpeechFormat PCM from r, finds the utterances in it with s and transcribes
+// each one with its own stream of stt. Silence between utterances is never sent to STT.
+// It returns one final result per utterance that produced text.
+func Transcribe(ctx context.Context, stt interfaces.STT, s Settings, r io.Reader) ([]interfaces.STTResult, error) {
+	var results []interfaces.STTResult
+	var stream interfaces.STTStream
+	var done chan interfaces.STTResult
+
+	finish := func() error {
+		if stream == nil {
+			return nil
+		}
+		st := stream
+		stream = nil
+		if err := st.Close(); err != nil {
+			<-done
+			return err
+		}
+		res := <-done
+		if err := st.Err(); err != nil {
+			return err
+		}
+		if res.Text != "" {
+			results = append(results, res)
+		}
+		return nil
+	}
+
+	seg := s.NewSegmenter(Callbacks{
+		OnStart: func() error {
+			st, err := stt.RecognizeStream(ctx)
+			if err != nil {
+				return fmt.Errorf("open stt stream: %w", err)
+			}
+			stream = st
+			done = make(chan interfaces.STTResult, 1)
+			go func() { done <- audio.CollectFinal(st, nil) }()
+			return nil
+		},
+		OnAudio: func(pcm []byte) error {
+			return stream.Write(pcm)
+		},
+		OnEnd: finish,
+	})
+
+	if _, err := io.Copy(seg, r); err != nil {
+		_ = finish()
+		return nil, err
+	}
+	if err := seg.Flush(); err != nil {
+		_ = finish()
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,88 @@
+// Package vad finds utterances in a stream of audio.SpeechFormat PCM so that only complete
+// turns of the caller are sent to STT.
+package vad
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+)
+
+// Detector classifies one frame of audio.FrameDuration SpeechFormat PCM as speech or not.
+// Detectors may keep state across frames, so each audio stream needs its own Detector.
+type Detector interface {
+	IsSpeech(frame []byte) (bool, error)
+}
+
+// Model creates a Detector for a new audio stream.
+type Model func() Detector
+
+// Config controls how frame decisions are turned into utterances.
+type Config struct {
+	// MinSpeech is how long speech must last before an utterance starts. Shorter bursts
+	// (clicks, coughs, line noise) are ignored.
+	MinSpeech time.Duration
+	// Hangover is how much audio after the last speech frame still belongs to the utterance,
+	// so trailing consonants and short pauses are not cut off.
+	Hangover time.Duration
+	// Silence is how long the caller must stay silent for the turn to end. It includes the
+	// hangover; if speech resumes earlier, the pause is kept in the utterance.
+	Silence time.Duration
+	// PreRoll is how much audio from before the detected start is included in the utterance.
+	PreRoll time.Duration
+	// MaxUtterance ends an utterance that runs this long. Zero means no limit.
+	MaxUtterance time.Duration
+}
+
+// DefaultConfig returns settings suited to conversational telephone speech.
+func DefaultConfig() Config {
+	return Config{
+		MinSpeech:    100 * time.Millisecond,
+		Hangover:     200 * time.Millisecond,
+		Silence:      700 * time.Millisecond,
+		PreRoll:      200 * time.Millisecond,
+		MaxUtterance: 30 * time.Second,
+	}
+}
+
+// Validate reports whether c is usable.
+func (c Config) Validate() error {
+	if c.MinSpeech < 0 || c.Hangover < 0 || c.PreRoll < 0 || c.MaxUtterance < 0 {
+		return fmt.Errorf("vad: negative duration in %+v", c)
+	}
+	if c.Silence < audio.FrameDuration {
+		return fmt.Errorf("vad: silence must be at least %v", audio.FrameDuration)
+	}
+	if c.Hangover > c.Silence {
+		return fmt.Errorf("vad: hangover %v exceeds silence %v", c.Hangover, c.Silence)
+	}
+	return nil
+}
+
+// Settings selects the detector and segmentation used for calls.
+type Settings struct {
+	Model  Model
+	Config Config
+}
+
+// Default returns the energy detector with DefaultConfig.
+func Default() Settings {
+	return Settings{
+		Model:  func() Detector { return NewEnergy(DefaultThreshold) },
+		Config: DefaultConfig(),
+	}
+}
+
+// NewSegmenter returns a Segmenter for a new audio stream. A zero Settings uses Default.
+func (s Settings) NewSegmenter(cb Callbacks) *Segmenter {
+	if s.Model == nil {
+		s = Default()
+	}
+	return NewSegmenter(s.Model(), s.Config, cb)
+}
+
+// frames converts d to a number of frames, rounding up.
+func frames(d time.Duration) int {
+	return int((d + audio.FrameDuration - 1) / audio.FrameDuration)
+}
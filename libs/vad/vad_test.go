@@ -0,0 +1,159 @@
+package vad
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+func tone(d time.Duration) []byte { return toneAt(d, 8000) }
+
+func toneAt(d time.Duration, amplitude float64) []byte {
+	n := int(d.Seconds() * float64(audio.SpeechFormat.SampleRate))
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(amplitude * math.Sin(2*math.Pi*440*float64(i)/float64(audio.SpeechFormat.SampleRate)))
+	}
+	return audio.SamplesToBytes(samples)
+}
+
+func silence(d time.Duration) []byte {
+	return make([]byte, int(d.Seconds()*float64(audio.SpeechFormat.BytesPerSecond())))
+}
+
+func concat(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+func bytesFor(d time.Duration) int {
+	return int(d.Seconds() * float64(audio.SpeechFormat.BytesPerSecond()))
+}
+
+func TestSegmenter_FindsUtterance(t *testing.T) {
+	input := concat(
+		silence(500*time.Millisecond),
+		tone(60*time.Millisecond), // click: shorter than MinSpeech
+		silence(500*time.Millisecond),
+		tone(time.Second),
+		silence(300*time.Millisecond), // pause: shorter than Silence
+		tone(500*time.Millisecond),
+		silence(time.Second),
+	)
+
+	var starts, ends int
+	var utterance []byte
+	seg := Default().NewSegmenter(Callbacks{
+		OnStart: func() error { starts++; return nil },
+		OnAudio: func(pcm []byte) error { utterance = append(utterance, pcm...); return nil },
+		OnEnd:   func() error { ends++; return nil },
+	})
+	// odd write sizes must not matter
+	for i := 0; i < len(input); i += 777 {
+		if _, err := seg.Write(input[i:min(i+777, len(input))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := seg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if starts != 1 || ends != 1 {
+		t.Fatalf("starts=%d ends=%d, want 1 and 1", starts, ends)
+	}
+	// pre-roll + speech + pause + speech + hangover
+	want := bytesFor(200*time.Millisecond + time.Second + 300*time.Millisecond + 500*time.Millisecond + 200*time.Millisecond)
+	if len(utterance) != want {
+		t.Fatalf("utterance is %v, want %v", pcmDuration(len(utterance)), pcmDuration(want))
+	}
+}
+
+func TestSegmenter_MaxUtterance(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxUtterance = time.Second
+	var ends int
+	seg := NewSegmenter(NewEnergy(DefaultThreshold), cfg, Callbacks{OnEnd: func() error { ends++; return nil }})
+	if _, err := seg.Write(tone(2500 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := seg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ends != 3 {
+		t.Fatalf("ends = %d, want 3", ends)
+	}
+}
+
+func TestTranscribe_OneStreamPerUtterance(t *testing.T) {
+	input := concat(silence(time.Second), tone(time.Second), silence(time.Second), tone(600*time.Millisecond), silence(2*time.Second))
+	stt := &recordingSTT{}
+	results, err := Transcribe(context.Background(), stt, Default(), bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for i, sent := range stt.sent {
+		if sent > bytesFor(1400*time.Millisecond) {
+			t.Fatalf("stream %d got %v of audio, expected only the utterance", i, pcmDuration(sent))
+		}
+	}
+}
+
+func TestEnergy_AdaptsToNoise(t *testing.T) {
+	e := NewEnergy(-60)
+	noise := toneAt(audio.FrameDuration, 100) // about -50 dBFS
+	first, _ := e.IsSpeech(noise)
+	if !first {
+		t.Fatalf("expected noise above threshold to count as speech at first")
+	}
+	var last bool
+	for i := 0; i < 1000; i++ {
+		last, _ = e.IsSpeech(noise)
+	}
+	if last {
+		t.Fatalf("steady noise still detected as speech after 20s")
+	}
+	if speech, _ := e.IsSpeech(tone(audio.FrameDuration)); !speech {
+		t.Fatalf("loud tone over noise not detected")
+	}
+}
+
+func pcmDuration(n int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(audio.SpeechFormat.BytesPerSecond())
+}
+
+type recordingSTT struct {
+	sent []int
+}
+
+func (r *recordingSTT) Recognize(ctx context.Context, audio []byte, opts ...interfaces.STTOption) (string, float32, error) {
+	return "", 0, nil
+}
+
+func (r *recordingSTT) RecognizeStream(ctx context.Context, opts ...interfaces.STTOption) (interfaces.STTStream, error) {
+	r.sent = append(r.sent, 0)
+	return &recordingStream{stt: r, idx: len(r.sent) - 1, results: make(chan interfaces.STTResult, 1)}, nil
+}
+
+type recordingStream struct {
+	stt     *recordingSTT
+	idx     int
+	results chan interfaces.STTResult
+}
+
+func (s *recordingStream) Write(pcm []byte) error { s.stt.sent[s.idx] += len(pcm); return nil }
+
+func (s *recordingStream) Results() <-chan interfaces.STTResult { return s.results }
+
+func (s *recordingStream) Close() error {
+	s.results <- interfaces.STTResult{Text: fmt.Sprintf("utterance %d", s.idx), Final: true, Confidence: 1}
+	close(s.results)
+	return nil
+}
+
+func (s *recordingStream) Err() error { return nil }
@@ -1,17 +1,88 @@
 package livekit
 
-import "github.com/jacky-htg/ai-call-center/libs/interfaces"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
-type livekitProvider struct{}
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
 
-func New() interfaces.WebRTCProvider { return &livekitProvider{} }
+// DefaultURL is the address of a LiveKit server started with `livekit-server --dev`.
+const DefaultURL = "http://localhost:7880"
 
-func (l *livekitProvider) StartSession(opts ...interfaces.WebRTCOption) (string, error) {
-	// Stub: return a fake session id
-	return "livekit-session-stub", nil
+// Client manages LiveKit rooms through the RoomService API. A session is a room: StartSession
+// creates it and returns its name, StopSession deletes it.
+type Client struct {
+	url       string
+	apiKey    string
+	apiSecret string
+	client    *http.Client
 }
 
-func (l *livekitProvider) StopSession(sessionID string) error {
-	// No-op for stub
+var _ interfaces.WebRTCProvider = (*Client)(nil)
+
+// New returns a RoomService client for the LiveKit server at url, authenticated with the
+// API key and secret. ws:// and wss:// URLs are accepted and mapped to http(s).
+func New(url, apiKey, apiSecret string) *Client {
+	if url == "" {
+		url = DefaultURL
+	}
+	switch {
+	case strings.HasPrefix(url, "ws://"):
+		url = "http://" + strings.TrimPrefix(url, "ws://")
+	case strings.HasPrefix(url, "wss://"):
+		url = "https://" + strings.TrimPrefix(url, "wss://")
+	}
+	return &Client{
+		url:       strings.TrimSuffix(url, "/"),
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// StartSession creates the room and returns its name as the session id. Without WithRoom a
+// random room name is used.
+func (c *Client) StartSession(ctx context.Context, opts ...interfaces.WebRTCOption) (string, error) {
+	o := interfaces.ApplyWebRTCOptions(opts...)
+	if o.EmptyTimeout < 0 {
+		return "", fmt.Errorf("livekit: invalid empty timeout %s", o.EmptyTimeout)
+	}
+	if o.MaxParticipants < 0 {
+		return "", fmt.Errorf("livekit: invalid max participants %d", o.MaxParticipants)
+	}
+	name := o.Room
+	if name == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("generate room name: %w", err)
+		}
+		name = "room-" + hex.EncodeToString(b)
+	}
+	room, err := c.CreateRoom(ctx, CreateRoomRequest{
+		Name: name,
+		// LiveKit counts the empty timeout in whole seconds; round up so a short timeout
+		// does not become "server default"
+		EmptyTimeout:    uint32((o.EmptyTimeout + time.Second - 1) / time.Second),
+		MaxParticipants: uint32(o.MaxParticipants),
+		Metadata:        o.Metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+	return room.Name, nil
+}
+
+// StopSession deletes the room, disconnecting all participants. Stopping a room that is
+// already gone is not an error.
+func (c *Client) StopSession(ctx context.Context, sessionID string) error {
+	if err := c.DeleteRoom(ctx, sessionID); err != nil && !IsNotFound(err) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,238 @@
+package livekit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+)
+
+// fakeRoomService is an in-memory Twirp RoomService that checks the token grants of every call.
+type fakeRoomService struct {
+	mu           sync.Mutex
+	rooms        map[string]Room
+	participants map[string][]Participant
+	// unsubscribed is the tracks each participant was unsubscribed from, by room/identity
+	unsubscribed map[string][]string
+}
+
+func newFakeRoomService(t *testing.T) *httptest.Server {
+	f := &fakeRoomService{rooms: map[string]Room{}, participants: map[string][]Participant{}, unsubscribed: map[string][]string{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (f *fakeRoomService) twirpError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"code": code, "msg": msg})
+}
+
+func (f *fakeRoomService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, roomServicePrefix)
+	if !ok || r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		f.twirpError(w, http.StatusNotFound, "bad_route", "no handler for "+r.URL.Path)
+		return
+	}
+	var grant livekitauth.VideoGrant
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), claims, func(*jwt.Token) (any, error) {
+		return []byte("secret"), nil
+	})
+	if err != nil || claims["iss"] != "devkey" {
+		f.twirpError(w, http.StatusUnauthorized, "unauthenticated", "invalid token")
+		return
+	}
+	b, _ := json.Marshal(claims["video"])
+	_ = json.Unmarshal(b, &grant)
+
+	var req struct {
+		CreateRoomRequest
+		Room      string   `json:"room"`
+		Identity  string   `json:"identity"`
+		Names     []string `json:"names"`
+		TrackSids []string `json:"track_sids"`
+		Subscribe bool     `json:"subscribe"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.twirpError(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res any = struct{}{}
+	switch method {
+	case "CreateRoom":
+		if !grant.RoomCreate {
+			f.twirpError(w, http.StatusUnauthorized, "permission_denied", "roomCreate required")
+			return
+		}
+		room, ok := f.rooms[req.Name]
+		if !ok {
+			room = Room{Sid: "RM_" + req.Name, Name: req.Name, EmptyTimeout: req.EmptyTimeout, MaxParticipants: req.MaxParticipants, Metadata: req.Metadata}
+			f.rooms[req.Name] = room
+			f.participants[req.Name] = []Participant{{Sid: "PA_caller", Identity: "caller", State: "ACTIVE"}, {Sid: "PA_agent", Identity: "agent", State: "ACTIVE"}}
+		}
+		res = room
+	case "ListRooms":
+		if !grant.RoomList {
+			f.twirpError(w, http.StatusUnauthorized, "permission_denied", "roomList required")
+			return
+		}
+		rooms := []Room{}
+		for _, room := range f.rooms {
+			if len(req.Names) == 0 || slices.Contains(req.Names, room.Name) {
+				rooms = append(rooms, room)
+			}
+		}
+		res = map[string]any{"rooms": rooms}
+	case "DeleteRoom":
+		if !grant.RoomCreate {
+			f.twirpError(w, http.StatusUnauthorized, "permission_denied", "roomCreate required")
+			return
+		}
+		if _, ok := f.rooms[req.Room]; !ok {
+			f.twirpError(w, http.StatusNotFound, "not_found", "room not found")
+			return
+		}
+		delete(f.rooms, req.Room)
+		delete(f.participants, req.Room)
+	case "ListParticipants", "RemoveParticipant", "UpdateSubscriptions":
+		if !grant.RoomAdmin || grant.Room != req.Room {
+			f.twirpError(w, http.StatusUnauthorized, "permission_denied", "roomAdmin for "+req.Room+" required")
+			return
+		}
+		ps, ok := f.participants[req.Room]
+		if !ok {
+			f.twirpError(w, http.StatusNotFound, "not_found", "room not found")
+			return
+		}
+		if method == "ListParticipants" {
+			res = map[string]any{"participants": ps}
+			break
+		}
+		if method == "UpdateSubscriptions" {
+			key := req.Room + "/" + req.Identity
+			if req.Subscribe {
+				f.unsubscribed[key] = slices.DeleteFunc(f.unsubscribed[key], func(sid string) bool { return slices.Contains(req.TrackSids, sid) })
+			} else {
+				f.unsubscribed[key] = append(f.unsubscribed[key], req.TrackSids...)
+			}
+			break
+		}
+		for i, p := range ps {
+			if p.Identity == req.Identity {
+				f.participants[req.Room] = append(ps[:i:i], ps[i+1:]...)
+				break
+			}
+		}
+	default:
+		f.twirpError(w, http.StatusNotFound, "bad_route", "unknown method "+method)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	srv := newFakeRoomService(t)
+	c := New(srv.URL, "devkey", "secret")
+	ctx := context.Background()
+
+	id, err := c.StartSession(ctx,
+		interfaces.WithRoom("call-1"),
+		interfaces.WithEmptyTimeout(1500*time.Millisecond),
+		interfaces.WithMaxParticipants(2),
+		interfaces.WithRoomMetadata(`{"caller":"alice"}`),
+	)
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	if id != "call-1" {
+		t.Fatalf("session id = %q, want call-1", id)
+	}
+	rooms, err := c.ListRooms(ctx, "call-1")
+	if err != nil {
+		t.Fatalf("ListRooms: %v", err)
+	}
+	if len(rooms) != 1 {
+		t.Fatalf("rooms = %+v", rooms)
+	}
+	// the empty timeout is rounded up to whole seconds
+	if room := rooms[0]; room.EmptyTimeout != 2 || room.MaxParticipants != 2 || room.Metadata != `{"caller":"alice"}` {
+		t.Fatalf("room = %+v", room)
+	}
+
+	if err := c.UpdateSubscriptions(ctx, "call-1", "caller", []string{"TR_whisper"}, false); err != nil {
+		t.Fatalf("UpdateSubscriptions: %v", err)
+	}
+	fake := srv.Config.Handler.(*fakeRoomService)
+	fake.mu.Lock()
+	unsubscribed := fake.unsubscribed["call-1/caller"]
+	fake.mu.Unlock()
+	if !slices.Equal(unsubscribed, []string{"TR_whisper"}) {
+		t.Fatalf("caller unsubscribed from %v", unsubscribed)
+	}
+
+	if err := c.RemoveParticipant(ctx, "call-1", "agent"); err != nil {
+		t.Fatalf("RemoveParticipant: %v", err)
+	}
+	ps, err := c.ListParticipants(ctx, "call-1")
+	if err != nil {
+		t.Fatalf("ListParticipants: %v", err)
+	}
+	if len(ps) != 1 || ps[0].Identity != "caller" {
+		t.Fatalf("participants = %+v", ps)
+	}
+
+	if err := c.StopSession(ctx, id); err != nil {
+		t.Fatalf("StopSession: %v", err)
+	}
+	if _, err := c.ListParticipants(ctx, "call-1"); !IsNotFound(err) {
+		t.Fatalf("ListParticipants after stop: %v, want not_found", err)
+	}
+	// stopping twice is fine
+	if err := c.StopSession(ctx, id); err != nil {
+		t.Fatalf("second StopSession: %v", err)
+	}
+}
+
+func TestStartSession_GeneratesRoomName(t *testing.T) {
+	c := New(newFakeRoomService(t).URL, "devkey", "secret")
+	a, err := c.StartSession(context.Background())
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	b, err := c.StartSession(context.Background())
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	if a == "" || a == b {
+		t.Fatalf("room names %q and %q, want distinct", a, b)
+	}
+}
+
+func TestCall_Errors(t *testing.T) {
+	srv := newFakeRoomService(t)
+
+	if _, err := New(srv.URL, "devkey", "wrong").StartSession(context.Background()); err == nil || !strings.Contains(err.Error(), "unauthenticated") {
+		t.Fatalf("bad secret: %v", err)
+	}
+	if _, err := New(srv.URL, "", "").StartSession(context.Background()); err == nil {
+		t.Fatal("expected error without credentials")
+	}
+	if _, err := New(srv.URL, "devkey", "secret").StartSession(context.Background(), interfaces.WithMaxParticipants(-1)); err == nil {
+		t.Fatal("expected error for negative max participants")
+	}
+}
@@ -0,0 +1,187 @@
+package livekit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	livekitauth "github.com/jacky-htg/ai-call-center/libs/livekit"
+)
+
+// roomServicePrefix is the Twirp route prefix of LiveKit's RoomService.
+const roomServicePrefix = "/twirp/livekit.RoomService/"
+
+// serviceTokenTTL is how long the tokens signed for a single RoomService call are valid.
+const serviceTokenTTL = 10 * time.Minute
+
+// Room is the part of a LiveKit room returned by the RoomService.
+type Room struct {
+	Sid             string `json:"sid"`
+	Name            string `json:"name"`
+	EmptyTimeout    uint32 `json:"empty_timeout"`
+	MaxParticipants uint32 `json:"max_participants"`
+	Metadata        string `json:"metadata"`
+}
+
+// Participant is the part of a LiveKit participant returned by the RoomService.
+type Participant struct {
+	Sid      string `json:"sid"`
+	Identity string `json:"identity"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Metadata string `json:"metadata"`
+	// Attributes are the participant attributes, e.g. those set by its access token.
+	Attributes map[string]string `json:"attributes"`
+	Tracks     []Track           `json:"tracks"`
+}
+
+// Track is the part of a published track returned by the RoomService.
+type Track struct {
+	Sid    string `json:"sid"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// CreateRoomRequest describes a room to create. Zero values use the server defaults.
+type CreateRoomRequest struct {
+	Name            string `json:"name"`
+	EmptyTimeout    uint32 `json:"empty_timeout,omitempty"`
+	MaxParticipants uint32 `json:"max_participants,omitempty"`
+	Metadata        string `json:"metadata,omitempty"`
+}
+
+// Error is a Twirp error returned by the LiveKit server.
+type Error struct {
+	Status int
+	Code   string `json:"code"`
+	Msg    string `json:"msg"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("livekit: %s: %s (status %d)", e.Code, e.Msg, e.Status)
+}
+
+// IsNotFound reports whether err is a Twirp not_found error.
+func IsNotFound(err error) bool {
+	var te *Error
+	return errors.As(err, &te) && te.Code == "not_found"
+}
+
+// CreateRoom creates a room, or returns the existing one with the same name.
+func (c *Client) CreateRoom(ctx context.Context, req CreateRoomRequest) (*Room, error) {
+	var room Room
+	if err := c.call(ctx, "CreateRoom", livekitauth.VideoGrant{RoomCreate: true}, req, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// ListRooms lists the active rooms; with names it returns only those rooms.
+func (c *Client) ListRooms(ctx context.Context, names ...string) ([]Room, error) {
+	var res struct {
+		Rooms []Room `json:"rooms"`
+	}
+	req := struct {
+		Names []string `json:"names,omitempty"`
+	}{names}
+	if err := c.call(ctx, "ListRooms", livekitauth.VideoGrant{RoomList: true}, req, &res); err != nil {
+		return nil, err
+	}
+	return res.Rooms, nil
+}
+
+// DeleteRoom closes a room and disconnects everyone in it.
+func (c *Client) DeleteRoom(ctx context.Context, room string) error {
+	req := struct {
+		Room string `json:"room"`
+	}{room}
+	return c.call(ctx, "DeleteRoom", livekitauth.VideoGrant{RoomCreate: true}, req, nil)
+}
+
+// ListParticipants lists the participants in room.
+func (c *Client) ListParticipants(ctx context.Context, room string) ([]Participant, error) {
+	var res struct {
+		Participants []Participant `json:"participants"`
+	}
+	req := struct {
+		Room string `json:"room"`
+	}{room}
+	if err := c.call(ctx, "ListParticipants", livekitauth.VideoGrant{Room: room, RoomAdmin: true}, req, &res); err != nil {
+		return nil, err
+	}
+	return res.Participants, nil
+}
+
+// RemoveParticipant disconnects identity from room.
+func (c *Client) RemoveParticipant(ctx context.Context, room, identity string) error {
+	req := struct {
+		Room     string `json:"room"`
+		Identity string `json:"identity"`
+	}{room, identity}
+	return c.call(ctx, "RemoveParticipant", livekitauth.VideoGrant{Room: room, RoomAdmin: true}, req, nil)
+}
+
+// UpdateSubscriptions subscribes identity in room to the tracks with trackSids, or
+// unsubscribes it from them.
+func (c *Client) UpdateSubscriptions(ctx context.Context, room, identity string, trackSids []string, subscribe bool) error {
+	req := struct {
+		Room      string   `json:"room"`
+		Identity  string   `json:"identity"`
+		TrackSids []string `json:"track_sids"`
+		Subscribe bool     `json:"subscribe"`
+	}{room, identity, trackSids, subscribe}
+	return c.call(ctx, "UpdateSubscriptions", livekitauth.VideoGrant{Room: room, RoomAdmin: true}, req, nil)
+}
+
+// call invokes a RoomService method with a JSON request and decodes the JSON response into res.
+func (c *Client) call(ctx context.Context, method string, grant livekitauth.VideoGrant, req, res any) error {
+	token, err := c.serviceToken(grant)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal %s request: %w", method, err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+roomServicePrefix+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", method, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("livekit %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		te := &Error{Status: resp.StatusCode}
+		if err := json.Unmarshal(b, te); err != nil || te.Code == "" {
+			te.Code = "unknown"
+			te.Msg = strings.TrimSpace(string(b))
+		}
+		return fmt.Errorf("livekit %s: %w", method, te)
+	}
+	if res == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
+	return nil
+}
+
+// serviceToken signs a short-lived token carrying grant for a RoomService call.
+func (c *Client) serviceToken(grant livekitauth.VideoGrant) (string, error) {
+	return livekitauth.NewAccessToken(c.apiKey, c.apiSecret).SetVideoGrant(grant).SetTTL(serviceTokenTTL).ToJWT()
+}
@@ -2,18 +2,27 @@ package ollama
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/jacky-htg/ai-call-center/libs/interfaces"
 )
 
+// responseTimeout bounds waiting for Ollama to start answering, and the whole request of
+// non-streamed calls. Streamed replies take as long as they take; their callers cancel ctx
+// to stop them.
+const responseTimeout = 30 * time.Second
+
 type ollamaLLM struct {
-	endpoint string
-	model    string
-	client   *http.Client
+	endpoint     string
+	chatEndpoint string
+	model        string
+	client       *http.Client
 }
 
 // New returns a client configured for the local Ollama HTTP API.
@@ -22,6 +31,7 @@ func New() interfaces.LLM {
 }
 
 // NewWithEndpointModel creates an Ollama client with custom endpoint and model.
+// The endpoint is the /api/generate URL; the /api/chat URL is derived from it.
 func NewWithEndpointModel(endpoint, model string) interfaces.LLM {
 	if endpoint == "" {
 		endpoint = "http://localhost:11434/api/generate"
@@ -29,13 +39,59 @@ func NewWithEndpointModel(endpoint, model string) interfaces.LLM {
 	if model == "" {
 		model = "tinyllama"
 	}
-	return &ollamaLLM{endpoint: endpoint, model: model, client: &http.Client{Timeout: 30 * time.Second}}
+	return &ollamaLLM{
+		endpoint:     endpoint,
+		chatEndpoint: chatEndpointFor(endpoint),
+		model:        model,
+		client:       newHTTPClient(responseTimeout),
+	}
+}
+
+// newHTTPClient returns a client that gives up on a server that sends no response headers
+// within headerTimeout. It has no overall timeout, which would cut streamed replies short.
+func newHTTPClient(headerTimeout time.Duration) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.ResponseHeaderTimeout = headerTimeout
+	return &http.Client{Transport: tr}
+}
+
+// chatEndpointFor maps a generate endpoint to the chat endpoint on the same server.
+func chatEndpointFor(endpoint string) string {
+	if strings.HasSuffix(endpoint, "/api/generate") {
+		return strings.TrimSuffix(endpoint, "/api/generate") + "/api/chat"
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/api/chat"
 }
 
 type ollamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model   string        `json:"model"`
+	Prompt  string        `json:"prompt"`
+	Stream  bool          `json:"stream"`
+	Options *modelOptions `json:"options,omitempty"`
+}
+
+// modelOptions is the "options" object of Ollama requests. All LLMOptions are supported:
+// temperature, max tokens (num_predict), stop sequences and a per-request model override.
+type modelOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// requestOptions validates opts and returns the model to use and the Ollama options object.
+func (o *ollamaLLM) requestOptions(opts []interfaces.LLMOption) (string, *modelOptions, error) {
+	lo := interfaces.ApplyLLMOptions(opts...)
+	if lo.MaxTokens < 0 {
+		return "", nil, fmt.Errorf("ollama: invalid max tokens %d", lo.MaxTokens)
+	}
+	model := o.model
+	if lo.Model != "" {
+		model = lo.Model
+	}
+	if lo.Temperature == nil && lo.MaxTokens == 0 && len(lo.Stop) == 0 {
+		return model, nil, nil
+	}
+	return model, &modelOptions{Temperature: lo.Temperature, NumPredict: lo.MaxTokens, Stop: lo.Stop}, nil
 }
 
 type ollamaResponse struct {
@@ -43,16 +99,44 @@ type ollamaResponse struct {
 	CreatedAt string `json:"created_at"`
 	Response  string `json:"response"`
 	Done      bool   `json:"done"`
+	Error     string `json:"error,omitempty"`
+}
+
+type chatMessage struct {
+	Role     string `json:"role"`
+	Content  string `json:"content"`
+	ToolName string `json:"tool_name,omitempty"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  *modelOptions `json:"options,omitempty"`
+}
+
+type chatResponse struct {
+	Model     string      `json:"model"`
+	CreatedAt string      `json:"created_at"`
+	Message   chatMessage `json:"message"`
+	Done      bool        `json:"done"`
+	Error     string      `json:"error,omitempty"`
 }
 
-func (o *ollamaLLM) Generate(prompt string, opts ...interfaces.LLMOption) (string, error) {
-	reqBody := ollamaRequest{Model: o.model, Prompt: prompt, Stream: false}
+func (o *ollamaLLM) Generate(ctx context.Context, prompt string, opts ...interfaces.LLMOption) (string, error) {
+	model, mo, err := o.requestOptions(opts)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, responseTimeout)
+	defer cancel()
+	reqBody := ollamaRequest{Model: model, Prompt: prompt, Stream: false, Options: mo}
 	b, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", fmt.Errorf("marshal ollama request: %w", err)
 	}
 
-	resp, err := o.client.Post(o.endpoint, "application/json", bytes.NewReader(b))
+	resp, err := o.post(ctx, o.endpoint, b)
 	if err != nil {
 		return "", fmt.Errorf("post to ollama: %w", err)
 	}
@@ -65,3 +149,134 @@ func (o *ollamaLLM) Generate(prompt string, opts ...interfaces.LLMOption) (strin
 
 	return out.Response, nil
 }
+
+// GenerateStream requests a streamed completion. Ollama answers with newline-delimited JSON
+// objects, each carrying the next token(s) in "response" until one arrives with "done": true.
+// Every token is written to w as soon as it is decoded.
+func (o *ollamaLLM) GenerateStream(ctx context.Context, prompt string, w io.Writer, opts ...interfaces.LLMOption) error {
+	model, mo, err := o.requestOptions(opts)
+	if err != nil {
+		return err
+	}
+	reqBody := ollamaRequest{Model: model, Prompt: prompt, Stream: true, Options: mo}
+	return o.stream(ctx, o.endpoint, reqBody, func(dec *json.Decoder) (string, bool, error) {
+		var chunk ollamaResponse
+		if err := dec.Decode(&chunk); err != nil {
+			return "", false, err
+		}
+		if chunk.Error != "" {
+			return "", false, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		return chunk.Response, chunk.Done, nil
+	}, w)
+}
+
+// Chat sends the conversation to /api/chat and returns the assistant reply.
+func (o *ollamaLLM) Chat(ctx context.Context, messages []interfaces.Message, opts ...interfaces.LLMOption) (string, error) {
+	model, mo, err := o.requestOptions(opts)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, responseTimeout)
+	defer cancel()
+	reqBody := chatRequest{Model: model, Messages: toChatMessages(messages), Stream: false, Options: mo}
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", fmt.Errorf("marshal ollama chat request: %w", err)
+	}
+
+	resp, err := o.post(ctx, o.chatEndpoint, b)
+	if err != nil {
+		return "", fmt.Errorf("post to ollama chat: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("ollama chat bad status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var out chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode ollama chat response: %w", err)
+	}
+	if out.Error != "" {
+		return "", fmt.Errorf("ollama: %s", out.Error)
+	}
+	return out.Message.Content, nil
+}
+
+// ChatStream streams the assistant reply from /api/chat, writing each token to w as it arrives.
+func (o *ollamaLLM) ChatStream(ctx context.Context, messages []interfaces.Message, w io.Writer, opts ...interfaces.LLMOption) error {
+	model, mo, err := o.requestOptions(opts)
+	if err != nil {
+		return err
+	}
+	reqBody := chatRequest{Model: model, Messages: toChatMessages(messages), Stream: true, Options: mo}
+	return o.stream(ctx, o.chatEndpoint, reqBody, func(dec *json.Decoder) (string, bool, error) {
+		var chunk chatResponse
+		if err := dec.Decode(&chunk); err != nil {
+			return "", false, err
+		}
+		if chunk.Error != "" {
+			return "", false, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		return chunk.Message.Content, chunk.Done, nil
+	}, w)
+}
+
+// stream posts reqBody to endpoint and decodes the NDJSON response with next until it reports done.
+func (o *ollamaLLM) stream(ctx context.Context, endpoint string, reqBody any, next func(*json.Decoder) (string, bool, error), w io.Writer) error {
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("marshal ollama request: %w", err)
+	}
+
+	resp, err := o.post(ctx, endpoint, b)
+	if err != nil {
+		return fmt.Errorf("post to ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ollama bad status %d: %s", resp.StatusCode, string(body))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		token, done, err := next(dec)
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("ollama stream ended before done")
+			}
+			return fmt.Errorf("decode ollama stream: %w", err)
+		}
+		if token != "" {
+			if _, err := io.WriteString(w, token); err != nil {
+				return fmt.Errorf("write ollama token: %w", err)
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// post sends a JSON body to the given endpoint. The request is aborted when ctx is cancelled.
+func (o *ollamaLLM) post(ctx context.Context, endpoint string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return o.client.Do(req)
+}
+
+func toChatMessages(messages []interfaces.Message) []chatMessage {
+	out := make([]chatMessage, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, chatMessage{Role: m.Role, Content: m.Content, ToolName: m.ToolName})
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+	"github.com/jacky-htg/ai-call-center/libs/sentence"
+)
+
+func TestGenerateStream_SplitsSentences(t *testing.T) {
+	tokens := []string{"Hello", " there", ".", " How", " can", " I", " help", "?"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Errorf("expected stream request")
+		}
+		enc := json.NewEncoder(w)
+		for _, tok := range tokens {
+			_ = enc.Encode(ollamaResponse{Response: tok})
+			w.(http.Flusher).Flush()
+		}
+		_ = enc.Encode(ollamaResponse{Done: true})
+	}))
+	defer srv.Close()
+
+	var got []string
+	sw := sentence.NewWriter(func(s string) { got = append(got, s) })
+	if err := NewWithEndpointModel(srv.URL, "tinyllama").GenerateStream(context.Background(), "hi", sw); err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	sw.Flush()
+	want := []string{"Hello there.", "How can I help?"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("sentences = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateStream_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(ollamaResponse{Response: "partial"})
+		_ = json.NewEncoder(w).Encode(ollamaResponse{Error: "model crashed"})
+	}))
+	defer srv.Close()
+
+	var sb strings.Builder
+	err := NewWithEndpointModel(srv.URL, "tinyllama").GenerateStream(context.Background(), "hi", &sb)
+	if err == nil || !strings.Contains(err.Error(), "model crashed") {
+		t.Fatalf("expected model error, got %v", err)
+	}
+	if sb.String() != "partial" {
+		t.Fatalf("written = %q, want %q", sb.String(), "partial")
+	}
+}
+
+func TestChat_SendsHistoryToChatEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		var req chatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		var roles []string
+		for _, m := range req.Messages {
+			roles = append(roles, m.Role)
+		}
+		_ = json.NewEncoder(w).Encode(chatResponse{
+			Message: chatMessage{Role: "assistant", Content: strings.Join(roles, ",")},
+			Done:    true,
+		})
+	}))
+	defer srv.Close()
+
+	llm := NewWithEndpointModel(srv.URL+"/api/generate", "tinyllama")
+	reply, err := llm.Chat(context.Background(), []interfaces.Message{
+		{Role: interfaces.RoleSystem, Content: "be brief"},
+		{Role: interfaces.RoleUser, Content: "my name is Sam"},
+		{Role: interfaces.RoleAssistant, Content: "hi Sam"},
+		{Role: interfaces.RoleUser, Content: "what is my name?"},
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if reply != "system,user,assistant,user" {
+		t.Fatalf("reply = %q", reply)
+	}
+}
+
+func TestChatStream_CancelAbortsRequest(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(chatResponse{Message: chatMessage{Content: "Let me think"}})
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	var sb strings.Builder
+	err := NewWithEndpointModel(srv.URL, "tinyllama").ChatStream(ctx, nil, &sb)
+	if err == nil {
+		t.Fatalf("expected error after cancel")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("ChatStream returned after %s, want prompt abort", elapsed)
+	}
+	if sb.String() != "Let me think" {
+		t.Fatalf("written = %q", sb.String())
+	}
+}
+
+func TestChatStream_OutlivesResponseTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := json.NewEncoder(w)
+		for i := 0; i < 10; i++ {
+			_ = enc.Encode(chatResponse{Message: chatMessage{Content: "word "}})
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		_ = enc.Encode(chatResponse{Done: true})
+	}))
+	defer srv.Close()
+
+	// the reply streams for longer than the client waits for a response to start
+	llm := NewWithEndpointModel(srv.URL, "tinyllama").(*ollamaLLM)
+	llm.client = newHTTPClient(50 * time.Millisecond)
+	var sb strings.Builder
+	if err := llm.ChatStream(context.Background(), nil, &sb); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if sb.String() != strings.Repeat("word ", 10) {
+		t.Fatalf("written = %q", sb.String())
+	}
+}
+
+func TestChatStream_StuckServer(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	llm := NewWithEndpointModel(srv.URL, "tinyllama").(*ollamaLLM)
+	llm.client = newHTTPClient(50 * time.Millisecond)
+	if err := llm.ChatStream(context.Background(), nil, &strings.Builder{}); err == nil {
+		t.Fatal("expected error from a server that never answers")
+	}
+}
+
+func TestChat_ForwardsOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req chatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "llama3" {
+			t.Errorf("model = %q, want llama3", req.Model)
+		}
+		if req.Options == nil || req.Options.Temperature == nil || *req.Options.Temperature != 0.2 ||
+			req.Options.NumPredict != 64 || strings.Join(req.Options.Stop, ",") != "\n" {
+			t.Errorf("options = %+v", req.Options)
+		}
+		_ = json.NewEncoder(w).Encode(chatResponse{Message: chatMessage{Role: "assistant", Content: "ok"}, Done: true})
+	}))
+	defer srv.Close()
+
+	llm := NewWithEndpointModel(srv.URL+"/api/generate", "tinyllama")
+	_, err := llm.Chat(context.Background(), []interfaces.Message{{Role: interfaces.RoleUser, Content: "hi"}},
+		interfaces.WithModel("llama3"), interfaces.WithTemperature(0.2), interfaces.WithMaxTokens(64), interfaces.WithStop("\n"))
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+}
@@ -2,14 +2,18 @@ package piper
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jacky-htg/ai-call-center/libs/audio"
 	"github.com/jacky-htg/ai-call-center/libs/interfaces"
 )
 
@@ -32,14 +36,83 @@ func NewWithEndpoint(endpoint string) interfaces.TTS {
 }
 
 type ttsRequest struct {
-	Text string `json:"text"`
+	Text        string  `json:"text"`
+	Voice       string  `json:"voice,omitempty"`
+	LengthScale float64 `json:"length_scale,omitempty"`
 }
 
-func (p *piperTTS) Speak(text string, opts ...interfaces.TTSOption) ([]byte, error) {
+// Options: the voice and speaking rate are forwarded to the Piper server as the "voice" and
+// "length_scale" fields (Piper's length scale is the inverse of the speaking rate). Piper always
+// renders WAV at the voice model's native rate, so sample rate and PCM output are produced by
+// converting the server's response. Other output formats are rejected.
+
+// ttsParams validates opts and returns the request fields derived from them.
+func ttsParams(text string, opts []interfaces.TTSOption) (ttsRequest, interfaces.TTSOptions, error) {
+	o := interfaces.ApplyTTSOptions(opts...)
+	if o.Format != "" && o.Format != interfaces.FormatWAV && o.Format != interfaces.FormatPCM {
+		return ttsRequest{}, o, interfaces.Unsupported("piper", "output format "+o.Format)
+	}
+	if o.SpeakingRate < 0 || o.SampleRate < 0 {
+		return ttsRequest{}, o, fmt.Errorf("piper: invalid tts options %+v", o)
+	}
+	req := ttsRequest{Text: text, Voice: o.Voice}
+	if o.SpeakingRate > 0 {
+		req.LengthScale = 1 / o.SpeakingRate
+	}
+	return req, o, nil
+}
+
+func (r ttsRequest) values() url.Values {
+	v := url.Values{}
+	v.Set("text", r.Text)
+	if r.Voice != "" {
+		v.Set("voice", r.Voice)
+	}
+	if r.LengthScale > 0 {
+		v.Set("length_scale", strconv.FormatFloat(r.LengthScale, 'f', 3, 64))
+	}
+	return v
+}
+
+// convertOutput applies the requested sample rate and format to a WAV produced by Piper.
+func convertOutput(wav []byte, o interfaces.TTSOptions) ([]byte, error) {
+	if o.SampleRate == 0 && o.Format != interfaces.FormatPCM {
+		return wav, nil
+	}
+	pcm, f, err := audio.DecodeWAV(wav)
+	if err != nil {
+		return nil, fmt.Errorf("decode piper wav: %w", err)
+	}
+	if o.SampleRate != 0 {
+		to := audio.Format{SampleRate: o.SampleRate, Channels: f.Channels, BitsPerSample: 16}
+		if pcm, err = audio.Convert(pcm, f, to); err != nil {
+			return nil, err
+		}
+		f = to
+	}
+	if o.Format == interfaces.FormatPCM {
+		return pcm, nil
+	}
+	return audio.EncodeWAV(pcm, f), nil
+}
+
+func (p *piperTTS) Speak(ctx context.Context, text string, opts ...interfaces.TTSOption) ([]byte, error) {
+	params, o, err := ttsParams(text, opts)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.synthesize(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return convertOutput(body, o)
+}
+
+func (p *piperTTS) synthesize(ctx context.Context, params ttsRequest) ([]byte, error) {
+	text := params.Text
 	// Primary: send url-encoded form with field "text" to match server's r.FormValue("text")
-	form := url.Values{}
-	form.Set("text", text)
-	resp, err := p.client.Post(p.endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	form := params.values()
+	resp, err := p.do(ctx, http.MethodPost, p.endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("post form to piper tts: %w", err)
 	}
@@ -54,10 +127,13 @@ func (p *piperTTS) Speak(text string, opts ...interfaces.TTSOption) ([]byte, err
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		return body, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Fallbacks if form didn't work: try JSON then text/plain then GET
-	reqBody, _ := json.Marshal(ttsRequest{Text: text})
-	resp2, err := p.client.Post(p.endpoint, "application/json", bytes.NewReader(reqBody))
+	reqBody, _ := json.Marshal(params)
+	resp2, err := p.do(ctx, http.MethodPost, p.endpoint, "application/json", bytes.NewReader(reqBody))
 	if err == nil {
 		defer resp2.Body.Close()
 		if resp2.StatusCode >= 200 && resp2.StatusCode < 300 {
@@ -66,22 +142,25 @@ func (p *piperTTS) Speak(text string, opts ...interfaces.TTSOption) ([]byte, err
 		}
 	}
 
-	resp3, err := p.client.Post(p.endpoint, "text/plain", strings.NewReader(text))
-	if err == nil {
-		defer resp3.Body.Close()
-		if resp3.StatusCode >= 200 && resp3.StatusCode < 300 {
-			b3, _ := io.ReadAll(resp3.Body)
-			return b3, nil
+	// text/plain cannot carry voice options
+	if params.Voice == "" && params.LengthScale == 0 {
+		resp3, err := p.do(ctx, http.MethodPost, p.endpoint, "text/plain", strings.NewReader(text))
+		if err == nil {
+			defer resp3.Body.Close()
+			if resp3.StatusCode >= 200 && resp3.StatusCode < 300 {
+				b3, _ := io.ReadAll(resp3.Body)
+				return b3, nil
+			}
 		}
 	}
 
 	getURL := p.endpoint
 	if strings.Contains(getURL, "?") {
-		getURL = getURL + "&text=" + url.QueryEscape(text)
+		getURL = getURL + "&" + form.Encode()
 	} else {
-		getURL = getURL + "?text=" + url.QueryEscape(text)
+		getURL = getURL + "?" + form.Encode()
 	}
-	resp4, err := p.client.Get(getURL)
+	resp4, err := p.do(ctx, http.MethodGet, getURL, "", nil)
 	if err == nil {
 		defer resp4.Body.Close()
 		if resp4.StatusCode >= 200 && resp4.StatusCode < 300 {
@@ -94,11 +173,24 @@ func (p *piperTTS) Speak(text string, opts ...interfaces.TTSOption) ([]byte, err
 }
 
 // SpeakStream streams audio produced by the Piper server directly to the provided writer.
-// This avoids buffering large audio in memory and enables low-latency playback.
-func (p *piperTTS) SpeakStream(text string, w io.Writer, opts ...interfaces.TTSOption) error {
-	form := url.Values{}
-	form.Set("text", text)
-	resp, err := p.client.Post(p.endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+// This avoids buffering large audio in memory and enables low-latency playback. Sample rate
+// conversion needs the whole clip, so with WithSampleRate the audio is buffered first.
+func (p *piperTTS) SpeakStream(ctx context.Context, text string, w io.Writer, opts ...interfaces.TTSOption) error {
+	params, o, err := ttsParams(text, opts)
+	if err != nil {
+		return err
+	}
+	if o.SampleRate != 0 {
+		b, err := p.Speak(ctx, text, opts...)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	form := params.values()
+	resp, err := p.do(ctx, http.MethodPost, p.endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
 	if err != nil {
 		return fmt.Errorf("post form to piper tts: %w", err)
 	}
@@ -109,18 +201,82 @@ func (p *piperTTS) SpeakStream(text string, w io.Writer, opts ...interfaces.TTSO
 		return fmt.Errorf("piper tts bad status %d: %s", resp.StatusCode, string(b))
 	}
 
-	// Copy the streaming response body to the writer until EOF.
-	if _, err := io.Copy(w, resp.Body); err != nil {
+	// Copy the streaming response body to the writer until EOF, dropping the WAV header for PCM.
+	var body io.Reader = resp.Body
+	if o.Format == interfaces.FormatPCM {
+		pcm, _, err := audio.NewPCMReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("read tts wav header: %w", err)
+		}
+		body = pcm
+	}
+	if _, err := io.Copy(w, body); err != nil {
 		return fmt.Errorf("stream tts response: %w", err)
 	}
 	return nil
 }
 
+// do sends a request to the Piper server. The request is aborted when ctx is cancelled.
+func (p *piperTTS) do(ctx context.Context, method, target, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return p.client.Do(req)
+}
+
 // Keep a legacy STT stub available as NewSTT if someone needs it.
 type piperSTT struct{}
 
 func NewSTT() interfaces.STT { return &piperSTT{} }
 
-func (p *piperSTT) Recognize(audio []byte, opts ...interfaces.STTOption) (string, float32, error) {
+func (p *piperSTT) Recognize(ctx context.Context, audio []byte, opts ...interfaces.STTOption) (string, float32, error) {
+	if err := checkSTTStubOptions(opts); err != nil {
+		return "", 0, err
+	}
 	return "transcript from piper (stub)", 0.93, nil
 }
+
+func (p *piperSTT) RecognizeStream(ctx context.Context, opts ...interfaces.STTOption) (interfaces.STTStream, error) {
+	if err := checkSTTStubOptions(opts); err != nil {
+		return nil, err
+	}
+	return &piperSTTStream{results: make(chan interfaces.STTResult, 1)}, nil
+}
+
+// checkSTTStubOptions rejects every STT option: the stub cannot honour any of them.
+func checkSTTStubOptions(opts []interfaces.STTOption) error {
+	o := interfaces.ApplySTTOptions(opts...)
+	switch {
+	case o.Language != "":
+		return interfaces.Unsupported("piper stt", "language")
+	case o.Prompt != "":
+		return interfaces.Unsupported("piper stt", "prompt hints")
+	case o.WordTimestamps:
+		return interfaces.Unsupported("piper stt", "word timestamps")
+	}
+	return nil
+}
+
+// piperSTTStream discards audio and emits a single stub final result on Close.
+type piperSTTStream struct {
+	results chan interfaces.STTResult
+	once    sync.Once
+}
+
+func (s *piperSTTStream) Write(pcm []byte) error { return nil }
+
+func (s *piperSTTStream) Results() <-chan interfaces.STTResult { return s.results }
+
+func (s *piperSTTStream) Close() error {
+	s.once.Do(func() {
+		s.results <- interfaces.STTResult{Text: "transcript from piper (stub)", Confidence: 0.93, Final: true}
+		close(s.results)
+	})
+	return nil
+}
+
+func (s *piperSTTStream) Err() error { return nil }
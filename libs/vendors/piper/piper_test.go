@@ -0,0 +1,95 @@
+package piper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// piperFormat is the native format of the fake Piper voice.
+var piperFormat = audio.Format{SampleRate: 22050, Channels: 1, BitsPerSample: 16}
+
+// newFakePiper serves 100ms of WAV at piperFormat and records the form of the last request.
+func newFakePiper(t *testing.T, form *http.Request) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*form = *r
+		_, _ = w.Write(audio.EncodeWAV(make([]byte, piperFormat.BytesPerSecond()/10), piperFormat))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestSpeak_ForwardsOptions(t *testing.T) {
+	var req http.Request
+	srv, _ := newFakePiper(t, &req)
+	tts := NewWithEndpoint(srv.URL)
+
+	wav, err := tts.Speak(context.Background(), "hello", interfaces.WithVoice("en_US-amy"), interfaces.WithSpeakingRate(2), interfaces.WithSampleRate(16000))
+	if err != nil {
+		t.Fatalf("Speak: %v", err)
+	}
+	if got := req.PostForm; got.Get("text") != "hello" || got.Get("voice") != "en_US-amy" || got.Get("length_scale") != "0.500" {
+		t.Fatalf("form = %v", got)
+	}
+	// the sample rate is applied to Piper's output
+	pcm, f, err := audio.DecodeWAV(wav)
+	if err != nil || f != audio.SpeechFormat || len(pcm) != audio.SpeechFormat.BytesPerSecond()/10 {
+		t.Fatalf("output %+v with %d bytes, %v", f, len(pcm), err)
+	}
+
+	pcm, err = tts.Speak(context.Background(), "hello", interfaces.WithOutputFormat(interfaces.FormatPCM))
+	if err != nil {
+		t.Fatalf("Speak pcm: %v", err)
+	}
+	if len(pcm) != piperFormat.BytesPerSecond()/10 {
+		t.Fatalf("pcm output of %d bytes", len(pcm))
+	}
+
+	var out bytes.Buffer
+	if err := tts.SpeakStream(context.Background(), "hello", &out, interfaces.WithOutputFormat(interfaces.FormatPCM)); err != nil {
+		t.Fatalf("SpeakStream pcm: %v", err)
+	}
+	if out.Len() != piperFormat.BytesPerSecond()/10 {
+		t.Fatalf("streamed pcm output of %d bytes", out.Len())
+	}
+}
+
+func TestSpeak_RejectsUnsupportedOptions(t *testing.T) {
+	var req http.Request
+	srv, requests := newFakePiper(t, &req)
+	tts := NewWithEndpoint(srv.URL)
+
+	if _, err := tts.Speak(context.Background(), "hello", interfaces.WithOutputFormat("mp3")); !errors.Is(err, interfaces.ErrUnsupportedOption) {
+		t.Fatalf("Speak mp3: %v, want ErrUnsupportedOption", err)
+	}
+	if err := tts.SpeakStream(context.Background(), "hello", &bytes.Buffer{}, interfaces.WithOutputFormat("opus")); !errors.Is(err, interfaces.ErrUnsupportedOption) {
+		t.Fatalf("SpeakStream opus: %v, want ErrUnsupportedOption", err)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("%d requests sent for rejected options", n)
+	}
+
+	stt := NewSTT()
+	for _, opt := range []interfaces.STTOption{interfaces.WithLanguage("id"), interfaces.WithPromptHint("ACME"), interfaces.WithWordTimestamps()} {
+		if _, _, err := stt.Recognize(context.Background(), nil, opt); !errors.Is(err, interfaces.ErrUnsupportedOption) {
+			t.Fatalf("stt Recognize: %v, want ErrUnsupportedOption", err)
+		}
+		if _, err := stt.RecognizeStream(context.Background(), opt); !errors.Is(err, interfaces.ErrUnsupportedOption) {
+			t.Fatalf("stt RecognizeStream: %v, want ErrUnsupportedOption", err)
+		}
+	}
+}
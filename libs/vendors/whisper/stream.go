@@ -0,0 +1,273 @@
+package whisper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// Streaming recognition supports two transports:
+//
+// Websocket: when a stream endpoint is configured the adapter dials it and sends PCM frames as
+// binary messages. A text message {"type":"eof"} marks the end of audio. The server answers with
+// JSON text messages of the form
+//
+//	{"type":"partial"|"final","text":"...","start":0.0,"end":1.2,"confidence":0.9}
+//
+// where start/end are seconds from the start of the stream, and closes the connection once the
+// last final result has been sent. Options are passed as the query parameters "language",
+// "prompt" and "word_timestamps"; with word timestamps, results also carry
+// "words":[{"word":"...","start":0.0,"end":0.4,"probability":0.9}].
+//
+// Chunked: without a stream endpoint, the audio received so far is periodically posted to the
+// regular inference endpoint to produce partial results, and once more on Close for the final one.
+
+// partialInterval is how much new audio the chunked transport waits for before requesting a partial.
+const partialInterval = time.Second
+
+// streamFlushTimeout bounds how long a stream waits for final results after Close.
+const streamFlushTimeout = 15 * time.Second
+
+func (w *whisperSTT) RecognizeStream(ctx context.Context, opts ...interfaces.STTOption) (interfaces.STTStream, error) {
+	o := interfaces.ApplySTTOptions(opts...)
+	if w.streamEndpoint != "" {
+		return w.dialStream(ctx, o)
+	}
+	return newChunkedStream(ctx, w, o), nil
+}
+
+type wsMessage struct {
+	Type       string        `json:"type"`
+	Text       string        `json:"text"`
+	Start      float64       `json:"start"`
+	End        float64       `json:"end"`
+	Confidence float32       `json:"confidence"`
+	Words      []whisperWord `json:"words,omitempty"`
+}
+
+type wsStream struct {
+	ctx     context.Context
+	stop    func() bool
+	conn    *websocket.Conn
+	results chan interfaces.STTResult
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	err     error
+}
+
+func (w *whisperSTT) dialStream(ctx context.Context, o interfaces.STTOptions) (*wsStream, error) {
+	u, err := url.Parse(w.streamEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse whisper stream endpoint: %w", err)
+	}
+	q := u.Query()
+	if o.Language != "" {
+		q.Set("language", o.Language)
+	}
+	if o.Prompt != "" {
+		q.Set("prompt", o.Prompt)
+	}
+	if o.WordTimestamps {
+		q.Set("word_timestamps", "true")
+	}
+	u.RawQuery = q.Encode()
+
+	dialer := websocket.Dialer{HandshakeTimeout: w.client.Timeout}
+	conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial whisper stream: %w", err)
+	}
+	s := &wsStream{ctx: ctx, conn: conn, results: make(chan interfaces.STTResult, 16)}
+	// closing the connection unblocks the read loop, which then reports ctx.Err()
+	s.stop = context.AfterFunc(ctx, func() { _ = conn.Close() })
+	go s.readLoop()
+	return s, nil
+}
+
+func (s *wsStream) readLoop() {
+	defer close(s.results)
+	defer s.stop()
+	defer s.conn.Close()
+	for {
+		var msg wsMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			if ctxErr := s.ctx.Err(); ctxErr != nil {
+				s.setErr(ctxErr)
+			} else if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				s.setErr(fmt.Errorf("read whisper stream: %w", err))
+			}
+			return
+		}
+		if msg.Type == "error" {
+			s.setErr(fmt.Errorf("whisper stream error: %s", msg.Text))
+			return
+		}
+		conf := msg.Confidence
+		if conf == 0 {
+			conf = 1.0
+		}
+		res := interfaces.STTResult{
+			Text:       msg.Text,
+			Confidence: conf,
+			Final:      msg.Type == "final",
+			Start:      seconds(msg.Start),
+			End:        seconds(msg.End),
+		}
+		for _, wd := range msg.Words {
+			res.Words = append(res.Words, wd.toWord())
+		}
+		select {
+		case s.results <- res:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+func (s *wsStream) Write(pcm []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, pcm); err != nil {
+		return fmt.Errorf("write whisper stream: %w", err)
+	}
+	return nil
+}
+
+func (s *wsStream) Results() <-chan interfaces.STTResult { return s.results }
+
+func (s *wsStream) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	eof, _ := json.Marshal(wsMessage{Type: "eof"})
+	if err := s.conn.WriteMessage(websocket.TextMessage, eof); err != nil {
+		return fmt.Errorf("write whisper stream eof: %w", err)
+	}
+	// the server closes the connection after the final result; don't wait forever for it
+	return s.conn.SetReadDeadline(time.Now().Add(streamFlushTimeout))
+}
+
+func (s *wsStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *wsStream) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+type chunkedStream struct {
+	ctx     context.Context
+	w       *whisperSTT
+	opts    interfaces.STTOptions
+	results chan interfaces.STTResult
+	mu      sync.Mutex
+	pcm     []byte
+	pending int // bytes written since the last partial request was started
+	busy    bool
+	closed  bool
+	wg      sync.WaitGroup
+	err     error
+}
+
+func newChunkedStream(ctx context.Context, w *whisperSTT, o interfaces.STTOptions) *chunkedStream {
+	return &chunkedStream{ctx: ctx, w: w, opts: o, results: make(chan interfaces.STTResult, 16)}
+}
+
+func (s *chunkedStream) Write(pcm []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("whisper stream closed")
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.pcm = append(s.pcm, pcm...)
+	s.pending += len(pcm)
+	threshold := int(partialInterval.Seconds() * float64(audio.SpeechFormat.BytesPerSecond()))
+	if s.pending >= threshold && !s.busy {
+		s.busy = true
+		s.pending = 0
+		snapshot := append([]byte(nil), s.pcm...)
+		s.wg.Add(1)
+		go s.partial(snapshot)
+	}
+	return nil
+}
+
+// partial transcribes the audio received so far. Partials are best effort: errors are
+// ignored and results are dropped if the consumer is not keeping up.
+func (s *chunkedStream) partial(pcm []byte) {
+	defer s.wg.Done()
+	t, err := s.w.transcribe(s.ctx, audio.EncodeWAV(pcm, audio.SpeechFormat), s.opts)
+	s.mu.Lock()
+	s.busy = false
+	s.mu.Unlock()
+	if err != nil || t.text == "" {
+		return
+	}
+	select {
+	case s.results <- interfaces.STTResult{Text: t.text, Confidence: t.confidence, End: pcmDuration(len(pcm)), Words: t.words}:
+	default:
+	}
+}
+
+func (s *chunkedStream) Results() <-chan interfaces.STTResult { return s.results }
+
+func (s *chunkedStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	pcm := s.pcm
+	s.mu.Unlock()
+
+	go func() {
+		defer close(s.results)
+		s.wg.Wait()
+		if len(pcm) == 0 {
+			return
+		}
+		t, err := s.w.transcribe(s.ctx, audio.EncodeWAV(pcm, audio.SpeechFormat), s.opts)
+		if err != nil {
+			if ctxErr := s.ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			return
+		}
+		select {
+		case s.results <- interfaces.STTResult{Text: t.text, Confidence: t.confidence, Final: true, End: pcmDuration(len(pcm)), Words: t.words}:
+		case <-s.ctx.Done():
+			s.mu.Lock()
+			s.err = s.ctx.Err()
+			s.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (s *chunkedStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func pcmDuration(n int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(audio.SpeechFormat.BytesPerSecond())
+}
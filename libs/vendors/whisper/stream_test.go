@@ -0,0 +1,135 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// The fake stream server emits a partial after every binary frame and a single
+// final covering all received audio once it sees the eof message.
+func TestRecognizeStream_Websocket(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received := 0
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if mt == websocket.BinaryMessage {
+				received += len(data)
+				_ = conn.WriteJSON(wsMessage{Type: "partial", Text: "hello", End: pcmDuration(received).Seconds()})
+				continue
+			}
+			_ = conn.WriteJSON(wsMessage{Type: "final", Text: "hello world", Start: 0, End: pcmDuration(received).Seconds(), Confidence: 0.8})
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}))
+	defer srv.Close()
+
+	stt := NewWithEndpoints("", "ws"+strings.TrimPrefix(srv.URL, "http"))
+	pcm := make([]byte, audio.SpeechFormat.BytesPerSecond()/2) // 500ms of silence
+	partials := 0
+	res, err := audio.Transcribe(context.Background(), stt, bytes.NewReader(pcm), func(interfaces.STTResult) { partials++ })
+	if err != nil {
+		t.Fatalf("transcribe: %v", err)
+	}
+	if res.Text != "hello world" || !res.Final {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.End != 500*time.Millisecond {
+		t.Fatalf("end = %s, want 500ms", res.End)
+	}
+	if res.Confidence != 0.8 {
+		t.Fatalf("confidence = %v, want 0.8", res.Confidence)
+	}
+	if partials == 0 {
+		t.Fatalf("expected partial results")
+	}
+}
+
+func TestRecognizeStream_Chunked(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, _, err := audio.NewPCMReader(f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(whisperResp{Text: "chunked transcript"})
+	}))
+	defer srv.Close()
+
+	stt := NewWithEndpoint(srv.URL)
+	stream, err := stt.RecognizeStream(context.Background())
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	frame := make([]byte, audio.SpeechFormat.FrameBytes())
+	for i := 0; i < 50; i++ { // one second of audio
+		if err := stream.Write(frame); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	res := audio.CollectFinal(stream, nil)
+	if err := stream.Err(); err != nil {
+		t.Fatalf("stream err: %v", err)
+	}
+	if res.Text != "chunked transcript" || res.End != time.Second {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if n := requests.Load(); n < 2 {
+		t.Fatalf("expected a partial and a final request, got %d", n)
+	}
+}
+
+func TestRecognizeStream_WebsocketForwardsOptions(t *testing.T) {
+	queries := make(chan url.Values, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	stt := NewWithEndpoints("", "ws"+strings.TrimPrefix(srv.URL, "http")+"/stream")
+	stream, err := stt.RecognizeStream(context.Background(), interfaces.WithLanguage("id"), interfaces.WithPromptHint("ACME"), interfaces.WithWordTimestamps())
+	if err != nil {
+		t.Fatalf("RecognizeStream: %v", err)
+	}
+	defer stream.Close()
+	q := <-queries
+	if q.Get("language") != "id" || q.Get("prompt") != "ACME" || q.Get("word_timestamps") != "true" {
+		t.Fatalf("query = %v", q)
+	}
+}
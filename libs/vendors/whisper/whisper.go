@@ -2,21 +2,25 @@ package whisper
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/jacky-htg/ai-call-center/libs/interfaces"
 )
 
 // whisperSTT calls a local Whisper-like inference HTTP server that accepts a multipart "file" field
-// and returns JSON {"text":"..."}.
+// and returns JSON {"text":"..."}. When a stream endpoint is configured, RecognizeStream talks to a
+// websocket server instead (see stream.go).
 type whisperSTT struct {
-	endpoint string
-	client   *http.Client
+	endpoint       string
+	streamEndpoint string
+	client         *http.Client
 }
 
 // New constructs a Whisper STT adapter that posts to the default endpoint.
@@ -26,61 +30,139 @@ func New() interfaces.STT {
 
 // NewWithEndpoint constructs a Whisper STT adapter using a custom endpoint.
 func NewWithEndpoint(endpoint string) interfaces.STT {
+	return NewWithEndpoints(endpoint, "")
+}
+
+// NewWithEndpoints constructs a Whisper STT adapter using a custom inference endpoint and an
+// optional websocket stream endpoint (e.g. ws://localhost:7070/stream). Without a stream
+// endpoint, RecognizeStream falls back to chunked requests against the inference endpoint.
+func NewWithEndpoints(endpoint, streamEndpoint string) interfaces.STT {
 	if endpoint == "" {
 		endpoint = "http://localhost:7070/inference"
 	}
 	return &whisperSTT{
-		endpoint: endpoint,
-		client:   &http.Client{Timeout: 15 * time.Second},
+		endpoint:       endpoint,
+		streamEndpoint: streamEndpoint,
+		client:         &http.Client{Timeout: 15 * time.Second},
 	}
 }
 
+// Options: language and prompt hints are sent as the "language" and "prompt" form fields of the
+// whisper.cpp server. Word timestamps request "verbose_json" output and are read from the
+// segments' "words"; they are only available through RecognizeStream, since Recognize cannot
+// return them.
+
 type whisperResp struct {
-	Text string `json:"text"`
+	Text     string           `json:"text"`
+	Segments []whisperSegment `json:"segments"`
+}
+
+type whisperSegment struct {
+	Words []whisperWord `json:"words"`
+}
+
+type whisperWord struct {
+	Word        string  `json:"word"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float32 `json:"probability"`
+}
+
+func (w whisperWord) toWord() interfaces.Word {
+	return interfaces.Word{
+		Text:       strings.TrimSpace(w.Word),
+		Start:      seconds(w.Start),
+		End:        seconds(w.End),
+		Confidence: w.Probability,
+	}
+}
+
+// transcription is the result of a single inference request.
+type transcription struct {
+	text       string
+	confidence float32
+	words      []interfaces.Word
+}
+
+func (w *whisperSTT) Recognize(ctx context.Context, audio []byte, opts ...interfaces.STTOption) (string, float32, error) {
+	o := interfaces.ApplySTTOptions(opts...)
+	if o.WordTimestamps {
+		return "", 0, interfaces.Unsupported("whisper Recognize", "word timestamps (use RecognizeStream)")
+	}
+	t, err := w.transcribe(ctx, audio, o)
+	if err != nil {
+		return "", 0, err
+	}
+	return t.text, t.confidence, nil
 }
 
-func (w *whisperSTT) Recognize(audio []byte, opts ...interfaces.STTOption) (string, float32, error) {
+// transcribe posts a complete audio file to the inference endpoint.
+func (w *whisperSTT) transcribe(ctx context.Context, audio []byte, o interfaces.STTOptions) (transcription, error) {
 	// build multipart form with field name "file"
 	var b bytes.Buffer
 	mw := multipart.NewWriter(&b)
 	fw, err := mw.CreateFormFile("file", "audio.wav")
 	if err != nil {
-		return "", 0, fmt.Errorf("create form file: %w", err)
+		return transcription{}, fmt.Errorf("create form file: %w", err)
 	}
 	if _, err := fw.Write(audio); err != nil {
-		return "", 0, fmt.Errorf("write audio to form: %w", err)
+		return transcription{}, fmt.Errorf("write audio to form: %w", err)
+	}
+	fields := map[string]string{"language": o.Language, "prompt": o.Prompt}
+	if o.WordTimestamps {
+		fields["response_format"] = "verbose_json"
+	}
+	for k, v := range fields {
+		if v == "" {
+			continue
+		}
+		if err := mw.WriteField(k, v); err != nil {
+			return transcription{}, fmt.Errorf("write form field %s: %w", k, err)
+		}
 	}
 	// close writer to finalize boundary
 	if err := mw.Close(); err != nil {
-		return "", 0, fmt.Errorf("close multipart writer: %w", err)
+		return transcription{}, fmt.Errorf("close multipart writer: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", w.endpoint, &b)
+	req, err := http.NewRequestWithContext(ctx, "POST", w.endpoint, &b)
 	if err != nil {
-		return "", 0, fmt.Errorf("new request: %w", err)
+		return transcription{}, fmt.Errorf("new request: %w", err)
 	}
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 
 	resp, err := w.client.Do(req)
 	if err != nil {
-		return "", 0, fmt.Errorf("post to whisper server: %w", err)
+		return transcription{}, fmt.Errorf("post to whisper server: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", 0, fmt.Errorf("read response body: %w", err)
+		return transcription{}, fmt.Errorf("read response body: %w", err)
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", 0, fmt.Errorf("whisper server returned status %d: %s", resp.StatusCode, string(body))
+		return transcription{}, fmt.Errorf("whisper server returned status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var wr whisperResp
 	if err := json.Unmarshal(body, &wr); err != nil {
-		return "", 0, fmt.Errorf("unmarshal response: %w", err)
+		return transcription{}, fmt.Errorf("unmarshal response: %w", err)
 	}
 
 	// The local server returned plain transcript. Confidence isn't provided, return 1.0 by default.
-	return wr.Text, 1.0, nil
+	t := transcription{text: strings.TrimSpace(wr.Text), confidence: 1.0}
+	if o.WordTimestamps {
+		for _, seg := range wr.Segments {
+			for _, wd := range seg.Words {
+				t.words = append(t.words, wd.toWord())
+			}
+		}
+	}
+	return t, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
 }
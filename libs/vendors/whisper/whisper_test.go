@@ -0,0 +1,101 @@
+package whisper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/jacky-htg/ai-call-center/libs/audio"
+	"github.com/jacky-htg/ai-call-center/libs/interfaces"
+)
+
+// fakeInference answers like whisper.cpp's server and records the form fields it got.
+type fakeInference struct {
+	mu     sync.Mutex
+	fields url.Values
+}
+
+func (f *fakeInference) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.fields = r.MultipartForm.Value
+	f.mu.Unlock()
+	resp := whisperResp{Text: " hello world "}
+	if r.FormValue("response_format") == "verbose_json" {
+		resp.Segments = []whisperSegment{{Words: []whisperWord{{Word: " hello", Start: 0, End: 0.4, Probability: 0.9}, {Word: " world", Start: 0.4, End: 0.9, Probability: 0.8}}}}
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeInference) field(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fields.Get(name)
+}
+
+func TestRecognize_ForwardsOptions(t *testing.T) {
+	fake := &fakeInference{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	text, _, err := NewWithEndpoint(srv.URL).Recognize(context.Background(), audio.EncodeWAV(nil, audio.SpeechFormat),
+		interfaces.WithLanguage("id"), interfaces.WithPromptHint("ACME Bank"))
+	if err != nil {
+		t.Fatalf("Recognize: %v", err)
+	}
+	if text != "hello world" {
+		t.Fatalf("text = %q", text)
+	}
+	if fake.field("language") != "id" || fake.field("prompt") != "ACME Bank" || fake.field("response_format") != "" {
+		t.Fatalf("fields = %v", fake.fields)
+	}
+}
+
+func TestRecognize_RejectsWordTimestamps(t *testing.T) {
+	fake := &fakeInference{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	_, _, err := NewWithEndpoint(srv.URL).Recognize(context.Background(), nil, interfaces.WithWordTimestamps())
+	if !errors.Is(err, interfaces.ErrUnsupportedOption) {
+		t.Fatalf("Recognize with word timestamps: %v, want ErrUnsupportedOption", err)
+	}
+	if fake.fields != nil {
+		t.Fatalf("request sent for a rejected option: %v", fake.fields)
+	}
+}
+
+func TestRecognizeStream_ChunkedWordTimestamps(t *testing.T) {
+	fake := &fakeInference{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	stream, err := NewWithEndpoint(srv.URL).RecognizeStream(context.Background(), interfaces.WithWordTimestamps(), interfaces.WithLanguage("en"))
+	if err != nil {
+		t.Fatalf("RecognizeStream: %v", err)
+	}
+	if err := stream.Write(make([]byte, audio.SpeechFormat.FrameBytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	res := audio.CollectFinal(stream, nil)
+	if err := stream.Err(); err != nil {
+		t.Fatalf("stream err: %v", err)
+	}
+	if fake.field("response_format") != "verbose_json" || fake.field("language") != "en" {
+		t.Fatalf("fields = %v", fake.fields)
+	}
+	if len(res.Words) != 2 || res.Words[1].Text != "world" || res.Words[1].Confidence != 0.8 {
+		t.Fatalf("words = %+v", res.Words)
+	}
+}